@@ -0,0 +1,350 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/sirupsen/logrus"
+	"github.com/ulikunitz/xz"
+	"github.com/urfave/cli"
+)
+
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	xzMagic    = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	bzip2Magic = []byte("BZh")
+	zipMagic   = []byte("PK\x03\x04")
+	zipEmpty   = []byte("PK\x05\x06")
+
+	// bzip2BlockMagic starts the first compressed block (after the "BZh" and the block size digit)
+	bzip2BlockMagic = []byte{0x31, 0x41, 0x59, 0x26, 0x53, 0x59}
+)
+
+const tarEndMarkerLen = 1024 // two zero-filled 512-byte blocks
+
+// decompressReader wraps the input into the decompressor, based on the magic bytes of the content
+//   - returns the compression name (or "" if the content is not compressed)
+func decompressReader(in io.Reader) (io.Reader, string, error) {
+	br := bufio.NewReader(in)
+	if hdr, _ := br.Peek(len(gzipMagic)); bytes.Equal(hdr, gzipMagic) {
+		zr, err := gzip.NewReader(br)
+		return zr, "gzip", err
+	} else if hdr, _ := br.Peek(len(zstdMagic)); bytes.Equal(hdr, zstdMagic) {
+		zr, err := zstd.NewReader(br)
+		return zr, "zstd", err
+	} else if hdr, _ := br.Peek(len(xzMagic)); bytes.Equal(hdr, xzMagic) {
+		zr, err := xz.NewReader(br)
+		return zr, "xz", err
+	} else if hdr, _ := br.Peek(len(bzip2Magic) + 1 + len(bzip2BlockMagic)); isBzip2Header(hdr) {
+		return bzip2.NewReader(br), "bzip2", nil
+	}
+	return br, "", nil
+}
+
+// isBzip2Header checks the bzip2 stream header -- "BZh", the block size ('1'-'9') and the magic of the first block
+//   - NOTE: the "BZh" alone is too weak, e.g. a plain-text key could start with it
+func isBzip2Header(hdr []byte) bool {
+	n := len(bzip2Magic)
+	return len(hdr) == n+1+len(bzip2BlockMagic) && bytes.HasPrefix(hdr, bzip2Magic) && hdr[n] >= '1' && hdr[n] <= '9' &&
+		bytes.Equal(hdr[n+1:], bzip2BlockMagic)
+}
+
+// tarCompressionNames are the compressions of the `untar --compression` option
+var tarCompressionNames = []string{"auto", "none", "gzip", "zstd", "xz", "bzip2"}
+
+// decompressReaderAs wraps the input into the given decompressor (or detects the compression, if "auto" or "")
+func decompressReaderAs(in io.Reader, comp string) (io.Reader, string, error) {
+	var err error
+	switch comp {
+	case "", "auto":
+		return decompressReader(in)
+	case "none":
+		return in, "", nil
+	case "gzip":
+		in, err = gzip.NewReader(in)
+	case "zstd":
+		in, err = zstd.NewReader(in)
+	case "xz":
+		in, err = xz.NewReader(in)
+	case "bzip2":
+		in = bzip2.NewReader(in)
+	default:
+		return nil, "", fmt.Errorf("Invalid compression '%s' (expected one of %s)", comp, strings.Join(tarCompressionNames, ", "))
+	}
+	return in, comp, err
+}
+
+// newZstdWriter wraps the output into the zstd compressor, using the zstd-compatible compression level (e.g. 1-22)
+func newZstdWriter(out io.Writer, level int) (io.WriteCloser, error) {
+	return zstd.NewWriter(out, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+}
+
+// zstdCompress compresses the content using the zstd-compatible compression level
+func zstdCompress(in []byte, level int) ([]byte, error) {
+	zw, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	if err != nil {
+		return nil, err
+	}
+	defer zw.Close()
+	return zw.EncodeAll(in, nil), nil
+}
+
+// zstdDecompress decompresses the zstd-compressed content
+func zstdDecompress(in []byte) ([]byte, error) {
+	zr, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return zr.DecodeAll(in, nil)
+}
+
+// isZipFile checks if the file (or the first volume of the split archive) starts with ZIP magic bytes
+func isZipFile(fname string) (bool, error) {
+	f, err := openArchive(fname)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	hdr := make([]byte, len(zipMagic))
+	if _, err = io.ReadFull(f, hdr); err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return false, err
+	}
+	return bytes.Equal(hdr, zipMagic) || bytes.Equal(hdr, zipEmpty), nil
+}
+
+// tailReader remembers the last bytes read from the underlying reader
+type tailReader struct {
+	io.Reader
+	tail []byte
+}
+
+func (t *tailReader) Read(p []byte) (int, error) {
+	n, err := t.Reader.Read(p)
+	t.tail = append(t.tail, p[:n]...)
+	if l := len(t.tail); l > tarEndMarkerLen {
+		t.tail = t.tail[l-tarEndMarkerLen:]
+	}
+	return n, err
+}
+
+// entryFunc is called for each entry read from the archive
+type entryFunc func(name string, data []byte) error
+
+// readTar reads all the entries from the (optionally compressed) TAR archive
+func readTar(in io.Reader, comp string, fn entryFunc) (entries int, size int64, err error) {
+	if in, err = decryptingReader(in); err != nil {
+		return 0, 0, err
+	}
+	in, comp, err = decompressReaderAs(in, comp)
+	if err != nil {
+		return 0, 0, err
+	}
+	logrus.Debugf("Reading TAR archive (compression: %q)...", comp)
+	tin := &tailReader{Reader: in}
+	tr := tar.NewReader(tin)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return entries, size, err
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err == nil {
+			err = fn(hdr.Name, data)
+		}
+		if err != nil {
+			return entries, size, fmt.Errorf("Entry %s: %v", hdr.Name, err)
+		}
+		entries++
+		size += int64(len(data))
+	}
+
+	// archive/tar does not complain about truncated archives, so we check the end-of-archive marker ourselves
+	if len(tin.tail) < tarEndMarkerLen || !bytes.Equal(tin.tail, make([]byte, tarEndMarkerLen)) {
+		return entries, size, fmt.Errorf("Missing end-of-archive marker (truncated archive?)")
+	}
+	// .. also read up the remainder, to validate the compression checksums
+	if _, err = io.Copy(ioutil.Discard, in); err != nil {
+		return entries, size, err
+	}
+	return entries, size, nil
+}
+
+// readZip reads all the entries from the ZIP archive (also validating the CRC32 checksums)
+func readZip(fname string, fn entryFunc) (entries int, size int64, err error) {
+	af, err := openArchive(fname)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer af.Close()
+	ra, zsize, err := af.readerAt()
+	if err != nil {
+		return 0, 0, err
+	}
+	zr, err := zip.NewReader(ra, zsize)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			return entries, size, fmt.Errorf("Entry %s: %v", f.Name, err)
+		}
+		data, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err == nil {
+			err = fn(f.Name, data)
+		}
+		if err != nil {
+			return entries, size, fmt.Errorf("Entry %s: %v", f.Name, err)
+		}
+		entries++
+		size += int64(len(data))
+	}
+	return entries, size, nil
+}
+
+// readArchive reads all the entries from the (optionally compressed) TAR or ZIP archive (detecting the archive type)
+func readArchive(fname string, fn entryFunc) (entries int, size int64, err error) {
+	isZip, err := isZipFile(fname)
+	if err != nil {
+		return 0, 0, err
+	} else if isZip {
+		return readZip(fname, fn)
+	}
+	af, err := openArchive(fname)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer af.Close()
+	return readTar(af, "auto", fn)
+}
+
+// verifyArchive checks that all the entries of the archive are readable, and match the checksums of the manifest
+//   - with compare, the archived entries are also compared against the EtcD content (to detect the drift)
+func verifyArchive(fname string, compare bool) error {
+	var (
+		names    []string
+		sums     = make(map[string][sha256.Size]byte)
+		mf       *manifest
+		verifyFn = func(name string, data []byte) error {
+			logrus.Debugf("Verified %s [%d]", name, len(data))
+			if name == manifestName {
+				var err error
+				if mf, err = parseManifest(data); err != nil {
+					return fmt.Errorf("Invalid manifest: %v", err)
+				}
+				logrus.Infof("Archive %s has manifest of %d keys (revision %d%s)", fname, len(mf.Keys), mf.Revision,
+					mf.incremental())
+				return nil
+			} else if strings.HasSuffix(name, "/") {
+				return nil
+			}
+			names = append(names, name)
+			sums[name] = sha256.Sum256(data)
+			return nil
+		}
+	)
+
+	entries, size, err := readArchive(fname, verifyFn)
+	if err != nil {
+		return fmt.Errorf("Archive %s is corrupted: %v", fname, err)
+	}
+
+	// the manifest is the last entry of the archive, so the checksums are validated at the end
+	if bad := verifyChecksums(mf, sums); bad > 0 {
+		return fmt.Errorf("Archive %s is corrupted: %d keys do not match the checksums of the manifest", fname, bad)
+	}
+	if err := statInput(fname + ".errors"); err == nil {
+		logrus.Warnf("Archive %s is incomplete (failed keys are listed in %s.errors)", fname, fname)
+	}
+	logrus.Infof("Archive %s OK [%d entries, %d bytes]", fname, entries, size)
+
+	if !compare {
+		return nil
+	}
+
+	// the manifest maps the file-names to the original keys
+	keys := make([]string, len(names))
+	for i, name := range names {
+		keys[i] = mf.key(name)
+	}
+	kvs, err := batchGet(getEtcdClient(), keys)
+	if err != nil {
+		return err
+	}
+	var missing, changed int
+	for i, kv := range kvs {
+		if kv == nil {
+			fmt.Printf("missing: %s\n", keys[i])
+			missing++
+		} else if sha256.Sum256(kv.Value) != sums[names[i]] {
+			fmt.Printf("changed: %s\n", keys[i])
+			changed++
+		}
+	}
+	logrus.Infof("Compared %d keys: %d missing, %d changed", len(keys), missing, changed)
+	if missing+changed > 0 {
+		return fmt.Errorf("Archive %s differs from EtcD content", fname)
+	}
+	return nil
+}
+
+// verifyChecksums compares the checksums of the archived entries with the manifest, and returns the number of
+// mismatched (or lost) keys
+//   - NOTE: the archives written before the checksums were introduced are only checked for readability
+func verifyChecksums(mf *manifest, sums map[string][sha256.Size]byte) int {
+	if mf == nil {
+		logrus.Warnf("Archive has no manifest (checksums not verified)")
+		return 0
+	}
+	var checked, bad int
+	for name, mk := range mf.Keys {
+		if mk.SHA256 == "" {
+			continue
+		}
+		checked++
+		if sum, has := sums[name]; !has {
+			fmt.Printf("lost: %s\n", mk.Key)
+			bad++
+		} else if hex.EncodeToString(sum[:]) != mk.SHA256 {
+			fmt.Printf("corrupted: %s\n", mk.Key)
+			bad++
+		}
+	}
+	if checked <= 0 && len(mf.Keys) > 0 {
+		logrus.Warnf("Manifest has no checksums (written by %s)", mf.Tool)
+	} else {
+		logrus.Debugf("Verified %d checksums (%d mismatched)", checked, bad)
+	}
+	return bad
+}
+
+func actVerifyArchive(c *cli.Context) error {
+	optFile := c.String("f")
+	if optFile == "" {
+		return fmt.Errorf("Must specify archive file (-f file)")
+	}
+	return verifyArchive(optFile, c.Bool("compare"))
+}
+
+func actVerify(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("Must specify the archive file")
+	}
+	return verifyArchive(c.Args().First(), c.Bool("compare"))
+}
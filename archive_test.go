@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerifyArchiveTruncated(t *testing.T) {
+	var (
+		prefix = testPrefix(t)
+		dir    = t.TempDir()
+		kvs    []string
+	)
+	for i := 0; i < 20; i++ {
+		kvs = append(kvs, fmt.Sprintf("%sk%02d", prefix, i), strings.Repeat(fmt.Sprintf("value-%d,", i), 200))
+	}
+	putTestKeys(t, kvs...)
+
+	for _, cmd := range []string{"tar", "zip"} {
+		fname := filepath.Join(dir, "backup."+cmd)
+		mustRunApp(t, cmd, "-f", fname, prefix)
+		if _, err := runApp(t, "verify-archive", "-f", fname); err != nil {
+			t.Fatalf("Expected valid %s archive: %v", cmd, err)
+		}
+
+		st, err := os.Stat(fname)
+		if err != nil {
+			t.Fatal(err)
+		} else if err = os.Truncate(fname, st.Size()/2); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := runApp(t, "verify-archive", "-f", fname); err == nil {
+			t.Errorf("Expected truncated %s archive to fail the verification", cmd)
+		}
+	}
+}
+
+func TestZstdRoundTrip(t *testing.T) {
+	var (
+		prefix = testPrefix(t)
+		dir    = t.TempDir()
+		fname  = filepath.Join(dir, "backup.tar.zst")
+		bin    = string([]byte{0, 1, 2, 0xff, 0xfe}) + strings.Repeat("zstd", 1000)
+	)
+	putTestKeys(t, prefix+"src/bin", bin, prefix+"src/text", "hello")
+
+	mustRunApp(t, "dump", "--format", "tar", "--zstd", "-f", fname, prefix+"src/")
+	if ok, err := fileHasPrefix(fname, zstdMagic); err != nil || !ok {
+		t.Fatalf("Expected zstd-compressed archive (%v)", err)
+	}
+	mustRunApp(t, "untar", "-f", fname, "--prefix", prefix+"tar")
+
+	// the zstd-compressed files of the dir format are decompressed on upload
+	mustRunApp(t, "dump", "-C", filepath.Join(dir, "files"), "--zstd", prefix+"src/")
+	mustRunApp(t, "upload", "-C", filepath.Join(dir, "files"), "--prefix", prefix+"dir", strings.TrimPrefix(prefix, "/")+"src")
+
+	for _, p := range []string{prefix + "tar", prefix + "dir"} {
+		got := getTestKeys(t, p)
+		if got[p+prefix+"src/bin"] != bin || got[p+prefix+"src/text"] != "hello" || len(got) != 2 {
+			t.Errorf("Expected identical values restored under %s, got %d keys", p, len(got))
+		}
+	}
+}
+
+func TestDecompressReaderBzip2(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		comp string
+	}{
+		{"BZh91AY&SY\x00\x01", "bzip2"},
+		{"BZh11AY&SY", "bzip2"},
+		// the plain content starting with "BZh" is not mistaken for bzip2
+		{"BZh is not compressed", ""},
+		{"BZh0" + "1AY&SY", ""},
+		{"BZh9", ""},
+	} {
+		r, comp, err := decompressReader(strings.NewReader(tc.in))
+		if err != nil {
+			t.Fatal(err)
+		} else if comp != tc.comp {
+			t.Errorf("Expected compression %q of %q, got %q", tc.comp, tc.in, comp)
+		} else if comp != "" {
+			continue
+		}
+		if buf, err := ioutil.ReadAll(r); err != nil || string(buf) != tc.in {
+			t.Errorf("Expected the plain content %q, got %q (%v)", tc.in, buf, err)
+		}
+	}
+}
+
+func TestReadArchiveSize(t *testing.T) {
+	var (
+		prefix = testPrefix(t)
+		dir    = t.TempDir()
+	)
+	putTestKeys(t, prefix+"a", strings.Repeat("a", 1000), prefix+"b", strings.Repeat("b", 234))
+
+	// the size is the total size of the entries (not of the archive file)
+	for _, cmd := range []string{"tar", "zip"} {
+		fname := filepath.Join(dir, "backup."+cmd)
+		mustRunApp(t, cmd, "-f", fname, prefix)
+		var total int64
+		entries, size, err := readArchive(fname, func(name string, data []byte) error {
+			total += int64(len(data))
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		} else if entries < 2 || size != total {
+			t.Errorf("Expected %s size %d of the entries, got %d bytes in %d entries", cmd, total, size, entries)
+		}
+	}
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"go.etcd.io/etcd/auth/authpb"
+	"go.etcd.io/etcd/clientv3"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// newPassword returns the password of the new user (`--new-password` option, or prompts twice on the terminal)
+func newPassword(c *cli.Context, user string) (string, error) {
+	if pw := c.String("new-password"); pw != "" {
+		return pw, nil
+	} else if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+		return "", fmt.Errorf("Cannot prompt for the password of %s (use the --new-password option)", user)
+	}
+	var pws [2]string
+	for i, prompt := range []string{"New password for %s: ", "Retype password for %s: "} {
+		fmt.Fprintf(logrus.StandardLogger().Out, prompt, user)
+		buf, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(logrus.StandardLogger().Out)
+		if err != nil {
+			return "", err
+		}
+		pws[i] = string(buf)
+	}
+	if pws[0] != pws[1] {
+		return "", fmt.Errorf("Passwords do not match")
+	} else if pws[0] == "" {
+		return "", fmt.Errorf("Empty password (use --no-password for the users without password)")
+	}
+	return pws[0], nil
+}
+
+// parsePermission parses the permission type (read, write or readwrite)
+func parsePermission(s string) (clientv3.PermissionType, error) {
+	perm, err := clientv3.StrToPermissionType(strings.ToUpper(s))
+	if err != nil {
+		return perm, fmt.Errorf("Invalid permission '%s' (expecting read, write or readwrite)", s)
+	}
+	return perm, nil
+}
+
+// permRange returns the range end of the permission (`--prefix` option grants all the keys starting with the key)
+func permRange(c *cli.Context, key string) string {
+	if c.Bool("prefix") {
+		return clientv3.GetPrefixRangeEnd(key)
+	}
+	return ""
+}
+
+// permKeys describes the keys of the permission (the key, the prefix, or the range of keys)
+func permKeys(p *authpb.Permission) string {
+	switch string(p.RangeEnd) {
+	case "":
+		return string(p.Key)
+	case clientv3.GetPrefixRangeEnd(string(p.Key)):
+		return string(p.Key) + " (prefix)"
+	case "\x00":
+		return string(p.Key) + " (and all the keys after)"
+	}
+	return fmt.Sprintf("%s .. %s", p.Key, p.RangeEnd)
+}
+
+func actUserAdd(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("Must specify the user name")
+	}
+
+	var (
+		client = getEtcdClient()
+		user   = c.Args().First()
+		pw     string
+		err    error
+	)
+
+	if c.Bool("no-password") {
+		if c.String("new-password") != "" {
+			return fmt.Errorf("Options --no-password and --new-password are mutually exclusive")
+		}
+	} else if pw, err = newPassword(c, user); err != nil {
+		return err
+	}
+	logrus.Debugf("Doing USERADD(%s)...", user)
+	if _, err = client.UserAddWithOptions(ctx, user, pw, &clientv3.UserAddOptions{NoPassword: pw == ""}); err != nil {
+		return err
+	}
+	logrus.Infof("Added user %s", user)
+	return nil
+}
+
+func actUserDelete(c *cli.Context) error {
+	if c.NArg() <= 0 {
+		return fmt.Errorf("Must specify which users to delete")
+	}
+
+	var (
+		client = getEtcdClient()
+		failed = failedKeys{op: "delete user"}
+	)
+
+	for _, a := range c.Args().Slice() {
+		logrus.Debugf("Doing USERDELETE(%s)...", a)
+		_, err := client.UserDelete(ctx, a)
+		if err != nil && !opt.failFast {
+			failed.add(a, err)
+			continue
+		}
+		checkErr(err)
+		logrus.Infof("Deleted user %s", a)
+	}
+	return failed.result("")
+}
+
+func actUserPasswd(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("Must specify the user name")
+	}
+
+	var (
+		client = getEtcdClient()
+		user   = c.Args().First()
+	)
+
+	pw, err := newPassword(c, user)
+	if err != nil {
+		return err
+	}
+	logrus.Debugf("Doing USERPASSWD(%s)...", user)
+	if _, err = client.UserChangePassword(ctx, user, pw); err != nil {
+		return err
+	}
+	logrus.Infof("Changed password of user %s", user)
+	return nil
+}
+
+func actUserList(c *cli.Context) error {
+	client := getEtcdClient()
+
+	logrus.Debugf("Doing USERLIST()...")
+	res, err := client.UserList(ctx)
+	if err != nil {
+		return err
+	}
+	for _, u := range res.Users {
+		fmt.Printf("%s\n", u)
+	}
+	logrus.Infof("Found %d users.", len(res.Users))
+	return nil
+}
+
+func actUserGet(c *cli.Context) error {
+	if c.NArg() <= 0 {
+		return fmt.Errorf("Must specify which users to show")
+	}
+
+	var (
+		client = getEtcdClient()
+		failed = failedKeys{op: "get user"}
+	)
+
+	for _, a := range c.Args().Slice() {
+		logrus.Debugf("Doing USERGET(%s)...", a)
+		res, err := client.UserGet(ctx, a)
+		if err != nil && !opt.failFast {
+			failed.add(a, err)
+			continue
+		}
+		checkErr(err)
+		fmt.Printf("%s\n", a)
+		fmt.Printf("   roles: %s\n", strings.Join(res.Roles, ", "))
+	}
+	return failed.result("")
+}
+
+func actUserGrant(c *cli.Context) error {
+	if c.NArg() < 2 {
+		return fmt.Errorf("Must specify <user> and the roles to grant")
+	}
+
+	var (
+		client = getEtcdClient()
+		user   = c.Args().First()
+	)
+
+	for _, role := range c.Args().Slice()[1:] {
+		logrus.Debugf("Doing USERGRANT(%s,%s)...", user, role)
+		if _, err := client.UserGrantRole(ctx, user, role); err != nil {
+			return fmt.Errorf("Could not grant role %s to %s: %v", role, user, err)
+		}
+		logrus.Infof("Granted role %s to user %s", role, user)
+	}
+	return nil
+}
+
+func actUserRevoke(c *cli.Context) error {
+	if c.NArg() < 2 {
+		return fmt.Errorf("Must specify <user> and the roles to revoke")
+	}
+
+	var (
+		client = getEtcdClient()
+		user   = c.Args().First()
+	)
+
+	for _, role := range c.Args().Slice()[1:] {
+		logrus.Debugf("Doing USERREVOKE(%s,%s)...", user, role)
+		if _, err := client.UserRevokeRole(ctx, user, role); err != nil {
+			return fmt.Errorf("Could not revoke role %s from %s: %v", role, user, err)
+		}
+		logrus.Infof("Revoked role %s from user %s", role, user)
+	}
+	return nil
+}
+
+func actRoleAdd(c *cli.Context) error {
+	if c.NArg() <= 0 {
+		return fmt.Errorf("Must specify which roles to add")
+	}
+
+	client := getEtcdClient()
+	for _, a := range c.Args().Slice() {
+		logrus.Debugf("Doing ROLEADD(%s)...", a)
+		if _, err := client.RoleAdd(ctx, a); err != nil {
+			return fmt.Errorf("Could not add role %s: %v", a, err)
+		}
+		logrus.Infof("Added role %s", a)
+	}
+	return nil
+}
+
+func actRoleDelete(c *cli.Context) error {
+	if c.NArg() <= 0 {
+		return fmt.Errorf("Must specify which roles to delete")
+	}
+
+	var (
+		client = getEtcdClient()
+		failed = failedKeys{op: "delete role"}
+	)
+
+	for _, a := range c.Args().Slice() {
+		logrus.Debugf("Doing ROLEDELETE(%s)...", a)
+		_, err := client.RoleDelete(ctx, a)
+		if err != nil && !opt.failFast {
+			failed.add(a, err)
+			continue
+		}
+		checkErr(err)
+		logrus.Infof("Deleted role %s", a)
+	}
+	return failed.result("")
+}
+
+func actRoleList(c *cli.Context) error {
+	client := getEtcdClient()
+
+	logrus.Debugf("Doing ROLELIST()...")
+	res, err := client.RoleList(ctx)
+	if err != nil {
+		return err
+	}
+	for _, r := range res.Roles {
+		fmt.Printf("%s\n", r)
+	}
+	logrus.Infof("Found %d roles.", len(res.Roles))
+	return nil
+}
+
+func actRoleGet(c *cli.Context) error {
+	if c.NArg() <= 0 {
+		return fmt.Errorf("Must specify which roles to show")
+	}
+
+	var (
+		client = getEtcdClient()
+		failed = failedKeys{op: "get role"}
+	)
+
+	for _, a := range c.Args().Slice() {
+		logrus.Debugf("Doing ROLEGET(%s)...", a)
+		res, err := client.RoleGet(ctx, a)
+		if err != nil && !opt.failFast {
+			failed.add(a, err)
+			continue
+		}
+		checkErr(err)
+		fmt.Printf("%s\n", a)
+		for _, p := range res.Perm {
+			fmt.Printf("   %-10s %s\n", strings.ToLower(p.PermType.String())+":", permKeys(p))
+		}
+	}
+	return failed.result("")
+}
+
+func actRoleGrant(c *cli.Context) error {
+	if c.NArg() != 3 {
+		return fmt.Errorf("Must specify <role> <read|write|readwrite> <key>")
+	}
+
+	var (
+		client = getEtcdClient()
+		role   = c.Args().Get(0)
+		key    = c.Args().Get(2)
+	)
+
+	perm, err := parsePermission(c.Args().Get(1))
+	if err != nil {
+		return err
+	}
+	var (
+		end   = permRange(c, key)
+		pName = strings.ToLower(authpb.Permission_Type(perm).String())
+	)
+	logrus.Debugf("Doing ROLEGRANT(%s,%s,%q..%q)...", role, pName, key, end)
+	if _, err = client.RoleGrantPermission(ctx, role, key, end, perm); err != nil {
+		return err
+	}
+	logrus.Infof("Granted %s of %s to role %s", pName, key, role)
+	return nil
+}
+
+func actRoleRevoke(c *cli.Context) error {
+	if c.NArg() != 2 {
+		return fmt.Errorf("Must specify <role> <key>")
+	}
+
+	var (
+		client = getEtcdClient()
+		role   = c.Args().Get(0)
+		key    = c.Args().Get(1)
+		end    = permRange(c, key)
+	)
+
+	logrus.Debugf("Doing ROLEREVOKE(%s,%q..%q)...", role, key, end)
+	if _, err := client.RoleRevokePermission(ctx, role, key, end); err != nil {
+		return err
+	}
+	logrus.Infof("Revoked permission of %s from role %s", key, role)
+	return nil
+}
+
+func actAuthEnable(c *cli.Context) error {
+	client := getEtcdClient()
+
+	logrus.Debugf("Doing AUTHENABLE()...")
+	if _, err := client.AuthEnable(ctx); err != nil {
+		return fmt.Errorf("Could not enable authentication: %v (the root user with the root role must exist)", err)
+	}
+	logrus.Infof("Authentication enabled")
+	return nil
+}
+
+func actAuthDisable(c *cli.Context) error {
+	client := getEtcdClient()
+
+	logrus.Debugf("Doing AUTHDISABLE()...")
+	if _, err := client.AuthDisable(ctx); err != nil {
+		return err
+	}
+	logrus.Infof("Authentication disabled")
+	return nil
+}
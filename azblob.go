@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+)
+
+// azblobBackend stores the archives in the Azure Blob Storage containers (azblob://container/key)
+type azblobBackend struct {
+	service *azblob.ServiceURL
+}
+
+// init connects to the storage account, configured via the AZURE_STORAGE_CONNECTION_STRING, or the
+// AZURE_STORAGE_ACCOUNT with either AZURE_STORAGE_KEY or AZURE_STORAGE_SAS_TOKEN (same as for the az(1) CLI)
+func (b *azblobBackend) init() error {
+	if b.service != nil {
+		return nil
+	}
+	cfg := map[string]string{
+		"AccountName":           os.Getenv("AZURE_STORAGE_ACCOUNT"),
+		"AccountKey":            os.Getenv("AZURE_STORAGE_KEY"),
+		"SharedAccessSignature": os.Getenv("AZURE_STORAGE_SAS_TOKEN"),
+	}
+	if cs := os.Getenv("AZURE_STORAGE_CONNECTION_STRING"); cs != "" {
+		for _, kv := range strings.Split(cs, ";") {
+			if i := strings.IndexByte(kv, '='); i > 0 {
+				cfg[kv[:i]] = kv[i+1:]
+			}
+		}
+	}
+	if cfg["AccountName"] == "" {
+		return fmt.Errorf("Must specify the Azure storage account (AZURE_STORAGE_ACCOUNT or AZURE_STORAGE_CONNECTION_STRING)")
+	}
+	endpoint := cfg["BlobEndpoint"]
+	if endpoint == "" {
+		proto, suffix := cfg["DefaultEndpointsProtocol"], cfg["EndpointSuffix"]
+		if proto == "" {
+			proto = "https"
+		}
+		if suffix == "" {
+			suffix = "core.windows.net"
+		}
+		endpoint = fmt.Sprintf("%s://%s.blob.%s", proto, cfg["AccountName"], suffix)
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("Invalid Azure blob endpoint %s: %v", endpoint, err)
+	}
+	u.RawQuery = strings.TrimPrefix(cfg["SharedAccessSignature"], "?")
+	cred := azblob.NewAnonymousCredential()
+	if cfg["AccountKey"] != "" {
+		if cred, err = azblob.NewSharedKeyCredential(cfg["AccountName"], cfg["AccountKey"]); err != nil {
+			return fmt.Errorf("Invalid Azure storage key: %v", err)
+		}
+	}
+	svc := azblob.NewServiceURL(*u, azblob.NewPipeline(cred, azblob.PipelineOptions{}))
+	b.service = &svc
+	return nil
+}
+
+// azblobError shortens the errors of the storage service (which otherwise include the whole HTTP request)
+func azblobError(err error) error {
+	if serr, ok := err.(azblob.StorageError); ok {
+		return fmt.Errorf("%s (%s)", serr.ServiceCode(), serr.Response().Status)
+	}
+	return err
+}
+
+func (b *azblobBackend) blobURL(container, key string) (azblob.BlockBlobURL, error) {
+	if err := b.init(); err != nil {
+		return azblob.BlockBlobURL{}, err
+	}
+	return b.service.NewContainerURL(container).NewBlockBlobURL(key), nil
+}
+
+// upload uses the block blob upload (in blocks of remoteChunkSize)
+func (b *azblobBackend) upload(container, key string, in io.Reader) error {
+	bu, err := b.blobURL(container, key)
+	if err != nil {
+		return err
+	}
+	_, err = azblob.UploadStreamToBlockBlob(ctx, in, bu, azblob.UploadStreamToBlockBlobOptions{BufferSize: remoteChunkSize,
+		MaxBuffers: 4})
+	return azblobError(err)
+}
+
+func (b *azblobBackend) size(container, key string) (int64, error) {
+	bu, err := b.blobURL(container, key)
+	if err != nil {
+		return 0, err
+	}
+	res, err := bu.GetProperties(ctx, azblob.BlobAccessConditions{})
+	if serr, ok := err.(azblob.StorageError); ok && serr.Response().StatusCode == http.StatusNotFound {
+		return 0, os.ErrNotExist
+	} else if err != nil {
+		return 0, azblobError(err)
+	}
+	return res.ContentLength(), nil
+}
+
+func (b *azblobBackend) readRange(container, key string, off, n int64) ([]byte, error) {
+	bu, err := b.blobURL(container, key)
+	if err != nil {
+		return nil, err
+	}
+	res, err := bu.Download(ctx, off, n, azblob.BlobAccessConditions{}, false)
+	if err != nil {
+		return nil, azblobError(err)
+	}
+	body := res.Body(azblob.RetryReaderOptions{MaxRetryRequests: 3})
+	defer body.Close()
+	return ioutil.ReadAll(body)
+}
+
+func (b *azblobBackend) remove(container, key string) error {
+	bu, err := b.blobURL(container, key)
+	if err != nil {
+		return err
+	}
+	_, err = bu.Delete(ctx, azblob.DeleteSnapshotsOptionInclude, azblob.BlobAccessConditions{})
+	return azblobError(err)
+}
+
+func (b *azblobBackend) list(container, prefix string) ([]string, error) {
+	if err := b.init(); err != nil {
+		return nil, err
+	}
+	var (
+		cu   = b.service.NewContainerURL(container)
+		keys []string
+	)
+	for marker := (azblob.Marker{}); marker.NotDone(); {
+		res, err := cu.ListBlobsFlatSegment(ctx, marker, azblob.ListBlobsSegmentOptions{Prefix: prefix})
+		if err != nil {
+			return nil, azblobError(err)
+		}
+		for _, it := range res.Segment.BlobItems {
+			keys = append(keys, it.Name)
+		}
+		marker = res.NextMarker
+	}
+	return keys, nil
+}
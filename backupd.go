@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"go.etcd.io/etcd/clientv3"
+)
+
+// backupStatus is the JSON content of the health endpoint (`backupd --health-addr` option)
+type backupStatus struct {
+	Status      string     `json:"status"` // ok, or failing if the last backup failed
+	Schedule    string     `json:"schedule"`
+	Target      string     `json:"target,omitempty"` // the last backup file
+	LastRun     *time.Time `json:"last_run,omitempty"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	Duration    float64    `json:"duration,omitempty"` // seconds of the last backup
+	Backups     int        `json:"backups"`
+	Failures    int        `json:"failures"`
+	NextRun     time.Time  `json:"next_run"`
+}
+
+// backupDaemon runs the scheduled backups
+type backupDaemon struct {
+	sync.Mutex
+	c        *cli.Context
+	client   *clientv3.Client
+	format   string
+	target   string
+	keepDays int
+	keepCnt  int
+	schedule cron.Schedule
+	status   backupStatus
+}
+
+// expandTarget replaces the date(1)-style %Y, %m, %d, %H, %M and %S of the target with the (UTC) time of the backup
+func expandTarget(target string, t time.Time) string {
+	t = t.UTC()
+	return strings.NewReplacer("%Y", t.Format("2006"), "%m", t.Format("01"), "%d", t.Format("02"),
+		"%H", t.Format("15"), "%M", t.Format("04"), "%S", t.Format("05"), "%%", "%").Replace(target)
+}
+
+// run performs one backup, and records the result into the status
+func (d *backupDaemon) run() {
+	var (
+		start = time.Now()
+		fname = expandTarget(d.target, start)
+	)
+	logrus.Infof("Starting backup %s...", fname)
+	err := runDump(d.c, d.client, "backup", d.format, fname)
+	if err == nil && (d.keepDays > 0 || d.keepCnt > 0) {
+		// the backup is read back before pruning, so the old backups are not deleted in favor of a corrupted one (which
+		// is then marked as failed via the <file>.errors)
+		if err = verifyArchive(fname, false); err != nil {
+			failed := failedKeys{op: "verify", keys: []string{fname}, errs: []error{err}}
+			if werr := failed.writeFile(fname + ".errors"); werr != nil {
+				logrus.WithError(werr).Warnf("Could not mark %s as failed", fname)
+			}
+		}
+	}
+
+	d.Lock()
+	defer d.Unlock()
+	d.status.Target, d.status.LastRun, d.status.Duration = fname, &start, time.Since(start).Seconds()
+	if err != nil {
+		logrus.WithError(err).Errorf("Backup %s failed", fname)
+		d.status.Status, d.status.LastError = "failing", err.Error()
+		d.status.Failures++
+		return
+	}
+	logrus.Infof("Backup %s done in %s", fname, time.Since(start).Round(time.Millisecond))
+	d.status.Status, d.status.LastError, d.status.LastSuccess = "ok", "", &start
+	d.status.Backups++
+
+	if d.keepDays > 0 || d.keepCnt > 0 {
+		if err = pruneBackups(d.target, d.keepDays, d.keepCnt, false); err != nil {
+			logrus.WithError(err).Warnf("Could not prune backups of %s", d.target)
+		}
+	}
+}
+
+// ServeHTTP reports the status as JSON (the 503 status signals that the last backup failed)
+func (d *backupDaemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.Lock()
+	data, _ := json.MarshalIndent(d.status, "", "  ")
+	failing := d.status.LastError != ""
+	d.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	if failing {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write(append(data, '\n'))
+}
+
+// actBackupd runs the backups on the cron-style schedule until interrupted
+//   - NOTE: the backups do not overlap -- the next run is scheduled after the previous backup finished
+func actBackupd(c *cli.Context) error {
+	var (
+		optSchedule = c.String("schedule")
+		optHealth   = c.String("health-addr")
+		sigs        = make(chan os.Signal, 1)
+		dctx, abort = context.WithCancel(ctx)
+		err         error
+	)
+	defer abort()
+
+	d := &backupDaemon{c: c, format: c.String("format"), target: c.String("target"), keepDays: c.Int("keep-days"),
+		keepCnt: c.Int("keep-count")}
+	if optSchedule == "" {
+		return fmt.Errorf("Must specify the --schedule")
+	} else if d.schedule, err = cron.ParseStandard(optSchedule); err != nil {
+		return fmt.Errorf("Invalid schedule '%s': %v", optSchedule, err)
+	} else if d.target == "" {
+		return fmt.Errorf("Must specify the --target file (or URL)")
+	} else if d.format == "dir" {
+		return fmt.Errorf("The dir format is not supported by backupd")
+	} else if c.String("rev") != "" {
+		return fmt.Errorf("Option --rev is not supported by backupd")
+	} else if c.Bool("encrypt") && opt.passphraseFile == "" && opt.encKeyFile == "" {
+		return fmt.Errorf("Must specify the --passphrase-file or --encryption-key-file for the encrypted backups")
+	} else if _, err = keyArgs(c, "backup"); err != nil {
+		return err
+	} else if d.keepDays > 0 || d.keepCnt > 0 {
+		if _, _, err = targetRegexp(d.target); err != nil {
+			return err
+		}
+	}
+	d.client = getEtcdClient()
+	defer d.client.Close()
+	d.status = backupStatus{Status: "ok", Schedule: optSchedule, NextRun: d.schedule.Next(time.Now())}
+
+	if optHealth != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/healthz", d)
+		ln, err := net.Listen("tcp", optHealth)
+		if err != nil {
+			return err
+		}
+		srv := &http.Server{Handler: mux}
+		go srv.Serve(ln)
+		defer srv.Close()
+		logrus.Infof("Serving health status on http://%s/healthz", optHealth)
+	}
+
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	go func() {
+		if s, ok := <-sigs; ok {
+			logrus.Infof("Got %s, stopping", s)
+			abort()
+		}
+	}()
+
+	if c.Bool("run-now") {
+		d.run()
+	}
+	for dctx.Err() == nil {
+		d.Lock()
+		next := d.schedule.Next(time.Now())
+		d.status.NextRun = next
+		d.Unlock()
+		logrus.Infof("Next backup at %s", next.Format(time.RFC3339))
+		select {
+		case <-time.After(time.Until(next)):
+			d.run()
+		case <-dctx.Done():
+		}
+	}
+	d.Lock()
+	defer d.Unlock()
+	logrus.Infof("Stopped after %d backups (%d failed).", d.status.Backups+d.status.Failures, d.status.Failures)
+	return nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/mvcc/mvccpb"
+	"golang.org/x/time/rate"
+)
+
+// batchGet reads the given keys using transactions of up to maxTxnOps reads, to save the round-trips
+//   - returns the key-values in the same order as the keys (nil for the missing keys)
+func batchGet(client *clientv3.Client, keys []string, opts ...clientv3.OpOption) ([]*mvccpb.KeyValue, error) {
+	ret := make([]*mvccpb.KeyValue, len(keys))
+	for i := 0; i < len(keys); i += maxTxnOps {
+		end := i + maxTxnOps
+		if end > len(keys) {
+			end = len(keys)
+		}
+		ops := make([]clientv3.Op, 0, end-i)
+		for _, k := range keys[i:end] {
+			ops = append(ops, clientv3.OpGet(k, opts...))
+		}
+		logrus.Debugf("Doing TXN(GET %s..%s)...", keys[i], keys[end-1])
+		res, err := client.Txn(ctx).Then(ops...).Commit()
+		if err != nil {
+			return nil, err
+		}
+		for j, r := range res.Responses {
+			if rr := r.GetResponseRange(); rr != nil && len(rr.Kvs) > 0 {
+				ret[i+j] = rr.Kvs[0]
+			}
+		}
+	}
+	return ret, nil
+}
+
+// getResult is the result of a single Get performed by parallelGet
+type getResult struct {
+	res *clientv3.GetResponse
+	err error
+}
+
+// parallelGet performs the Get for each of the keys using concurrent workers, and passes the
+// results to fn in the same order as the keys (processing stops if fn returns an error)
+//   - NOTE: if rps > 0, the reads of all the workers are limited to rps reads per second
+func parallelGet(client *clientv3.Client, keys []string, workers int, rps float64,
+	optsFn func(key string) []clientv3.OpOption, fn func(key string, res *clientv3.GetResponse, err error) error) error {
+	var (
+		jobs    = make(chan int)
+		done    = make(chan struct{})
+		results = make([]chan getResult, len(keys))
+		limiter *rate.Limiter
+		wg      sync.WaitGroup
+	)
+
+	if workers < 1 {
+		workers = 1
+	}
+	if rps > 0 {
+		limiter = rate.NewLimiter(rate.Limit(rps), 1)
+	}
+	for i := range results {
+		results[i] = make(chan getResult, 1)
+	}
+
+	go func() {
+		defer close(jobs)
+		for i := range keys {
+			select {
+			case jobs <- i:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if limiter != nil {
+					if err := limiter.Wait(ctx); err != nil {
+						results[i] <- getResult{nil, err}
+						continue
+					}
+				}
+				opts := optsFn(keys[i])
+				logrus.Debugf("Doing GET(%s,%#v)...", keys[i], opts)
+				res, err := client.Get(ctx, keys[i], opts...)
+				results[i] <- getResult{res, err}
+			}
+		}()
+	}
+
+	defer wg.Wait()
+	defer close(done)
+	for i, k := range keys {
+		r := <-results[i]
+		if err := fn(k, r.res, r.err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rangePages reads the keys under the prefix in pages of countPageSize keys, and passes each page to fn
+//   - NOTE: this keeps the memory use bounded for large prefixes (all the pages are read at the revision of the first page)
+func rangePages(client *clientv3.Client, prefix string, fn func(res *clientv3.GetResponse) error, opts ...clientv3.OpOption) error {
+	var rev int64
+	return rangePagesAt(client, prefix, &rev, fn, opts...)
+}
+
+// rangePagesAt reads the pages at given revision (if *rev is 0, it is set to the revision of the first page)
+//   - NOTE: reading multiple prefixes with the same rev gives a point-in-time consistent view of the keys
+func rangePagesAt(client *clientv3.Client, prefix string, rev *int64, fn func(res *clientv3.GetResponse) error, opts ...clientv3.OpOption) error {
+	if prefix == "" {
+		return rangePagesFrom(client, "\x00", "\x00", rev, fn, opts...)
+	}
+	return rangePagesFrom(client, prefix, clientv3.GetPrefixRangeEnd(prefix), rev, fn, opts...)
+}
+
+// rangePagesFrom reads the pages of the [start, end) key range (the end "\x00" means all keys from start)
+//   - NOTE: etcd ignores the limit of the ranges filtered by the revisions (WithMin/MaxModRev, WithMin/MaxCreateRev),
+//     so such ranges are paged without the filters (keys-only), the keys are filtered here, and the values of the
+//     matching keys are then read at the same revision (the empty pages are skipped)
+func rangePagesFrom(client *clientv3.Client, start, end string, rev *int64, fn func(res *clientv3.GetResponse) error, opts ...clientv3.OpOption) error {
+	var (
+		probe    = clientv3.OpGet("", opts...)
+		filtered = probe.MinModRev() > 0 || probe.MaxModRev() > 0 || probe.MinCreateRev() > 0 || probe.MaxCreateRev() > 0
+	)
+	if filtered {
+		opts = append(append([]clientv3.OpOption{}, opts...), clientv3.WithMinModRev(0), clientv3.WithMaxModRev(0),
+			clientv3.WithMinCreateRev(0), clientv3.WithMaxCreateRev(0), clientv3.WithKeysOnly())
+	}
+	for {
+		popts := append([]clientv3.OpOption{clientv3.WithRange(end), clientv3.WithLimit(countPageSize)}, opts...)
+		if *rev > 0 {
+			popts = append(popts, clientv3.WithRev(*rev))
+		}
+		logrus.Debugf("Doing GET(%q..%q,page,rev=%d)...", start, end, *rev)
+		res, err := client.Get(ctx, start, popts...)
+		if err != nil {
+			return err
+		} else if *rev <= 0 {
+			*rev = res.Header.Revision
+		}
+		page := res
+		if filtered {
+			if page, err = filterPage(client, res, *rev, probe); err != nil {
+				return err
+			}
+		}
+		if len(page.Kvs) > 0 {
+			if err = fn(page); err != nil {
+				return err
+			}
+		}
+		if !res.More || len(res.Kvs) <= 0 {
+			return nil
+		}
+		start = string(res.Kvs[len(res.Kvs)-1].Key) + "\x00"
+	}
+}
+
+// filterPage returns the copy of the (keys-only) page with the keys matching the revision filters of the probe, and
+// reads their values at the revision (unless the probe is keys-only)
+func filterPage(client *clientv3.Client, res *clientv3.GetResponse, rev int64, probe clientv3.Op) (*clientv3.GetResponse, error) {
+	var (
+		page = *res
+		keys []string
+	)
+	page.Kvs = nil
+	for _, v := range res.Kvs {
+		if inRange(v.ModRevision, probe.MinModRev(), probe.MaxModRev()) &&
+			inRange(v.CreateRevision, probe.MinCreateRev(), probe.MaxCreateRev()) {
+			page.Kvs = append(page.Kvs, v)
+			keys = append(keys, string(v.Key))
+		}
+	}
+	if probe.IsKeysOnly() || len(keys) <= 0 {
+		return &page, nil
+	}
+	kvs, err := batchGet(client, keys, clientv3.WithRev(rev))
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range kvs {
+		if v != nil {
+			page.Kvs[i] = v
+		}
+	}
+	return &page, nil
+}
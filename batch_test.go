@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+// benchKeys writes n keys under the prefix of the benchmark, and returns the keys
+func benchKeys(b *testing.B, n int) []string {
+	var (
+		prefix = testPrefix(b)
+		kvs    []string
+		keys   []string
+	)
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("%sk%04d", prefix, i)
+		kvs, keys = append(kvs, key, "value"), append(keys, key)
+	}
+	putTestKeys(b, kvs...)
+	return keys
+}
+
+func TestParallelGet(t *testing.T) {
+	var (
+		prefix = testPrefix(t)
+		kvs    []string
+		args   = []string{"get", "-o", "ndjson", "--parallel", "8"}
+	)
+	for i := 0; i < 200; i++ {
+		key := fmt.Sprintf("%sk%03d", prefix, i)
+		kvs, args = append(kvs, key, fmt.Sprint(i)), append(args, key)
+	}
+	putTestKeys(t, kvs...)
+
+	// the keys are requested in the reverse order, which the output must keep
+	for i, j := 5, len(args)-1; i < j; i, j = i+1, j-1 {
+		args[i], args[j] = args[j], args[i]
+	}
+	lines := strings.Split(strings.TrimSpace(mustRunApp(t, args...)), "\n")
+	if len(lines) != 200 {
+		t.Fatalf("Expected 200 records, got %d", len(lines))
+	}
+	for i, l := range lines {
+		var rec kvRecord
+		if err := json.Unmarshal([]byte(l), &rec); err != nil {
+			t.Fatal(err)
+		}
+		if exp := fmt.Sprintf("%sk%03d", prefix, 199-i); rec.Key != exp || string(rec.Value) != fmt.Sprint(199-i) {
+			t.Fatalf("Expected %s at position %d, got %s=%s", exp, i, rec.Key, rec.Value)
+		}
+	}
+
+	// 20 reads at 50/s take at least ~380ms (the first read is not delayed)
+	start := time.Now()
+	mustRunApp(t, append([]string{"get", "--parallel", "8", "--rate", "50"}, args[5:25]...)...)
+	if d := time.Since(start); d < 350*time.Millisecond {
+		t.Errorf("Expected the reads limited by --rate, took only %v", d)
+	}
+}
+
+func BenchmarkBatchGet(b *testing.B) {
+	var (
+		keys   = benchKeys(b, 1000)
+		client = testClient(b)
+	)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		kvs, err := batchGet(client, keys)
+		if err != nil {
+			b.Fatal(err)
+		} else if len(kvs) != len(keys) {
+			b.Fatalf("Expected %d keys, got %d", len(keys), len(kvs))
+		}
+	}
+}
+
+func BenchmarkPerKeyGet(b *testing.B) {
+	var (
+		keys   = benchKeys(b, 1000)
+		client = testClient(b)
+	)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, k := range keys {
+			if _, err := client.Get(ctx, k); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// unfilteredKV fails the filtered ranges (etcd ignores their limit), so the revision filters must be applied locally
+type unfilteredKV struct {
+	clientv3.KV
+}
+
+func (kv *unfilteredKV) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	op := clientv3.OpGet(key, opts...)
+	if len(op.RangeBytes()) > 0 && (op.MinModRev() > 0 || op.MaxModRev() > 0 || op.MinCreateRev() > 0 || op.MaxCreateRev() > 0) {
+		return nil, fmt.Errorf("Range of %s filtered by the revisions", key)
+	}
+	return kv.KV.Get(ctx, key, opts...)
+}
+
+func TestRangePagesFiltered(t *testing.T) {
+	var (
+		prefix = testPrefix(t)
+		client = testClient(t)
+		kvs    []string
+	)
+	for i := 0; i < 1500; i++ {
+		kvs = append(kvs, fmt.Sprintf("%sk%04d", prefix, i), "old")
+	}
+	since := putTestKeys(t, kvs...)
+	// the changed keys are spread over both pages
+	putTestKeys(t, prefix+"k0100", "new", prefix+"k1200", "new", prefix+"k1499", "new")
+	client.KV = &unfilteredKV{KV: client.KV}
+
+	for _, keysOnly := range []bool{false, true} {
+		var (
+			got   = make(map[string]string)
+			pages int
+			rev   int64
+			opts  = []clientv3.OpOption{clientv3.WithMinModRev(since + 1)}
+		)
+		if keysOnly {
+			opts = append(opts, clientv3.WithKeysOnly())
+		}
+		err := rangePagesAt(client, prefix, &rev, func(res *clientv3.GetResponse) error {
+			pages++
+			for _, v := range res.Kvs {
+				got[string(v.Key)] = string(v.Value)
+			}
+			return nil
+		}, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exp := map[string]string{prefix + "k0100": "new", prefix + "k1200": "new", prefix + "k1499": "new"}
+		if keysOnly {
+			for k := range exp {
+				exp[k] = ""
+			}
+		}
+		if !reflect.DeepEqual(got, exp) || pages != 2 {
+			t.Errorf("Expected %v in 2 pages (keys-only=%v), got %v in %d pages", exp, keysOnly, got, pages)
+		}
+	}
+}
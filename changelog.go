@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/etcdserver/api/v3rpc/rpctypes"
+)
+
+// changelog appends the watch events of the prefixes to the file, as JSON lines (same records as `watch --output json`)
+//   - NOTE: all the prefixes are watched via their common prefix, so the events are recorded in the order of revisions
+type changelog struct {
+	client   *clientv3.Client
+	prefix   string   // the common prefix of the watched prefixes
+	prefixes []string // the recorded prefixes
+	w        io.Writer
+	rev      int64 // the last watched revision
+	events   int
+}
+
+// commonPrefix returns the longest common prefix of the keys
+func commonPrefix(keys []string) string {
+	if len(keys) <= 0 {
+		return ""
+	}
+	prefix := keys[0]
+	for _, k := range keys[1:] {
+		i := 0
+		for i < len(prefix) && i < len(k) && prefix[i] == k[i] {
+			i++
+		}
+		prefix = prefix[:i]
+	}
+	return prefix
+}
+
+// isPathError checks for the errors of writing the changelog file (which are not retried)
+func isPathError(err error) bool {
+	_, ok := err.(*os.PathError)
+	return ok
+}
+
+// lastChangelogRevision returns the revision to resume the changelog file from, i.e. the revision before the
+// revision of the last record (or 0 if empty)
+//   - NOTE: the incomplete last record (e.g. after a crash) is truncated, and so are the records of the last revision,
+//     which might have been recorded only partly (the whole revision is recorded again)
+func lastChangelogRevision(f *os.File) (int64, error) {
+	st, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	var (
+		buf  []byte // the records read so far (the file content from off)
+		off  = st.Size()
+		end  = off // the end of the records kept
+		last int64 // the revision of the last record
+	)
+	truncate := func() error {
+		if end >= st.Size() {
+			return nil
+		}
+		logrus.Infof("Truncating %d bytes at the end of %s (revision %d is recorded again)", st.Size()-end, f.Name(), last)
+		return f.Truncate(end)
+	}
+	for off > 0 {
+		n := int64(64 << 10)
+		if n > off {
+			n = off
+		}
+		off -= n
+		chunk := make([]byte, n, n+int64(len(buf)))
+		if _, err = f.ReadAt(chunk, off); err != nil {
+			return 0, err
+		}
+		buf = append(chunk, buf...)
+
+		if end == st.Size() && buf[len(buf)-1] != '\n' {
+			i := bytes.LastIndexByte(buf, '\n')
+			if i < 0 && off > 0 {
+				continue
+			}
+			end = off + int64(i+1)
+			logrus.Warnf("Truncating incomplete record at the end of %s [%d bytes]", f.Name(), st.Size()-end)
+			buf = buf[:i+1]
+		}
+		// the records are parsed from the end, once the preceding newline (or the start of the file) was read
+		for len(buf) > 0 {
+			i := bytes.LastIndexByte(buf[:len(buf)-1], '\n')
+			if i < 0 && off > 0 {
+				break
+			}
+			var rec watchRecord
+			if err = json.Unmarshal(buf[i+1:], &rec); err != nil || rec.Kv == nil {
+				return 0, fmt.Errorf("Invalid record of %s at offset %d: %v", f.Name(), off+int64(i+1), err)
+			} else if last > 0 && rec.Kv.ModRevision != last {
+				return last - 1, truncate()
+			}
+			last, buf, end = rec.Kv.ModRevision, buf[:i+1], off+int64(i+1)
+		}
+	}
+	if last <= 0 {
+		return 0, truncate()
+	}
+	return last - 1, truncate()
+}
+
+// watch records the changes after the last recorded revision, until the watch fails (or the context is canceled)
+func (cl *changelog) watch(wctx context.Context) error {
+	logrus.Debugf("Doing WATCH(%s,prefix,rev=%d)...", cl.prefix, cl.rev+1)
+	wch := cl.client.Watch(clientv3.WithRequireLeader(wctx), cl.prefix, clientv3.WithPrefix(), clientv3.WithRev(cl.rev+1))
+	for wres := range wch {
+		if wres.CompactRevision != 0 {
+			return rpctypes.ErrCompacted
+		} else if err := wres.Err(); err != nil {
+			return err
+		}
+		var (
+			buf bytes.Buffer
+			cnt int
+		)
+		for _, ev := range wres.Events {
+			if !hasAnyPrefix(ev.Kv.Key, cl.prefixes) {
+				continue
+			}
+			data, err := json.Marshal(newWatchRecord(ev))
+			if err != nil {
+				return err
+			}
+			buf.Write(append(data, '\n'))
+			cnt++
+		}
+		if n := len(wres.Events); n > 0 {
+			cl.rev = wres.Events[n-1].Kv.ModRevision
+		}
+		if cnt <= 0 {
+			continue
+		}
+		// the events of the response (i.e. of the whole revisions) are written with a single write
+		if _, err := cl.w.Write(buf.Bytes()); err != nil {
+			return err
+		}
+		cl.events += cnt
+		logrus.Debugf("Recorded %d events (revision %d)", cnt, cl.rev)
+	}
+	if err := wctx.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("Watch of %s closed", cl.prefix)
+}
+
+func actChangelog(c *cli.Context) error {
+	args, err := keyArgs(c, "record")
+	if err != nil {
+		return err
+	}
+
+	var (
+		cl = &changelog{
+			client:   getEtcdClient(),
+			prefix:   commonPrefix(args),
+			prefixes: args,
+		}
+		optFile     = c.String("f")
+		out         = io.Writer(os.Stdout)
+		sigs        = make(chan os.Signal, 1)
+		wctx, abort = context.WithCancel(ctx)
+	)
+	defer abort()
+
+	if optFile != "" {
+		f, err := os.OpenFile(optFile, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if cl.rev, err = lastChangelogRevision(f); err != nil {
+			return err
+		} else if cl.rev > 0 {
+			logrus.Infof("Resuming at revision %d (recorded in %s)", cl.rev+1, optFile)
+		}
+		out = f
+	}
+	if s := c.String("since-rev"); s != "" && cl.rev <= 0 {
+		if cl.rev, err = parseRevision(s); err != nil {
+			return err
+		}
+	} else if cl.rev <= 0 {
+		res, err := cl.client.Get(ctx, cl.prefix, clientv3.WithPrefix(), clientv3.WithCountOnly())
+		if err != nil {
+			return err
+		}
+		cl.rev = res.Header.Revision
+	}
+	cl.w = out
+
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	go func() {
+		if s, ok := <-sigs; ok {
+			logrus.Infof("Got %s, stopping", s)
+			abort()
+		}
+	}()
+
+	logrus.Infof("Recording changes of %d prefixes after revision %d (interrupt to stop)...", len(args), cl.rev)
+	for wctx.Err() == nil {
+		err = cl.watch(wctx)
+		switch {
+		case wctx.Err() != nil:
+		case err == rpctypes.ErrCompacted:
+			return fmt.Errorf("Revision %d was compacted, the changes since were lost (start a new changelog after a full backup)",
+				cl.rev+1)
+		case isPathError(err):
+			return err
+		default:
+			logrus.WithError(err).Warnf("Watch failed, resuming from revision %d", cl.rev)
+			select {
+			case <-time.After(mirrorRetryDelay):
+			case <-wctx.Done():
+			}
+		}
+	}
+	logrus.Infof("Recorded %d events, stopped at revision %d.", cl.events, cl.rev)
+	return nil
+}
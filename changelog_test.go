@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+// changelogRecord returns the changelog record of the PUT of the key at the revision (the size of the base64 value
+// must be a multiple of 4)
+func changelogRecord(key string, rev int64, size int) string {
+	return fmt.Sprintf(`{"type":"PUT","kv":{"key":%q,"value":%q,"mod_revision":%d}}`+"\n", key, strings.Repeat("v", size), rev)
+}
+
+func TestLastChangelogRevision(t *testing.T) {
+	var (
+		dir     = t.TempDir()
+		r3      = changelogRecord("a", 3, 12) + changelogRecord("b", 3, 12)
+		r4      = changelogRecord("a", 4, 12)
+		huge    = changelogRecord("huge", 5, 200<<10)
+		many    strings.Builder
+		partial = `{"type":"PUT","kv":{"key":"c","value":"` + strings.Repeat("v", 100<<10)
+	)
+	// the records of the last revision span several chunks read from the end
+	for i := 0; i < 2000; i++ {
+		many.WriteString(changelogRecord(fmt.Sprintf("k%04d", i), 6, 52))
+	}
+	for _, tc := range []struct {
+		name    string
+		content string
+		rev     int64  // the revision before the last recorded revision
+		keep    string // the records kept
+	}{
+		{"empty", "", 0, ""},
+		{"single revision", r3, 2, ""},
+		{"last revision", r3 + r4, 3, r3},
+		{"incomplete record", r3 + r4 + `{"type":"PUT","kv":{"ke`, 3, r3},
+		{"incomplete only", `{"type":"PUT"`, 0, ""},
+		{"huge record", r3 + huge, 4, r3},
+		{"huge incomplete record", r3 + r4 + partial, 3, r3},
+		{"many records", r3 + r4 + many.String(), 5, r3 + r4},
+		{"many records and incomplete", r3 + r4 + many.String() + partial, 5, r3 + r4},
+	} {
+		fname := filepath.Join(dir, strings.Replace(tc.name, " ", "-", -1)+".log")
+		if err := ioutil.WriteFile(fname, []byte(tc.content), 0666); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.OpenFile(fname, os.O_RDWR, 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rev, err := lastChangelogRevision(f)
+		f.Close()
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		} else if rev != tc.rev {
+			t.Errorf("%s: expected revision %d, got %d", tc.name, tc.rev, rev)
+		}
+		if buf, err := ioutil.ReadFile(fname); err != nil {
+			t.Fatal(err)
+		} else if string(buf) != tc.keep {
+			t.Errorf("%s: expected %d bytes kept, got %d", tc.name, len(tc.keep), len(buf))
+		}
+	}
+
+	fname := filepath.Join(dir, "invalid.log")
+	if err := ioutil.WriteFile(fname, []byte(r3+"not a record\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(fname, os.O_RDWR, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err = lastChangelogRevision(f); err == nil {
+		t.Error("Expected the invalid record to fail")
+	}
+}
+
+// readChangelog returns the keys and revisions of the changelog records
+func readChangelog(t *testing.T, fname string) []string {
+	t.Helper()
+	buf, err := ioutil.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ret []string
+	for _, l := range strings.Split(strings.TrimSuffix(string(buf), "\n"), "\n") {
+		var rec watchRecord
+		if l == "" {
+			continue
+		} else if err = json.Unmarshal([]byte(l), &rec); err != nil {
+			t.Fatalf("Invalid record %q: %v", l, err)
+		}
+		ret = append(ret, fmt.Sprintf("%s %s@%d", rec.Type, rec.Kv.Key, rec.Kv.ModRevision))
+	}
+	return ret
+}
+
+func TestChangelogResume(t *testing.T) {
+	var (
+		prefix = testPrefix(t)
+		client = testClient(t)
+		fname  = filepath.Join(t.TempDir(), "changes.log")
+	)
+	base := putTestKeys(t, prefix+"a/1", "old")
+	res, err := client.Txn(ctx).Then(clientv3.OpPut(prefix+"a/1", "x"), clientv3.OpPut(prefix+"b/1", "y"),
+		clientv3.OpPut(prefix+"c/1", "z")).Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	txnRev := res.Header.Revision
+	if _, err = client.Delete(ctx, prefix+"c/1"); err != nil {
+		t.Fatal(err)
+	}
+	exp := []string{
+		fmt.Sprintf("PUT %sa/1@%d", prefix, txnRev),
+		fmt.Sprintf("PUT %sc/1@%d", prefix, txnRev),
+		fmt.Sprintf("DELETE %sc/1@%d", prefix, txnRev+1),
+	}
+
+	// record records the changes after the revision, until all the expected records are written
+	record := func(rev int64) {
+		f, err := os.OpenFile(fname, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		var (
+			cl          = &changelog{client: client, prefixes: []string{prefix + "a/", prefix + "c/"}, w: f, rev: rev}
+			done        = make(chan error, 1)
+			wctx, abort = context.WithCancel(ctx)
+		)
+		defer abort()
+		cl.prefix = commonPrefix(cl.prefixes)
+		go func() { done <- cl.watch(wctx) }()
+
+		deadline := time.Now().Add(5 * time.Second)
+		for len(readChangelog(t, fname)) < len(exp) {
+			select {
+			case err := <-done:
+				t.Fatalf("Recording failed: %v", err)
+			case <-time.After(50 * time.Millisecond):
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected %d records, got %q", len(exp), readChangelog(t, fname))
+			}
+		}
+		abort()
+		if err := <-done; err != context.Canceled {
+			t.Errorf("Expected the canceled watch, got %v", err)
+		}
+	}
+	record(base)
+	if got := readChangelog(t, fname); !reflect.DeepEqual(got, exp) {
+		t.Fatalf("Expected records %q, got %q", exp, got)
+	}
+
+	// the revision written only partly (the crash after the first record of the transaction) is recorded again
+	buf, err := ioutil.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	} else if err = ioutil.WriteFile(fname, buf[:strings.IndexByte(string(buf), '\n')+1], 0666); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(fname, os.O_RDWR, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rev, err := lastChangelogRevision(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	} else if rev != txnRev-1 {
+		t.Fatalf("Expected to resume at revision %d, got %d", txnRev, rev+1)
+	}
+	record(rev)
+	if got := readChangelog(t, fname); !reflect.DeepEqual(got, exp) {
+		t.Fatalf("Expected the resumed records %q, got %q", exp, got)
+	}
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"go.etcd.io/etcd/clientv3"
+)
+
+// clusterEndpoints returns the endpoints for the maintenance commands -- the `--endpoints`, or with the `--cluster`
+// option, the client URLs of all the cluster members
+func clusterEndpoints(c *cli.Context, client *clientv3.Client) ([]string, error) {
+	if !c.Bool("cluster") {
+		return client.Endpoints(), nil
+	}
+	return memberEndpoints(client)
+}
+
+// memberEndpoints returns the client URLs of the cluster members (the first URL of each started member)
+func memberEndpoints(client *clientv3.Client) ([]string, error) {
+	mctx, cancel := context.WithTimeout(ctx, timeoutOr(opt.opTimeout))
+	defer cancel()
+	logrus.Debugf("Doing MEMBERLIST()...")
+	res, err := client.MemberList(mctx)
+	if err != nil {
+		return nil, err
+	}
+	var eps []string
+	for _, m := range res.Members {
+		if len(m.ClientURLs) <= 0 {
+			logrus.Warnf("Skipping member %x (not started yet)", m.ID)
+			continue
+		}
+		eps = append(eps, m.ClientURLs[0])
+	}
+	return eps, nil
+}
+
+// endpointStatus returns the status of the endpoint (within the `--op-timeout`)
+func endpointStatus(client *clientv3.Client, ep string) (*clientv3.StatusResponse, error) {
+	sctx, cancel := context.WithTimeout(ctx, timeoutOr(opt.opTimeout))
+	defer cancel()
+	logrus.Debugf("Doing STATUS(%s)...", ep)
+	return client.Status(sctx, ep)
+}
+
+// checkEndpointHealth checks the endpoint responds, and follows a leader
+//   - NOTE: the reported errors (e.g. the NOSPACE alarm) are only logged, since the defrag is what clears them
+func checkEndpointHealth(client *clientv3.Client, ep string) (*clientv3.StatusResponse, error) {
+	st, err := endpointStatus(client, ep)
+	if err != nil {
+		return nil, err
+	} else if st.Leader == 0 {
+		return st, fmt.Errorf("%s has no leader", ep)
+	} else if len(st.Errors) > 0 {
+		logrus.Warnf("%s reports errors: %s", ep, strings.Join(st.Errors, "; "))
+	}
+	return st, nil
+}
+
+func actDefrag(c *cli.Context) error {
+	var (
+		client  = getEtcdClient()
+		timeout = time.Duration(c.Int("defrag-timeout")) * time.Second
+		done    int
+	)
+
+	eps, err := clusterEndpoints(c, client)
+	if err != nil {
+		return err
+	}
+
+	// the members are defragmented one by one (the member does not serve requests while defragmenting),
+	// and the next one only if the previous member is healthy again
+	for _, ep := range eps {
+		before, err := checkEndpointHealth(client, ep)
+		if err != nil {
+			return fmt.Errorf("Defragmented %d of %d endpoints, stopped at unhealthy %s: %v", done, len(eps), ep, err)
+		}
+		logrus.Infof("Defragmenting %s [%d bytes]...", ep, before.DbSize)
+		start := time.Now()
+		dctx, cancel := context.WithTimeout(ctx, timeout)
+		logrus.Debugf("Doing DEFRAG(%s)...", ep)
+		_, err = client.Defragment(dctx, ep)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("Defragmented %d of %d endpoints, then %s failed: %v", done, len(eps), ep, err)
+		}
+		after, err := checkEndpointHealth(client, ep)
+		if err != nil {
+			return fmt.Errorf("Defragmented %d of %d endpoints, then %s is unhealthy: %v", done+1, len(eps), ep, err)
+		}
+		logrus.Infof("Defragmented %s in %s [%d -> %d bytes]", ep, time.Since(start).Round(time.Millisecond), before.DbSize, after.DbSize)
+		done++
+	}
+	logrus.Infof("Defragmented %d endpoints.", done)
+	return nil
+}
+
+// statusRecord is the status of the endpoint (`status` command)
+type statusRecord struct {
+	Endpoint         string   `json:"endpoint"`
+	Healthy          bool     `json:"healthy"`
+	MemberID         string   `json:"member_id,omitempty"`
+	Version          string   `json:"version,omitempty"`
+	DbSize           int64    `json:"db_size,omitempty"`
+	DbSizeInUse      int64    `json:"db_size_in_use,omitempty"`
+	Leader           string   `json:"leader,omitempty"`
+	IsLeader         bool     `json:"is_leader"`
+	RaftTerm         uint64   `json:"raft_term,omitempty"`
+	RaftIndex        uint64   `json:"raft_index,omitempty"`
+	RaftAppliedIndex uint64   `json:"raft_applied_index,omitempty"`
+	Alarms           []string `json:"alarms,omitempty"`
+	Errors           []string `json:"errors,omitempty"`
+}
+
+func newStatusRecord(ep string, st *clientv3.StatusResponse, alarms map[uint64][]string) *statusRecord {
+	id := st.Header.MemberId
+	return &statusRecord{
+		Endpoint:         ep,
+		Healthy:          st.Leader != 0 && len(alarms[id]) <= 0 && len(st.Errors) <= 0,
+		MemberID:         fmt.Sprintf("%x", id),
+		Version:          st.Version,
+		DbSize:           st.DbSize,
+		DbSizeInUse:      st.DbSizeInUse,
+		Leader:           fmt.Sprintf("%x", st.Leader),
+		IsLeader:         st.Leader == id,
+		RaftTerm:         st.RaftTerm,
+		RaftIndex:        st.RaftIndex,
+		RaftAppliedIndex: st.RaftAppliedIndex,
+		Alarms:           alarms[id],
+		Errors:           st.Errors,
+	}
+}
+
+// health returns the health summary of the endpoint (the table output)
+func (sr *statusRecord) health() string {
+	switch {
+	case len(sr.Errors) > 0 && sr.MemberID == "":
+		return "unreachable: " + sr.Errors[0]
+	case sr.Leader == "0":
+		return "no leader"
+	case len(sr.Alarms) > 0:
+		return "alarm: " + strings.Join(sr.Alarms, ",")
+	case len(sr.Errors) > 0:
+		return strings.Join(sr.Errors, "; ")
+	}
+	return "ok"
+}
+
+// memberAlarms returns the active alarms (e.g. NOSPACE), indexed by the member IDs
+func memberAlarms(client *clientv3.Client) (map[uint64][]string, error) {
+	actx, cancel := context.WithTimeout(ctx, timeoutOr(opt.opTimeout))
+	defer cancel()
+	logrus.Debugf("Doing ALARMLIST()...")
+	res, err := client.AlarmList(actx)
+	if err != nil {
+		return nil, err
+	}
+	alarms := make(map[uint64][]string)
+	for _, a := range res.Alarms {
+		alarms[a.MemberID] = append(alarms[a.MemberID], a.Alarm.String())
+	}
+	return alarms, nil
+}
+
+func actStatus(c *cli.Context) error {
+	var (
+		client    = getEtcdClient()
+		optOutput = c.String("output")
+		recs      []*statusRecord
+		unhealthy int
+	)
+
+	if optOutput != "table" && optOutput != "json" {
+		return fmt.Errorf("Invalid output format '%s' (expecting table or json)", optOutput)
+	}
+	eps, err := clusterEndpoints(c, client)
+	if err != nil {
+		return err
+	}
+	alarms, err := memberAlarms(client)
+	if err != nil {
+		logrus.WithError(err).Warnf("Could not list alarms")
+	}
+
+	for _, ep := range eps {
+		st, err := endpointStatus(client, ep)
+		rec := &statusRecord{Endpoint: ep}
+		if err != nil {
+			rec.Errors = []string{err.Error()}
+		} else {
+			rec = newStatusRecord(ep, st, alarms)
+		}
+		if !rec.Healthy {
+			unhealthy++
+		}
+		recs = append(recs, rec)
+	}
+
+	if optOutput == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		for _, rec := range recs {
+			checkErr(enc.Encode(rec))
+		}
+	} else {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(tw, "ENDPOINT\tID\tVERSION\tDB SIZE\tLEADER\tTERM\tINDEX\tAPPLIED\tHEALTH\n")
+		for _, r := range recs {
+			if r.MemberID == "" {
+				fmt.Fprintf(tw, "%s\t-\t-\t-\t-\t-\t-\t-\t%s\n", r.Endpoint, r.health())
+				continue
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%v\t%d\t%d\t%d\t%s\n", r.Endpoint, r.MemberID, r.Version, r.DbSize, r.IsLeader,
+				r.RaftTerm, r.RaftIndex, r.RaftAppliedIndex, r.health())
+		}
+		tw.Flush()
+	}
+
+	if unhealthy > 0 {
+		return fmt.Errorf("%d of %d endpoints are unhealthy", unhealthy, len(eps))
+	}
+	return nil
+}
+
+func actAlarmList(c *cli.Context) error {
+	client := getEtcdClient()
+
+	logrus.Debugf("Doing ALARMLIST()...")
+	res, err := client.AlarmList(ctx)
+	if err != nil {
+		return err
+	}
+	for _, a := range res.Alarms {
+		fmt.Printf("memberID:%x alarm:%s\n", a.MemberID, a.Alarm)
+	}
+	logrus.Infof("Found %d alarms.", len(res.Alarms))
+	return nil
+}
+
+func actAlarmDisarm(c *cli.Context) error {
+	var (
+		client = getEtcdClient()
+		member uint64
+		err    error
+	)
+
+	if s := c.String("member"); s != "" {
+		if member, err = parseMemberID(s); err != nil {
+			return err
+		}
+	}
+	logrus.Debugf("Doing ALARMLIST()...")
+	res, err := client.AlarmList(ctx)
+	if err != nil {
+		return err
+	}
+
+	cnt := 0
+	for _, a := range res.Alarms {
+		if member != 0 && a.MemberID != member {
+			continue
+		}
+		logrus.Debugf("Doing ALARMDISARM(%x,%s)...", a.MemberID, a.Alarm)
+		if _, err = client.AlarmDisarm(ctx, (*clientv3.AlarmMember)(a)); err != nil {
+			return fmt.Errorf("Could not disarm %s alarm of member %x: %v", a.Alarm, a.MemberID, err)
+		}
+		logrus.Infof("Disarmed %s alarm of member %x", a.Alarm, a.MemberID)
+		cnt++
+	}
+	logrus.Infof("Disarmed %d alarms.", cnt)
+	return nil
+}
+
+// findLeader returns the client URL and the ID of the leader (asks each member, if it is the leader)
+func findLeader(client *clientv3.Client) (string, uint64, error) {
+	eps, err := memberEndpoints(client)
+	if err != nil {
+		return "", 0, err
+	}
+	for _, ep := range eps {
+		st, err := endpointStatus(client, ep)
+		if err != nil {
+			logrus.WithError(err).Warnf("Could not get status of %s", ep)
+			continue
+		} else if st.Leader == st.Header.MemberId {
+			return ep, st.Leader, nil
+		}
+	}
+	return "", 0, fmt.Errorf("Could not find the leader of the cluster")
+}
+
+func actMoveLeader(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("Must specify the member to move the leadership to")
+	}
+	id, err := parseMemberID(c.Args().First())
+	if err != nil {
+		return err
+	}
+
+	client := getEtcdClient()
+	ep, leader, err := findLeader(client)
+	if err != nil {
+		return err
+	} else if leader == id {
+		logrus.Infof("Member %x is already the leader", id)
+		return nil
+	}
+
+	// the leadership can only be transferred by the leader itself
+	logrus.Debugf("Connecting to leader %x at %s...", leader, ep)
+	client.SetEndpoints(ep)
+	logrus.Debugf("Doing MOVELEADER(%x)...", id)
+	if _, err = client.MoveLeader(ctx, id); err != nil {
+		return fmt.Errorf("Could not move leadership from %x to %x: %v", leader, id, err)
+	}
+	logrus.Infof("Moved leadership from %x to %x", leader, id)
+	return nil
+}
+
+// hashRecord is the KV hash of the endpoint (`check hashkv` command)
+type hashRecord struct {
+	Endpoint        string `json:"endpoint"`
+	MemberID        string `json:"member_id,omitempty"`
+	Hash            uint32 `json:"hash,omitempty"`
+	CompactRevision int64  `json:"compact_revision,omitempty"`
+	Error           string `json:"error,omitempty"`
+}
+
+func actCheckHashKV(c *cli.Context) error {
+	var (
+		client    = getEtcdClient()
+		optOutput = c.String("output")
+		rev       = c.Int64("rev")
+		recs      []*hashRecord
+		failed    int
+	)
+
+	if optOutput != "table" && optOutput != "json" {
+		return fmt.Errorf("Invalid output format '%s' (expecting table or json)", optOutput)
+	}
+	eps, err := clusterEndpoints(c, client)
+	if err != nil {
+		return err
+	}
+	if rev <= 0 {
+		// all the members must hash the same revision (the current one, via the linearizable read)
+		res, err := client.Get(ctx, "\x00", clientv3.WithCountOnly())
+		if err != nil {
+			return err
+		}
+		rev = res.Header.Revision
+	}
+
+	for _, ep := range eps {
+		hctx, cancel := context.WithTimeout(ctx, timeoutOr(opt.opTimeout))
+		logrus.Debugf("Doing HASHKV(%s,%d)...", ep, rev)
+		res, err := client.HashKV(hctx, ep, rev)
+		cancel()
+		if err != nil {
+			recs = append(recs, &hashRecord{Endpoint: ep, Error: err.Error()})
+			failed++
+			continue
+		}
+		recs = append(recs, &hashRecord{
+			Endpoint:        ep,
+			MemberID:        fmt.Sprintf("%x", res.Header.MemberId),
+			Hash:            res.Hash,
+			CompactRevision: res.CompactRevision,
+		})
+	}
+
+	// the hashes are comparable only between the members compacted at the same revision
+	var (
+		base     *hashRecord
+		diverged int
+		skipped  int
+	)
+	for _, r := range recs {
+		if r.Error != "" {
+			continue
+		} else if base == nil {
+			base = r
+		} else if r.CompactRevision != base.CompactRevision {
+			logrus.Warnf("Skipping %s (compacted at revision %d, %s at %d)", r.Endpoint, r.CompactRevision,
+				base.Endpoint, base.CompactRevision)
+			skipped++
+		} else if r.Hash != base.Hash {
+			r.Error = fmt.Sprintf("hash differs from %s", base.Endpoint)
+			diverged++
+		}
+	}
+
+	if optOutput == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		for _, r := range recs {
+			checkErr(enc.Encode(r))
+		}
+	} else {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(tw, "ENDPOINT\tID\tHASH\tCOMPACT REV\tERROR\n")
+		for _, r := range recs {
+			if r.MemberID == "" {
+				fmt.Fprintf(tw, "%s\t-\t-\t-\t%s\n", r.Endpoint, r.Error)
+				continue
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\n", r.Endpoint, r.MemberID, r.Hash, r.CompactRevision, r.Error)
+		}
+		tw.Flush()
+	}
+
+	switch {
+	case diverged > 0:
+		return fmt.Errorf("KV hashes of %d of %d endpoints diverge at revision %d", diverged, len(eps), rev)
+	case failed > 0:
+		return fmt.Errorf("Could not hash %d of %d endpoints at revision %d", failed, len(eps), rev)
+	}
+	logrus.Infof("KV hashes of %d endpoints match at revision %d.", len(eps)-skipped, rev)
+	return nil
+}
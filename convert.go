@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/mvcc/mvccpb"
+)
+
+// convertExts maps the file-name extensions to the dump formats (`convert` command)
+var convertExts = []struct{ ext, format string }{
+	{".tar.gz", "tar.gz"}, {".tgz", "tar.gz"}, {".tar.zst", "tar.zst"},
+	{".tar.xz", "tar.xz"}, {".txz", "tar.xz"}, {".tar.bz2", "tar.bz2"}, {".tbz2", "tar.bz2"}, {".tar", "tar"}, {".zip", "zip"},
+	{".ndjson", "ndjson"}, {".jsonl", "ndjson"}, {".json", "json"},
+}
+
+// convertFormat returns the dump format of the output file (by its extension), or "" if not known
+func convertFormat(fname string) string {
+	for _, ce := range convertExts {
+		if strings.HasSuffix(fname, ce.ext) {
+			return ce.format
+		}
+	}
+	return ""
+}
+
+// convertSnapshot writes the keys of the snapshot (under the prefixes), and the manifest
+func convertSnapshot(fname string, prefixes []string, w dumpWriter) (int, error) {
+	kv, err := openSnapshot(fname)
+	if err != nil {
+		return 0, err
+	}
+	var (
+		client = kv.client()
+		mf     = newManifest()
+		cnt    int
+	)
+	for _, p := range prefixes {
+		err = rangePagesAt(client, p, &mf.Revision, func(res *clientv3.GetResponse) error {
+			for _, v := range res.Kvs {
+				name := kvKey2FileName(v)
+				if err := w.writeEntry(name, v, v.Value); err != nil {
+					return err
+				}
+				mf.add(name, v, v.Value)
+				if v.Lease != 0 {
+					if err := mf.addLease(client, v.Lease); err != nil {
+						return err
+					}
+				}
+				cnt++
+			}
+			return nil
+		})
+		if err != nil {
+			return cnt, err
+		}
+	}
+	return cnt, w.writeManifest(mf)
+}
+
+// convertArchive writes the entries of the TAR or ZIP archive (under the prefixes), and the manifest
+//   - NOTE: the archive is read twice, since the manifest (with the original keys) is its last entry
+func convertArchive(fname string, prefixes []string, w dumpWriter) (int, error) {
+	mf, err := readArchiveManifest(fname)
+	if err != nil {
+		return 0, err
+	}
+	var (
+		cnt int
+		out *manifest
+	)
+	if mf != nil {
+		// only the converted keys are kept in the manifest
+		out = &manifest{Tool: mf.Tool, Created: mf.Created, ClusterID: mf.ClusterID, MemberID: mf.MemberID, Revision: mf.Revision,
+			Since: mf.Since, Keys: make(map[string]*manifestKey), Leases: make(map[int64]*manifestLease)}
+	}
+	_, _, err = readArchive(fname, func(name string, data []byte) error {
+		if name == manifestName || strings.HasSuffix(name, "/") {
+			return nil
+		}
+		kv := &mvccpb.KeyValue{Key: []byte(mf.key(name)), Value: data}
+		if mk := mf.entry(name); mk != nil {
+			kv.CreateRevision, kv.ModRevision, kv.Version, kv.Lease = mk.CreateRevision, mk.ModRevision, mk.Version, mk.Lease
+		}
+		if !hasAnyPrefix(kv.Key, prefixes) {
+			return nil
+		}
+		if out != nil {
+			out.add(name, kv, data)
+			if le, has := mf.Leases[kv.Lease]; has {
+				out.Leases[kv.Lease] = le
+			}
+		}
+		cnt++
+		return w.writeEntry(name, kv, data)
+	})
+	if err != nil || out == nil {
+		return cnt, err
+	}
+	return cnt, w.writeManifest(out)
+}
+
+func actConvert(c *cli.Context) error {
+	if c.NArg() < 2 {
+		return fmt.Errorf("Must specify <input> and <output>")
+	}
+
+	var (
+		optIn     = c.Args().Get(0)
+		optOut    = c.Args().Get(1)
+		optFormat = c.String("format")
+		prefixes  = c.Args().Slice()[2:]
+		outFile   = optOut
+		cnt       int
+	)
+
+	if strings.HasSuffix(optOut, ".db") {
+		return fmt.Errorf("Cannot convert into a snapshot (import the keys into a cluster, then use snapshot save)")
+	} else if optFormat == "" {
+		if optFormat = convertFormat(optOut); optFormat == "" {
+			return fmt.Errorf("Cannot detect format of %s (use --format)", optOut)
+		}
+	}
+	if optFormat == "dir" {
+		return fmt.Errorf("Cannot convert into a directory (use untar or unzip, then dump)")
+	} else if optOut == "-" {
+		outFile = ""
+	}
+	if len(prefixes) <= 0 {
+		prefixes = []string{""}
+	}
+
+	isSnap, err := isSnapshotFile(optIn)
+	if err != nil {
+		return err
+	}
+	w, err := newDumpWriter(optFormat, "", outFile, false, c.Int("zstd-level"), 0, nil)
+	if err != nil {
+		return err
+	}
+	if isSnap {
+		logrus.Debugf("Converting snapshot %s into %s (%s)...", optIn, optOut, optFormat)
+		cnt, err = convertSnapshot(optIn, prefixes, w)
+	} else {
+		logrus.Debugf("Converting archive %s into %s (%s)...", optIn, optOut, optFormat)
+		cnt, err = convertArchive(optIn, prefixes, w)
+	}
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		if outFile != "" {
+			os.Remove(outFile)
+		}
+		return fmt.Errorf("Could not convert %s: %v", optIn, err)
+	}
+	logrus.Infof("Converted %d keys from %s into %s (%s).", cnt, optIn, optOut, optFormat)
+	return nil
+}
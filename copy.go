@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/mvcc/mvccpb"
+)
+
+// getTargetClient connects to the `--target-endpoints` cluster (with optional TLS and authentication)
+func getTargetClient(c *cli.Context) (*clientv3.Client, error) {
+	cfg := newEtcdClientConfig(c.String("target-endpoints"))
+
+	tlsCfg, err := newTLSConfig(c.String("target-endpoints"), c.String("target-cacert"), c.String("target-cert"), c.String("target-key"))
+	if err != nil {
+		return nil, err
+	}
+	cfg.TLS = tlsCfg
+	if u := c.String("target-user"); u != "" {
+		if cfg.Username, cfg.Password, err = parseUser(u, "", "--target-user user:password"); err != nil {
+			return nil, err
+		}
+	}
+
+	logrus.Debugf("Connecting to target %v...", cfg.Endpoints)
+	client, err := clientv3.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	client.KV = withOpTimeout(client.KV)
+	return client, nil
+}
+
+// targetFlags returns the `--target-*` flags, which connect to another cluster (see getTargetClient)
+func targetFlags(verb string) []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:  "target-endpoints",
+			Usage: verb + " the cluster at given endpoints (default is the same cluster)",
+		},
+		&cli.StringFlag{
+			Name:  "target-user",
+			Usage: "authenticate to the target cluster as given user (user[:password])",
+		},
+		&cli.StringFlag{
+			Name:  "target-cacert",
+			Usage: "verify the target cluster using given CA bundle",
+		},
+		&cli.StringFlag{
+			Name:  "target-cert",
+			Usage: "identify to the target cluster using given TLS certificate",
+		},
+		&cli.StringFlag{
+			Name:  "target-key",
+			Usage: "identify to the target cluster using given TLS key",
+		},
+	}
+}
+
+func actCopyKey(c *cli.Context) error {
+	if c.NArg() != 2 {
+		return fmt.Errorf("Must specify <src-key> <dst-key>")
+	}
+
+	var (
+		client    = getEtcdClient()
+		target    = client
+		optSrc    = c.Args().Get(0)
+		optDst    = c.Args().Get(1)
+		optEncode = c.Bool("e64")
+		optDecode = c.Bool("d64")
+		dbgOpts   = ""
+		err       error
+	)
+
+	if optEncode && optDecode {
+		return fmt.Errorf("Options --e64 and --d64 are mutually exclusive")
+	}
+	if c.String("target-endpoints") != "" {
+		if target, err = getTargetClient(c); err != nil {
+			return err
+		}
+		defer target.Close()
+	} else if optSrc == optDst {
+		return fmt.Errorf("Source and destination keys are the same")
+	}
+
+	logrus.Debugf("Doing GET(%s)...", optSrc)
+	res, err := client.Get(ctx, optSrc)
+	if err != nil {
+		return err
+	} else if len(res.Kvs) <= 0 {
+		return fmt.Errorf("Key %s not found", optSrc)
+	}
+
+	dbuf := res.Kvs[0].Value
+	if optEncode {
+		dbgOpts = ", b64 encoded"
+		ebuf := make([]byte, base64.StdEncoding.EncodedLen(len(dbuf)))
+		base64.StdEncoding.Encode(ebuf, dbuf)
+		dbuf = ebuf
+	} else if optDecode {
+		dbgOpts = ", b64-decoded"
+		if dbuf, err = base64.StdEncoding.DecodeString(string(dbuf)); err != nil {
+			return fmt.Errorf("Could not decode %s: %v", optSrc, err)
+		}
+	}
+
+	logrus.Debugf("Doing PUT(%s,XX)...", optDst)
+	if _, err = target.Put(ctx, optDst, string(dbuf)); err != nil {
+		return err
+	}
+	logrus.Infof("Copied %s to %s [%d%s]...", optSrc, optDst, len(dbuf), dbgOpts)
+	return nil
+}
+
+// copyChunk copies the keys into the new prefix within a single transaction (preserving the leases, unless copying
+// into another cluster)
+func copyChunk(client *clientv3.Client, chunk []*mvccpb.KeyValue, src, dst string, leases bool) error {
+	ops := make([]clientv3.Op, 0, len(chunk))
+	for _, v := range chunk {
+		var putOpts []clientv3.OpOption
+		if leases && v.Lease != 0 {
+			putOpts = append(putOpts, clientv3.WithLease(clientv3.LeaseID(v.Lease)))
+		}
+		ops = append(ops, clientv3.OpPut(dst+string(v.Key[len(src):]), string(v.Value), putOpts...))
+	}
+	logrus.Debugf("Doing TXN(%s..%s)...", chunk[0].Key, chunk[len(chunk)-1].Key)
+	_, err := client.Txn(ctx).Then(ops...).Commit()
+	return err
+}
+
+func actCopy(c *cli.Context) error {
+	if c.NArg() != 2 && (c.NArg() != 1 || c.String("target-endpoints") == "") {
+		return fmt.Errorf("Must specify <src> <dst>")
+	}
+
+	var (
+		client       = getEtcdClient()
+		target       = client
+		optSrc       = c.Args().Get(0)
+		optDst       = c.Args().Get(1)
+		optRecursive = c.Bool("recursive")
+		optDryRun    = c.Bool("dry-run")
+		limit        keyLimit
+		copied       int
+		sameCluster  = true
+		err          error
+	)
+
+	if c.String("target-endpoints") != "" {
+		if target, err = getTargetClient(c); err != nil {
+			return err
+		}
+		defer target.Close()
+		sameCluster = false
+		if optDst == "" {
+			optDst = optSrc
+		}
+	}
+
+	if !optRecursive {
+		if sameCluster && optSrc == optDst {
+			return fmt.Errorf("Source and destination keys are the same")
+		}
+		logrus.Debugf("Doing GET(%s)...", optSrc)
+		res, err := client.Get(ctx, optSrc)
+		if err != nil {
+			return err
+		} else if len(res.Kvs) <= 0 {
+			return fmt.Errorf("Key %s not found", optSrc)
+		} else if optDryRun {
+			fmt.Printf("%s -> %s\n", optSrc, optDst)
+			return nil
+		}
+		if err = copyChunk(target, res.Kvs, optSrc, optDst, sameCluster); err != nil {
+			return err
+		}
+		logrus.Infof("Copied %s to %s [%d]...", optSrc, optDst, len(res.Kvs[0].Value))
+		return nil
+	}
+
+	if sameCluster && (optSrc == "" || strings.HasPrefix(optDst, optSrc) || strings.HasPrefix(optSrc, optDst)) {
+		return fmt.Errorf("Prefixes '%s' and '%s' must not overlap", optSrc, optDst)
+	}
+
+	err = rangePages(client, optSrc, func(res *clientv3.GetResponse) error {
+		if err := limit.add(int64(len(res.Kvs))); err != nil {
+			return err
+		}
+		if optDryRun {
+			for _, v := range res.Kvs {
+				fmt.Printf("%s -> %s%s\n", v.Key, optDst, v.Key[len(optSrc):])
+			}
+			copied += len(res.Kvs)
+			return nil
+		}
+		for kvs := res.Kvs; len(kvs) > 0; {
+			n := maxTxnOps
+			if n > len(kvs) {
+				n = len(kvs)
+			}
+			if err := copyChunk(target, kvs[:n], optSrc, optDst, sameCluster); err != nil {
+				return err
+			}
+			copied += n
+			kvs = kvs[n:]
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Copied %d keys, then failed: %v", copied, err)
+	}
+
+	if optDryRun {
+		logrus.Infof("Would copy %d keys.", copied)
+	} else {
+		logrus.Infof("Copied %d keys.", copied)
+	}
+	return nil
+}
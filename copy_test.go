@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestCopyKeyTargetCluster(t *testing.T) {
+	var (
+		prefix    = testPrefix(t)
+		src       = prefix + "bin"
+		value     = "\x00\x01\xfe\xff binary\n"
+		e, target = newTestEtcd(t)
+		ep        = e.Clients[0].Addr().String()
+	)
+	putTestKeys(t, src, value)
+
+	getTarget := func(key string) string {
+		t.Helper()
+		res, err := target.Get(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		} else if len(res.Kvs) != 1 {
+			t.Fatalf("Expected %s in the target cluster", key)
+		}
+		return string(res.Kvs[0].Value)
+	}
+
+	// the same key name is allowed in another cluster, and the value bytes are preserved
+	mustRunApp(t, "copy-key", "--target-endpoints", ep, src, src)
+	if got := getTarget(src); got != value {
+		t.Errorf("Expected value %q, got %q", value, got)
+	}
+	if got := getTestKeys(t, prefix); len(got) != 1 {
+		t.Errorf("Expected the source cluster unchanged, got %v", got)
+	}
+
+	mustRunApp(t, "copy-key", "--target-endpoints", ep, "--e64", src, prefix+"b64")
+	if got, exp := getTarget(prefix+"b64"), base64.StdEncoding.EncodeToString([]byte(value)); got != exp {
+		t.Errorf("Expected the encoded value %q, got %q", exp, got)
+	}
+
+	if _, err := runApp(t, "copy-key", "--target-endpoints", ep, prefix+"missing", prefix+"x"); err == nil {
+		t.Error("Expected the copy of the missing key to fail")
+	}
+}
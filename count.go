@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"go.etcd.io/etcd/clientv3"
+)
+
+// countGroups counts the keys under the prefix, grouped by the first depth path components below the prefix
+//   - NOTE: only the first key of each group is read, the group itself is counted by the server (WithCountOnly),
+//     so the cost depends on the number of groups, not on the number of keys
+func countGroups(client *clientv3.Client, prefix string, depth int, rev *int64) ([]*duGroup, error) {
+	var (
+		groups []*duGroup
+		start  = prefix
+		end    = clientv3.GetPrefixRangeEnd(prefix)
+	)
+	if prefix == "" {
+		start, end = "\x00", "\x00"
+	}
+	for {
+		opts := []clientv3.OpOption{clientv3.WithRange(end), clientv3.WithLimit(1), clientv3.WithKeysOnly()}
+		if *rev > 0 {
+			opts = append(opts, clientv3.WithRev(*rev))
+		}
+		logrus.Debugf("Doing GET(%q..%q,limit=1,rev=%d)...", start, end, *rev)
+		res, err := client.Get(ctx, start, opts...)
+		if err != nil {
+			return nil, err
+		} else if *rev <= 0 {
+			*rev = res.Header.Revision
+		}
+		if len(res.Kvs) <= 0 {
+			return groups, nil
+		}
+
+		key := string(res.Kvs[0].Key)
+		g := &duGroup{name: prefix + keyGroup(key[len(prefix):], depth), keys: 1}
+		if g.name == key && !strings.HasSuffix(key, "/") {
+			// a single key (not a subtree)
+			start = key + "\x00"
+		} else {
+			logrus.Debugf("Doing GET(%s,prefix,count-only,rev=%d)...", g.name, *rev)
+			res, err = client.Get(ctx, g.name, clientv3.WithPrefix(), clientv3.WithCountOnly(), clientv3.WithRev(*rev))
+			if err != nil {
+				return nil, err
+			}
+			g.keys = res.Count
+			start = clientv3.GetPrefixRangeEnd(g.name)
+		}
+		groups = append(groups, g)
+		if start == "\x00" || (end != "\x00" && start >= end) {
+			return groups, nil
+		}
+	}
+}
+
+func actCount(c *cli.Context) error {
+	args, err := keyArgs(c, "count")
+	if err != nil {
+		return err
+	}
+
+	var (
+		client   = getEtcdClient()
+		optDepth = c.Int("depth")
+		optSort  = c.String("sort")
+		tw       = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		rev      int64
+	)
+	if optDepth < 0 {
+		return fmt.Errorf("Invalid --depth %d", optDepth)
+	} else if optSort != "name" && optSort != "keys" {
+		return fmt.Errorf("Invalid sort order '%s' (expected name or keys)", optSort)
+	}
+
+	fmt.Fprintf(tw, "KEYS\tPREFIX\n")
+	for _, a := range args {
+		name := a
+		if name == "" {
+			name = "total"
+		}
+		if optDepth > 0 {
+			groups, err := countGroups(client, a, optDepth, &rev)
+			if err != nil {
+				return err
+			}
+			sortGroups(groups, optSort)
+			var total int64
+			for _, g := range groups {
+				fmt.Fprintf(tw, "%d\t%s\n", g.keys, g.name)
+				total += g.keys
+			}
+			fmt.Fprintf(tw, "%d\t%s\n", total, name)
+			continue
+		}
+		opts := []clientv3.OpOption{clientv3.WithPrefix(), clientv3.WithCountOnly()}
+		if a == "" {
+			opts = []clientv3.OpOption{clientv3.WithFromKey(), clientv3.WithCountOnly()}
+			a = "\x00"
+		}
+		if rev > 0 {
+			opts = append(opts, clientv3.WithRev(rev))
+		}
+		logrus.Debugf("Doing GET(%s,prefix,count-only,rev=%d)...", a, rev)
+		res, err := client.Get(ctx, a, opts...)
+		if err != nil {
+			return err
+		}
+		rev = res.Header.Revision
+		fmt.Fprintf(tw, "%d\t%s\n", res.Count, name)
+	}
+	logrus.Debugf("Counted at revision %d", rev)
+	return tw.Flush()
+}
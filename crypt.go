@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// encMagic starts the encrypted archives (`--encrypt` option)
+var encMagic = []byte("ETCDENC\x01")
+
+const (
+	encChunkSize = 64 * 1024 // the plaintext is encrypted in chunks, each sealed with AES-256-GCM
+	encSaltLen   = 16
+	encHeaderLen = 8 + 1 + encSaltLen // magic, key type, salt
+
+	encKeyRaw        = 0 // `--encryption-key-file` option
+	encKeyPassphrase = 1 // `--passphrase-file` option (or prompted)
+)
+
+// archiveEncryptor encrypts the archives (`--encrypt` or `--recipient` options)
+type archiveEncryptor interface {
+	encrypt(out io.WriteCloser) (io.WriteCloser, error)
+}
+
+// archiveKey is the secret used to encrypt the archives (the actual key is derived for the salt of each archive)
+type archiveKey struct {
+	kind    byte
+	secret  []byte
+	derived map[string]cipher.AEAD
+}
+
+// archiveKeys caches the loaded secrets (so the passphrase is only prompted once)
+var archiveKeys = make(map[byte]*archiveKey)
+
+// readKeyFile reads the encryption key (32 raw bytes, or 64 hexadecimal characters)
+func readKeyFile(fname string) ([]byte, error) {
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	if s := strings.TrimSpace(string(data)); len(s) == 2*32 {
+		if key, err := hex.DecodeString(s); err == nil {
+			return key, nil
+		}
+	}
+	if len(data) != 32 {
+		return nil, fmt.Errorf("Invalid key file %s (expecting 32 bytes, or 64 hexadecimal characters)", fname)
+	}
+	return data, nil
+}
+
+// readPassphrase reads the passphrase from the `--passphrase-file`, or prompts for it on the terminal
+func readPassphrase(confirm bool) ([]byte, error) {
+	if opt.passphraseFile != "" {
+		data, err := ioutil.ReadFile(opt.passphraseFile)
+		if err != nil {
+			return nil, err
+		}
+		pass := bytes.TrimRight(bytes.SplitN(data, []byte("\n"), 2)[0], "\r")
+		if len(pass) <= 0 {
+			return nil, fmt.Errorf("Empty passphrase in %s", opt.passphraseFile)
+		}
+		return pass, nil
+	} else if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+		return nil, fmt.Errorf("Cannot prompt for the passphrase (use the --passphrase-file or --encryption-key-file option)")
+	}
+	prompts := []string{"Passphrase: "}
+	if confirm {
+		prompts = append(prompts, "Retype passphrase: ")
+	}
+	var pass []byte
+	for i, prompt := range prompts {
+		fmt.Fprint(logrus.StandardLogger().Out, prompt)
+		buf, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(logrus.StandardLogger().Out)
+		if err != nil {
+			return nil, err
+		} else if i > 0 && !bytes.Equal(buf, pass) {
+			return nil, fmt.Errorf("Passphrases do not match")
+		}
+		pass = buf
+	}
+	if len(pass) <= 0 {
+		return nil, fmt.Errorf("Empty passphrase")
+	}
+	return pass, nil
+}
+
+// loadArchiveKey returns the secret of given kind (the `--encryption-key-file`, or the passphrase)
+func loadArchiveKey(kind byte, confirm bool) (*archiveKey, error) {
+	if k := archiveKeys[kind]; k != nil {
+		return k, nil
+	}
+	var (
+		secret []byte
+		err    error
+	)
+	if kind == encKeyRaw {
+		if opt.encKeyFile == "" {
+			return nil, fmt.Errorf("The archive is encrypted with a key file (use the --encryption-key-file option)")
+		}
+		secret, err = readKeyFile(opt.encKeyFile)
+	} else {
+		secret, err = readPassphrase(confirm)
+	}
+	if err != nil {
+		return nil, err
+	}
+	k := &archiveKey{kind: kind, secret: secret, derived: make(map[string]cipher.AEAD)}
+	archiveKeys[kind] = k
+	return k, nil
+}
+
+// encryptionKey returns the secret to encrypt the archives (`--encrypt` option)
+//   - the `--encryption-key-file` takes precedence, otherwise the passphrase is used
+func encryptionKey() (*archiveKey, error) {
+	if opt.encKeyFile != "" {
+		return loadArchiveKey(encKeyRaw, false)
+	}
+	return loadArchiveKey(encKeyPassphrase, true)
+}
+
+// aead returns the cipher for the archive with given salt
+func (k *archiveKey) aead(salt []byte) (cipher.AEAD, error) {
+	if a := k.derived[string(salt)]; a != nil {
+		return a, nil
+	}
+	key := make([]byte, 32)
+	if k.kind == encKeyPassphrase {
+		var err error
+		if key, err = scrypt.Key(k.secret, salt, 1<<15, 8, 1, len(key)); err != nil {
+			return nil, err
+		}
+	} else if _, err := io.ReadFull(hkdf.New(sha256.New, k.secret, salt, encMagic), key); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	a, err := cipher.NewGCM(block)
+	if err == nil {
+		k.derived[string(salt)] = a
+	}
+	return a, err
+}
+
+// chunkNonce returns the nonce of the n-th chunk (the last chunk is marked, so the truncation is detected)
+func chunkNonce(n uint64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], n)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// encryptWriter encrypts the archive (`--encrypt` option)
+//   - NOTE: the chunks are authenticated together with the header, and only the last chunk may be shorter
+type encryptWriter struct {
+	out  io.WriteCloser
+	aead cipher.AEAD
+	hdr  []byte
+	buf  []byte
+	n    uint64
+}
+
+func newEncryptWriter(out io.WriteCloser, key *archiveKey) (*encryptWriter, error) {
+	hdr := make([]byte, encHeaderLen)
+	copy(hdr, encMagic)
+	hdr[len(encMagic)] = key.kind
+	if _, err := io.ReadFull(rand.Reader, hdr[len(encMagic)+1:]); err != nil {
+		return nil, err
+	}
+	a, err := key.aead(hdr[len(encMagic)+1:])
+	if err != nil {
+		return nil, err
+	}
+	if _, err = out.Write(hdr); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{out: out, aead: a, hdr: hdr}, nil
+}
+
+func (k *archiveKey) encrypt(out io.WriteCloser) (io.WriteCloser, error) {
+	return newEncryptWriter(out, k)
+}
+
+// seal encrypts and writes out the chunk
+func (ew *encryptWriter) seal(chunk []byte, last bool) error {
+	_, err := ew.out.Write(ew.aead.Seal(nil, chunkNonce(ew.n, last), chunk, ew.hdr))
+	ew.n++
+	return err
+}
+
+func (ew *encryptWriter) Write(p []byte) (int, error) {
+	ew.buf = append(ew.buf, p...)
+	// keep the full chunk buffered, since it might be the last one
+	for len(ew.buf) > encChunkSize {
+		if err := ew.seal(ew.buf[:encChunkSize], false); err != nil {
+			return 0, err
+		}
+		ew.buf = ew.buf[encChunkSize:]
+	}
+	return len(p), nil
+}
+
+func (ew *encryptWriter) Close() error {
+	err := ew.seal(ew.buf, true)
+	if cerr := ew.out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// openEncrypted parses the header of the encrypted archive, and returns the cipher
+func openEncrypted(hdr []byte) (cipher.AEAD, error) {
+	if len(hdr) < encHeaderLen || !bytes.Equal(hdr[:len(encMagic)], encMagic) {
+		return nil, fmt.Errorf("Invalid header of the encrypted archive")
+	}
+	kind := hdr[len(encMagic)]
+	if kind != encKeyRaw && kind != encKeyPassphrase {
+		return nil, fmt.Errorf("Unsupported key type %d of the encrypted archive", kind)
+	}
+	logrus.Debugf("Decrypting archive (key type: %d)...", kind)
+	key, err := loadArchiveKey(kind, false)
+	if err != nil {
+		return nil, err
+	}
+	return key.aead(hdr[len(encMagic)+1 : encHeaderLen])
+}
+
+// decryptError is returned when the chunk fails to authenticate
+func decryptError(n uint64) error {
+	return fmt.Errorf("Could not decrypt chunk %d (wrong passphrase/key, or corrupted archive)", n)
+}
+
+// decryptReaderAt decrypts the archive with random access (e.g. ZIP archives)
+type decryptReaderAt struct {
+	in     io.ReaderAt
+	aead   cipher.AEAD
+	hdr    []byte
+	size   int64 // the size of the decrypted content
+	chunks int64
+	cur    int64 // the chunk in buf
+	buf    []byte
+}
+
+func newDecryptReaderAt(in io.ReaderAt, inSize int64) (*decryptReaderAt, error) {
+	hdr := make([]byte, encHeaderLen)
+	if _, err := in.ReadAt(hdr, 0); err != nil {
+		return nil, err
+	}
+	a, err := openEncrypted(hdr)
+	if err != nil {
+		return nil, err
+	}
+	full := int64(encChunkSize + a.Overhead())
+	encSize := inSize - encHeaderLen
+	chunks := (encSize + full - 1) / full
+	lastLen := encSize - (chunks-1)*full
+	if chunks <= 0 || lastLen < int64(a.Overhead()) {
+		return nil, fmt.Errorf("Truncated encrypted archive")
+	}
+	return &decryptReaderAt{
+		in:     in,
+		aead:   a,
+		hdr:    hdr,
+		size:   (chunks-1)*encChunkSize + lastLen - int64(a.Overhead()),
+		chunks: chunks,
+		cur:    -1,
+	}, nil
+}
+
+// chunk decrypts the n-th chunk (caching the last decrypted one)
+func (dr *decryptReaderAt) chunk(n int64) ([]byte, error) {
+	if n == dr.cur {
+		return dr.buf, nil
+	}
+	full := int64(encChunkSize + dr.aead.Overhead())
+	cbuf := make([]byte, full)
+	cnt, err := dr.in.ReadAt(cbuf, encHeaderLen+n*full)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	plain, err := dr.aead.Open(cbuf[:0], chunkNonce(uint64(n), n == dr.chunks-1), cbuf[:cnt], dr.hdr)
+	if err != nil {
+		return nil, decryptError(uint64(n))
+	}
+	dr.cur, dr.buf = n, plain
+	return plain, nil
+}
+
+func (dr *decryptReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	total := 0
+	for len(p) > 0 {
+		if off >= dr.size {
+			return total, io.EOF
+		}
+		plain, err := dr.chunk(off / encChunkSize)
+		if err != nil {
+			return total, err
+		}
+		n := copy(p, plain[off%encChunkSize:])
+		total += n
+		off += int64(n)
+		p = p[n:]
+	}
+	return total, nil
+}
+
+// decryptReader decrypts the archive streamed from the input (e.g. STDIN)
+type decryptReader struct {
+	in   *bufio.Reader
+	aead cipher.AEAD
+	hdr  []byte
+	n    uint64
+	buf  []byte
+	done bool
+	err  error // the errors are sticky (e.g. bufio.Reader only returns the error once)
+}
+
+func (dr *decryptReader) Read(p []byte) (int, error) {
+	for len(dr.buf) <= 0 {
+		if dr.err != nil {
+			return 0, dr.err
+		} else if dr.done {
+			return 0, io.EOF
+		}
+		cbuf := make([]byte, encChunkSize+dr.aead.Overhead())
+		cnt, err := io.ReadFull(dr.in, cbuf)
+		if err == io.EOF {
+			dr.err = io.ErrUnexpectedEOF // the last chunk is missing
+			continue
+		} else if err == io.ErrUnexpectedEOF {
+			dr.done = true
+		} else if err != nil {
+			dr.err = err
+			continue
+		} else if _, err = dr.in.Peek(1); err == io.EOF {
+			dr.done = true
+		}
+		if dr.buf, err = dr.aead.Open(cbuf[:0], chunkNonce(dr.n, dr.done), cbuf[:cnt], dr.hdr); err != nil {
+			dr.err = decryptError(dr.n)
+			continue
+		}
+		dr.n++
+	}
+	n := copy(p, dr.buf)
+	dr.buf = dr.buf[n:]
+	return n, nil
+}
+
+// decryptingReader wraps the input into the decryptor, if the content is encrypted (also to the recipients)
+func decryptingReader(in io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(in)
+	if format := peekRecipientFormat(br); format != "" {
+		return recipientDecryptReader(br, format)
+	} else if hdr, _ := br.Peek(len(encMagic)); !bytes.Equal(hdr, encMagic) {
+		return br, nil
+	}
+	hdr := make([]byte, encHeaderLen)
+	if _, err := io.ReadFull(br, hdr); err != nil {
+		return nil, err
+	}
+	a, err := openEncrypted(hdr)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{in: br, aead: a, hdr: hdr}, nil
+}
+
+// isEncrypted checks if the content starts with the encMagic
+func isEncrypted(in io.ReaderAt) bool {
+	hdr := make([]byte, len(encMagic))
+	_, err := in.ReadAt(hdr, 0)
+	return err == nil && bytes.Equal(hdr, encMagic)
+}
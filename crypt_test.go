@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// resetArchiveKeys drops the cached encryption keys and identities (the tests use several key files)
+func resetArchiveKeys() {
+	archiveKeys = make(map[byte]*archiveKey)
+	identities.loaded, identities.age, identities.gpg = false, nil, nil
+}
+
+// putRandomKeys puts the keys with the random (incompressible) values of given size, and returns the key-values
+func putRandomKeys(t *testing.T, prefix string, n, size int) map[string]string {
+	t.Helper()
+	var (
+		rnd = rand.New(rand.NewSource(int64(n)))
+		ret = make(map[string]string)
+		kvs []string
+	)
+	for i := 0; i < n; i++ {
+		buf := make([]byte, size)
+		rnd.Read(buf)
+		key := fmt.Sprintf("%sk%02d", prefix, i)
+		kvs, ret[key] = append(kvs, key, string(buf)), string(buf)
+	}
+	putTestKeys(t, kvs...)
+	return ret
+}
+
+// readDecrypted reads the entries of the (encrypted) archive via openArchive, as TAR or ZIP
+func readDecrypted(fname, format string) (map[string]string, error) {
+	var (
+		ret = make(map[string]string)
+		fn  = func(name string, data []byte) error {
+			if name != manifestName {
+				ret[name] = string(data)
+			}
+			return nil
+		}
+	)
+	if format == "zip" {
+		_, _, err := readZip(fname, fn)
+		return ret, err
+	}
+	af, err := openArchive(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer af.Close()
+	_, _, err = readTar(af, "auto", fn)
+	return ret, err
+}
+
+// sameEntries checks the entries read from the archive against the key-values
+func sameEntries(got, exp map[string]string) bool {
+	if len(got) != len(exp) {
+		return false
+	}
+	for k, v := range exp {
+		if got[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	var (
+		prefix   = testPrefix(t)
+		dir      = t.TempDir()
+		keyFile  = filepath.Join(dir, "backup.key")
+		hexFile  = filepath.Join(dir, "backup.hex")
+		badKey   = filepath.Join(dir, "other.key")
+		passFile = filepath.Join(dir, "pass.txt")
+		badPass  = filepath.Join(dir, "other.txt")
+		key      = make([]byte, 32)
+	)
+	defer func() { opt = testOpt }()
+	// several chunks of the encrypted content
+	exp := putRandomKeys(t, prefix, 10, 20<<10)
+	rand.New(rand.NewSource(1)).Read(key)
+	writeTestFiles(t, dir,
+		"backup.key", string(key),
+		"backup.hex", hex.EncodeToString(key)+"\n",
+		"other.key", strings.Repeat("k", 32),
+		"pass.txt", "secret passphrase\nignored line\n",
+		"other.txt", "wrong passphrase\n")
+
+	for _, tc := range []struct {
+		name     string
+		args     []string // the options of the encryption
+		decrypt  func()   // sets the options of the decryption
+		wrongKey func()
+	}{
+		{"key file", []string{"--encryption-key-file", keyFile},
+			func() { opt.encKeyFile = keyFile }, func() { opt.encKeyFile = badKey }},
+		// same key as the raw key file
+		{"hex key file", []string{"--encryption-key-file", hexFile},
+			func() { opt.encKeyFile = keyFile }, func() { opt.encKeyFile = badKey }},
+		{"passphrase", []string{"--passphrase-file", passFile},
+			func() { opt.passphraseFile = passFile }, func() { opt.passphraseFile = badPass }},
+	} {
+		for _, format := range []string{"tar", "tar.gz", "zip"} {
+			fname := filepath.Join(dir, strings.Replace(tc.name, " ", "-", -1)+"."+format)
+			mustRunApp(t, append(tc.args, "dump", "--format", format, "--encrypt", "-f", fname, prefix)...)
+			if ok, err := fileHasPrefix(fname, encMagic); err != nil || !ok {
+				t.Fatalf("%s: expected the encrypted %s archive (%v)", tc.name, format, err)
+			}
+
+			opt = testOpt
+			resetArchiveKeys()
+			if _, err := readDecrypted(fname, format); err == nil || !strings.Contains(err.Error(), "use the --") {
+				t.Errorf("%s: expected the %s archive to require the key, got %v", tc.name, format, err)
+			}
+
+			resetArchiveKeys()
+			tc.decrypt()
+			if got, err := readDecrypted(fname, format); err != nil {
+				t.Errorf("%s: could not read the %s archive: %v", tc.name, format, err)
+			} else if !sameEntries(got, exp) {
+				t.Errorf("%s: expected %d entries of the %s archive, got %d", tc.name, len(exp), format, len(got))
+			}
+
+			resetArchiveKeys()
+			tc.wrongKey()
+			if _, err := readDecrypted(fname, format); err == nil || !strings.Contains(err.Error(), "Could not decrypt chunk") {
+				t.Errorf("%s: expected the wrong key to fail the %s archive, got %v", tc.name, format, err)
+			}
+		}
+	}
+
+	// the restored keys are identical
+	mustRunApp(t, "--encryption-key-file", keyFile, "untar", "-f", filepath.Join(dir, "key-file.tar.gz"), "--prefix", prefix+"restored")
+	if got := getTestKeys(t, prefix+"restored"); len(got) != len(exp) || got[prefix+"restored"+prefix+"k03"] != exp[prefix+"k03"] {
+		t.Errorf("Expected %d keys restored, got %d", len(exp), len(got))
+	}
+}
+
+func TestReadKeyFile(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir,
+		"raw.key", strings.Repeat("\x01", 32),
+		"hex.key", "  "+strings.Repeat("0a", 32)+"\r\n",
+		"short.key", strings.Repeat("k", 31),
+		"badhex.key", strings.Repeat("zz", 32))
+
+	if key, err := readKeyFile(filepath.Join(dir, "raw.key")); err != nil || string(key) != strings.Repeat("\x01", 32) {
+		t.Errorf("Expected the raw key, got %x (%v)", key, err)
+	}
+	if key, err := readKeyFile(filepath.Join(dir, "hex.key")); err != nil || string(key) != strings.Repeat("\n", 32) {
+		t.Errorf("Expected the hex key, got %x (%v)", key, err)
+	}
+	for _, f := range []string{"short.key", "badhex.key", "missing.key"} {
+		if _, err := readKeyFile(filepath.Join(dir, f)); err == nil {
+			t.Errorf("Expected %s to fail", f)
+		}
+	}
+
+	opt.passphraseFile = filepath.Join(dir, "empty.txt")
+	writeTestFiles(t, dir, "empty.txt", "\nsecond line\n")
+	defer func() { opt = testOpt }()
+	if _, err := readPassphrase(false); err == nil || !strings.Contains(err.Error(), "Empty passphrase") {
+		t.Errorf("Expected the empty passphrase to fail, got %v", err)
+	}
+}
+
+func TestEncryptTampered(t *testing.T) {
+	var (
+		prefix  = testPrefix(t)
+		dir     = t.TempDir()
+		keyFile = filepath.Join(dir, "backup.key")
+		fname   = filepath.Join(dir, "backup.tar")
+	)
+	putRandomKeys(t, prefix, 10, 20<<10)
+	writeTestFiles(t, dir, "backup.key", strings.Repeat("k", 32))
+	mustRunApp(t, "--encryption-key-file", keyFile, "dump", "--format", "tar", "--encrypt", "-f", fname, prefix)
+	orig, err := ioutil.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	full := encChunkSize + 16 // the GCM tag
+	if chunks := (len(orig) - encHeaderLen + full - 1) / full; chunks < 3 {
+		t.Fatalf("Expected at least 3 chunks, got %d", chunks)
+	}
+
+	flip := func(off int) []byte {
+		buf := append([]byte{}, orig...)
+		buf[off] ^= 0x01
+		return buf
+	}
+	for _, tc := range []struct {
+		name string
+		data []byte
+	}{
+		{"modified chunk", flip(encHeaderLen + full + 100)},
+		{"modified salt", flip(len(encMagic) + 1)},
+		{"modified tag", flip(len(orig) - 1)},
+		// the remaining chunks are intact, but the last one is not marked as last
+		{"truncated at the chunk", orig[:encHeaderLen+full]},
+		{"truncated in the chunk", orig[:encHeaderLen+full+1000]},
+		{"missing chunks", orig[:encHeaderLen]},
+		{"repeated chunk", append(append(append([]byte{}, orig[:encHeaderLen]...), orig[encHeaderLen+full:encHeaderLen+2*full]...),
+			orig[encHeaderLen+full:]...)},
+	} {
+		tname := filepath.Join(dir, "tampered.tar")
+		if err = ioutil.WriteFile(tname, tc.data, 0666); err != nil {
+			t.Fatal(err)
+		}
+		opt.encKeyFile = keyFile
+
+		// both the random access, and the streamed decryption (e.g. from the STDIN) fail
+		resetArchiveKeys()
+		if _, err = readDecrypted(tname, "tar"); err == nil {
+			t.Errorf("%s: expected the archive to fail", tc.name)
+		}
+		resetArchiveKeys()
+		f, err := os.Open(tname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, err = readTar(f, "auto", func(string, []byte) error { return nil })
+		f.Close()
+		if err == nil {
+			t.Errorf("%s: expected the streamed archive to fail", tc.name)
+		}
+	}
+	opt = testOpt
+
+	// the command detects it as well
+	if _, err = runApp(t, "--encryption-key-file", keyFile, "verify-archive", "-f", filepath.Join(dir, "tampered.tar")); err == nil {
+		t.Error("Expected the verification of the tampered archive to fail")
+	} else if _, err = runApp(t, "--encryption-key-file", keyFile, "verify-archive", "-f", fname); err != nil {
+		t.Errorf("Expected the original archive to verify: %v", err)
+	}
+}
+
+func TestEncryptSplit(t *testing.T) {
+	var (
+		prefix  = testPrefix(t)
+		dir     = t.TempDir()
+		keyFile = filepath.Join(dir, "backup.key")
+		fname   = filepath.Join(dir, "split.tar")
+	)
+	exp := putRandomKeys(t, prefix, 10, 20<<10)
+	writeTestFiles(t, dir, "backup.key", strings.Repeat("k", 32))
+	mustRunApp(t, "--encryption-key-file", keyFile, "dump", "--format", "tar", "--encrypt", "--split-size", "65536", "-f", fname, prefix)
+	if vols := countVolumes(fname); vols < 3 {
+		t.Fatalf("Expected at least 3 volumes, got %d", vols)
+	} else if ok, err := fileHasPrefix(volumeName(fname, 0), encMagic); err != nil || !ok {
+		t.Fatalf("Expected the first volume encrypted (%v)", err)
+	}
+
+	opt.encKeyFile = keyFile
+	defer func() { opt = testOpt }()
+	resetArchiveKeys()
+	if got, err := readDecrypted(fname, "tar"); err != nil || !sameEntries(got, exp) {
+		t.Errorf("Expected %d entries of the split archive, got %d (%v)", len(exp), len(got), err)
+	}
+
+	// the modified volume fails the archive
+	vname := volumeName(fname, 1)
+	buf, err := ioutil.ReadFile(vname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf[len(buf)/2] ^= 0x01
+	if err = ioutil.WriteFile(vname, buf, 0666); err != nil {
+		t.Fatal(err)
+	}
+	resetArchiveKeys()
+	if _, err = readDecrypted(fname, "tar"); err == nil || !strings.Contains(err.Error(), "Could not decrypt chunk") {
+		t.Errorf("Expected the modified volume to fail the archive, got %v", err)
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"go.etcd.io/etcd/clientv3"
+)
+
+// keySet are the values of the keys under a prefix, indexed by the keys relative to the prefix
+type keySet map[string][]byte
+
+// loadPrefix reads the keys under the prefix from the cluster (in pages)
+func loadPrefix(client *clientv3.Client, prefix string, limit *keyLimit) (keySet, error) {
+	var rev int64
+	return loadPrefixAt(client, prefix, &rev, limit)
+}
+
+// loadPrefixAt reads the keys under the prefix at the revision (if 0, the revision of the first page is returned)
+func loadPrefixAt(client *clientv3.Client, prefix string, rev *int64, limit *keyLimit) (keySet, error) {
+	ks := make(keySet)
+	err := rangePagesAt(client, prefix, rev, func(res *clientv3.GetResponse) error {
+		if err := limit.add(int64(len(res.Kvs))); err != nil {
+			return err
+		}
+		for _, v := range res.Kvs {
+			ks[string(v.Key[len(prefix):])] = v.Value
+		}
+		return nil
+	})
+	return ks, err
+}
+
+// add adds the dumped entry, if the key (restored via the manifest) is under the prefix
+func (ks keySet) add(mf *manifest, prefix, name string, data []byte) {
+	if k := mf.key(name); strings.HasPrefix(k, prefix) {
+		ks[k[len(prefix):]] = data
+	}
+}
+
+// loadArchive reads the keys under the prefix from the TAR or ZIP archive
+func loadArchive(fname, prefix string) (keySet, error) {
+	var (
+		names []string
+		datas [][]byte
+		mf    *manifest
+	)
+	_, _, err := readArchive(fname, func(name string, data []byte) (err error) {
+		if name == manifestName {
+			mf, err = parseManifest(data)
+		} else if !strings.HasSuffix(name, "/") {
+			names, datas = append(names, name), append(datas, data)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	// the manifest is the last entry, so the file-names are converted at the end
+	ks := make(keySet)
+	for i, name := range names {
+		ks.add(mf, prefix, name, datas[i])
+	}
+	return ks, nil
+}
+
+// loadDir reads the keys under the prefix from the dumped directory (also decompresses the `dump --zstd` files)
+func loadDir(dir, prefix string) (keySet, error) {
+	dir = filepath.Clean(dir)
+	mf, err := readDirManifest(dir)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read manifest of %s: %v", dir, err)
+	}
+	ks := make(keySet)
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		} else if !info.Mode().IsRegular() || info.Name() == manifestName {
+			return nil
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if strings.HasSuffix(path, zstdExt) && bytes.HasPrefix(data, zstdMagic) {
+			if data, err = zstdDecompress(data); err != nil {
+				return fmt.Errorf("Could not decompress %s: %v", path, err)
+			}
+			path = strings.TrimSuffix(path, zstdExt)
+		}
+		ks.add(mf, prefix, localPath2Key(path[len(dir)+1:]), data)
+		return nil
+	})
+	return ks, err
+}
+
+// printChange prints the changed value as the line diff (or notes that the binary values differ)
+func printChange(key string, a, b []byte) {
+	fmt.Printf("@@ %s @@\n", key)
+	if !utf8.Valid(a) || !utf8.Valid(b) {
+		fmt.Printf(" (binary values differ [%d -> %d bytes])\n", len(a), len(b))
+		return
+	}
+	for _, l := range lineDiff(string(a), string(b)) {
+		fmt.Printf("%s\n", l)
+	}
+}
+
+func actDiff(c *cli.Context) error {
+	if c.NArg() < 1 || c.NArg() > 2 {
+		return fmt.Errorf("Must specify <prefix-a> [prefix-b]")
+	}
+
+	var (
+		client     = getEtcdClient()
+		target     = client
+		optFile    = c.String("f")
+		optDir     = c.String("directory")
+		optBrief   = c.Bool("brief")
+		prefixA    = c.Args().Get(0)
+		prefixB    = c.Args().Get(1)
+		nameA      = prefixA
+		nameB      string
+		a, b       keySet
+		limit      keyLimit
+		added      int
+		removed    int
+		changed    int
+		keys       []string
+		err        error
+		sameSource = optFile == "" && optDir == "" && c.String("target-endpoints") == ""
+	)
+
+	if prefixB == "" {
+		if sameSource {
+			return fmt.Errorf("Must specify <prefix-b> (or compare with -f archive, -C dir or --target-endpoints)")
+		}
+		prefixB = prefixA
+	}
+	nameB = prefixB
+
+	if c.String("target-endpoints") != "" {
+		if target, err = getTargetClient(c); err != nil {
+			return err
+		}
+		defer target.Close()
+		nameB = c.String("target-endpoints") + ":" + prefixB
+	}
+
+	switch {
+	case optFile != "" && optDir != "":
+		return fmt.Errorf("Options -f and -C are mutually exclusive")
+	case optFile != "":
+		nameA = optFile + ":" + prefixA
+		a, err = loadArchive(optFile, prefixA)
+	case optDir != "":
+		nameA = optDir + ":" + prefixA
+		a, err = loadDir(optDir, prefixA)
+	default:
+		a, err = loadPrefix(client, prefixA, &limit)
+	}
+	if err != nil {
+		return fmt.Errorf("Could not read %s: %v", nameA, err)
+	}
+	if b, err = loadPrefix(target, prefixB, &limit); err != nil {
+		return fmt.Errorf("Could not read %s: %v", nameB, err)
+	}
+
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, has := a[k]; !has {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		va, inA := a[k]
+		vb, inB := b[k]
+		if inA && inB && bytes.Equal(va, vb) {
+			continue
+		}
+		if added+removed+changed == 0 && !optBrief {
+			fmt.Printf("--- %s\n+++ %s\n", nameA, nameB)
+		}
+		switch {
+		case !inB:
+			removed++
+			if optBrief {
+				fmt.Printf("removed: %s\n", prefixA+k)
+			} else {
+				fmt.Printf("-%s\n", prefixA+k)
+			}
+		case !inA:
+			added++
+			if optBrief {
+				fmt.Printf("added: %s\n", prefixB+k)
+			} else {
+				fmt.Printf("+%s\n", prefixB+k)
+			}
+		default:
+			changed++
+			if optBrief {
+				fmt.Printf("changed: %s\n", prefixB+k)
+			} else {
+				printChange(prefixB+k, va, vb)
+			}
+		}
+	}
+
+	logrus.Infof("Compared %d keys: %d added, %d removed, %d changed", len(keys), added, removed, changed)
+	if added+removed+changed > 0 {
+		return fmt.Errorf("%s and %s differ", nameA, nameB)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"go.etcd.io/etcd/clientv3"
+)
+
+// duGroup is the size accounting of the keys sharing the first path components (`du` command)
+type duGroup struct {
+	name string
+	keys int64
+	size int64
+}
+
+// sortGroups sorts the groups by the name, size or number of keys (the largest first)
+func sortGroups(groups []*duGroup, by string) error {
+	var less func(a, b *duGroup) bool
+	switch by {
+	case "name":
+		less = func(a, b *duGroup) bool { return a.name < b.name }
+	case "size":
+		less = func(a, b *duGroup) bool { return a.size > b.size || (a.size == b.size && a.name < b.name) }
+	case "keys":
+		less = func(a, b *duGroup) bool { return a.keys > b.keys || (a.keys == b.keys && a.name < b.name) }
+	default:
+		return fmt.Errorf("Invalid sort order '%s' (expected name, size or keys)", by)
+	}
+	sort.Slice(groups, func(i, j int) bool { return less(groups[i], groups[j]) })
+	return nil
+}
+
+func actDu(c *cli.Context) error {
+	var (
+		client   = getEtcdClient()
+		args     = c.Args().Slice()
+		optDepth = c.Int("depth")
+		optSort  = c.String("sort")
+		optHuman = c.Bool("human")
+		tw       = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		limit    keyLimit
+	)
+	if optDepth < 1 {
+		return fmt.Errorf("Invalid --depth %d (must be at least 1)", optDepth)
+	} else if err := sortGroups(nil, optSort); err != nil {
+		return err
+	}
+	if len(args) <= 0 {
+		// the whole keyspace
+		args = []string{""}
+	}
+	size := func(n int64) string {
+		if optHuman {
+			return humanSize(n)
+		}
+		return strconv.FormatInt(n, 10)
+	}
+
+	fmt.Fprintf(tw, "SIZE\tKEYS\tPREFIX\n")
+	for _, a := range args {
+		var (
+			byName = make(map[string]*duGroup)
+			groups []*duGroup
+			total  = &duGroup{name: a}
+		)
+		// the values are read page by page, only the sizes are kept
+		err := rangePages(client, a, func(res *clientv3.GetResponse) error {
+			if err := limit.add(int64(len(res.Kvs))); err != nil {
+				return err
+			}
+			for _, v := range res.Kvs {
+				name := a + keyGroup(string(v.Key)[len(a):], optDepth)
+				g := byName[name]
+				if g == nil {
+					g = &duGroup{name: name}
+					byName[name] = g
+					groups = append(groups, g)
+				}
+				g.keys++
+				g.size += int64(len(v.Value))
+				total.keys++
+				total.size += int64(len(v.Value))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		sortGroups(groups, optSort)
+		for _, g := range groups {
+			fmt.Fprintf(tw, "%s\t%d\t%s\n", size(g.size), g.keys, g.name)
+		}
+		if total.name == "" {
+			total.name = "total"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", size(total.size), total.keys, total.name)
+		logrus.Debugf("Found %d keys in %d groups of %s", total.keys, len(groups), total.name)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,689 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dsnet/compress/bzip2"
+	"github.com/sirupsen/logrus"
+	"github.com/ulikunitz/xz"
+	"github.com/urfave/cli"
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/mvcc/mvccpb"
+)
+
+// dumpFormats are the output formats supported by the `dump --format` option
+var dumpFormats = []string{"dir", "tar", "tar.gz", "tar.zst", "tar.xz", "tar.bz2", "zip", "json", "ndjson"}
+
+// dumpWriter writes the dumped entries in one of the dumpFormats
+type dumpWriter interface {
+	// writeEntry writes the (processed) value of the key-value under given file-name
+	writeEntry(name string, kv *mvccpb.KeyValue, value []byte) error
+	// writeManifest writes the manifest of the dumped keys (no-op for the formats which keep the metadata)
+	writeManifest(m *manifest) error
+	Close() error
+}
+
+// dirWriter writes the entries as files into a directory (`--format dir`)
+type dirWriter struct {
+	dir       string
+	zstdLevel int // zstd-compress the files, if > 0
+}
+
+func (dw *dirWriter) writeEntry(name string, kv *mvccpb.KeyValue, value []byte) error {
+	var (
+		fname = filepath.Join(dw.dir, key2LocalPath(name))
+		err   error
+	)
+	if err = os.MkdirAll(filepath.Dir(fname), 0777); err != nil {
+		return err
+	}
+	if dw.zstdLevel > 0 {
+		if value, err = zstdCompress(value, dw.zstdLevel); err != nil {
+			return err
+		}
+		fname += zstdExt
+	}
+	if err = ioutil.WriteFile(fname, value, 0666); err != nil {
+		return err
+	}
+	logrus.Infof("Wrote %s [%d]...", fname, len(value))
+	return nil
+}
+
+// writeManifest writes the manifest into the directory, keeping the entries of the previous (e.g. `--since-file`) dumps
+func (dw *dirWriter) writeManifest(m *manifest) error {
+	prev, err := readDirManifest(dw.dir)
+	if err != nil {
+		logrus.WithError(err).Warnf("Replacing invalid manifest in %s", dw.dir)
+	} else if prev != nil {
+		for name, mk := range prev.Keys {
+			if _, has := m.Keys[name]; !has {
+				m.Keys[name] = mk
+			}
+		}
+	}
+	buf, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	fname := filepath.Join(dw.dir, manifestName)
+	if err = os.MkdirAll(filepath.Dir(fname), 0777); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fname, buf, 0666)
+}
+
+func (dw *dirWriter) Close() error { return nil }
+
+// tarWriter writes the entries into the (optionally compressed) TAR archive (`--format tar|tar.gz|tar.zst|tar.xz|tar.bz2`)
+type tarWriter struct {
+	tw      *tar.Writer
+	closers []io.Closer // closed in reverse order, after the TAR writer
+}
+
+func (t *tarWriter) writeFile(name string, value []byte) error {
+	header := new(tar.Header)
+	header.Name = name
+	header.Size = int64(len(value))
+	header.Mode = 0666
+	header.ModTime = time.Now()
+	if err := t.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := io.Copy(t.tw, bytes.NewReader(value))
+	return err
+}
+
+func (t *tarWriter) writeEntry(name string, kv *mvccpb.KeyValue, value []byte) error {
+	if err := t.writeFile(name, value); err != nil {
+		return err
+	}
+	logrus.Infof("Add %s [%d]...", kv.Key, len(value))
+	return nil
+}
+
+func (t *tarWriter) writeManifest(m *manifest) error {
+	buf, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return t.writeFile(manifestName, buf)
+}
+
+func (t *tarWriter) Close() error {
+	err := t.tw.Close()
+	for i := len(t.closers) - 1; i >= 0; i-- {
+		if cerr := t.closers[i].Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// zipWriter writes the entries into the ZIP archive (`--format zip`)
+type zipWriter struct {
+	zw  *zip.Writer
+	out io.Closer
+}
+
+func (z *zipWriter) writeFile(name string, value []byte) error {
+	f, err := z.zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(value)
+	return err
+}
+
+func (z *zipWriter) writeEntry(name string, kv *mvccpb.KeyValue, value []byte) error {
+	if err := z.writeFile(name, value); err != nil {
+		return err
+	}
+	logrus.Infof("Add %s [%d]...", kv.Key, len(value))
+	return nil
+}
+
+func (z *zipWriter) writeManifest(m *manifest) error {
+	buf, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return z.writeFile(manifestName, buf)
+}
+
+func (z *zipWriter) Close() error {
+	err := z.zw.Close()
+	if cerr := z.out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// ndjsonWriter writes the entries as JSON records, one per line (`--format ndjson`)
+//   - NOTE: the records keep the original keys (not the file-names), so they can be imported back
+type ndjsonWriter struct {
+	enc *json.Encoder
+	out io.Closer
+}
+
+func (n *ndjsonWriter) writeEntry(name string, kv *mvccpb.KeyValue, value []byte) error {
+	rec := newKvRecord(kv)
+	rec.Value = value
+	if err := n.enc.Encode(rec); err != nil {
+		return err
+	}
+	logrus.Debugf("Add %s [%d]...", kv.Key, len(value))
+	return nil
+}
+
+func (n *ndjsonWriter) writeManifest(m *manifest) error { return nil }
+
+func (n *ndjsonWriter) Close() error { return n.out.Close() }
+
+// jsonWriter writes the entries as a JSON array of records (`--format json`)
+type jsonWriter struct {
+	arr *jsonArrayWriter
+	out io.Closer
+}
+
+func (j *jsonWriter) writeEntry(name string, kv *mvccpb.KeyValue, value []byte) error {
+	rec := newKvRecord(kv)
+	rec.Value = value
+	if err := j.arr.write(rec); err != nil {
+		return err
+	}
+	logrus.Debugf("Add %s [%d]...", kv.Key, len(value))
+	return nil
+}
+
+func (j *jsonWriter) writeManifest(m *manifest) error { return nil }
+
+func (j *jsonWriter) Close() error {
+	err := j.arr.Close()
+	if cerr := j.out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// newDumpWriter creates the writer for given format (the archive formats write into the file, or STDOUT if empty)
+//   - the archives are split into the volumes of splitSize bytes, if > 0
+//   - the archives are encrypted using the enc, if not nil
+func newDumpWriter(format, dir, fname string, zstd bool, zstdLevel int, splitSize int64, enc archiveEncryptor) (dumpWriter, error) {
+	valid := false
+	for _, f := range dumpFormats {
+		valid = valid || f == format
+	}
+	if !valid {
+		return nil, fmt.Errorf("Invalid format '%s' (expected one of %s)", format, strings.Join(dumpFormats, ", "))
+	}
+
+	if format == "dir" {
+		if fname != "" {
+			return nil, fmt.Errorf("Use -C <dir> for the dir format (-f is for the archive formats)")
+		}
+		if splitSize > 0 {
+			return nil, fmt.Errorf("The --split-size option cannot be used with the dir format")
+		} else if !zstd {
+			zstdLevel = 0
+		}
+		return &dirWriter{dir: dir, zstdLevel: zstdLevel}, nil
+	} else if dir != "" {
+		return nil, fmt.Errorf("Use -f <file> for the %s format (-C is for the dir format)", format)
+	} else if zstd {
+		if format != "tar" {
+			return nil, fmt.Errorf("The --zstd option cannot be used with the %s format", format)
+		}
+		format = "tar.zst"
+	}
+
+	out := io.WriteCloser(nopWriteCloser{os.Stdout})
+	if splitSize > 0 {
+		if fname == "" {
+			return nil, fmt.Errorf("Must specify output file (-f file) when splitting the archive")
+		} else if format == "json" || format == "ndjson" {
+			return nil, fmt.Errorf("The --split-size option cannot be used with the %s format (use the export --split-by-size)", format)
+		}
+		out = &volumeWriter{fname: fname, maxSize: splitSize}
+	} else if fname != "" {
+		f, err := createOutput(fname)
+		if err != nil {
+			return nil, err
+		}
+		out = f
+	} else if format == "zip" {
+		return nil, fmt.Errorf("Must specify output file (-f file)")
+	}
+	raw := out
+	if enc != nil {
+		ew, err := enc.encrypt(out)
+		if err != nil {
+			out.Close()
+			return nil, err
+		}
+		out = ew
+	}
+
+	w, err := newArchiveWriter(format, out, zstdLevel)
+	if err != nil {
+		return nil, err
+	} else if a, ok := raw.(outputAborter); ok {
+		return &abortableWriter{dumpWriter: w, out: a}, nil
+	}
+	return w, nil
+}
+
+// newArchiveWriter creates the writer of the archive format into the output (the output is closed on failure)
+func newArchiveWriter(format string, out io.WriteCloser, zstdLevel int) (dumpWriter, error) {
+	switch format {
+	case "tar":
+		return &tarWriter{tw: tar.NewWriter(out), closers: []io.Closer{out}}, nil
+	case "tar.gz":
+		zw := gzip.NewWriter(out)
+		return &tarWriter{tw: tar.NewWriter(zw), closers: []io.Closer{out, zw}}, nil
+	case "tar.zst":
+		zw, err := newZstdWriter(out, zstdLevel)
+		if err != nil {
+			out.Close()
+			return nil, err
+		}
+		return &tarWriter{tw: tar.NewWriter(zw), closers: []io.Closer{out, zw}}, nil
+	case "tar.xz":
+		zw, err := xz.NewWriter(out)
+		if err != nil {
+			out.Close()
+			return nil, err
+		}
+		return &tarWriter{tw: tar.NewWriter(zw), closers: []io.Closer{out, zw}}, nil
+	case "tar.bz2":
+		zw, err := bzip2.NewWriter(out, nil)
+		if err != nil {
+			out.Close()
+			return nil, err
+		}
+		return &tarWriter{tw: tar.NewWriter(zw), closers: []io.Closer{out, zw}}, nil
+	case "zip":
+		return &zipWriter{zw: zip.NewWriter(out), out: out}, nil
+	case "json":
+		return &jsonWriter{arr: &jsonArrayWriter{out: out}, out: out}, nil
+	}
+	return &ndjsonWriter{enc: json.NewEncoder(out), out: out}, nil
+}
+
+// abortableWriter can discard the partially written archive of the failed dump (see abortDump)
+type abortableWriter struct {
+	dumpWriter
+	out outputAborter // the file, volumes or upload below the compression and encryption
+}
+
+// abortDump closes the writer of the failed dump -- the partial archive is discarded (the local file is removed, and
+// the remote upload is not completed), so the failed dump does not leave a truncated archive behind
+func abortDump(w dumpWriter, err error) {
+	if aw, ok := w.(*abortableWriter); ok {
+		aw.out.abort(err)
+	}
+	w.Close()
+}
+
+// dumpEntryName returns the file-name of the dumped key (`--strip` option keeps only the base-name)
+//   - the `--strip-level N` option removes N leading path components (returns "" if the key is not deep enough)
+func dumpEntryName(kv *mvccpb.KeyValue, strip bool, level int) string {
+	name := kvKey2FileName(kv)
+	if strip {
+		return path.Base(name)
+	} else if level <= 0 {
+		return name
+	}
+	parts := strings.Split(strings.TrimPrefix(name, "/"), "/")
+	if len(parts) <= level {
+		return ""
+	}
+	return strings.Join(parts[level:], "/")
+}
+
+// hasAnyPrefix checks if the key starts with any of the prefixes
+func hasAnyPrefix(key []byte, prefixes []string) bool {
+	for _, p := range prefixes {
+		if bytes.HasPrefix(key, []byte(p)) {
+			return true
+		}
+	}
+	return false
+}
+
+// runDump dumps the keys in given format into the file (or URL) -- this is shared by the dump, tar, zip and backupd
+// commands
+func runDump(c *cli.Context, client *clientv3.Client, op, format, optFile string) error {
+	args, err := keyArgs(c, op)
+	if err != nil {
+		return err
+	}
+
+	var (
+		optDecode  = c.Bool("d64")
+		optStrip   = c.Bool("strip")
+		optLevel   = c.Int("strip-level")
+		optExclude = c.StringSlice("exclude-prefix")
+		optCont    = c.Bool("continue-on-error") || !opt.failFast
+		optSince   = c.String("since-file")
+		optSinceRv = c.String("since-rev")
+		opts       []clientv3.OpOption
+		failed     = failedKeys{op: op}
+		lastRev    int64
+		curRev     int64
+		names      = make(map[string]string)
+		progress   = newProgressFile(c.String("progress-file"), op)
+		limit      keyLimit
+		mf         = newManifest()
+	)
+
+	if optStrip && optLevel > 0 {
+		return fmt.Errorf("Options --strip and --strip-level are mutually exclusive")
+	}
+	filter, err := newKeyFilter(c)
+	if err != nil {
+		return err
+	}
+
+	if s := c.String("rev"); s != "" {
+		if optSince != "" {
+			return fmt.Errorf("Options --rev and --since-file are mutually exclusive")
+		} else if curRev, err = parseRevision(s); err != nil {
+			return err
+		}
+		logrus.Infof("Dumping keys at revision %d", curRev)
+	}
+
+	if optSince != "" && optSinceRv != "" {
+		return fmt.Errorf("Options --since-file and --since-rev are mutually exclusive")
+	} else if optSince != "" {
+		if lastRev, err = readRevisionFile(optSince); err != nil {
+			return err
+		}
+	} else if optSinceRv != "" {
+		if lastRev, err = parseRevision(optSinceRv); err != nil {
+			return err
+		}
+	}
+	if lastRev > 0 {
+		logrus.Infof("Dumping keys modified after revision %d", lastRev)
+		opts = append(opts, clientv3.WithMinModRev(lastRev+1))
+		mf.Since = lastRev
+	}
+
+	// the keys are counted up front, so the dump exceeding the --max-keys fails before writing anything
+	//   - NOTE: the count ignores the revision filters, so the incremental dumps are only checked page by page
+	var total int64
+	if progress != nil || opt.maxKeys > 0 {
+		total = countPrefixes(client, args)
+	}
+	if lastRev <= 0 {
+		if err = new(keyLimit).add(total); err != nil {
+			return err
+		}
+	}
+
+	var enc archiveEncryptor
+	if recipients := c.StringSlice("recipient"); len(recipients) > 0 || c.Bool("encrypt") {
+		if format == "dir" {
+			return fmt.Errorf("The dir format cannot be encrypted")
+		} else if len(recipients) > 0 && c.Bool("encrypt") {
+			return fmt.Errorf("Options --encrypt and --recipient are mutually exclusive")
+		} else if len(recipients) > 0 {
+			enc, err = parseRecipients(recipients)
+		} else {
+			enc, err = encryptionKey()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	var w dumpWriter
+	if optOutTpl := c.String("output-template-file"); optOutTpl != "" {
+		if format != "dir" || optFile != "" || c.String("directory") != "" || c.Bool("zstd") || enc != nil {
+			return fmt.Errorf("The --output-template-file option cannot be combined with -f, -C, --format, --zstd or encryption")
+		}
+		tmpl, err := newKeyTemplate(c.String("template"), optOutTpl)
+		if err != nil {
+			return err
+		}
+		w = &templateWriter{tmpl: tmpl}
+	} else if c.String("template") != "" {
+		return fmt.Errorf("The --template option requires --output-template-file")
+	} else if w, err = newDumpWriter(format, c.String("directory"), optFile, c.Bool("zstd"), c.Int("zstd-level"),
+		c.Int64("split-size"), enc); err != nil {
+		return err
+	}
+
+	progress.setTotal(total)
+
+	writeFn := func(res *clientv3.GetResponse) error {
+		if err := limit.add(int64(len(res.Kvs))); err != nil {
+			return err
+		}
+		mf.setHeader(res)
+		for _, v := range res.Kvs {
+			if hasAnyPrefix(v.Key, optExclude) {
+				logrus.Debugf("Skipping %s (excluded)", v.Key)
+				continue
+			} else if !filter.match(v.Key) {
+				logrus.Debugf("Skipping %s (excluded or not matching)", v.Key)
+				continue
+			}
+			dbuf := v.Value
+			if optDecode {
+				dbuf = make([]byte, base64.StdEncoding.DecodedLen(len(v.Value)))
+				n, err := base64.StdEncoding.Decode(dbuf, v.Value)
+				if err != nil {
+					return fmt.Errorf("Could not decode %s: %v", v.Key, err)
+				}
+				dbuf = dbuf[:n]
+			}
+			name := dumpEntryName(v, optStrip, optLevel)
+			if name == "" {
+				logrus.Warnf("Skipping %s (not deeper than %d path components)", v.Key, optLevel)
+				continue
+			} else if optStrip || optLevel > 0 {
+				if prev, has := names[name]; has {
+					return fmt.Errorf("Keys %s and %s both map to %s after stripping", prev, v.Key, name)
+				}
+				names[name] = string(v.Key)
+			}
+			if err := w.writeEntry(name, v, dbuf); err != nil {
+				return err
+			}
+			mf.add(name, v, dbuf)
+			if v.Lease != 0 {
+				if err := mf.addLease(client, v.Lease); err != nil {
+					return fmt.Errorf("Could not get TTL of lease %x of %s: %v", v.Lease, v.Key, err)
+				}
+			}
+			progress.add(len(dbuf))
+		}
+		return nil
+	}
+
+	// readKeys reads the rest of the prefix key by key (after a page could not be read), so that only the unreadable
+	// keys are skipped in the --continue-on-error mode
+	var werr error
+	readKeys := func(start, end string) error {
+		return rangePagesFrom(client, start, end, &curRev, func(res *clientv3.GetResponse) error {
+			for _, v := range res.Kvs {
+				logrus.Debugf("Doing GET(%s,rev=%d)...", v.Key, curRev)
+				kres, err := client.Get(ctx, string(v.Key), clientv3.WithRev(curRev))
+				if err != nil {
+					failed.add(string(v.Key), err)
+					continue
+				} else if len(kres.Kvs) <= 0 {
+					continue
+				}
+				if werr = writeFn(kres); werr != nil {
+					return werr
+				}
+			}
+			return nil
+		}, append(opts, clientv3.WithKeysOnly())...)
+	}
+
+	for _, a := range args {
+		var lastKey []byte
+		logrus.Debugf("Doing DUMP(%s,%s,%#v)...", a, format, opts)
+		// all the prefixes are read at the same revision, so the dump is point-in-time consistent
+		err := rangePagesAt(client, a, &curRev, func(res *clientv3.GetResponse) error {
+			if werr = writeFn(res); werr == nil {
+				lastKey = res.Kvs[len(res.Kvs)-1].Key
+			}
+			return werr
+		}, opts...)
+		if err != nil && werr == nil && optCont && !limit.exceeded() {
+			logrus.WithError(err).Warnf("Could not read the keys of %s, reading them one by one", a)
+			start, end := a, clientv3.GetPrefixRangeEnd(a)
+			if a == "" {
+				start, end = "\x00", "\x00"
+			}
+			if lastKey != nil {
+				start = string(lastKey) + "\x00"
+			}
+			if err = readKeys(start, end); err != nil && werr == nil && !limit.exceeded() {
+				failed.add(a, err)
+				continue
+			}
+		}
+		if err != nil {
+			abortDump(w, err)
+			return err
+		}
+	}
+
+	mf.Revision = curRev
+	if err = w.writeManifest(mf); err != nil {
+		abortDump(w, err)
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	progress.done()
+	if optFile != "" {
+		logrus.Infof("Done writing %s (revision %d%s)", optFile, curRev, mf.incremental())
+		err = failed.result(optFile + ".errors")
+	} else {
+		err = failed.result("")
+	}
+	if err != nil {
+		return err
+	}
+
+	if optSince != "" && curRev > 0 {
+		return writeRevisionFile(optSince, curRev)
+	}
+	return nil
+}
+
+func actDump(c *cli.Context) error {
+	return runDump(c, getEtcdClient(), "dump", c.String("format"), c.String("f"))
+}
+
+// tarCompressions maps the compression options of the tar command to the dump formats (same as the GNU tar flags)
+var tarCompressions = []struct{ flag, format string }{
+	{"z", "tar.gz"}, {"J", "tar.xz"}, {"j", "tar.bz2"},
+}
+
+// actTar is the (deprecated) tar command, which forwards to `dump --format tar|tar.gz|tar.zst|tar.xz|tar.bz2`
+func actTar(c *cli.Context) error {
+	logrus.Warn("The tar command is deprecated, please use dump --format tar")
+	var (
+		format = "tar"
+		opts   []string
+	)
+	if c.Bool("zstd") {
+		opts = append(opts, "--zstd")
+	}
+	for _, tc := range tarCompressions {
+		if c.Bool(tc.flag) {
+			opts = append(opts, "-"+tc.flag)
+			format = tc.format
+		}
+	}
+	if len(opts) > 1 {
+		return fmt.Errorf("Options %s are mutually exclusive", strings.Join(opts, " and "))
+	}
+	return runDump(c, getEtcdClient(), "tar", format, c.String("f"))
+}
+
+// actZip is the (deprecated) zip command, which forwards to `dump --format zip`
+func actZip(c *cli.Context) error {
+	logrus.Warn("The zip command is deprecated, please use dump --format zip")
+	return runDump(c, getEtcdClient(), "zip", "zip", c.String("f"))
+}
+
+// dumpFlags are the flags shared by the dump, tar and zip commands
+func dumpFlags() []cli.Flag {
+	return append([]cli.Flag{
+		&cli.BoolFlag{
+			Name:  "all",
+			Usage: "process the whole keyspace",
+		},
+		&cli.BoolFlag{
+			Name:  "d64",
+			Usage: "perform base64 decoding",
+		},
+		&cli.BoolFlag{
+			Name:  "strip",
+			Usage: "strip path(s) of the key",
+		},
+		&cli.IntFlag{
+			Name:  "strip-level",
+			Usage: "strip given number of leading path components of the key",
+		},
+		&cli.StringSliceFlag{
+			Name:  "exclude-prefix",
+			Usage: "skip the keys with given prefix (can be repeated)",
+		},
+		&cli.StringFlag{
+			Name:  "rev",
+			Usage: "dump the keys at given (historical) revision",
+		},
+		&cli.StringFlag{
+			Name:  "since-rev",
+			Usage: "dump only keys modified after given revision (e.g. the revision recorded in the manifest of the previous backup)",
+		},
+		&cli.StringFlag{
+			Name:  "since-file",
+			Usage: "dump only keys modified since the revision recorded in given file (and update the file)",
+		},
+		&cli.StringFlag{
+			Name:  "progress-file",
+			Usage: "periodically write the progress (as JSON) into given file",
+		},
+		&cli.BoolFlag{
+			Name:  "continue-on-error",
+			Usage: "skip the keys that fail to read (listed in <file>.errors)",
+		},
+		&cli.BoolFlag{
+			Name:  "encrypt",
+			Usage: "encrypt the archive (AES-256-GCM; see the global --passphrase-file and --encryption-key-file options)",
+		},
+		&cli.StringSliceFlag{
+			Name:  "recipient",
+			Usage: "encrypt the archive to given age public key, or file with the age or GPG public keys (can be repeated)",
+		},
+		&cli.Int64Flag{
+			Name:  "split-size",
+			Usage: "split the archive into volumes of given max size (bytes; written as <file>.000, <file>.001, etc.)",
+		},
+	}, keyFilterFlags()...)
+}
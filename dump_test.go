@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/urfave/cli"
+	"go.etcd.io/etcd/clientv3"
+)
+
+// failingKV fails the reads of the failKey (and of the ranges including it), except for the keys-only reads
+type failingKV struct {
+	clientv3.KV
+	failKey string
+}
+
+func (kv *failingKV) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	op := clientv3.OpGet(key, opts...)
+	end := string(op.RangeBytes())
+	if !op.IsKeysOnly() && (key == kv.failKey || (end != "" && kv.failKey > key && (end == "\x00" || kv.failKey < end))) {
+		return nil, fmt.Errorf("Injected read failure")
+	}
+	return kv.KV.Get(ctx, key, opts...)
+}
+
+func TestDumpContinueOnError(t *testing.T) {
+	var (
+		prefix = testPrefix(t)
+		fname  = filepath.Join(t.TempDir(), "backup.tar")
+		app    = newApp()
+	)
+	putTestKeys(t, prefix+"k1", "v1", prefix+"k2", "v2", prefix+"k3", "v3")
+	app.Command("tar").Action = func(c *cli.Context) error {
+		client := getEtcdClient()
+		client.KV = &failingKV{KV: client.KV, failKey: prefix + "k2"}
+		return runDump(c, client, "tar", "tar", c.String("f"))
+	}
+
+	if _, err := runCmd(t, app, "tar", "--continue-on-error", "-f", fname, prefix); err == nil {
+		t.Fatal("Expected the tar command to fail")
+	}
+	entries := readTestArchive(t, fname)
+	if len(entries) != 2 || entries[prefix+"k1"] != "v1" || entries[prefix+"k3"] != "v3" {
+		t.Errorf("Expected k1 and k3 in the archive, got %v", entries)
+	}
+	buf, err := ioutil.ReadFile(fname + ".errors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(buf)), "\n"); len(lines) != 1 || !strings.HasPrefix(lines[0], prefix+"k2\t") {
+		t.Errorf("Expected only k2 in the errors file, got %q", buf)
+	}
+
+	// without --continue-on-error, the whole archive fails (and is removed)
+	if _, err := runCmd(t, app, "tar", "-f", fname+".2", prefix); err == nil {
+		t.Fatal("Expected the tar command to fail")
+	} else if _, err = os.Stat(fname + ".2"); !os.IsNotExist(err) {
+		t.Errorf("Expected the failed archive removed, got %v", err)
+	}
+}
+
+// failAfterKV fails the reads after the first n ones (except for the count-only reads)
+type failAfterKV struct {
+	clientv3.KV
+	n int
+}
+
+func (kv *failAfterKV) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	if !clientv3.OpGet(key, opts...).IsCountOnly() {
+		if kv.n <= 0 {
+			return nil, fmt.Errorf("Injected read failure")
+		}
+		kv.n--
+	}
+	return kv.KV.Get(ctx, key, opts...)
+}
+
+func TestDumpAbort(t *testing.T) {
+	var (
+		prefix  = testPrefix(t)
+		dir     = t.TempDir()
+		app     = newApp()
+		kvs     []string
+		mu      sync.Mutex
+		objects = make(map[string]int)
+	)
+	// the second page fails, so the first page is already written when the dump fails
+	for i := 0; i < 1200; i++ {
+		kvs = append(kvs, fmt.Sprintf("%sk%04d", prefix, i), strings.Repeat("v", 100))
+	}
+	putTestKeys(t, kvs...)
+	app.Command("dump").Action = func(c *cli.Context) error {
+		client := getEtcdClient()
+		client.KV = &failAfterKV{KV: client.KV, n: 1}
+		return runDump(c, client, "dump", c.String("format"), c.String("f"))
+	}
+
+	// the server keeps only the completely received uploads
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, err := io.Copy(ioutil.Discard, r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		objects[r.URL.Path] = int(n)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	for _, args := range [][]string{
+		{"--format", "tar.gz", "-f", filepath.Join(dir, "backup.tar.gz")},
+		{"--format", "tar", "-f", filepath.Join(dir, "split.tar"), "--split-size", "65536"},
+		{"--format", "tar.gz", "-f", srv.URL + "/backups/backup.tar.gz"},
+	} {
+		if _, err := runCmd(t, app, append(append([]string{"dump"}, args...), prefix)...); err == nil {
+			t.Fatalf("Expected the dump %v to fail", args)
+		}
+	}
+	if files, err := ioutil.ReadDir(dir); err != nil {
+		t.Fatal(err)
+	} else if len(files) != 0 {
+		t.Errorf("Expected the partial archives removed, got %d files (e.g. %s)", len(files), files[0].Name())
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(objects) != 0 {
+		t.Errorf("Expected the upload not completed, got %v", objects)
+	}
+}
+
+func TestDumpSinceFile(t *testing.T) {
+	var (
+		prefix = testPrefix(t)
+		dir    = t.TempDir()
+		since  = filepath.Join(dir, "since.rev")
+	)
+	putTestKeys(t, prefix+"a", "1", prefix+"b", "2")
+	mustRunApp(t, "dump", "--format", "tar", "-f", filepath.Join(dir, "full.tar"), "--since-file", since, prefix)
+	if got := readTestArchive(t, filepath.Join(dir, "full.tar")); len(got) != 2 {
+		t.Errorf("Expected the first dump to include all keys, got %v", got)
+	}
+
+	putTestKeys(t, prefix+"b", "22", prefix+"c", "3")
+	mustRunApp(t, "dump", "--format", "tar", "-f", filepath.Join(dir, "incr.tar"), "--since-file", since, prefix)
+	got := readTestArchive(t, filepath.Join(dir, "incr.tar"))
+	if len(got) != 2 || got[prefix+"b"] != "22" || got[prefix+"c"] != "3" {
+		t.Errorf("Expected only the changed keys b and c, got %v", got)
+	}
+
+	// nothing changed since the second dump
+	mustRunApp(t, "dump", "--format", "tar", "-f", filepath.Join(dir, "empty.tar"), "--since-file", since, prefix)
+	if got := readTestArchive(t, filepath.Join(dir, "empty.tar")); len(got) != 0 {
+		t.Errorf("Expected an empty incremental dump, got %v", got)
+	}
+}
+
+func TestDumpFormats(t *testing.T) {
+	var (
+		prefix = testPrefix(t)
+		dir    = t.TempDir()
+		exp    = map[string]string{prefix + "a": "1", prefix + "b/c": "2", prefix + "b/d/e": "3"}
+	)
+	putTestKeys(t, prefix+"a", "1", prefix+"b/c", "2", prefix+"b/d/e", "3")
+
+	for _, format := range []string{"tar", "tar.gz", "tar.zst", "tar.xz", "tar.bz2", "zip"} {
+		fname := filepath.Join(dir, "backup."+format)
+		mustRunApp(t, "dump", "--format", format, "-f", fname, prefix)
+		if got := readTestArchive(t, fname); !reflect.DeepEqual(got, exp) {
+			t.Errorf("%s: expected %v, got %v", format, exp, got)
+		}
+	}
+
+	// the deprecated tar and zip commands produce the same archives
+	for _, args := range [][]string{{"tar", "-z"}, {"zip"}} {
+		fname := filepath.Join(dir, "alias."+args[0])
+		mustRunApp(t, append(args, "-f", fname, prefix)...)
+		if got := readTestArchive(t, fname); !reflect.DeepEqual(got, exp) {
+			t.Errorf("%s: expected %v, got %v", args[0], exp, got)
+		}
+	}
+
+	for _, format := range []string{"json", "ndjson"} {
+		out := mustRunApp(t, "dump", "--format", format, prefix)
+		var recs []kvRecord
+		if format == "json" {
+			if err := json.Unmarshal([]byte(out), &recs); err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			for _, l := range strings.Split(strings.TrimSpace(out), "\n") {
+				var rec kvRecord
+				if err := json.Unmarshal([]byte(l), &rec); err != nil {
+					t.Fatal(err)
+				}
+				recs = append(recs, rec)
+			}
+		}
+		got := make(map[string]string)
+		for _, rec := range recs {
+			got[rec.Key] = string(rec.Value)
+		}
+		if !reflect.DeepEqual(got, exp) {
+			t.Errorf("%s: expected %v, got %v", format, exp, got)
+		}
+	}
+
+	mustRunApp(t, "dump", "-C", filepath.Join(dir, "files"), prefix)
+	got := make(map[string]string)
+	err := filepath.Walk(filepath.Join(dir, "files"), func(fname string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() || fi.Name() == manifestName {
+			return err
+		}
+		buf, err := ioutil.ReadFile(fname)
+		got[localPath2Key(strings.TrimPrefix(fname, filepath.Join(dir, "files")))] = string(buf)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(got, exp) {
+		t.Errorf("dir: expected %v, got %v", exp, got)
+	}
+}
+
+func TestDumpStripLevel(t *testing.T) {
+	var (
+		prefix = testPrefix(t)
+		dir    = t.TempDir()
+	)
+	// 4-level keys: /TestDumpStripLevel/<app>/<section>/<name>
+	putTestKeys(t, prefix+"web/conf/port", "80", prefix+"web/conf/host", "h", prefix+"db/data/size", "1", prefix+"short", "s")
+
+	mustRunApp(t, "dump", "-C", dir, "--strip-level", "2", prefix)
+	var files []string
+	err := filepath.Walk(dir, func(fname string, fi os.FileInfo, err error) error {
+		if err == nil && !fi.IsDir() && fi.Name() != manifestName {
+			rel, _ := filepath.Rel(dir, fname)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the keys not deeper than 2 levels are skipped
+	if exp := []string{"conf/host", "conf/port", "data/size"}; !reflect.DeepEqual(files, exp) {
+		t.Errorf("Expected files %v, got %v", exp, files)
+	}
+
+	putTestKeys(t, prefix+"api/conf/port", "8080")
+	if _, err := runApp(t, "dump", "-C", t.TempDir(), "--strip-level", "2", prefix); err == nil || !strings.Contains(err.Error(), "both map to conf/port") {
+		t.Errorf("Expected the collision of the stripped names, got %v", err)
+	}
+}
+
+// slowKV delays the paged reads, and records the content of the progress file seen by each read
+type slowKV struct {
+	clientv3.KV
+	fname string
+	seen  []progressRecord
+}
+
+func (kv *slowKV) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	if !clientv3.OpGet(key, opts...).IsCountOnly() {
+		var rec progressRecord
+		if buf, err := ioutil.ReadFile(kv.fname); err == nil && json.Unmarshal(buf, &rec) == nil {
+			kv.seen = append(kv.seen, rec)
+		}
+		time.Sleep(progressInterval)
+	}
+	return kv.KV.Get(ctx, key, opts...)
+}
+
+func TestDumpProgressFile(t *testing.T) {
+	var (
+		prefix = testPrefix(t)
+		dir    = t.TempDir()
+		fname  = filepath.Join(dir, "progress.json")
+		kv     = &slowKV{fname: fname}
+		app    = newApp()
+		kvs    []string
+	)
+	for i := 0; i < 2500; i++ {
+		kvs = append(kvs, fmt.Sprintf("%sk%04d", prefix, i), "v")
+	}
+	putTestKeys(t, kvs...)
+	app.Command("dump").Action = func(c *cli.Context) error {
+		client := getEtcdClient()
+		kv.KV = client.KV
+		client.KV = kv
+		return runDump(c, client, "dump", c.String("format"), c.String("f"))
+	}
+
+	if _, err := runCmd(t, app, "dump", "--format", "tar", "-f", filepath.Join(dir, "backup.tar"), "--progress-file", fname,
+		prefix); err != nil {
+		t.Fatal(err)
+	}
+	if len(kv.seen) < 3 {
+		t.Fatalf("Expected the progress file to be written during the dump, got %+v", kv.seen)
+	}
+	for i, rec := range kv.seen {
+		if rec.Op != "dump" || rec.Total != 2500 || rec.Done {
+			t.Errorf("Unexpected progress during the dump: %+v", rec)
+		} else if i > 0 && rec.Processed <= kv.seen[i-1].Processed {
+			t.Errorf("Expected increasing counts, got %d after %d", rec.Processed, kv.seen[i-1].Processed)
+		}
+	}
+
+	buf, err := ioutil.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rec progressRecord
+	if err = json.Unmarshal(buf, &rec); err != nil {
+		t.Fatal(err)
+	} else if !rec.Done || rec.Processed != 2500 || rec.Bytes != 2500 {
+		t.Errorf("Expected the final progress of 2500 keys, got %+v", rec)
+	}
+}
+
+func TestDumpMaxKeys(t *testing.T) {
+	var (
+		prefix = testPrefix(t)
+		dir    = t.TempDir()
+		fname  = filepath.Join(dir, "backup.tar")
+		kvs    []string
+	)
+	// more than a page of keys, so the limit is exceeded only by the second page
+	for i := 0; i < 1500; i++ {
+		kvs = append(kvs, fmt.Sprintf("%sk%04d", prefix, i), "v")
+	}
+	putTestKeys(t, kvs...)
+
+	if _, err := runApp(t, "--max-keys", "1200", "dump", "--format", "tar", "-f", fname, prefix); err == nil {
+		t.Fatal("Expected the dump over --max-keys to fail")
+	} else if _, err = os.Stat(fname); !os.IsNotExist(err) {
+		t.Errorf("Expected no archive written, got %v", err)
+	}
+	if _, err := runApp(t, "--max-keys", "1200", "dump", "-C", filepath.Join(dir, "out"), prefix); err == nil {
+		t.Fatal("Expected the dump over --max-keys to fail")
+	} else if _, err = os.Stat(filepath.Join(dir, "out")); !os.IsNotExist(err) {
+		t.Errorf("Expected no files dumped, got %v", err)
+	}
+	if _, err := runApp(t, "--max-keys", "1200", "rm", "-f", prefix); err == nil {
+		t.Fatal("Expected the removal over --max-keys to fail")
+	} else if got := getTestKeys(t, prefix); len(got) != 1500 {
+		t.Errorf("Expected no keys deleted, got %d keys left", len(got))
+	}
+
+	mustRunApp(t, "--max-keys", "1500", "dump", "--format", "tar", "-f", fname, prefix)
+	if got := readTestArchive(t, fname); len(got) != 1500 {
+		t.Errorf("Expected 1500 keys in the archive, got %d", len(got))
+	}
+
+	// only the listed (exported) keys count toward the limit
+	if out := mustRunApp(t, "--max-keys", "100", "ls", "--match", "*/k00*", prefix); strings.Count(out, "\n") != 100 {
+		t.Errorf("Expected 100 keys listed, got %d", strings.Count(out, "\n"))
+	}
+	if out := mustRunApp(t, "--max-keys", "1000", "export", "--exclude", "*/k1*", prefix); strings.Count(out, "\n") != 1000 {
+		t.Errorf("Expected 1000 keys exported, got %d", strings.Count(out, "\n"))
+	}
+	if _, err := runApp(t, "--max-keys", "99", "ls", "--match", "*/k00*", prefix); err == nil {
+		t.Error("Expected the listing over --max-keys to fail")
+	}
+}
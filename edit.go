@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"go.etcd.io/etcd/clientv3"
+)
+
+// editorCommand returns the editor from the $VISUAL or $EDITOR variables (or vi)
+func editorCommand() string {
+	for _, v := range []string{"VISUAL", "EDITOR"} {
+		if s := strings.TrimSpace(os.Getenv(v)); s != "" {
+			return s
+		}
+	}
+	return "vi"
+}
+
+// runEditor opens the file in the editor
+//   - NOTE: the editor is run via shell, so it can include the arguments (e.g. EDITOR='code --wait')
+func runEditor(fname string) error {
+	editor := editorCommand()
+	logrus.Debugf("Running %s %s...", editor, fname)
+	cmd := exec.Command("sh", "-c", editor+` "$1"`, "sh", fname)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("Editor %s failed: %v", editor, err)
+	}
+	return nil
+}
+
+func actEdit(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("Must specify the key to edit")
+	}
+	var (
+		client    = getEtcdClient()
+		optDecode = c.Bool("d64")
+		key       = c.Args().Get(0)
+		dbuf      []byte
+	)
+
+	logrus.Debugf("Doing GET(%s)...", key)
+	res, err := client.Get(ctx, key)
+	if err != nil {
+		return err
+	} else if len(res.Kvs) <= 0 {
+		return fmt.Errorf("Key %s not found", key)
+	}
+	kv := res.Kvs[0]
+	dbuf = kv.Value
+	if optDecode {
+		if dbuf, err = base64.StdEncoding.DecodeString(string(kv.Value)); err != nil {
+			return fmt.Errorf("Could not decode %s: %v", key, err)
+		}
+	}
+
+	// the temporary file keeps the extension of the key (e.g. `.json`), for the syntax highlighting in the editor
+	f, err := ioutil.TempFile("", "etcdTool-*"+path.Ext(key))
+	if err != nil {
+		return err
+	}
+	fname := f.Name()
+	_, err = f.Write(dbuf)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(fname)
+		return err
+	}
+
+	if err = runEditor(fname); err != nil {
+		os.Remove(fname)
+		return err
+	}
+	nbuf, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(nbuf, dbuf) {
+		os.Remove(fname)
+		logrus.Infof("No changes to %s", key)
+		return nil
+	}
+
+	dbgOpts := ""
+	if optDecode {
+		dbgOpts = ", b64 encoded"
+		nbuf = []byte(base64.StdEncoding.EncodeToString(nbuf))
+	}
+	var putOpts []clientv3.OpOption
+	if kv.Lease != 0 {
+		// keep the key attached to its lease
+		putOpts = append(putOpts, clientv3.WithIgnoreLease())
+	}
+
+	// the value is written only if the key was not modified while editing
+	logrus.Debugf("Doing PUT(%s,rev=%d)...", key, kv.ModRevision)
+	tres, err := client.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(key), "=", kv.ModRevision)).
+		Then(clientv3.OpPut(key, string(nbuf), putOpts...)).
+		Commit()
+	if err != nil {
+		return fmt.Errorf("Could not write %s (the changes are kept in %s): %v", key, fname, err)
+	} else if !tres.Succeeded {
+		return fmt.Errorf("Key %s was modified concurrently (the changes are kept in %s)", key, fname)
+	}
+	os.Remove(fname)
+	logrus.Infof("Put %s [%d%s]...", key, len(nbuf), dbgOpts)
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+	"go.etcd.io/etcd/clientv3"
+)
+
+// readEndpointsFile reads the endpoints from the file (separated by commas, spaces or new-lines)
+func readEndpointsFile(fname string) (string, error) {
+	buf, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return "", err
+	}
+	eps := strings.FieldsFunc(string(buf), func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	})
+	if len(eps) <= 0 {
+		return "", fmt.Errorf("No endpoints found in %s", fname)
+	}
+	return strings.Join(eps, ","), nil
+}
+
+// watchEndpointsFile reconfigures the client's endpoints whenever the endpoints file changes
+//   - NOTE: we watch the parent directory, since editors and config-management tools often replace the file
+func watchEndpointsFile(client *clientv3.Client, fname string) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err = w.Add(filepath.Dir(fname)); err != nil {
+		w.Close()
+		return err
+	}
+	logrus.Debugf("Watching %s for endpoint changes...", fname)
+
+	go func() {
+		defer w.Close()
+		fname = filepath.Clean(fname)
+		for {
+			select {
+			case ev, ok := <-w.Events:
+				if !ok {
+					return
+				} else if filepath.Clean(ev.Name) != fname || ev.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+					continue
+				}
+				eps, err := readEndpointsFile(fname)
+				if err != nil {
+					logrus.WithError(err).Warnf("Could not reload endpoints from %s", fname)
+					continue
+				}
+				if eps, err = expandEnv(eps); err != nil {
+					logrus.WithError(err).Warnf("Could not reload endpoints from %s", fname)
+					continue
+				}
+				logrus.Infof("Reloaded endpoints %s", eps)
+				client.SetEndpoints(strings.Split(eps, ",")...)
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				logrus.WithError(err).Warnf("Error watching %s", fname)
+			case <-client.Ctx().Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
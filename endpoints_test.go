@@ -0,0 +1,50 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+func TestEndpointsFileWatch(t *testing.T) {
+	var (
+		e, other = newTestEtcd(t)
+		ep       = e.Clients[0].Addr().String()
+		fname    = filepath.Join(t.TempDir(), "endpoints")
+	)
+	if _, err := other.Put(ctx, "/only-other", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fname, []byte(testEndpoint+"\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	client, err := clientv3.New(clientv3.Config{Endpoints: []string{testEndpoint}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err = watchEndpointsFile(client, fname); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ioutil.WriteFile(fname, []byte(ep+"\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		res, err := client.Get(ctx, "/only-other")
+		if err == nil && len(res.Kvs) > 0 {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("Client did not reconnect to %s (endpoints %v, err %v)", ep, client.Endpoints(), err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if eps := client.Endpoints(); !reflect.DeepEqual(eps, []string{ep}) {
+		t.Errorf("Expected endpoints [%s], got %v", ep, eps)
+	}
+}
@@ -1,43 +1,83 @@
 package main
 
 import (
-	"archive/tar"
-	"archive/zip"
 	"bytes"
-	"compress/gzip"
 	"context"
+	"crypto/tls"
 	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
-	"path"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"text/tabwriter"
 	"time"
 	"unicode"
+	"unicode/utf8"
 
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/etcdserver/api/v3rpc/rpctypes"
 	"go.etcd.io/etcd/mvcc/mvccpb"
+	"go.etcd.io/etcd/pkg/transport"
+	"golang.org/x/crypto/ssh/terminal"
 )
 
 const (
 	version              = "1.5"
 	unicodeFractSlashStr = "\u2044" // reserved unicode char
+	maxTxnOps            = 128      // default etcd limit of operations in a transaction (--max-txn-ops)
+	countPageSize        = 1000     // page size for counting the keys
 )
 
+// zstdExt is the file extension of the zstd-compressed files (`dump --zstd` option)
+const zstdExt = ".zst"
+
+// encodingKeySuffix names the companion key, which records the encoding of the value (`--auto-encode` option)
+const encodingKeySuffix = ".etcdtool-encoding"
+
 var (
 	ctx = context.Background()
 	opt = struct {
-		endpoints string
-		timeout   int
+		endpoints      string
+		endpointsFrom  string
+		endpointsWatch bool
+		cacert         string
+		cert           string
+		key            string
+		user           string
+		password       string
+		timeout        int
+		connectTimeout int
+		keepaliveTime  int
+		opTimeout      int
+		failFast       bool
+		maxKeys        int64
+		db             string
+		passphraseFile string
+		encKeyFile     string
+		identityFile   string
+		s3Endpoint     string
+		remoteUser     string
+		remotePassword string
+		httpHeaders    []string
+		sftpIdentity   string
+		sftpKnownHosts string
+		sftpInsecure   bool
 	}{
 		endpoints: "127.0.0.1:2379",
 		timeout:   5,
+		failFast:  true,
 	}
 	unicodeFractSlashBytes = []byte(unicodeFractSlashStr)
+	envVarRegex            = regexp.MustCompile(`\$\{[A-Za-z_][A-Za-z0-9_]*\}`)
 )
 
 // kvKey2FileName is a WORKAROUND transformation function - will convert `xxx/` keys into `xxx\u2044` file-names
@@ -63,16 +103,184 @@ func fileName2KvKey(in string) string {
 	return in
 }
 
-func getEtcdClient() *clientv3.Client {
-	client, err := clientv3.New(clientv3.Config{
-		Endpoints:            strings.Split(opt.endpoints, ","),
-		DialTimeout:          time.Duration(opt.timeout) * time.Second,
-		DialKeepAliveTime:    time.Duration(opt.timeout) * time.Second,
-		DialKeepAliveTimeout: time.Duration(opt.timeout) * time.Second * 3,
+// key2LocalPath converts the key (or file-name, using forward slashes) into the local OS-specific path
+func key2LocalPath(key string) string {
+	return filepath.FromSlash(key)
+}
+
+// localPath2Key converts the local OS-specific path into the key (using forward slashes, and no drive letters)
+func localPath2Key(fname string) string {
+	return filepath.ToSlash(fname[len(filepath.VolumeName(fname)):])
+}
+
+// expandEnv expands the `${VAR}` environment variables in the string, failing on the unset variables
+func expandEnv(in string) (string, error) {
+	var err error
+	out := envVarRegex.ReplaceAllStringFunc(in, func(m string) string {
+		name := m[2 : len(m)-1]
+		val, has := os.LookupEnv(name)
+		if !has && err == nil {
+			err = fmt.Errorf("Environment variable %s is not set (used in '%s')", name, in)
+		}
+		return val
 	})
+	return out, err
+}
+
+// timeoutOr returns the given timeout (in seconds), or the default `--timeout` if not set
+func timeoutOr(t int) time.Duration {
+	if t <= 0 {
+		t = opt.timeout
+	}
+	return time.Duration(t) * time.Second
+}
+
+// withOpTimeout wraps the KV with the `--op-timeout` (if set)
+//   - NOTE: the operations are not limited by default, so that reading the huge prefixes does not time out
+func withOpTimeout(kv clientv3.KV) clientv3.KV {
+	if opt.opTimeout <= 0 {
+		return kv
+	}
+	return &timeoutKV{KV: kv, timeout: time.Duration(opt.opTimeout) * time.Second}
+}
+
+// timeoutKV is a KV wrapper, which applies the `--op-timeout` to each of the KV operations
+type timeoutKV struct {
+	clientv3.KV
+	timeout time.Duration
+}
+
+func (kv *timeoutKV) Put(ctx context.Context, key, val string, opts ...clientv3.OpOption) (*clientv3.PutResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, kv.timeout)
+	defer cancel()
+	return kv.KV.Put(ctx, key, val, opts...)
+}
+
+func (kv *timeoutKV) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, kv.timeout)
+	defer cancel()
+	return kv.KV.Get(ctx, key, opts...)
+}
+
+func (kv *timeoutKV) Delete(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.DeleteResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, kv.timeout)
+	defer cancel()
+	return kv.KV.Delete(ctx, key, opts...)
+}
+
+func (kv *timeoutKV) Compact(ctx context.Context, rev int64, opts ...clientv3.CompactOption) (*clientv3.CompactResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, kv.timeout)
+	defer cancel()
+	return kv.KV.Compact(ctx, rev, opts...)
+}
+
+func (kv *timeoutKV) Do(ctx context.Context, op clientv3.Op) (clientv3.OpResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, kv.timeout)
+	defer cancel()
+	return kv.KV.Do(ctx, op)
+}
+
+func (kv *timeoutKV) Txn(ctx context.Context) clientv3.Txn {
+	ctx, cancel := context.WithTimeout(ctx, kv.timeout)
+	return &timeoutTxn{Txn: kv.KV.Txn(ctx), cancel: cancel}
+}
+
+// timeoutTxn is a Txn wrapper, which releases the `--op-timeout` context on Commit
+type timeoutTxn struct {
+	clientv3.Txn
+	cancel context.CancelFunc
+}
+
+func (t *timeoutTxn) If(cs ...clientv3.Cmp) clientv3.Txn {
+	t.Txn = t.Txn.If(cs...)
+	return t
+}
+
+func (t *timeoutTxn) Then(ops ...clientv3.Op) clientv3.Txn {
+	t.Txn = t.Txn.Then(ops...)
+	return t
+}
+
+func (t *timeoutTxn) Else(ops ...clientv3.Op) clientv3.Txn {
+	t.Txn = t.Txn.Else(ops...)
+	return t
+}
+
+func (t *timeoutTxn) Commit() (*clientv3.TxnResponse, error) {
+	defer t.cancel()
+	return t.Txn.Commit()
+}
+
+// newEtcdClientConfig returns the client configuration for the endpoints, using the global timeouts
+func newEtcdClientConfig(endpoints string) clientv3.Config {
+	return clientv3.Config{
+		Endpoints:            strings.Split(endpoints, ","),
+		DialTimeout:          timeoutOr(opt.connectTimeout),
+		DialKeepAliveTime:    timeoutOr(opt.keepaliveTime),
+		DialKeepAliveTimeout: timeoutOr(opt.keepaliveTime) * 3,
+	}
+}
+
+// newTLSConfig returns the TLS configuration using the given CA bundle and client certificate/key files
+//   - NOTE: returns nil (plaintext connection) if no files were given, unless the endpoints use the https:// scheme
+func newTLSConfig(endpoints, cacert, cert, key string) (*tls.Config, error) {
+	if cacert == "" && cert == "" && key == "" {
+		if strings.Contains(endpoints, "https://") {
+			// verify the server using the system CAs
+			return &tls.Config{}, nil
+		}
+		return nil, nil
+	} else if (cert == "") != (key == "") {
+		return nil, fmt.Errorf("The TLS certificate and key must be specified together")
+	}
+	tlsInfo := transport.TLSInfo{
+		TrustedCAFile: cacert,
+		CertFile:      cert,
+		KeyFile:       key,
+	}
+	return tlsInfo.ClientConfig()
+}
+
+// parseUser splits the `user[:password]` credentials, and prompts for the password if it was not given (passwordOpt
+// names the option to use instead of the prompt)
+func parseUser(user, password, passwordOpt string) (string, string, error) {
+	if i := strings.IndexByte(user, ':'); i >= 0 {
+		return user[:i], user[i+1:], nil
+	} else if password != "" {
+		return user, password, nil
+	} else if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+		return "", "", fmt.Errorf("Cannot prompt for the password of %s (use the %s option)", user, passwordOpt)
+	}
+	fmt.Fprintf(logrus.StandardLogger().Out, "Password for %s: ", user)
+	buf, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(logrus.StandardLogger().Out)
+	return user, string(buf), err
+}
+
+func getEtcdClient() *clientv3.Client {
+	if opt.db != "" {
+		return getSnapshotClient()
+	}
+	cfg := newEtcdClientConfig(opt.endpoints)
+	tlsCfg, err := newTLSConfig(opt.endpoints, opt.cacert, opt.cert, opt.key)
+	checkErr(err)
+	cfg.TLS = tlsCfg
+	if opt.user != "" {
+		cfg.Username, cfg.Password, err = parseUser(opt.user, opt.password, "--password")
+		checkErr(err)
+		// NOTE: the resolved credentials are kept, so the commands creating several clients prompt for the password once
+		opt.user, opt.password = cfg.Username+":"+cfg.Password, ""
+	}
+	client, err := clientv3.New(cfg)
 	if err != nil {
 		logrus.WithError(err).Panicf("clientv3.New() failed")
 	}
+	client.KV = withOpTimeout(client.KV)
+	if opt.endpointsWatch && opt.endpointsFrom != "" {
+		if err = watchEndpointsFile(client, opt.endpointsFrom); err != nil {
+			logrus.WithError(err).Warnf("Cannot watch %s", opt.endpointsFrom)
+		}
+	}
 	return client
 }
 
@@ -83,209 +291,510 @@ func checkErr(err error) {
 	}
 }
 
-func countKeys(path string) int64 {
-	var (
-		client = getEtcdClient()
-		opts   = []clientv3.OpOption{
-			clientv3.WithPrefix(),
-			clientv3.WithCountOnly(),
-		}
-	)
+// errorRecord is the JSON record of the failed key, written by the `--errors-to` option
+type errorRecord struct {
+	Key       string `json:"key"`
+	Operation string `json:"op"`
+	Message   string `json:"error"`
+}
 
-	res, err := client.Get(ctx, path, opts...)
-	checkErr(err)
-	return res.Count
+// errorsLog writes the failed keys into a separate file (`--errors-to` option)
+type errorsLog struct {
+	out  io.WriteCloser
+	json bool
 }
 
-func actList(c *cli.Context) error {
-	var (
-		client = getEtcdClient()
-		opts   = []clientv3.OpOption{
-			clientv3.WithPrefix(),
-			clientv3.WithKeysOnly(),
-			clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend),
-		}
-	)
+// errLog is the global errors log (or nil, if not redirected into a file)
+var errLog *errorsLog
 
-	// Set up default params
-	args := c.Args().Slice()
-	if len(args) <= 0 {
-		args = []string{""}
+func openErrorsLog(fname, format string) (*errorsLog, error) {
+	if format != "plain" && format != "json" {
+		return nil, fmt.Errorf("Invalid errors format '%s' (expected plain or json)", format)
 	}
-	for _, a := range args {
-		res, err := client.Get(ctx, a, opts...)
-		checkErr(err)
-		if len(args) > 1 || res.Count > 1 {
-			if a != "" {
-				logrus.Infof("Found %d keys in %s:", res.Count, a)
-			} else {
-				logrus.Infof("Found %d keys:", res.Count)
-			}
-		}
-		for _, v := range res.Kvs {
-			fmt.Printf("%s\n", v.Key)
+	f, err := os.Create(fname)
+	if err != nil {
+		return nil, err
+	}
+	return &errorsLog{out: f, json: format == "json"}, nil
+}
+
+func (el *errorsLog) record(op, key string, err error) {
+	var werr error
+	if el.json {
+		werr = json.NewEncoder(el.out).Encode(errorRecord{Key: key, Operation: op, Message: err.Error()})
+	} else {
+		_, werr = fmt.Fprintf(el.out, "%s\t%s\t%v\n", op, key, err)
+	}
+	if werr != nil {
+		logrus.WithError(werr).Error("Could not write errors log")
+	}
+}
+
+func (el *errorsLog) Close() error {
+	return el.out.Close()
+}
+
+// failedKeys collects the keys that could not be processed in the `--continue-on-error` mode
+type failedKeys struct {
+	op   string
+	keys []string
+	errs []error
+}
+
+func (f *failedKeys) add(key string, err error) {
+	if errLog != nil {
+		logrus.WithError(err).Debugf("Failed to %s %s", f.op, key)
+		errLog.record(f.op, key, err)
+	} else {
+		logrus.WithError(err).Errorf("Failed to %s %s", f.op, key)
+	}
+	f.keys = append(f.keys, key)
+	f.errs = append(f.errs, err)
+}
+
+func (f *failedKeys) len() int {
+	return len(f.keys)
+}
+
+// writeFile writes the failed keys (one per line, followed by the error) into the given file
+func (f *failedKeys) writeFile(fname string) error {
+	var buf bytes.Buffer
+	for i, k := range f.keys {
+		fmt.Fprintf(&buf, "%s\t%v\n", k, f.errs[i])
+	}
+	out, err := createOutput(fname)
+	if err != nil {
+		return err
+	}
+	if _, err = out.Write(buf.Bytes()); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// result returns the final error for the commands, and writes the failed keys into errFile (if specified)
+func (f *failedKeys) result(errFile string) error {
+	if f.len() <= 0 {
+		return nil
+	}
+	if errFile != "" {
+		if err := f.writeFile(errFile); err != nil {
+			return err
 		}
+		return fmt.Errorf("Failed to process %d keys (see %s)", f.len(), errFile)
+	}
+	return fmt.Errorf("Failed to process %d keys", f.len())
+}
+
+// keyLimit counts the keys processed by the command, enforcing the global `--max-keys` limit
+type keyLimit struct {
+	count int64
+}
+
+// add accounts for n more keys, and fails if the command would process more keys than allowed
+func (kl *keyLimit) add(n int64) error {
+	kl.count += n
+	if kl.exceeded() {
+		return fmt.Errorf("Would process %d keys, exceeding --max-keys=%d (raise the limit if this is intended)", kl.count, opt.maxKeys)
 	}
 	return nil
 }
 
-func actTar(c *cli.Context) error {
-	var (
-		client  = getEtcdClient()
-		optFile = c.String("f")
-		optGzip = c.Bool("z")
-		out     = io.WriteCloser(os.Stdout)
-		err     error
-	)
+func (kl *keyLimit) exceeded() bool {
+	return opt.maxKeys > 0 && kl.count > opt.maxKeys
+}
 
-	// figure out output
-	if optFile != "" {
-		if out, err = os.Create(optFile); err != nil {
-			return err
+// kvRecord is a JSON record of the key (the Value is base64-encoded by encoding/json)
+type kvRecord struct {
+	Key            string `json:"key"`
+	Value          []byte `json:"value"`
+	CreateRevision int64  `json:"create_revision,omitempty"`
+	ModRevision    int64  `json:"mod_revision,omitempty"`
+	Version        int64  `json:"version,omitempty"`
+	Lease          int64  `json:"lease,omitempty"`
+}
+
+func newKvRecord(kv *mvccpb.KeyValue) *kvRecord {
+	return &kvRecord{
+		Key:            string(kv.Key),
+		Value:          kv.Value,
+		CreateRevision: kv.CreateRevision,
+		ModRevision:    kv.ModRevision,
+		Version:        kv.Version,
+		Lease:          kv.Lease,
+	}
+}
+
+// prevKvLog records the previous values of the overwritten keys (`--prev` option)
+type prevKvLog struct {
+	out io.WriteCloser
+	enc *json.Encoder
+}
+
+// newPrevKvLog creates the log, which also writes the JSON records into fname (if specified)
+func newPrevKvLog(fname string) (*prevKvLog, error) {
+	pl := new(prevKvLog)
+	if fname != "" {
+		f, err := os.Create(fname)
+		if err != nil {
+			return nil, err
 		}
-		defer out.Close()
-	} else {
-		optFile = "STDOUT"
+		pl.out, pl.enc = f, json.NewEncoder(f)
+	}
+	return pl, nil
+}
+
+func (pl *prevKvLog) record(key string, prev *mvccpb.KeyValue) error {
+	if prev == nil {
+		logrus.Debugf("No previous value for %s", key)
+		return nil
 	}
-	if optGzip {
-		out = gzip.NewWriter(out)
-		defer out.Close()
+	logrus.Infof("Replaced %s [%d, rev %d]", key, len(prev.Value), prev.ModRevision)
+	return pl.write(prev)
+}
+
+// write saves the JSON record of the previous key-value into the file (if specified)
+func (pl *prevKvLog) write(prev *mvccpb.KeyValue) error {
+	if pl.enc == nil {
+		return nil
 	}
+	return pl.enc.Encode(newKvRecord(prev))
+}
 
-	tw := tar.NewWriter(out)
-	defer tw.Close()
+func (pl *prevKvLog) Close() error {
+	if pl.out != nil {
+		return pl.out.Close()
+	}
+	return nil
+}
 
-	// Set up default params
-	args := c.Args().Slice()
-	if len(args) <= 0 {
-		args = []string{""}
+// confirm prompts the user to continue, and aborts the program unless the answer was 'Y'
+func confirm(format string, args ...interface{}) {
+	var txt string
+	fmt.Fprintf(logrus.StandardLogger().Out, format+"  Continue [Y/*]? ", args...)
+	fmt.Scanln(&txt)
+	if len(txt) < 1 || unicode.ToUpper(rune(txt[0])) != 'Y' {
+		logrus.Error("Aborted.")
+		os.Exit(1)
 	}
+}
 
-	for _, a := range args {
-		opts := []clientv3.OpOption{
-			clientv3.WithPrefix(),
-			clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend),
+// keyArgs returns the keys/prefixes from the arguments, or the empty prefix for the whole keyspace with `--all` option
+func keyArgs(c *cli.Context, verb string) ([]string, error) {
+	if c.Bool("all") {
+		if c.NArg() > 0 {
+			return nil, fmt.Errorf("Cannot combine --all with the keys")
 		}
-		logrus.Debugf("Doing TAR(%s,%#v)...", a, opts)
-		res, err := client.Get(ctx, a, opts...)
+		return []string{""}, nil
+	} else if c.NArg() <= 0 {
+		return nil, fmt.Errorf("Must specify which keys to %s (or --all for the whole keyspace)", verb)
+	}
+	return c.Args().Slice(), nil
+}
+
+// countKeys counts the keys with given prefix
+//   - NOTE: some proxies and older etcd versions do not support WithCountOnly, so we double-check the zero counts
+func countKeys(client *clientv3.Client, path string) int64 {
+	opts := []clientv3.OpOption{
+		clientv3.WithPrefix(),
+		clientv3.WithCountOnly(),
+	}
+
+	res, err := client.Get(ctx, path, opts...)
+	if err == nil && res.Count > 0 {
+		return res.Count
+	} else if err == nil {
+		// double-check if there really are no keys
+		res, err = client.Get(ctx, path, clientv3.WithPrefix(), clientv3.WithKeysOnly(), clientv3.WithLimit(1))
 		checkErr(err)
-		for _, v := range res.Kvs {
-			header := new(tar.Header)
-			header.Name = kvKey2FileName(v)
-			header.Size = int64(len(v.Value))
-			header.Mode = 0666
-			header.ModTime = time.Now()
-			if err := tw.WriteHeader(header); err != nil {
-				return err
-			}
-			if _, err := io.Copy(tw, bytes.NewReader(v.Value)); err != nil {
-				return err
-			}
-			logrus.Infof("Add %s [%d]...", v.Key, len(v.Value))
+		if len(res.Kvs) <= 0 {
+			return 0
 		}
+		logrus.Warnf("Count-only request returned 0 keys for %s, falling back to paginated count", path)
+	} else {
+		logrus.WithError(err).Warnf("Count-only request failed for %s, falling back to paginated count", path)
 	}
 
-	logrus.Infof("Done writing %s", optFile)
-	return nil
+	cnt, err := countKeysPaged(client, path)
+	checkErr(err)
+	return cnt
 }
 
-func actZip(c *cli.Context) error {
-	var (
-		client  = getEtcdClient()
-		optFile = c.String("f")
-		out     io.WriteCloser
-		err     error
-	)
+// countKeysPaged counts the keys by paging through the key names (slow fallback for countKeys)
+func countKeysPaged(client *clientv3.Client, prefix string) (int64, error) {
+	var cnt int64
+	err := rangePages(client, prefix, func(res *clientv3.GetResponse) error {
+		cnt += int64(len(res.Kvs))
+		return nil
+	}, clientv3.WithKeysOnly())
+	return cnt, err
+}
 
-	if optFile == "" {
-		return fmt.Errorf("Must specify output file (-f file)")
-	} else if out, err = os.Create(optFile); err != nil {
-		return err
+// parseRevision parses the revision argument
+//   - NOTE: etcd does not record the modification times, so the timestamps cannot be mapped to revisions
+func parseRevision(in string) (int64, error) {
+	if _, err := time.Parse(time.RFC3339, in); err == nil {
+		return 0, fmt.Errorf("Cannot map timestamp '%s' to revision (etcd does not record modification times)", in)
+	}
+	rev, err := strconv.ParseInt(in, 10, 64)
+	if err != nil || rev < 0 {
+		return 0, fmt.Errorf("Invalid revision '%s'", in)
 	}
+	return rev, nil
+}
 
-	// Set up default params
-	args := c.Args().Slice()
-	if len(args) <= 0 {
-		args = []string{""}
+// readRevisionFile reads the revision recorded in the file (returns 0 if the file does not exist)
+func readRevisionFile(fname string) (int64, error) {
+	buf, err := ioutil.ReadFile(fname)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	rev, err := parseRevision(strings.TrimSpace(string(buf)))
+	if err != nil {
+		return 0, fmt.Errorf("Could not parse %s: %v", fname, err)
 	}
+	return rev, nil
+}
 
-	zw := zip.NewWriter(out)
-	defer func() {
-		checkErr(zw.Close())
-		out.Close()
-	}()
+// writeRevisionFile records the revision into the file
+func writeRevisionFile(fname string, rev int64) error {
+	logrus.Debugf("Writing revision %d into %s", rev, fname)
+	return ioutil.WriteFile(fname, []byte(fmt.Sprintf("%d\n", rev)), 0666)
+}
 
-	for _, a := range args {
-		opts := []clientv3.OpOption{
-			clientv3.WithPrefix(),
-			clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend),
-		}
-		logrus.Debugf("Doing ZIP(%s,%#v)...", a, opts)
-		res, err := client.Get(ctx, a, opts...)
-		checkErr(err)
-		var f io.Writer
-		for _, v := range res.Kvs {
-			f, err = zw.Create(kvKey2FileName(v))
-			checkErr(err)
-			_, err = f.Write(v.Value)
-			checkErr(err)
-			logrus.Infof("Add %s [%d]...", v.Key, len(v.Value))
-		}
+// revisionFilterFlags are the `--with-min/max-create/mod-rev` flags of the list/get commands
+func revisionFilterFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.Int64Flag{
+			Name:  "with-min-create-rev",
+			Usage: "filter out keys created before given revision",
+		},
+		&cli.Int64Flag{
+			Name:  "with-max-create-rev",
+			Usage: "filter out keys created after given revision",
+		},
+		&cli.Int64Flag{
+			Name:  "with-min-mod-rev",
+			Usage: "filter out keys modified before given revision",
+		},
+		&cli.Int64Flag{
+			Name:  "with-max-mod-rev",
+			Usage: "filter out keys modified after given revision",
+		},
 	}
+}
 
-	logrus.Infof("Done writing %s", optFile)
-	return nil
+// revisionFilterOpts maps the `--with-min/max-create/mod-rev` flags to the clientv3 options
+func revisionFilterOpts(c *cli.Context) []clientv3.OpOption {
+	var opts []clientv3.OpOption
+	if rev := c.Int64("with-min-create-rev"); rev > 0 {
+		opts = append(opts, clientv3.WithMinCreateRev(rev))
+	}
+	if rev := c.Int64("with-max-create-rev"); rev > 0 {
+		opts = append(opts, clientv3.WithMaxCreateRev(rev))
+	}
+	if rev := c.Int64("with-min-mod-rev"); rev > 0 {
+		opts = append(opts, clientv3.WithMinModRev(rev))
+	}
+	if rev := c.Int64("with-max-mod-rev"); rev > 0 {
+		opts = append(opts, clientv3.WithMaxModRev(rev))
+	}
+	return opts
 }
 
-func actDump(c *cli.Context) error {
-	if c.NArg() <= 0 {
-		return fmt.Errorf("Must specify which keys to dump")
+func actList(c *cli.Context) error {
+	args, err := keyArgs(c, "list")
+	if err != nil {
+		return err
 	}
 
 	var (
 		client    = getEtcdClient()
-		optDir    = c.String("directory")
-		optDecode = c.Bool("d64")
-		optStrip  = c.Bool("strip")
-		opts      = []clientv3.OpOption{
-			clientv3.WithPrefix(),
-			clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend),
-		}
-		logFmt = "Wrote %s [%d]..."
+		opts      []clientv3.OpOption
+		optSince  int64
+		optDepth  = c.Int("group-by-depth")
+		optOutput = c.String("output")
+		optLong   = c.Bool("long")
+		failed    = failedKeys{op: "list"}
+		limit     keyLimit
+		cw        *csv.Writer
+		tw        *tabwriter.Writer
+		enc       *json.Encoder
 	)
 
-	if optDecode {
-		logFmt = "Wrote %s [%d, b64-decoded]..."
+	opts = append(opts, revisionFilterOpts(c)...)
+	filter, err := newKeyFilter(c)
+	if err != nil {
+		return err
 	}
 
-	for _, a := range c.Args().Slice() {
-		logrus.Debugf("Doing GET(%s,%#v)...", a, opts)
-		res, err := client.Get(ctx, a, opts...)
-		checkErr(err)
-		for _, v := range res.Kvs {
-			kk := kvKey2FileName(v)
-			if optStrip {
-				kk = path.Base(kk)
+	if optLong && !c.IsSet("output") {
+		optOutput = "table"
+	}
+	switch optOutput {
+	case "plain":
+	case "csv":
+		columns := []string{"key", "create_revision", "mod_revision", "version", "lease", "value"}
+		if optDepth > 0 {
+			columns = []string{"group", "keys"}
+		}
+		if cw, err = newCSVWriter(c.String("csv-delimiter"), c.Bool("csv-header"), columns...); err != nil {
+			return err
+		}
+	case "json":
+		enc = json.NewEncoder(os.Stdout)
+	case "table":
+		// NOTE: the table is flushed after each page (so the large listings are streamed), i.e. the columns are aligned
+		// within the page
+		tw = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		switch {
+		case optDepth > 0:
+			fmt.Fprintf(tw, "GROUP\tKEYS\n")
+		case optLong:
+			fmt.Fprintf(tw, "KEY\tCREATE REV\tMOD REV\tVERSION\tLEASE\tSIZE\n")
+		default:
+			fmt.Fprintf(tw, "KEY\tCREATE REV\tMOD REV\tVERSION\n")
+		}
+	default:
+		return fmt.Errorf("Invalid output format '%s' (expected plain, csv, json or table)", optOutput)
+	}
+	if optLong && tw == nil {
+		return fmt.Errorf("Option --long requires the table output")
+	}
+	// the values are read only for the CSV output and the value sizes
+	if optDepth > 0 || (cw == nil && enc == nil && !optLong) {
+		opts = append(opts, clientv3.WithKeysOnly())
+	}
+
+	if s := c.String("modified-since"); s != "" {
+		if optSince, err = parseRevision(s); err != nil {
+			return err
+		}
+	}
+
+	for _, a := range args {
+		var (
+			groups = make(map[string]int)
+			cnt    int
+		)
+		// the keys are listed page by page, so the huge prefixes are not loaded into memory at once
+		err := rangePages(client, a, func(res *clientv3.GetResponse) error {
+			// NOTE: only the listed keys are counted (the res.Count ignores the filters)
+			kvs := make([]*mvccpb.KeyValue, 0, len(res.Kvs))
+			for _, v := range res.Kvs {
+				if v.ModRevision >= optSince && filter.match(v.Key) {
+					kvs = append(kvs, v)
+				}
 			}
-			kk = path.Join(optDir, kk)
-			if err := os.MkdirAll(path.Dir(kk), 0777); err != nil {
+			if err := limit.add(int64(len(kvs))); err != nil {
 				return err
 			}
-			dbuf := v.Value
-			if optDecode {
-				dbuf = make([]byte, base64.StdEncoding.DecodedLen(len(v.Value)))
-				if _, err := base64.StdEncoding.Decode(dbuf, v.Value); err != nil {
-					return err
+			for _, v := range kvs {
+				cnt++
+				if optDepth > 0 {
+					groups[keyGroup(string(v.Key), optDepth)]++
+					continue
+				}
+				switch {
+				case cw != nil:
+					cw.Write([]string{string(v.Key), strconv.FormatInt(v.CreateRevision, 10), strconv.FormatInt(v.ModRevision, 10),
+						strconv.FormatInt(v.Version, 10), strconv.FormatInt(v.Lease, 16), string(v.Value)})
+				case enc != nil:
+					checkErr(enc.Encode(newKeyInfo(v)))
+				case optLong:
+					lease := "-"
+					if v.Lease != 0 {
+						lease = strconv.FormatInt(v.Lease, 16)
+					}
+					fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%s\t%d\n", v.Key, v.CreateRevision, v.ModRevision, v.Version, lease,
+						len(v.Value))
+				case tw != nil:
+					fmt.Fprintf(tw, "%s\t%d\t%d\t%d\n", v.Key, v.CreateRevision, v.ModRevision, v.Version)
+				default:
+					fmt.Printf("%s\n", v.Key)
 				}
 			}
-			if err := ioutil.WriteFile(kk, dbuf, 0666); err != nil {
-				return err
+			if tw != nil && optDepth <= 0 {
+				return tw.Flush()
 			}
-			logrus.Infof(logFmt, kk, len(dbuf))
+			return nil
+		}, opts...)
+		if limit.exceeded() {
+			return err
+		} else if err != nil && !opt.failFast {
+			failed.add(a, err)
+			continue
+		}
+		checkErr(err)
+		if len(args) > 1 || cnt > 1 {
+			if a != "" {
+				logrus.Infof("Found %d keys in %s.", cnt, a)
+			} else {
+				logrus.Infof("Found %d keys.", cnt)
+			}
+		}
+		if optDepth > 0 {
+			names := make([]string, 0, len(groups))
+			for g := range groups {
+				names = append(names, g)
+			}
+			sort.Strings(names)
+			for _, g := range names {
+				switch {
+				case cw != nil:
+					cw.Write([]string{g, strconv.Itoa(groups[g])})
+				case enc != nil:
+					checkErr(enc.Encode(map[string]interface{}{"group": g, "keys": groups[g]}))
+				case tw != nil:
+					fmt.Fprintf(tw, "%s\t%d\n", g, groups[g])
+				default:
+					fmt.Printf("%8d  %s\n", groups[g], g)
+				}
+			}
+		}
+	}
+	if cw != nil {
+		if cw.Flush(); cw.Error() != nil {
+			return cw.Error()
+		}
+	} else if tw != nil {
+		if err = tw.Flush(); err != nil {
+			return err
+		}
+	}
+	return failed.result("")
+}
+
+// keyGroup returns the first `depth` path components of the key (e.g. `/a/b/` for `/a/b/c/d` and depth 2)
+func keyGroup(key string, depth int) string {
+	i := 0
+	if strings.HasPrefix(key, "/") {
+		i = 1
+	}
+	for ; depth > 0; depth-- {
+		j := strings.IndexByte(key[i:], '/')
+		if j < 0 {
+			return key
 		}
+		i += j + 1
 	}
+	return key[:i]
+}
 
-	return nil
+// trimExtensions removes the first matching file-extension from the key
+func trimExtensions(key string, exts []string) string {
+	for _, e := range exts {
+		if !strings.HasPrefix(e, ".") {
+			e = "." + e
+		}
+		if strings.HasSuffix(key, e) && len(key) > len(e) {
+			return key[:len(key)-len(e)]
+		}
+	}
+	return key
 }
 
 func actUpload(c *cli.Context) error {
@@ -298,53 +807,162 @@ func actUpload(c *cli.Context) error {
 		optDir    = c.String("directory")
 		optDirLen int
 		optEncode = c.Bool("e64")
+		optAuto   = c.Bool("auto-encode")
 		optPrefix = c.String("prefix")
+		optTrim   = c.StringSlice("trim-extension")
+		optPrev   = c.Bool("prev") || c.String("prev-out") != ""
+		optMkdirs = c.Bool("mkdirs")
+		prevLog   *prevKvLog
+		putOpts   []clientv3.OpOption
+		dirs      = make(map[string]bool)
+		progress  = newProgressFile(c.String("progress-file"), "upload")
+		limit     keyLimit
+		mf        *manifest
+		leases    *leaseRestorer
+		filter    *keyFilter
 		logFmt    = "Put %s [%d]..."
-		uploadFn  = func(fname string) error {
+		// keyFn maps the file to the key and the manifest entry name (the key is "" if the file is filtered out)
+		keyFn = func(fname string) (string, string, error) {
+			if strings.HasSuffix(fname, zstdExt) {
+				compressed, err := fileHasPrefix(fname, zstdMagic)
+				if err != nil {
+					return "", "", err
+				} else if compressed {
+					fname = strings.TrimSuffix(fname, zstdExt)
+				}
+			}
+			name := localPath2Key(fname[optDirLen:])
+			kk := trimExtensions(optPrefix+name, optTrim)
+			if mk := mf.entry(name); mk != nil {
+				// the manifest keeps the original key (e.g. for the stripped dumps)
+				kk = optPrefix + mk.Key
+			}
+			if !filter.match([]byte(fileName2KvKey(kk))) {
+				return "", name, nil
+			}
+			return kk, name, nil
+		}
+		uploadFn = func(fname, kk, name string) error {
 			dbuf, err := ioutil.ReadFile(fname)
 			if err != nil {
 				return err
 			}
 			logrus.Debugf("Read %s [%d] ...", fname, len(dbuf))
+			if strings.HasSuffix(fname, zstdExt) && bytes.HasPrefix(dbuf, zstdMagic) {
+				if dbuf, err = zstdDecompress(dbuf); err != nil {
+					return fmt.Errorf("Could not decompress %s: %v", fname, err)
+				}
+				logrus.Debugf("Decompressed %s (zstd) [%d] ...", fname, len(dbuf))
+			}
 			if optEncode {
 				ebuf := make([]byte, base64.StdEncoding.EncodedLen(len(dbuf)))
 				base64.StdEncoding.Encode(ebuf, dbuf)
 				dbuf = ebuf
 			}
-			kk := optPrefix + fname[optDirLen:]
-			if _, err = client.Put(ctx, fileName2KvKey(kk), string(dbuf)); err == nil {
-				logrus.Infof(logFmt, kk, len(dbuf))
+			if optMkdirs {
+				if err = mkdirs(client, fileName2KvKey(kk), dirs); err != nil {
+					return err
+				}
 			}
-			return err
+			var extraOps []clientv3.Op
+			if optAuto {
+				var op clientv3.Op
+				dbuf, op = autoEncode(fileName2KvKey(kk), dbuf)
+				extraOps = append(extraOps, op)
+			}
+			leaseOpts, err := leases.opts(name)
+			if err != nil {
+				return err
+			}
+			prevKv, err := putValue(client, fileName2KvKey(kk), string(dbuf), extraOps, append(leaseOpts, putOpts...)...)
+			if err != nil {
+				return err
+			}
+			logrus.Infof(logFmt, kk, len(dbuf))
+			progress.add(len(dbuf))
+			if optPrev {
+				return prevLog.record(kk, prevKv)
+			}
+			return nil
 		}
 		inFnameFn = func(a string) string { return a }
+		files     []string
+		err       error
 	)
 
-	if optEncode {
+	if optEncode && optAuto {
+		return fmt.Errorf("Options --e64 and --auto-encode are mutually exclusive")
+	} else if optEncode {
 		logFmt = "Put %s [%d, b64 encoded]..."
 	}
+	if filter, err = newKeyFilter(c); err != nil {
+		return err
+	}
+
+	if optPrev {
+		if prevLog, err = newPrevKvLog(c.String("prev-out")); err != nil {
+			return err
+		}
+		defer prevLog.Close()
+		putOpts = append(putOpts, clientv3.WithPrevKV())
+	}
 
 	if optDir != "" {
-		optDir = path.Clean(optDir)
+		optDir = filepath.Clean(optDir)
 		optDirLen = len(optDir) + 1
-		inFnameFn = func(a string) string { return path.Join(optDir, a) }
+		inFnameFn = func(a string) string { return filepath.Join(optDir, a) }
+		if mf, err = readDirManifest(optDir); err != nil {
+			return fmt.Errorf("Could not read manifest of %s: %v", optDir, err)
+		}
+		leases = newLeaseRestorer(c, client, mf)
+	} else if c.Bool("restore-leases") {
+		return fmt.Errorf("Option --restore-leases requires the dumped directory (-C dir)")
+	}
+
+	if c.Bool("restore-leases") && (c.Int64("ttl") > 0 || c.String("lease") != "") {
+		return fmt.Errorf("Option --restore-leases cannot be combined with --ttl or --lease")
+	}
+	lease, err := leaseOption(c, client)
+	if err != nil {
+		return err
+	} else if lease != clientv3.NoLease {
+		logrus.Infof("Attaching the keys to lease %x", lease)
+		putOpts = append(putOpts, clientv3.WithLease(lease))
+	}
+
+	var ignore ignoreRules
+	if optDir != "" && !c.Bool("no-ignore") {
+		if err = ignore.load(optDir); err != nil {
+			return err
+		}
 	}
 
+	// collect the files first, so we know the total
 	for _, a := range c.Args().Slice() {
-		a = inFnameFn(a)
-		logrus.Debugf("Doing PUT(%s,XX)...", a)
+		a = filepath.Clean(inFnameFn(a))
 		st, err := os.Stat(a)
 		if err != nil {
 			return err
 		}
+		root := a
+		if optDir != "" {
+			root = optDir
+		}
 		if st.IsDir() {
 			err = filepath.Walk(a, func(path string, info os.FileInfo, err error) error {
-				if info.Mode().IsRegular() {
-					if err = uploadFn(path); err != nil {
-						return err
+				if info.Mode().IsRegular() && (info.Name() == manifestName || info.Name() == ignoreFileName) {
+					logrus.Debugf("Skipping %s", path)
+				} else if path != root && ignore.ignored(root, path, info.IsDir()) {
+					logrus.Debugf("Skipping %s (ignored)", path)
+					if info.IsDir() {
+						return filepath.SkipDir
 					}
+				} else if info.Mode().IsRegular() {
+					files = append(files, path)
 				} else if info.Mode().IsDir() {
-					// .. ignore
+					if path != optDir && !c.Bool("no-ignore") {
+						return ignore.load(path)
+					}
 				} else {
 					logrus.Warnf("Skipping '%s' (not a file or a directory)", a)
 				}
@@ -353,29 +971,195 @@ func actUpload(c *cli.Context) error {
 			if err != nil {
 				return err
 			}
+		} else if st.Mode().IsRegular() && optDir != "" && ignore.ignored(optDir, a, false) {
+			logrus.Debugf("Skipping %s (ignored)", a)
 		} else if st.Mode().IsRegular() {
-			// upload
-			if err = uploadFn(a); err != nil {
-				return err
-			}
+			files = append(files, a)
 		} else {
 			logrus.Warnf("Skipping '%s' (not a file or a directory)", a)
 		}
 	}
+
+	// map all the files to the keys first, so the collisions are detected before any key is written
+	var (
+		keys     = make([]string, len(files))
+		names    = make([]string, len(files))
+		uploaded = make(map[string]string)
+	)
+	for i, f := range files {
+		if keys[i], names[i], err = keyFn(f); err != nil {
+			return err
+		} else if keys[i] == "" {
+			logrus.Debugf("Skipping %s (excluded or not matching)", f)
+			continue
+		} else if prev, has := uploaded[keys[i]]; has {
+			return fmt.Errorf("Files '%s' and '%s' both map to key %s", prev, f, keys[i])
+		}
+		uploaded[keys[i]] = f
+	}
+
+	if err = limit.add(int64(len(files))); err != nil {
+		return err
+	}
+	progress.setTotal(int64(len(files)))
+	for i, f := range files {
+		if keys[i] == "" {
+			continue
+		}
+		logrus.Debugf("Doing PUT(%s,XX)...", f)
+		if err = uploadFn(f, keys[i], names[i]); err != nil {
+			return err
+		}
+	}
+	progress.done()
 	return nil
 }
 
+// fileHasPrefix checks if the file starts with given bytes
+func fileHasPrefix(fname string, prefix []byte) (bool, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	hdr := make([]byte, len(prefix))
+	if _, err = io.ReadFull(f, hdr); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return bytes.Equal(hdr, prefix), nil
+}
+
+// selectOlderThan returns the keys attached to leases which were granted (or last renewed) more than `age` ago
+//   - NOTE: etcd does not record the modification times, so the keys without leases are never selected
+func selectOlderThan(client *clientv3.Client, kvs []*mvccpb.KeyValue, age time.Duration) ([]*mvccpb.KeyValue, error) {
+	var (
+		ret     []*mvccpb.KeyValue
+		ages    = make(map[int64]time.Duration)
+		noLease int
+	)
+
+	for _, v := range kvs {
+		if v.Lease == 0 {
+			noLease++
+			continue
+		}
+		la, has := ages[v.Lease]
+		if !has {
+			res, err := client.TimeToLive(ctx, clientv3.LeaseID(v.Lease))
+			if err != nil {
+				return nil, err
+			}
+			la = time.Duration(res.GrantedTTL-res.TTL) * time.Second
+			logrus.Debugf("Lease %x of %s granted %ds ago", v.Lease, v.Key, res.GrantedTTL-res.TTL)
+			ages[v.Lease] = la
+		}
+		if la >= age {
+			ret = append(ret, v)
+		}
+	}
+	if noLease > 0 {
+		logrus.Warnf("Skipping %d keys without leases (cannot determine their age)", noLease)
+	}
+	return ret, nil
+}
+
+// removeSelected removes the given keys, unless they were modified in the meantime
+//   - the deleted key-values (including the values) are passed to the deletedFn callback
+func removeSelected(client *clientv3.Client, kvs []*mvccpb.KeyValue, deletedFn func(kv *mvccpb.KeyValue)) (deleted int64, err error) {
+	for _, v := range kvs {
+		k := string(v.Key)
+		logrus.Debugf("Doing DEL(%s,rev=%d)...", k, v.ModRevision)
+		res, err := client.Txn(ctx).
+			If(clientv3.Compare(clientv3.ModRevision(k), "=", v.ModRevision)).
+			Then(clientv3.OpDelete(k, clientv3.WithPrevKV())).
+			Commit()
+		if err != nil {
+			return deleted, err
+		} else if !res.Succeeded {
+			logrus.Warnf("Key %s modified concurrently, skipping", k)
+			continue
+		}
+		for _, pv := range res.Responses[0].GetResponseDeleteRange().PrevKvs {
+			deletedFn(pv)
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// removePaged deletes the keys under the prefix page by page (in transactions of up to maxTxnOps deletes), and passes
+// the deleted key-values to deletedFn as each batch is deleted
+func removePaged(client *clientv3.Client, prefix string, deletedFn func(kv *mvccpb.KeyValue)) (deleted int64, err error) {
+	err = rangePages(client, prefix, func(res *clientv3.GetResponse) error {
+		for i := 0; i < len(res.Kvs); i += maxTxnOps {
+			end := i + maxTxnOps
+			if end > len(res.Kvs) {
+				end = len(res.Kvs)
+			}
+			ops := make([]clientv3.Op, 0, end-i)
+			for _, v := range res.Kvs[i:end] {
+				ops = append(ops, clientv3.OpDelete(string(v.Key), clientv3.WithPrevKV()))
+			}
+			logrus.Debugf("Doing TXN(%d deletes)...", len(ops))
+			tres, err := client.Txn(ctx).Then(ops...).Commit()
+			if err != nil {
+				return err
+			}
+			for _, r := range tres.Responses {
+				dr := r.GetResponseDeleteRange()
+				for _, pv := range dr.PrevKvs {
+					deletedFn(pv)
+				}
+				deleted += dr.Deleted
+			}
+		}
+		return nil
+	}, clientv3.WithKeysOnly())
+	return deleted, err
+}
+
 func actRemove(c *cli.Context) error {
 	if c.NArg() <= 0 {
 		return fmt.Errorf("Must specify which keys to remove")
 	}
 
 	var (
-		client   = getEtcdClient()
-		optForce = c.Bool("f")
-		txt      string
+		client    = getEtcdClient()
+		optForce  = c.Bool("f")
+		optDryRun = c.Bool("dry-run")
+		optOlder  = c.Duration("older-than")
+		optJSON   = c.Bool("json")
+		optPrint  = c.Bool("print-deleted") || optJSON
+		optReq    = c.Bool("require-existing")
+		optPrev   = c.String("prev-out")
+		missing   []string
+		limit     keyLimit
+		prevLog   *prevKvLog
+		enc       = json.NewEncoder(os.Stdout)
+		deletedFn = func(kv *mvccpb.KeyValue) {
+			if optPrev != "" {
+				checkErr(prevLog.write(kv))
+			}
+			if optJSON {
+				checkErr(enc.Encode(newKvRecord(kv)))
+			} else if optPrint {
+				fmt.Printf("%s\n", kv.Key)
+			}
+		}
 	)
 
+	if optPrev != "" && !optDryRun {
+		var err error
+		if prevLog, err = newPrevKvLog(optPrev); err != nil {
+			return err
+		}
+		defer prevLog.Close()
+	} else {
+		optPrev = ""
+	}
+
 	for _, a := range c.Args().Slice() {
 		opts := []clientv3.OpOption{}
 		ask := false
@@ -386,176 +1170,1075 @@ func actRemove(c *cli.Context) error {
 			}
 			ask = !optForce
 		}
-		logrus.Debugf("Doing DEL(%s,%#v)...", a, opts)
-		if ask {
-			if cnt := countKeys(a); cnt > 0 {
-				fmt.Fprintf(logrus.StandardLogger().Out,
-					"WARNING: About to delete %d keys in %s!  Continue [Y/*]? ", cnt, a)
-				fmt.Scanln(&txt)
-				if len(txt) < 1 || unicode.ToUpper(rune(txt[0])) != 'Y' {
-					logrus.Error("Aborted.")
-					os.Exit(1)
+		if optDryRun || optOlder > 0 {
+			var kvs []*mvccpb.KeyValue
+			if len(opts) > 0 {
+				checkErr(rangePages(client, a, func(res *clientv3.GetResponse) error {
+					kvs = append(kvs, res.Kvs...)
+					return nil
+				}, clientv3.WithKeysOnly()))
+			} else {
+				logrus.Debugf("Doing GET(%s)...", a)
+				res, err := client.Get(ctx, a, clientv3.WithKeysOnly())
+				checkErr(err)
+				kvs = res.Kvs
+			}
+			var err error
+			if optOlder > 0 {
+				kvs, err = selectOlderThan(client, kvs, optOlder)
+				checkErr(err)
+			}
+			if err = limit.add(int64(len(kvs))); err != nil {
+				return err
+			}
+			if optDryRun {
+				for _, v := range kvs {
+					fmt.Printf("%s\n", v.Key)
 				}
+				logrus.Infof("Would delete %d keys.", len(kvs))
+				if len(kvs) <= 0 {
+					missing = append(missing, a)
+				}
+				continue
+			}
+			if len(kvs) > 0 && !optForce {
+				confirm("WARNING: About to delete %d keys older than %s in %s!", len(kvs), optOlder, a)
+			}
+			deleted, err := removeSelected(client, kvs, deletedFn)
+			checkErr(err)
+			logrus.Infof("Deleted %d keys.", deleted)
+			if deleted <= 0 {
+				missing = append(missing, a)
+			}
+			continue
+		}
+		logrus.Debugf("Doing DEL(%s,%#v)...", a, opts)
+		cnt := int64(1)
+		if len(opts) > 0 && (ask || opt.maxKeys > 0) {
+			cnt = countKeys(client, a)
+		}
+		if err := limit.add(cnt); err != nil {
+			return err
+		}
+		if ask && cnt > 0 {
+			confirm("WARNING: About to delete %d keys in %s!", cnt, a)
+		}
+		if len(opts) > 0 && (optPrint || optPrev != "") {
+			// the deleted keys are streamed batch by batch, instead of returning the whole subtree at once
+			deleted, err := removePaged(client, a, deletedFn)
+			checkErr(err)
+			logrus.Infof("Deleted %d keys.", deleted)
+			if deleted <= 0 {
+				missing = append(missing, a)
 			}
+			continue
+		} else if optPrint || optPrev != "" {
+			opts = append(opts, clientv3.WithPrevKV())
 		}
 		res, err := client.Delete(ctx, a, opts...)
 		checkErr(err)
+		for _, v := range res.PrevKvs {
+			deletedFn(v)
+		}
 		logrus.Infof("Deleted %d keys.", res.Deleted)
+		if res.Deleted <= 0 {
+			missing = append(missing, a)
+		}
 	}
 
+	if optReq && len(missing) > 0 {
+		return fmt.Errorf("No keys deleted for %s", strings.Join(missing, ", "))
+	}
 	return nil
 }
 
-func actGet(c *cli.Context) error {
-	if c.NArg() <= 0 {
-		return fmt.Errorf("Must specify which keys to get")
+func actRenamePrefix(c *cli.Context) error {
+	if c.NArg() != 2 {
+		return fmt.Errorf("Must specify <old-prefix> <new-prefix>")
 	}
 
 	var (
 		client    = getEtcdClient()
-		optDecode = c.Bool("d64")
-		logFmt    = "Got %s [%d]..."
+		optOld    = c.Args().Get(0)
+		optNew    = c.Args().Get(1)
+		optDryRun = c.Bool("dry-run")
+		optForce  = c.Bool("f")
+		opts      = []clientv3.OpOption{
+			clientv3.WithPrefix(),
+			clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend),
+		}
+		limit         keyLimit
+		moved, failed int
 	)
 
-	if optDecode {
-		logFmt = "Got %s [%d, b64-decoded]..."
+	if optOld == "" || strings.HasPrefix(optNew, optOld) || strings.HasPrefix(optOld, optNew) {
+		return fmt.Errorf("Prefixes '%s' and '%s' must not overlap", optOld, optNew)
 	}
 
-	for _, a := range c.Args().Slice() {
-		opts := []clientv3.OpOption{}
-		if strings.HasSuffix(a, "/") {
-			// dumping subtree
-			opts = []clientv3.OpOption{
-				clientv3.WithPrefix(),
-				clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend),
-			}
-		}
-		logrus.Debugf("Doing GET(%s,%#v)...", a, opts)
-		res, err := client.Get(ctx, a, opts...)
-		checkErr(err)
+	logrus.Debugf("Doing GET(%s,%#v)...", optOld, opts)
+	res, err := client.Get(ctx, optOld, opts...)
+	checkErr(err)
+	if res.Count <= 0 {
+		logrus.Infof("No keys found in %s", optOld)
+		return nil
+	} else if err = limit.add(res.Count); err != nil {
+		return err
+	}
+
+	if optDryRun {
 		for _, v := range res.Kvs {
-			dbuf := v.Value
-			if optDecode {
-				dbuf = make([]byte, base64.StdEncoding.DecodedLen(len(v.Value)))
-				if _, err := base64.StdEncoding.Decode(dbuf, v.Value); err != nil {
-					return err
-				}
+			fmt.Printf("%s -> %s%s\n", v.Key, optNew, v.Key[len(optOld):])
+		}
+		logrus.Infof("Would rename %d keys.", res.Count)
+		return nil
+	} else if !optForce {
+		confirm("WARNING: About to rename %d keys in %s to %s!", res.Count, optOld, optNew)
+	}
+
+	// each key takes 2 operations (put+delete), so we can move maxTxnOps/2 keys per transaction
+	for kvs := res.Kvs; len(kvs) > 0; {
+		n := maxTxnOps / 2
+		if n > len(kvs) {
+			n = len(kvs)
+		}
+		chunk := kvs[:n]
+		kvs = kvs[n:]
+
+		cmps := make([]clientv3.Cmp, 0, len(chunk))
+		ops := make([]clientv3.Op, 0, 2*len(chunk))
+		for _, v := range chunk {
+			var putOpts []clientv3.OpOption
+			if v.Lease != 0 {
+				putOpts = append(putOpts, clientv3.WithLease(clientv3.LeaseID(v.Lease)))
 			}
-			logrus.Infof(logFmt, v.Key, len(dbuf))
-			os.Stdout.Write(dbuf)
+			nk := optNew + string(v.Key[len(optOld):])
+			cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(string(v.Key)), "=", v.ModRevision))
+			ops = append(ops, clientv3.OpPut(nk, string(v.Value), putOpts...), clientv3.OpDelete(string(v.Key)))
+		}
+
+		logrus.Debugf("Doing TXN(%s..%s)...", chunk[0].Key, chunk[len(chunk)-1].Key)
+		tres, err := client.Txn(ctx).If(cmps...).Then(ops...).Commit()
+		if err != nil {
+			logrus.WithError(err).Errorf("Failed to rename %s..%s", chunk[0].Key, chunk[len(chunk)-1].Key)
+			failed += len(chunk)
+		} else if !tres.Succeeded {
+			logrus.Errorf("Keys %s..%s modified concurrently, skipping", chunk[0].Key, chunk[len(chunk)-1].Key)
+			failed += len(chunk)
+		} else {
+			moved += len(chunk)
 		}
 	}
+
+	logrus.Infof("Renamed %d keys, %d failed.", moved, failed)
+	if failed > 0 {
+		return fmt.Errorf("Failed to rename %d keys", failed)
+	}
 	return nil
 }
 
-func actPut(c *cli.Context) error {
-	if c.NArg() < 2 {
-		return fmt.Errorf("Must specify <file|-> <key>")
+// readKeysFile reads the keys from the file (one per line; "-" reads from STDIN)
+func readKeysFile(fname string) ([]string, error) {
+	var (
+		buf []byte
+		err error
+		ret []string
+	)
+	if fname == "-" {
+		buf, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		buf, err = ioutil.ReadFile(fname)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range strings.Split(string(buf), "\n") {
+		if l = strings.TrimRight(l, "\r"); l != "" {
+			ret = append(ret, l)
+		}
 	}
+	return ret, nil
+}
 
+func actGet(c *cli.Context) error {
 	var (
 		client    = getEtcdClient()
-		optEncode = c.Bool("e64")
-		optFile   = c.Args().Get(0)
-		optKvPath = c.Args().Get(1)
-		in        = io.ReadCloser(os.Stdin)
+		optDecode = c.Bool("d64")
+		optAuto   = c.Bool("auto-decode")
+		optPretty = c.Bool("json-pretty")
+		optIndent = c.Int("indent")
+		optOutTpl = c.String("output-template-file")
+		optRecur  = c.Bool("recursive")
+		optJqRaw  = c.Bool("jq-raw")
+		optKeys   = c.Args().Slice()
+		logFmt    = "Got %s [%d]..."
+		failed    = failedKeys{op: "get"}
+		limit     keyLimit
+		optRev    int64
+		optOnComp = c.String("on-compacted")
+		optOutput = c.String("output")
+		arr       = &jsonArrayWriter{out: os.Stdout}
+		enc       = json.NewEncoder(os.Stdout)
+		tmpl      *keyTemplate
+		jq        *jqQuery
+		err       error
+		keyOpts   = func(key string) []clientv3.OpOption {
+			if strings.HasSuffix(key, "/") {
+				// dumping subtree
+				return []clientv3.OpOption{
+					clientv3.WithPrefix(),
+					clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend),
+				}
+			}
+			return nil
+		}
+		revOpts = revisionFilterOpts(c)
+		optsFn  = func(key string) []clientv3.OpOption {
+			opts := append(keyOpts(key), revOpts...)
+			if optRev > 0 {
+				opts = append(opts, clientv3.WithRev(optRev))
+			}
+			return opts
+		}
 	)
 
-	// figure out input
-	if optFile != "-" {
-		f, err := os.Open(optFile)
-		if err != nil {
+	if optOnComp != "latest" && optOnComp != "fail" {
+		return fmt.Errorf("Invalid --on-compacted policy '%s' (expected latest or fail)", optOnComp)
+	} else if optIndent < 0 {
+		return fmt.Errorf("Invalid --indent %d", optIndent)
+	}
+	if s := c.String("rev"); s != "" {
+		if optRev, err = parseRevision(s); err != nil {
 			return err
 		}
-		in = f
-		defer f.Close()
 	}
 
-	dbuf, err := ioutil.ReadAll(in)
-	if err != nil {
-		return err
+	if fname := c.String("keys-from"); fname != "" {
+		keys, err := readKeysFile(fname)
+		if err != nil {
+			return err
+		}
+		optKeys = append(optKeys, keys...)
 	}
-
-	dbgOpts := ""
-	if optEncode {
-		dbgOpts = ", b64 encoded"
-		ebuf := make([]byte, base64.StdEncoding.EncodedLen(len(dbuf)))
-		base64.StdEncoding.Encode(ebuf, dbuf)
-		dbuf = ebuf
+	if len(optKeys) <= 0 {
+		return fmt.Errorf("Must specify which keys to get")
 	}
 
-	logrus.Debugf("Doing PUT(%s,%#v)...", optFile, optKvPath)
-	_, err = client.Put(ctx, fileName2KvKey(optKvPath), string(dbuf))
-	checkErr(err)
-	logrus.Infof("Put %s [%d%s]...", optKvPath, len(dbuf), dbgOpts)
-
-	return nil
-}
+	if optDecode && optAuto {
+		return fmt.Errorf("Options --d64 and --auto-decode are mutually exclusive")
+	} else if optDecode {
+		logFmt = "Got %s [%d, b64-decoded]..."
+	}
 
-func main() {
-	if s := os.Getenv("ETCD_LISTEN_CLIENT_URLS"); s != "" {
-		opt.endpoints = s
+	switch optOutput {
+	case "raw", "json", "ndjson":
+	default:
+		return fmt.Errorf("Invalid output format '%s' (expected raw, json or ndjson)", optOutput)
 	}
 
-	app := cli.NewApp()
-	app.Version = version
-	app.Usage = "A dump/restore tool for etcd3."
-	app.UsageText = app.Name + " <list|get|put|remove|dump|upload|tar|zip> [command options] [arguments...]\n\n" +
-		`ENVIRONMENT VARIABLES:
-   ETCD_LISTEN_CLIENT_URLS      Changes default endpoint`
-	app.Flags = []cli.Flag{
-		&cli.StringFlag{
-			Name:        "endpoints, e",
-			Value:       opt.endpoints,
-			Usage:       "Specify endpoints",
-			Destination: &opt.endpoints,
-		},
-		&cli.IntFlag{
-			Name:        "timeout, T",
-			Value:       opt.timeout,
-			Usage:       "Specify timeout",
-			Destination: &opt.timeout,
-		},
-		&cli.BoolFlag{
-			Name:  "debug",
-			Usage: "Turn on debug output",
-		},
-		&cli.BoolFlag{
-			Name:  "quiet",
-			Usage: "Suppress info messages",
-		},
+	if optOutTpl != "" {
+		if optOutput != "raw" {
+			return fmt.Errorf("The --output-template-file option requires --output raw")
+		}
+		if tmpl, err = newKeyTemplate(c.String("template"), optOutTpl); err != nil {
+			return err
+		}
+	} else if c.String("template") != "" {
+		return fmt.Errorf("The --template option requires --output-template-file")
 	}
-	app.Before = func(c *cli.Context) error {
-		if c.Bool("debug") {
-			logrus.SetLevel(logrus.DebugLevel)
-			logrus.Debug("Logging level set to DEBUG")
-		} else if c.Bool("quiet") {
-			logrus.SetLevel(logrus.WarnLevel)
+
+	if expr := c.String("jq"); expr != "" {
+		if optOutput != "raw" || tmpl != nil || optPretty {
+			return fmt.Errorf("The --jq option requires --output raw (and no --output-template-file or --json-pretty)")
 		}
-		return nil
+		if jq, err = parseJqQuery(expr); err != nil {
+			return err
+		}
+	} else if optJqRaw {
+		return fmt.Errorf("The --jq-raw option requires --jq")
 	}
 
-	app.Commands = []*cli.Command{
-		{
-			Name:    "list",
-			Aliases: []string{"ls"},
-			Usage:   "list keys",
-			Action:  actList,
-		},
-		{
-			Name:   "get",
-			Usage:  "get entries",
-			Action: actGet,
-			Flags: []cli.Flag{
-				&cli.BoolFlag{
-					Name:  "d64",
-					Usage: "perform base64 decoding",
-				},
-			},
-			UsageText: app.Name + " get key1 [key2...]",
-		},
-		{
-			Name:   "put",
+	show := func(kvs []*mvccpb.KeyValue) (err error) {
+		if err = limit.add(int64(len(kvs))); err != nil {
+			return err
+		}
+		if optAuto {
+			if kvs, err = autoDecode(client, kvs); err != nil {
+				return err
+			}
+		}
+		for _, v := range kvs {
+			dbuf := v.Value
+			if optDecode {
+				dbuf = make([]byte, base64.StdEncoding.DecodedLen(len(v.Value)))
+				n, err := base64.StdEncoding.Decode(dbuf, v.Value)
+				if err != nil {
+					return err
+				}
+				dbuf = dbuf[:n]
+			}
+			if optOutput != "raw" {
+				rec := newKvRecord(v)
+				rec.Value = dbuf
+				if optOutput == "json" {
+					err = arr.write(rec)
+				} else {
+					err = enc.Encode(rec)
+				}
+				if err != nil {
+					return err
+				}
+				logrus.Debugf(logFmt, v.Key, len(dbuf))
+				continue
+			}
+			if jq != nil {
+				res, err := jq.eval(dbuf)
+				if err != nil {
+					logrus.Warnf("Skipping %s (%v)", v.Key, err)
+					continue
+				}
+				for _, r := range res {
+					out, err := jq.format(r, optJqRaw)
+					if err != nil {
+						return err
+					}
+					os.Stdout.Write(append(out, '\n'))
+				}
+				logrus.Debugf("Got %s [%d results]...", v.Key, len(res))
+				continue
+			}
+			if optPretty {
+				dbuf = jsonPretty(dbuf, strings.Repeat(" ", optIndent))
+			}
+			if tmpl != nil {
+				fname, err := tmpl.render(v, dbuf)
+				if err != nil {
+					return err
+				}
+				logrus.Infof("Wrote %s into %s...", v.Key, fname)
+				continue
+			}
+			logrus.Infof(logFmt, v.Key, len(dbuf))
+			os.Stdout.Write(dbuf)
+		}
+		return nil
+	}
+
+	if optRecur {
+		// the prefixes are read (and written) page by page, so the huge ranges are not loaded into memory at once
+		for _, a := range optKeys {
+			var (
+				rev        = optRev
+				start, end = a, clientv3.GetPrefixRangeEnd(a)
+				page       = func(res *clientv3.GetResponse) error {
+					if err := show(res.Kvs); err != nil {
+						return err
+					}
+					start = string(res.Kvs[len(res.Kvs)-1].Key) + "\x00"
+					return nil
+				}
+			)
+			if a == "" {
+				start, end = "\x00", "\x00"
+			}
+			err = rangePagesFrom(client, start, end, &rev, page, revOpts...)
+			if err == rpctypes.ErrCompacted && optOnComp == "latest" {
+				// the pages written so far are not repeated, the rest of the prefix is read at the latest revision
+				logrus.Warnf("Revision %d of %s was compacted, reading the rest from %q at the latest revision", rev, a, start)
+				rev = 0
+				err = rangePagesFrom(client, start, end, &rev, page, revOpts...)
+			}
+			if limit.exceeded() {
+				return err
+			} else if err != nil && !opt.failFast {
+				failed.add(a, err)
+				continue
+			}
+			checkErr(err)
+		}
+	} else {
+		err = parallelGet(client, optKeys, c.Int("parallel"), c.Float64("rate"), optsFn, func(a string, res *clientv3.GetResponse, err error) error {
+			if err == rpctypes.ErrCompacted && optOnComp == "latest" {
+				logrus.Warnf("Revision %d of %s was compacted, reading at the latest revision", optRev, a)
+				opts := append(keyOpts(a), revOpts...)
+				logrus.Debugf("Doing GET(%s,%#v)...", a, opts)
+				res, err = client.Get(ctx, a, opts...)
+			}
+			if err != nil && !opt.failFast {
+				failed.add(a, err)
+				return nil
+			}
+			checkErr(err)
+			return show(res.Kvs)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if optOutput == "json" {
+		if err = arr.Close(); err != nil {
+			return err
+		}
+	}
+	return failed.result("")
+}
+
+// jsonPretty re-indents the JSON content, or returns the content unchanged if not valid JSON
+func jsonPretty(in []byte, indent string) []byte {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, in, "", indent); err != nil {
+		logrus.WithError(err).Debug("Not a JSON value, skipping indentation")
+		return in
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+// mkdirs creates the empty v2-style "directory" keys for the parents of the key (e.g. `/a/` and `/a/b/` for `/a/b/c`)
+//   - the existing keys are left untouched, and the `done` map caches the directories created so far
+func mkdirs(client *clientv3.Client, key string, done map[string]bool) error {
+	for i := 1; i < len(key)-1; i++ {
+		if key[i] != '/' {
+			continue
+		}
+		dir := key[:i+1]
+		if done[dir] {
+			continue
+		}
+		logrus.Debugf("Doing MKDIR(%s)...", dir)
+		res, err := client.Txn(ctx).
+			If(clientv3.Compare(clientv3.CreateRevision(dir), "=", 0)).
+			Then(clientv3.OpPut(dir, "")).
+			Commit()
+		if err != nil {
+			return err
+		} else if res.Succeeded {
+			logrus.Infof("Created directory %s", dir)
+		}
+		done[dir] = true
+	}
+	return nil
+}
+
+// autoEncode base64-encodes the value only if it is not valid UTF-8 (`--auto-encode` option)
+//   - returns the operation recording the choice in the companion <key>.etcdtool-encoding key
+func autoEncode(key string, val []byte) ([]byte, clientv3.Op) {
+	ek := key + encodingKeySuffix
+	if utf8.Valid(val) {
+		logrus.Infof("Content of %s is text, storing as-is", key)
+		return val, clientv3.OpDelete(ek)
+	}
+	logrus.Infof("Content of %s is binary, storing b64 encoded", key)
+	ebuf := make([]byte, base64.StdEncoding.EncodedLen(len(val)))
+	base64.StdEncoding.Encode(ebuf, val)
+	return ebuf, clientv3.OpPut(ek, "base64")
+}
+
+// putValue puts the value (along with the extra operations in the same transaction), and returns the previous value
+//   - NOTE: the previous value is returned only if requested via clientv3.WithPrevKV()
+func putValue(client *clientv3.Client, key, val string, extra []clientv3.Op, opts ...clientv3.OpOption) (*mvccpb.KeyValue, error) {
+	if len(extra) <= 0 {
+		res, err := client.Put(ctx, key, val, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return res.PrevKv, nil
+	}
+	ops := append([]clientv3.Op{clientv3.OpPut(key, val, opts...)}, extra...)
+	res, err := client.Txn(ctx).Then(ops...).Commit()
+	if err != nil {
+		return nil, err
+	}
+	return res.Responses[0].GetResponsePut().PrevKv, nil
+}
+
+// createValue puts the value (and the extra operations) only if the key does not exist (atomically)
+//   - returns false if the key already exists
+func createValue(client *clientv3.Client, key, val string, extra []clientv3.Op, opts ...clientv3.OpOption) (bool, error) {
+	ops := append([]clientv3.Op{clientv3.OpPut(key, val, opts...)}, extra...)
+	res, err := client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(ops...).
+		Commit()
+	if err != nil {
+		return false, err
+	}
+	return res.Succeeded, nil
+}
+
+// autoDecode drops the companion encoding keys, and base64-decodes the values marked as encoded (`--auto-decode` option)
+func autoDecode(client *clientv3.Client, kvs []*mvccpb.KeyValue) ([]*mvccpb.KeyValue, error) {
+	var (
+		ret  = make([]*mvccpb.KeyValue, 0, len(kvs))
+		keys = make([]string, 0, len(kvs))
+	)
+	for _, v := range kvs {
+		if !bytes.HasSuffix(v.Key, []byte(encodingKeySuffix)) {
+			ret = append(ret, v)
+			keys = append(keys, string(v.Key)+encodingKeySuffix)
+		}
+	}
+	encs, err := batchGet(client, keys)
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range ret {
+		if encs[i] == nil || string(encs[i].Value) != "base64" {
+			continue
+		}
+		dbuf, err := base64.StdEncoding.DecodeString(string(v.Value))
+		if err != nil {
+			return nil, fmt.Errorf("Could not decode %s: %v", v.Key, err)
+		}
+		logrus.Debugf("Decoding %s (b64 encoded)", v.Key)
+		kv := *v
+		kv.Value = dbuf
+		ret[i] = &kv
+	}
+	return ret, nil
+}
+
+func actPut(c *cli.Context) error {
+	optValue := c.IsSet("value")
+	if optValue && c.NArg() != 1 {
+		return fmt.Errorf("Must specify --value <string> <key>")
+	} else if !optValue && c.NArg() < 2 {
+		return fmt.Errorf("Must specify <file|-> <key>")
+	}
+
+	var (
+		client    = getEtcdClient()
+		optEncode = c.Bool("e64")
+		optAuto   = c.Bool("auto-encode")
+		optFile   = c.Args().Get(0)
+		optKvPath = c.Args().Get(1)
+		optPrev   = c.Bool("prev") || c.String("prev-out") != ""
+		optKeep   = c.Bool("lease-keepalive")
+		in        = io.ReadCloser(os.Stdin)
+		dbuf      []byte
+		lease     clientv3.LeaseID
+		err       error
+	)
+
+	if optEncode && optAuto {
+		return fmt.Errorf("Options --e64 and --auto-encode are mutually exclusive")
+	} else if optPrev && c.Bool("if-not-exists") {
+		return fmt.Errorf("Options --prev and --if-not-exists are mutually exclusive")
+	} else if optKeep && c.Int64("ttl") <= 0 && c.String("lease") == "" {
+		return fmt.Errorf("The --lease-keepalive option requires --ttl or --lease")
+	}
+
+	// figure out input
+	if optValue {
+		optFile, optKvPath = "--value", c.Args().Get(0)
+		dbuf = []byte(c.String("value"))
+	} else {
+		if optFile != "-" {
+			f, err := os.Open(optFile)
+			if err != nil {
+				return err
+			}
+			in = f
+			defer f.Close()
+		}
+		if dbuf, err = ioutil.ReadAll(in); err != nil {
+			return err
+		}
+	}
+
+	dbgOpts := ""
+	if optEncode {
+		dbgOpts = ", b64 encoded"
+		ebuf := make([]byte, base64.StdEncoding.EncodedLen(len(dbuf)))
+		base64.StdEncoding.Encode(ebuf, dbuf)
+		dbuf = ebuf
+	}
+
+	var extraOps []clientv3.Op
+	if optAuto {
+		var op clientv3.Op
+		dbuf, op = autoEncode(fileName2KvKey(optKvPath), dbuf)
+		extraOps = append(extraOps, op)
+	}
+
+	var (
+		putOpts []clientv3.OpOption
+		prevLog *prevKvLog
+	)
+	if optPrev {
+		// the log is opened before the put, so the previous value cannot get lost
+		if prevLog, err = newPrevKvLog(c.String("prev-out")); err != nil {
+			return err
+		}
+		defer prevLog.Close()
+		putOpts = append(putOpts, clientv3.WithPrevKV())
+	}
+
+	lease, err = leaseOption(c, client)
+	checkErr(err)
+	if lease != clientv3.NoLease {
+		putOpts = append(putOpts, clientv3.WithLease(lease))
+		dbgOpts += fmt.Sprintf(", lease %x", lease)
+	}
+
+	if c.Bool("mkdirs") {
+		checkErr(mkdirs(client, fileName2KvKey(optKvPath), make(map[string]bool)))
+	}
+
+	if c.Bool("if-not-exists") {
+		logrus.Debugf("Doing PUT(%s,%#v,create-only)...", optFile, optKvPath)
+		created, err := createValue(client, fileName2KvKey(optKvPath), string(dbuf), extraOps, putOpts...)
+		checkErr(err)
+		if !created {
+			if c.Int64("ttl") > 0 {
+				// do not leave the new lease behind
+				_, err = client.Revoke(ctx, lease)
+				checkErr(err)
+			}
+			return fmt.Errorf("Key %s already exists", optKvPath)
+		}
+		logrus.Infof("Put %s [%d%s]...", optKvPath, len(dbuf), dbgOpts)
+		if optKeep {
+			return keepLeaseAlive(client, lease)
+		}
+		return nil
+	}
+
+	logrus.Debugf("Doing PUT(%s,%#v)...", optFile, optKvPath)
+	prevKv, err := putValue(client, fileName2KvKey(optKvPath), string(dbuf), extraOps, putOpts...)
+	checkErr(err)
+	logrus.Infof("Put %s [%d%s]...", optKvPath, len(dbuf), dbgOpts)
+
+	if optPrev {
+		if err = prevLog.record(optKvPath, prevKv); err != nil {
+			return err
+		}
+	}
+	if optKeep {
+		return keepLeaseAlive(client, lease)
+	}
+	return nil
+}
+
+func main() {
+	if s := os.Getenv("ETCD_LISTEN_CLIENT_URLS"); s != "" {
+		opt.endpoints = s
+	}
+
+	if err := newApp().Run(os.Args); err != nil {
+		logrus.Error(err)
+		os.Exit(1)
+	}
+}
+
+// newApp returns the application with all the commands and global flags
+func newApp() *cli.App {
+	app := cli.NewApp()
+	app.Version = version
+	app.Usage = "A dump/restore tool for etcd3."
+	app.UsageText = app.Name + " <list|stat|tree|du|count|get|put|edit|txn|watch|changelog|history|lease|remove|rename-prefix|cp|copy-key|sync|mirror|dump|upload|export|import|tar|zip|backupd|prune|untar|unzip|replay|verify-archive|verify|snapshot|convert|diff|defrag|status|member|alarm|move-leader|user|role|auth|check> [command options] [arguments...]\n\n" +
+		`ENVIRONMENT VARIABLES:
+   ETCD_LISTEN_CLIENT_URLS      Changes default endpoint
+   ETCDCTL_CACERT               Changes default --cacert
+   ETCDCTL_CERT                 Changes default --cert
+   ETCDCTL_KEY                  Changes default --key
+   ETCDCTL_USER                 Changes default --user
+   ETCDCTL_PASSWORD             Changes default --password
+   AWS_ENDPOINT_URL             Changes default --s3-endpoint
+
+   The ${VAR} references in the endpoints are expanded from the environment.`
+	app.Flags = []cli.Flag{
+		&cli.StringFlag{
+			Name:        "endpoints, e",
+			Value:       opt.endpoints,
+			Usage:       "Specify endpoints",
+			Destination: &opt.endpoints,
+		},
+		&cli.StringFlag{
+			Name:        "endpoints-from",
+			Usage:       "Read endpoints from given file",
+			Destination: &opt.endpointsFrom,
+		},
+		&cli.BoolFlag{
+			Name:        "endpoints-file-watch",
+			Usage:       "Reload endpoints when the --endpoints-from file changes",
+			Destination: &opt.endpointsWatch,
+		},
+		&cli.StringFlag{
+			Name:        "cacert",
+			Usage:       "Verify the server using given CA bundle",
+			EnvVars:     []string{"ETCDCTL_CACERT"},
+			Destination: &opt.cacert,
+		},
+		&cli.StringFlag{
+			Name:        "cert",
+			Usage:       "Identify to the server using given TLS certificate",
+			EnvVars:     []string{"ETCDCTL_CERT"},
+			Destination: &opt.cert,
+		},
+		&cli.StringFlag{
+			Name:        "key",
+			Usage:       "Identify to the server using given TLS key",
+			EnvVars:     []string{"ETCDCTL_KEY"},
+			Destination: &opt.key,
+		},
+		&cli.StringFlag{
+			Name:        "user",
+			Usage:       "Authenticate as given user (user[:password], prompts for the password if not given)",
+			EnvVars:     []string{"ETCDCTL_USER"},
+			Destination: &opt.user,
+		},
+		&cli.StringFlag{
+			Name:        "password",
+			Usage:       "Specify password of the --user",
+			EnvVars:     []string{"ETCDCTL_PASSWORD"},
+			Destination: &opt.password,
+		},
+		&cli.IntFlag{
+			Name:        "timeout, T",
+			Value:       opt.timeout,
+			Usage:       "Specify timeout (default for the timeouts below)",
+			Destination: &opt.timeout,
+		},
+		&cli.IntFlag{
+			Name:        "connect-timeout",
+			Usage:       "Specify timeout for the initial connection",
+			Destination: &opt.connectTimeout,
+		},
+		&cli.IntFlag{
+			Name:        "keepalive-time",
+			Usage:       "Specify keepalive interval (connection fails after 3x keepalive-time of inactivity)",
+			Destination: &opt.keepaliveTime,
+		},
+		&cli.IntFlag{
+			Name:        "op-timeout",
+			Usage:       "Specify timeout for each get/put/delete operation (not limited by default, unless --timeout is set)",
+			Destination: &opt.opTimeout,
+		},
+		&cli.BoolFlag{
+			Name:  "debug",
+			Usage: "Turn on debug output",
+		},
+		&cli.BoolFlag{
+			Name:  "quiet",
+			Usage: "Suppress info messages",
+		},
+		&cli.StringFlag{
+			Name:  "errors-to",
+			Usage: "Write the errors of the failed keys/prefixes into given file",
+		},
+		&cli.StringFlag{
+			Name:  "errors-format",
+			Value: "plain",
+			Usage: "Format of the --errors-to file (plain or json)",
+		},
+		&cli.BoolFlag{
+			Name:        "fail-fast",
+			Value:       opt.failFast,
+			Usage:       "Abort on first failed key/prefix (use --fail-fast=false to continue with the remaining ones)",
+			Destination: &opt.failFast,
+		},
+		&cli.Int64Flag{
+			Name:        "max-keys",
+			Usage:       "Abort if the command would process more than given number of keys (0 for no limit)",
+			Destination: &opt.maxKeys,
+		},
+		&cli.StringFlag{
+			Name:        "db",
+			Usage:       "Read the keys offline, from given etcd snapshot file (no cluster needed)",
+			Destination: &opt.db,
+		},
+		&cli.StringFlag{
+			Name:        "passphrase-file",
+			Usage:       "Read the passphrase of the encrypted archives from given file (prompts for the passphrase if not given)",
+			Destination: &opt.passphraseFile,
+		},
+		&cli.StringFlag{
+			Name:        "encryption-key-file",
+			Usage:       "Encrypt/decrypt the archives using the key from given file (32 bytes, or 64 hexadecimal characters)",
+			Destination: &opt.encKeyFile,
+		},
+		&cli.StringFlag{
+			Name:        "identity-file",
+			Usage:       "Decrypt the archives encrypted to the --recipient, using the age identities or GPG secret keys from given file",
+			Destination: &opt.identityFile,
+		},
+		&cli.StringFlag{
+			Name:        "s3-endpoint",
+			Usage:       "Use given S3-compatible endpoint for the s3://bucket/key archives (e.g. http://minio:9000)",
+			EnvVars:     []string{"AWS_ENDPOINT_URL"},
+			Destination: &opt.s3Endpoint,
+		},
+		&cli.StringFlag{
+			Name:        "remote-user",
+			Usage:       "Authenticate to the http(s):// and sftp:// archives as given user (user[:password], prompts for the password if not given)",
+			Destination: &opt.remoteUser,
+		},
+		&cli.StringFlag{
+			Name:        "remote-password",
+			Usage:       "Specify the password of the --remote-user",
+			Destination: &opt.remotePassword,
+		},
+		&cli.StringSliceFlag{
+			Name:  "http-header",
+			Usage: "Add given header to the requests of the http(s):// archives (e.g. 'Authorization: Bearer TOKEN'; can be repeated)",
+		},
+		&cli.StringFlag{
+			Name:        "sftp-identity-file",
+			Usage:       "Authenticate to the sftp:// archives using the SSH private key from given file (default is ssh-agent, and ~/.ssh/id_*)",
+			Destination: &opt.sftpIdentity,
+		},
+		&cli.StringFlag{
+			Name:        "sftp-known-hosts",
+			Usage:       "Verify the SSH host keys of the sftp:// archives using given file (default is ~/.ssh/known_hosts)",
+			Destination: &opt.sftpKnownHosts,
+		},
+		&cli.BoolFlag{
+			Name:        "sftp-insecure",
+			Usage:       "Skip verification of the SSH host keys of the sftp:// archives",
+			Destination: &opt.sftpInsecure,
+		},
+	}
+	app.Before = func(c *cli.Context) error {
+		if c.Bool("debug") {
+			logrus.SetLevel(logrus.DebugLevel)
+			logrus.Debug("Logging level set to DEBUG")
+		} else if c.Bool("quiet") {
+			logrus.SetLevel(logrus.WarnLevel)
+		}
+		opt.httpHeaders = c.StringSlice("http-header")
+		if c.IsSet("timeout") && !c.IsSet("op-timeout") {
+			// the explicit --timeout also limits the individual operations
+			opt.opTimeout = opt.timeout
+		}
+		if opt.endpointsFrom != "" {
+			ep, err := readEndpointsFile(opt.endpointsFrom)
+			if err != nil {
+				return err
+			}
+			opt.endpoints = ep
+		}
+		ep, err := expandEnv(opt.endpoints)
+		if err != nil {
+			return err
+		} else if ep != opt.endpoints {
+			logrus.Debugf("Endpoints expanded to %s", ep)
+			opt.endpoints = ep
+		}
+		if opt.db != "" {
+			if cmd := c.App.Command(c.Args().First()); cmd != nil && !offlineCommands[cmd.Name] {
+				return fmt.Errorf("Command %s does not support --db (offline mode)", cmd.Name)
+			}
+		}
+		if fname := c.String("errors-to"); fname != "" {
+			if errLog, err = openErrorsLog(fname, c.String("errors-format")); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	app.After = func(c *cli.Context) error {
+		if errLog != nil {
+			return errLog.Close()
+		}
+		return nil
+	}
+
+	app.Commands = []*cli.Command{
+		{
+			Name:      "list",
+			Aliases:   []string{"ls"},
+			Usage:     "list keys",
+			Action:    actList,
+			UsageText: app.Name + " list [-o plain|csv|json|table] [-l] <--all|prefix1 [prefix2...]>",
+			Flags: append([]cli.Flag{
+				&cli.BoolFlag{
+					Name:  "all",
+					Usage: "process the whole keyspace",
+				},
+				&cli.StringFlag{
+					Name:  "modified-since",
+					Usage: "list only keys modified at or after given revision (a revision number, not a timestamp)",
+				},
+				&cli.IntFlag{
+					Name:  "group-by-depth",
+					Usage: "instead of the keys, show key counts grouped by first N path components",
+				},
+				&cli.StringFlag{
+					Name:  "output, o",
+					Value: "plain",
+					Usage: "output format (plain: key names, csv: keys with metadata and values, json: keys with metadata, table: aligned columns)",
+				},
+				&cli.BoolFlag{
+					Name:  "long, l",
+					Usage: "also show the lease and value size columns (implies --output table)",
+				},
+				&cli.StringFlag{
+					Name:  "csv-delimiter",
+					Value: ",",
+					Usage: "field delimiter of the CSV output (e.g. '\\t' for TAB)",
+				},
+				&cli.BoolFlag{
+					Name:  "csv-header",
+					Value: true,
+					Usage: "write the header line of the CSV output",
+				},
+			}, append(keyFilterFlags(), revisionFilterFlags()...)...),
+		},
+		{
+			Name:   "stat",
+			Usage:  "show metadata of entries",
+			Action: actStat,
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "recursive, r",
+					Usage: "show aggregate statistics of all keys under given prefixes",
+				},
+				&cli.BoolFlag{
+					Name:  "json",
+					Usage: "output as JSON (one record per line)",
+				},
+			},
+			UsageText: app.Name + " stat [--recursive] key1 [key2...]",
+		},
+		{
+			Name:   "tree",
+			Usage:  "show the hierarchy of entries as a tree",
+			Action: actTree,
+			Flags: []cli.Flag{
+				&cli.IntFlag{
+					Name:  "level, L",
+					Usage: "descend at most given number of path components (0 for all)",
+				},
+				&cli.BoolFlag{
+					Name:  "dirs-only, d",
+					Usage: "show only the subtrees (not the individual keys)",
+				},
+			},
+			UsageText: app.Name + " tree [-L <level>] [-d] [prefix1 prefix2...]",
+			Description: `Tree command shows the keys under the prefixes (or the whole keyspace) as an indented tree, split on '/',
+   with the number of keys and total size of the values of each subtree (and the value size of each key).`,
+		},
+		{
+			Name:   "du",
+			Usage:  "show size of entries grouped by prefixes",
+			Action: actDu,
+			Flags: []cli.Flag{
+				&cli.IntFlag{
+					Name:  "depth, d",
+					Value: 1,
+					Usage: "group the keys by first N path components (below the given prefix)",
+				},
+				&cli.StringFlag{
+					Name:  "sort",
+					Value: "size",
+					Usage: "order of the groups (size, keys or name)",
+				},
+				&cli.BoolFlag{
+					Name:  "human, H",
+					Usage: "print the sizes in human-readable units (e.g. 1.5K, 12M)",
+				},
+			},
+			UsageText: app.Name + " du [--depth <N>] [--sort size|keys|name] [prefix1 prefix2...]",
+			Description: `Du command shows the total size of the values and the number of keys, grouped by the first N path
+   components below the prefixes (or in the whole keyspace), the largest groups first.`,
+		},
+		{
+			Name:   "count",
+			Usage:  "count entries, optionally grouped by prefixes",
+			Action: actCount,
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "all",
+					Usage: "process the whole keyspace",
+				},
+				&cli.IntFlag{
+					Name:  "depth, d",
+					Usage: "show the key counts grouped by first N path components (below the given prefix)",
+				},
+				&cli.StringFlag{
+					Name:  "sort",
+					Value: "name",
+					Usage: "order of the groups (name or keys)",
+				},
+			},
+			UsageText: app.Name + " count [--depth <N>] [--sort name|keys] <--all|prefix1 [prefix2...]>",
+			Description: `Count command shows the number of keys under the prefixes (or in the whole keyspace with --all), counted by the server
+   without transferring the keys. With --depth, the counts are broken down by the first N path components.`,
+		},
+		{
+			Name:   "get",
+			Usage:  "get entries",
+			Action: actGet,
+			Flags: append([]cli.Flag{
+				&cli.BoolFlag{
+					Name:  "d64",
+					Usage: "perform base64 decoding",
+				},
+				&cli.BoolFlag{
+					Name:  "json-pretty",
+					Usage: "pretty-print JSON values",
+				},
+				&cli.IntFlag{
+					Name:  "indent",
+					Value: 2,
+					Usage: "indentation width for --json-pretty",
+				},
+				&cli.StringFlag{
+					Name:  "output-template-file",
+					Usage: "write each key into a file named by given template (e.g. '/etc/app/{{.Name}}.conf')",
+				},
+				&cli.StringFlag{
+					Name:  "template",
+					Usage: "render the written files using given template file",
+				},
+				&cli.StringFlag{
+					Name:  "keys-from",
+					Usage: "also get the keys listed in given file (one per line, - for STDIN)",
+				},
+				&cli.IntFlag{
+					Name:  "parallel",
+					Value: 1,
+					Usage: "number of concurrent reads",
+				},
+				&cli.Float64Flag{
+					Name:  "rate",
+					Usage: "limit the reads to given number of keys per second (0 is unlimited)",
+				},
+				&cli.StringFlag{
+					Name:  "rev",
+					Usage: "read the keys at given (historical) revision",
+				},
+				&cli.StringFlag{
+					Name:  "on-compacted",
+					Value: "fail",
+					Usage: "what to do if the --rev revision was compacted (latest: read at the latest revision, fail)",
+				},
+				&cli.BoolFlag{
+					Name:  "recursive, r",
+					Usage: "get all keys under given prefixes (read and written page by page)",
+				},
+				&cli.StringFlag{
+					Name:  "output, o",
+					Value: "raw",
+					Usage: "output format (raw: the values, json: array of records, ndjson: one record per line)",
+				},
+				&cli.BoolFlag{
+					Name:  "auto-decode",
+					Usage: "perform base64 decoding of the values stored with --auto-encode",
+				},
+				&cli.StringFlag{
+					Name:  "jq",
+					Usage: "print the fields of the JSON values selected by given jq-style query (e.g. '.spec.replicas')",
+				},
+				&cli.BoolFlag{
+					Name:  "jq-raw",
+					Usage: "print the strings selected by --jq without quotes",
+				},
+			}, revisionFilterFlags()...),
+			UsageText: app.Name + " get [-r] [-o raw|json|ndjson] [--jq <query>] key1 [key2...]",
+		},
+		{
+			Name:   "put",
 			Usage:  "put entry",
 			Action: actPut,
 			Flags: []cli.Flag{
@@ -563,8 +2246,227 @@ func main() {
 					Name:  "e64",
 					Usage: "perform base64 encoding",
 				},
+				&cli.BoolFlag{
+					Name:  "auto-encode",
+					Usage: "perform base64 encoding only for binary (non UTF-8) content",
+				},
+				&cli.StringFlag{
+					Name:  "value",
+					Usage: "put the given string instead of the file content",
+				},
+				&cli.BoolFlag{
+					Name:  "prev",
+					Usage: "report the previous value of the key",
+				},
+				&cli.StringFlag{
+					Name:  "prev-out",
+					Usage: "save the previous value into given file (as JSON; implies --prev)",
+				},
+				&cli.BoolFlag{
+					Name:  "mkdirs",
+					Usage: "also create empty v2-style directory keys for the parents (e.g. /a/ for /a/b)",
+				},
+				&cli.Int64Flag{
+					Name:  "ttl",
+					Usage: "attach the key to a new lease with given TTL (in seconds)",
+				},
+				&cli.StringFlag{
+					Name:  "lease",
+					Usage: "attach the key to an existing lease (hexadecimal ID, see the lease grant command)",
+				},
+				&cli.BoolFlag{
+					Name:  "if-not-exists",
+					Usage: "put only if the key does not exist yet (fail otherwise)",
+				},
+				&cli.BoolFlag{
+					Name:  "lease-keepalive",
+					Usage: "keep the --ttl (or --lease) lease alive until interrupted (the key expires afterwards)",
+				},
+			},
+			UsageText: app.Name + " put <file|-> key\n   " + app.Name + " put --value <string> key",
+		},
+		{
+			Name:   "edit",
+			Usage:  "edit entry using $EDITOR",
+			Action: actEdit,
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "d64",
+					Usage: "perform base64 decoding (and encoding of the changed value)",
+				},
+			},
+			UsageText: app.Name + " edit [--d64] key",
+			Description: `Edit command opens the value of the key in the editor ($VISUAL, $EDITOR or vi), and writes it back if changed.
+   The value is written only if the key was not modified in the meantime (otherwise the changes are kept
+   in the temporary file).`,
+		},
+		{
+			Name:   "txn",
+			Usage:  "execute conditional transaction",
+			Action: actTxn,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "output, o",
+					Value: "plain",
+					Usage: "output format (plain or json)",
+				},
+				&cli.BoolFlag{
+					Name:  "require-success",
+					Usage: "fail if the comparisons do not succeed (i.e. the failure operations were executed)",
+				},
+			},
+			UsageText: app.Name + " txn [file|-]",
+			Description: `Txn command reads the transaction (from the file, or STDIN), and executes it atomically.
+   The input has 3 sections separated by empty lines -- the comparisons, and the operations
+   executed when all the comparisons succeed, or when any of them fails (same as etcdctl txn):
+
+      mod("/config/app") = "405"
+
+      put /config/app "new value"
+      del --prefix /cache/app/
+
+      get /config/app
+
+   The input can also be a JSON object with "compare", "success" and "failure" lists.`,
+		},
+		{
+			Name:   "watch",
+			Usage:  "watch entries for changes",
+			Action: actWatch,
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "prev-value",
+					Usage: "also show the previous values of the changed (or deleted) keys",
+				},
+				&cli.BoolFlag{
+					Name:  "diff",
+					Usage: "show the changes of the text values as line diffs (implies --prev-value)",
+				},
+				&cli.StringFlag{
+					Name:  "rev",
+					Usage: "start watching at given (historical) revision",
+				},
+				&cli.BoolFlag{
+					Name:  "prefix",
+					Usage: "watch all the keys starting with the key-parameters",
+				},
+				&cli.StringFlag{
+					Name:  "output, o",
+					Value: "plain",
+					Usage: "output format (plain or json)",
+				},
+			},
+			UsageText: app.Name + " watch key1 [key2/ ...]",
+			Description: `Watch command displays the changes of the entries, until interrupted.
+   If a key-parameter ends with '/' (e.g. key/), all the keys inside the "directory" are watched.
+   With --prefix, all the keys starting with the key-parameters are watched (e.g. key watches also key1 and key/a).`,
+		},
+		{
+			Name:   "changelog",
+			Usage:  "record the changes of entries into append-only file (continuous incremental backup)",
+			Action: actChangelog,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "f",
+					Usage: "append the changes to given file, and resume after its last change (default is STDOUT)",
+				},
+				&cli.BoolFlag{
+					Name:  "all",
+					Usage: "record the changes of the whole keyspace",
+				},
+				&cli.StringFlag{
+					Name:  "since-rev",
+					Usage: "record the changes after given revision (e.g. the revision of the base backup; default is the current revision)",
+				},
+			},
+			UsageText: app.Name + " changelog [-f <file>] [--since-rev <revision>] <--all|prefix1 [prefix2...]>",
+			Description: `Changelog command watches the prefixes, and appends the PUT and DELETE events (as JSON lines, same as watch --output json)
+   to the file, until interrupted. When restarted, the recording resumes after the last change recorded in the file.
+   Started with the --since-rev of a full backup, the changelog allows restoring the keys at any later revision.`,
+		},
+		{
+			Name:   "history",
+			Usage:  "show previous versions of entry",
+			Action: actHistory,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "rev",
+					Usage: "start at given (historical) revision (e.g. for the deleted keys)",
+				},
+				&cli.IntFlag{
+					Name:  "max-versions",
+					Usage: "show at most given number of versions (0 for all)",
+				},
+				&cli.BoolFlag{
+					Name:  "values",
+					Usage: "also show the values of the versions",
+				},
+				&cli.BoolFlag{
+					Name:  "diff",
+					Usage: "show the changes of the text values as line diffs against the previous versions",
+				},
+				&cli.StringFlag{
+					Name:  "output, o",
+					Value: "plain",
+					Usage: "output format (plain or json)",
+				},
 			},
-			UsageText: app.Name + " put <file|-> key",
+			UsageText: app.Name + " history [--diff] key",
+			Description: `History command shows the versions of the key (newest first), back to its creation.
+   Only the versions which were not compacted yet are available.`,
+		},
+		{
+			Name:  "lease",
+			Usage: "manage leases",
+			Subcommands: []*cli.Command{
+				{
+					Name:      "grant",
+					Usage:     "grant a new lease (prints the lease ID)",
+					Action:    actLeaseGrant,
+					UsageText: app.Name + " lease grant <ttl>",
+				},
+				{
+					Name:      "revoke",
+					Usage:     "revoke leases (deletes the attached keys)",
+					Action:    actLeaseRevoke,
+					UsageText: app.Name + " lease revoke id1 [id2...]",
+				},
+				{
+					Name:   "ttl",
+					Usage:  "show remaining TTL of leases",
+					Action: actLeaseTTL,
+					Flags: []cli.Flag{
+						&cli.BoolFlag{
+							Name:  "keys",
+							Usage: "also show the keys attached to the leases",
+						},
+						&cli.BoolFlag{
+							Name:  "json",
+							Usage: "output as JSON (one record per line)",
+						},
+					},
+					UsageText: app.Name + " lease ttl [--keys] id1 [id2...]",
+				},
+				{
+					Name:      "list",
+					Usage:     "list active leases",
+					Action:    actLeaseList,
+					UsageText: app.Name + " lease list",
+				},
+				{
+					Name:   "keepalive",
+					Usage:  "keep lease alive",
+					Action: actLeaseKeepAlive,
+					Flags: []cli.Flag{
+						&cli.BoolFlag{
+							Name:  "once",
+							Usage: "renew the lease once, and exit",
+						},
+					},
+					UsageText: app.Name + " lease keepalive [--once] id",
+				},
+			},
+			UsageText: app.Name + " lease <grant|revoke|ttl|list|keepalive> [arguments...]",
 		},
 		{
 			Name:    "remove",
@@ -576,38 +2478,186 @@ func main() {
 					Name:  "force, f",
 					Usage: "remove without prompting",
 				},
+				&cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "only show what would be removed",
+				},
+				&cli.BoolFlag{
+					Name:  "require-existing",
+					Usage: "fail if no keys were deleted for any of the arguments",
+				},
+				&cli.BoolFlag{
+					Name:  "print-deleted",
+					Usage: "print the names of the deleted keys",
+				},
+				&cli.BoolFlag{
+					Name:  "json",
+					Usage: "print the deleted keys and their values as JSON records (implies --print-deleted)",
+				},
+				&cli.StringFlag{
+					Name:  "prev-out",
+					Usage: "save the deleted keys and their values into given file (as JSON lines, see the import command)",
+				},
+				&cli.DurationFlag{
+					Name:  "older-than",
+					Usage: "remove only keys with leases granted (or renewed) before given duration (e.g. 24h)",
+				},
 			},
 			UsageText: app.Name + " rm key1 [key2/ ...]",
 			Description: `Remove command removes entries (or directories) from the EtcD.
    If a key-parameter ends with '/' (e.g. key/), the key will be interpreted as a "directory",
-   and everything inside will be removed _recursively_.`,
+   and everything inside will be removed _recursively_.
+   The --older-than option selects only the keys attached to leases, which were granted
+   (or last renewed) before given duration -- etcd does not record the age of the other keys.`,
+		},
+		{
+			Name:    "rename-prefix",
+			Aliases: []string{"mv"},
+			Usage:   "rename all entries under a prefix",
+			Action:  actRenamePrefix,
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "force, f",
+					Usage: "rename without prompting",
+				},
+				&cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "only show what would be renamed",
+				},
+			},
+			UsageText: app.Name + " rename-prefix <old-prefix> <new-prefix>",
+			Description: `Rename-prefix command moves all entries under <old-prefix> into <new-prefix>.
+   The entries are moved in batches of transactions, so each entry is either moved or left in place,
+   and entries modified concurrently are skipped (and reported as failed).`,
+		},
+		{
+			Name:   "cp",
+			Usage:  "copy entry (or with -r, all entries under a prefix)",
+			Action: actCopy,
+			Flags: append([]cli.Flag{
+				&cli.BoolFlag{
+					Name:  "recursive, r",
+					Usage: "copy all entries under the <src> prefix into the <dst> prefix",
+				},
+				&cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "only show what would be copied",
+				},
+			}, targetFlags("copy into")...),
+			UsageText: app.Name + " cp [-r] <src> <dst>\n   " +
+				app.Name + " cp [-r] --target-endpoints <endpoints> <src> [dst]",
+			Description: `Cp command copies the <src> entry into <dst> (within the same cluster, or into the --target-endpoints cluster).
+   With -r, all entries under the <src> prefix are copied under the <dst> prefix, in batches of transactions.`,
+		},
+		{
+			Name:   "copy-key",
+			Usage:  "copy entry (optionally into another cluster)",
+			Action: actCopyKey,
+			Flags: append(targetFlags("write into"),
+				&cli.BoolFlag{
+					Name:  "e64",
+					Usage: "perform base64 encoding of the copied value",
+				},
+				&cli.BoolFlag{
+					Name:  "d64",
+					Usage: "perform base64 decoding of the copied value",
+				},
+			),
+			UsageText: app.Name + " copy-key [--target-endpoints <endpoints>] <src-key> <dst-key>",
+		},
+		{
+			Name:   "sync",
+			Usage:  "make entries under prefix identical to another prefix (optionally in another cluster)",
+			Action: actSync,
+			Flags: append([]cli.Flag{
+				&cli.BoolFlag{
+					Name:  "delete",
+					Usage: "also delete the <dst> keys which are missing under <src>",
+				},
+				&cli.BoolFlag{
+					Name:  "dry-run, plan",
+					Usage: "only show what would be changed",
+				},
+			}, targetFlags("write into")...),
+			UsageText: app.Name + " sync [--delete] <src-prefix> <dst-prefix>\n   " +
+				app.Name + " sync [--delete] --target-endpoints <endpoints> <src-prefix> [dst-prefix]",
+			Description: `Sync command creates the missing and updates the changed keys under <dst-prefix>, so they match the keys under <src-prefix>.
+   With --delete, the extra keys under <dst-prefix> are deleted as well, so both prefixes are identical.
+   The unchanged keys are not written, and the changes are applied in batches of transactions.`,
+		},
+		{
+			Name:   "mirror",
+			Usage:  "continuously replicate entries under prefix into another prefix (or cluster)",
+			Action: actMirror,
+			Flags: append([]cli.Flag{
+				&cli.BoolFlag{
+					Name:  "delete",
+					Usage: "also delete the <dst> keys which are missing under <src> on the initial sync",
+				},
+				&cli.StringFlag{
+					Name:  "rev-file",
+					Usage: "record the last replicated revision into given file (and resume from it on restart)",
+				},
+				&cli.StringFlag{
+					Name:  "progress-file",
+					Usage: "periodically write the progress (as JSON) into given file",
+				},
+				&cli.BoolFlag{
+					Name:  "dry-run, plan",
+					Usage: "only show what the initial sync would change (and exit)",
+				},
+			}, targetFlags("write into")...),
+			UsageText: app.Name + " mirror [--rev-file <file>] <src-prefix> <dst-prefix>\n   " +
+				app.Name + " mirror [--rev-file <file>] --target-endpoints <endpoints> <src-prefix> [dst-prefix]",
+			Description: `Mirror command syncs the keys under <dst-prefix> (same as the sync command), and then follows
+   the changes under <src-prefix>, and replicates them into <dst-prefix> until interrupted.
+   If the watch fails, the replication resumes from the last replicated revision (or re-syncs, if the revision was compacted).`,
 		},
 		{
 			Name:   "dump",
 			Usage:  "dump entries",
 			Action: actDump,
-			Flags: []cli.Flag{
+			Flags: append([]cli.Flag{
+				&cli.StringFlag{
+					Name:  "format",
+					Value: "dir",
+					Usage: "output format (dir, tar, tar.gz, tar.zst, tar.xz, tar.bz2, zip, json or ndjson)",
+				},
 				&cli.StringFlag{
 					Name:  "directory, C",
-					Usage: "dump entries into given directory",
+					Usage: "dump entries into given directory (dir format)",
 				},
-				&cli.BoolFlag{
-					Name:  "d64",
-					Usage: "perform base64 decoding",
+				&cli.StringFlag{
+					Name:  "f",
+					Usage: "specify output filename, or s3://, gs://, azblob://, http(s):// or sftp:// URL (archive formats; default is STDOUT)",
 				},
 				&cli.BoolFlag{
-					Name:  "strip",
-					Usage: "strip path(s) of the key",
+					Name:  "zstd",
+					Usage: "compress the dumped files (zstd; adds .zst extension), or the TAR archive",
 				},
-			},
-			UsageText: app.Name + " dump [-C <dir>] key1 [key2...]",
+				&cli.IntFlag{
+					Name:  "zstd-level",
+					Value: 3,
+					Usage: "zstd compression level (1-22)",
+				},
+				&cli.StringFlag{
+					Name:  "output-template-file",
+					Usage: "write each key into a file named by given template (e.g. '/etc/app/{{.Name}}.conf')",
+				},
+				&cli.StringFlag{
+					Name:  "template",
+					Usage: "render the written files using given template file",
+				},
+			}, dumpFlags()...),
+			UsageText: app.Name + " dump [-C <dir>] <--all|key1 [key2...]>\n   " +
+				app.Name + " dump --format <tar|tar.gz|tar.zst|tar.xz|tar.bz2|zip|json|ndjson> [-f <file>] <--all|key1 [key2...]>",
 		},
 		{
 			Name:    "upload",
 			Aliases: []string{"up"},
 			Usage:   "upload entries",
 			Action:  actUpload,
-			Flags: []cli.Flag{
+			Flags: append([]cli.Flag{
 				&cli.StringFlag{
 					Name:  "directory, C",
 					Usage: "load entries from given directory",
@@ -616,44 +2666,664 @@ func main() {
 					Name:  "e64",
 					Usage: "perform base64 encoding",
 				},
+				&cli.BoolFlag{
+					Name:  "auto-encode",
+					Usage: "perform base64 encoding only for binary (non UTF-8) content",
+				},
 				&cli.StringFlag{
 					Name:  "prefix",
 					Usage: "prefix the keys on upload",
 				},
-			},
+				&cli.StringSliceFlag{
+					Name:  "trim-extension",
+					Usage: "strip file extension from the keys (e.g. .json; can be repeated)",
+				},
+				&cli.BoolFlag{
+					Name:  "prev",
+					Usage: "report the previous values of the keys",
+				},
+				&cli.StringFlag{
+					Name:  "prev-out",
+					Usage: "save the previous values into given file (as JSON lines; implies --prev)",
+				},
+				&cli.StringFlag{
+					Name:  "progress-file",
+					Usage: "periodically write the progress (as JSON) into given file",
+				},
+				&cli.BoolFlag{
+					Name:  "no-ignore",
+					Usage: "do not skip the files listed in the .etcdignore files of the uploaded directories",
+				},
+				&cli.BoolFlag{
+					Name:  "mkdirs",
+					Usage: "also create empty v2-style directory keys for the parents (e.g. /a/ for /a/b)",
+				},
+				&cli.BoolFlag{
+					Name:  "restore-leases",
+					Usage: "attach the keys to new leases, granted with the TTLs recorded in the manifest (requires -C)",
+				},
+				&cli.Int64Flag{
+					Name:  "ttl",
+					Usage: "attach all the keys to a new lease with given TTL (in seconds)",
+				},
+				&cli.StringFlag{
+					Name:  "lease",
+					Usage: "attach all the keys to an existing lease (hexadecimal ID, see the lease grant command)",
+				},
+			}, keyFilterFlags()...),
 			UsageText: app.Name + " upload [-C dir] dir1 [dir2...]",
 		},
+		{
+			Name:   "export",
+			Usage:  "export entries as JSON lines",
+			Action: actExport,
+			Flags: append([]cli.Flag{
+				&cli.BoolFlag{
+					Name:  "all",
+					Usage: "process the whole keyspace",
+				},
+				&cli.StringFlag{
+					Name:  "f",
+					Usage: "specify output filename",
+				},
+				&cli.Int64Flag{
+					Name:  "split-by-size",
+					Usage: "split output into files of given max size (bytes)",
+				},
+				&cli.Int64Flag{
+					Name:  "split-by-count",
+					Usage: "split output into files of given max number of keys",
+				},
+				&cli.StringFlag{
+					Name:  "format",
+					Value: "ndjson",
+					Usage: "output format (ndjson: one record per line, yaml: single document mapping the keys to the values)",
+				},
+				&cli.BoolFlag{
+					Name:  "with-metadata",
+					Usage: "also write the revisions, versions and leases of the keys into the YAML document",
+				},
+			}, keyFilterFlags()...),
+			UsageText: app.Name + " export [-f <file.ndjson>] [--split-by-size <bytes>] <--all|key1 [key2...]>",
+			Description: `Export command writes the entries as JSON records (one per line), with base64-encoded values.
+   When splitting the output, the files will be named <file>.001.ndjson, <file>.002.ndjson, etc.
+   With --format yaml, the entries are written as a single YAML document, mapping the keys to the values.`,
+		},
+		{
+			Name:   "import",
+			Usage:  "import entries from JSON lines",
+			Action: actImport,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "format",
+					Value: "auto",
+					Usage: "input format (json: array of records, ndjson: one record per line, yaml: mapping of the keys, auto: detect)",
+				},
+				&cli.StringFlag{
+					Name:  "prefix",
+					Usage: "prefix the keys on import",
+				},
+			},
+			UsageText: app.Name + " import [--prefix <prefix>] <file.ndjson|-> [file2.*.ndjson...]",
+		},
 		{
 			Name:   "tar",
-			Usage:  "create TAR archive from the EtcD entries",
+			Usage:  "create TAR archive from the EtcD entries (deprecated: use dump --format tar)",
 			Action: actTar,
-			Flags: []cli.Flag{
+			Flags: append([]cli.Flag{
 				&cli.StringFlag{
 					Name:  "f",
-					Usage: "specify TAR filename",
+					Usage: "specify TAR filename (or s3://, gs://, azblob://, http(s):// or sftp:// URL)",
 				},
 				&cli.BoolFlag{
 					Name:  "z",
 					Usage: "compress archive (GZip)",
 				},
-			},
-			UsageText: app.Name + " tar [-f <file.tar>] [-z] key1 [key2...]",
+				&cli.BoolFlag{
+					Name:  "J",
+					Usage: "compress archive (xz)",
+				},
+				&cli.BoolFlag{
+					Name:  "j",
+					Usage: "compress archive (bzip2)",
+				},
+				&cli.BoolFlag{
+					Name:  "zstd",
+					Usage: "compress archive (zstd)",
+				},
+				&cli.IntFlag{
+					Name:  "zstd-level",
+					Value: 3,
+					Usage: "zstd compression level (1-22)",
+				},
+			}, dumpFlags()...),
+			UsageText: app.Name + " tar [-f <file.tar>] [-z|-J|-j|--zstd] <--all|key1 [key2...]>",
 		},
 		{
 			Name:   "zip",
-			Usage:  "create ZIP archive from the EtcD entries",
+			Usage:  "create ZIP archive from the EtcD entries (deprecated: use dump --format zip)",
 			Action: actZip,
+			Flags: append([]cli.Flag{
+				&cli.StringFlag{
+					Name:  "f",
+					Usage: "specify ZIP filename (or s3://, gs://, azblob://, http(s):// or sftp:// URL)",
+				},
+			}, dumpFlags()...),
+			UsageText: app.Name + " zip -f <file.zip> <--all|key1 [key2...]>",
+		},
+		{
+			Name:   "backupd",
+			Usage:  "run scheduled backups in the foreground (cron-style schedule)",
+			Action: actBackupd,
+			Flags: append([]cli.Flag{
+				&cli.StringFlag{
+					Name:  "schedule",
+					Usage: "cron-style schedule of the backups (e.g. \"0 2 * * *\", or @daily, @every 6h)",
+				},
+				&cli.StringFlag{
+					Name:  "target",
+					Usage: "specify backup filename, or s3://, gs://, azblob://, http(s):// or sftp:// URL (%Y, %m, %d, %H, %M and %S expand to the UTC time)",
+				},
+				&cli.StringFlag{
+					Name:  "format",
+					Value: "tar.gz",
+					Usage: "archive format (tar, tar.gz, tar.zst, tar.xz, tar.bz2, zip, json or ndjson)",
+				},
+				&cli.BoolFlag{
+					Name:  "zstd",
+					Usage: "compress the TAR archive (zstd)",
+				},
+				&cli.IntFlag{
+					Name:  "zstd-level",
+					Value: 3,
+					Usage: "zstd compression level (1-22)",
+				},
+				&cli.StringFlag{
+					Name:  "health-addr",
+					Usage: "serve the status of the backups on http://<addr>/healthz (e.g. :8080)",
+				},
+				&cli.BoolFlag{
+					Name:  "run-now",
+					Usage: "also run the backup on start",
+				},
+			}, append(pruneFlags(), dumpFlags()...)...),
+			UsageText: app.Name + " backupd --schedule <cron> --target <file|URL> [--health-addr <addr>] <--all|key1 [key2...]>",
+			Description: `Backupd command runs in the foreground, and dumps the keys into the --target on the --schedule (same as the dump command),
+   until interrupted. Each backup is consistent (all the keys are read at the same revision), and the backups do not overlap.
+   The --health-addr serves the status of the last backup as JSON (with the 503 status, if the last backup failed).
+   With --keep-days or --keep-count, the old backups are pruned after each successful (and verified) backup (same as the prune command).`,
+		},
+		{
+			Name:   "prune",
+			Usage:  "delete the old backups",
+			Action: actPrune,
+			Flags: append([]cli.Flag{
+				&cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "only list the backups which would be deleted",
+				},
+			}, pruneFlags()...),
+			UsageText: app.Name + " prune [--keep-days <days>] [--keep-count <count>] [--dry-run] <target>",
+			Description: `Prune command lists the backups matching the <target> file or URL (with the %Y, %m, %d, %H, %M and %S time fields,
+   same as the backupd --target), and deletes the ones older than --keep-days, and beyond the --keep-count newest ones.
+   The newest complete backup is never deleted, and the failed backups (with the <file>.errors) are not counted.
+   The volumes of the split archives and the <file>.errors are deleted with the backup.`,
+		},
+		{
+			Name:   "untar",
+			Usage:  "restore EtcD entries from TAR archive",
+			Action: actUntar,
+			Flags: append([]cli.Flag{
+				&cli.StringFlag{
+					Name:  "f",
+					Usage: "specify TAR filename, or s3://, gs://, azblob://, http(s):// or sftp:// URL (default is STDIN)",
+				},
+				&cli.StringFlag{
+					Name:  "compression",
+					Value: "auto",
+					Usage: "compression of the archive (auto, none, gzip, zstd, xz or bzip2)",
+				},
+				&cli.BoolFlag{
+					Name:  "z",
+					Usage: "force GZip decompression (same as --compression gzip)",
+				},
+			}, restoreFlags()...),
+			UsageText: app.Name + " untar [-f <file.tar|file.tar.gz|file.tar.zst|file.tar.xz|file.tar.bz2>] [--prefix <prefix>]",
+			Description: `Untar command puts the entries of the TAR archive back into the EtcD.
+   The archive compression (GZip, zstd, xz or bzip2) is detected automatically, unless forced via --compression (or -z).
+   The archive is read only once, and the keys are restored after the whole archive was read successfully.`,
+		},
+		{
+			Name:   "unzip",
+			Usage:  "restore EtcD entries from ZIP archive",
+			Action: actUnzip,
+			Flags: append([]cli.Flag{
+				&cli.StringFlag{
+					Name:  "f",
+					Usage: "specify ZIP filename (or s3://, gs://, azblob://, http(s):// or sftp:// URL)",
+				},
+			}, restoreFlags()...),
+			UsageText:   app.Name + " unzip -f <file.zip> [--prefix <prefix>]",
+			Description: `Unzip command puts the entries of the ZIP archive back into the EtcD.`,
+		},
+		{
+			Name:   "replay",
+			Usage:  "restore EtcD entries at given revision, from the full backup and changelogs",
+			Action: actReplay,
+			Flags: append([]cli.Flag{
+				&cli.StringFlag{
+					Name:  "until-rev",
+					Usage: "replay the changes up to given revision (default is all the recorded changes)",
+				},
+			}, restoreFlags()...),
+			UsageText: app.Name + " replay [--until-rev <revision>] [--prefix <prefix>] <backup.tar|backup.zip> <changelog1> [changelog2...]",
+			Description: `Replay command restores the base backup (TAR or ZIP archive with manifest, local or remote), then applies the
+   PUT and DELETE events recorded by the changelog command after the revision of the backup, up to the --until-rev.
+   The changelogs (optionally compressed) must be given in order. The events of each revision are applied together.`,
+		},
+		{
+			Name:   "verify-archive",
+			Usage:  "verify TAR or ZIP archive",
+			Action: actVerifyArchive,
 			Flags: []cli.Flag{
 				&cli.StringFlag{
 					Name:  "f",
-					Usage: "specify ZIP filename",
+					Usage: "specify archive filename (or s3://, gs://, azblob://, http(s):// or sftp:// URL)",
+				},
+				&cli.BoolFlag{
+					Name:  "compare",
+					Usage: "also compare the archive against the EtcD content",
 				},
 			},
-			UsageText: app.Name + " zip -f <file.tar> key1 [key2...]",
+			UsageText: app.Name + " verify-archive -f <file.tar|file.tar.gz|file.tar.zst|file.tar.xz|file.tar.bz2|file.zip>",
+			Description: `Verify-archive command checks that all the entries of the archive are readable (without connecting to the EtcD).
+   The archive type (TAR, TAR-GZ, TAR-ZST, TAR-XZ, TAR-BZ2 or ZIP) is detected automatically, and the entries are
+   checked against the SHA-256 checksums of the archive manifest (see also the verify command).
+   With --compare, the archived entries are also compared against the EtcD keys (read in batched transactions).`,
+		},
+		{
+			Name:   "verify",
+			Usage:  "verify archive checksums (optionally against the EtcD content)",
+			Action: actVerify,
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "compare",
+					Usage: "also compare the archive against the EtcD content (to detect the drift)",
+				},
+			},
+			UsageText: app.Name + " verify [--compare] <archive>",
+			Description: `Verify command checks that all the entries of the archive are readable, and match the SHA-256 checksums
+   recorded in the archive manifest (the corrupted and lost keys are listed).
+   With --compare, the archived entries are also compared against the EtcD keys, listing the keys that are missing
+   or changed since the archive was written.`,
+		},
+		{
+			Name:  "snapshot",
+			Usage: "manage etcd snapshots (physical backups)",
+			Subcommands: []*cli.Command{
+				{
+					Name:      "save",
+					Usage:     "save snapshot of the etcd database into file",
+					Action:    actSnapshotSave,
+					UsageText: app.Name + " snapshot save <file.db>",
+					Description: `Snapshot save command streams the whole database of the connected etcd member into the file.
+   The file is the same as saved by etcdctl snapshot save (i.e. can be restored by etcdctl snapshot restore),
+   and it can also be read offline via the --db option.`,
+				},
+			},
+			UsageText: app.Name + " snapshot <save> [arguments...]",
+		},
+		{
+			Name:   "convert",
+			Usage:  "convert snapshot or archive into another backup format",
+			Action: actConvert,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "format",
+					Usage: "output format (tar, tar.gz, tar.zst, tar.xz, tar.bz2, zip, json or ndjson; default is detected by the output extension)",
+				},
+				&cli.IntFlag{
+					Name:  "zstd-level",
+					Value: 3,
+					Usage: "zstd compression level (1-22)",
+				},
+			},
+			UsageText: app.Name + " convert [--format <format>] <snapshot.db|archive> <output|-> [prefix1 prefix2...]",
+			Description: `Convert command reads the keys from the etcd snapshot (e.g. saved by the snapshot save command),
+   or from the TAR or ZIP archive, and writes them in given format (without connecting to the EtcD).
+   The JSON and NDJSON outputs can be imported back via the import command.
+   If the prefixes are given, only the keys under the prefixes are converted.`,
+		},
+		{
+			Name:   "diff",
+			Usage:  "compare entries under two prefixes, clusters, or against archive",
+			Action: actDiff,
+			Flags: append([]cli.Flag{
+				&cli.StringFlag{
+					Name:  "f",
+					Usage: "compare the TAR or ZIP archive (instead of <prefix-a> in the EtcD)",
+				},
+				&cli.StringFlag{
+					Name:  "directory, C",
+					Usage: "compare the dumped directory (instead of <prefix-a> in the EtcD)",
+				},
+				&cli.BoolFlag{
+					Name:  "brief, q",
+					Usage: "only list the added, removed and changed keys",
+				},
+			}, targetFlags("compare with")...),
+			UsageText: app.Name + " diff <prefix-a> <prefix-b>\n   " +
+				app.Name + " diff [-f <archive>|-C <dir>] [--target-endpoints <endpoints>] <prefix-a> [prefix-b]",
+			Description: `Diff command compares the keys under <prefix-a> with the keys under <prefix-b> (default is <prefix-a>).
+   The <prefix-a> keys are read from the archive (-f), the dumped directory (-C), or the EtcD,
+   while the <prefix-b> keys are read from the EtcD (or the --target-endpoints cluster).
+   The added, removed and changed keys are shown in a unified-diff-like format (the exit code is non-zero if the keys differ).`,
+		},
+		{
+			Name:   "defrag",
+			Usage:  "defragment the database of the etcd members",
+			Action: actDefrag,
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "cluster",
+					Usage: "defragment all the cluster members (default is the --endpoints)",
+				},
+				&cli.IntFlag{
+					Name:  "defrag-timeout",
+					Value: 300,
+					Usage: "timeout of defragmenting each member (in seconds)",
+				},
+			},
+			UsageText: app.Name + " defrag [--cluster]",
+			Description: `Defrag command defragments the endpoints one by one, so the space of the deleted and compacted keys
+   is released (the member does not serve the requests while defragmenting).
+   Each member is health-checked before and after the defragmentation, and the command stops at the first unhealthy member.`,
+		},
+		{
+			Name:    "status",
+			Aliases: []string{"health"},
+			Usage:   "show status and health of the etcd members",
+			Action:  actStatus,
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "cluster",
+					Usage: "show all the cluster members (default is the --endpoints)",
+				},
+				&cli.StringFlag{
+					Name:  "output, o",
+					Value: "table",
+					Usage: "output format (table or json)",
+				},
+			},
+			UsageText: app.Name + " status [--cluster] [-o table|json]",
+			Description: `Status command shows the version, database size, leadership, raft term and index, and the health
+   of each endpoint (the health also reports the active alarms, e.g. NOSPACE).
+   The exit code is non-zero if any of the endpoints is unhealthy.`,
+		},
+		{
+			Name:  "member",
+			Usage: "manage cluster members",
+			Subcommands: []*cli.Command{
+				{
+					Name:   "list",
+					Usage:  "list cluster members",
+					Action: actMemberList,
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:  "output, o",
+							Value: "table",
+							Usage: "output format (table or json)",
+						},
+					},
+					UsageText: app.Name + " member list [-o table|json]",
+				},
+				{
+					Name:   "add",
+					Usage:  "add a new member (prints the configuration of the new member)",
+					Action: actMemberAdd,
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:  "peer-urls",
+							Usage: "peer URLs of the new member (comma-separated)",
+						},
+						&cli.BoolFlag{
+							Name:  "learner",
+							Usage: "add the member as a non-voting learner (see member promote)",
+						},
+					},
+					UsageText: app.Name + " member add --peer-urls <urls> [--learner] <name>",
+				},
+				{
+					Name:      "remove",
+					Usage:     "remove members",
+					Action:    actMemberRemove,
+					UsageText: app.Name + " member remove id1 [id2...]",
+				},
+				{
+					Name:   "update",
+					Usage:  "update peer URLs of a member",
+					Action: actMemberUpdate,
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:  "peer-urls",
+							Usage: "new peer URLs of the member (comma-separated)",
+						},
+					},
+					UsageText: app.Name + " member update --peer-urls <urls> <id>",
+				},
+				{
+					Name:      "promote",
+					Usage:     "promote a learner to voting member",
+					Action:    actMemberPromote,
+					UsageText: app.Name + " member promote <id>",
+				},
+			},
+			UsageText: app.Name + " member <list|add|remove|update|promote> [arguments...]",
+		},
+		{
+			Name:  "alarm",
+			Usage: "manage cluster alarms (e.g. NOSPACE)",
+			Subcommands: []*cli.Command{
+				{
+					Name:      "list",
+					Usage:     "list active alarms",
+					Action:    actAlarmList,
+					UsageText: app.Name + " alarm list",
+				},
+				{
+					Name:   "disarm",
+					Usage:  "disarm active alarms",
+					Action: actAlarmDisarm,
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:  "member",
+							Usage: "only disarm the alarms of given member (hexadecimal ID)",
+						},
+					},
+					UsageText: app.Name + " alarm disarm [--member <id>]",
+				},
+			},
+			UsageText: app.Name + " alarm <list|disarm> [arguments...]",
+		},
+		{
+			Name:      "move-leader",
+			Usage:     "transfer the leadership to another member",
+			Action:    actMoveLeader,
+			UsageText: app.Name + " move-leader <member-id>",
+			Description: `Move-leader command transfers the raft leadership to given member (hexadecimal ID, see member list),
+   e.g. before the defragmentation or maintenance of the current leader.
+   The current leader is found automatically (the --endpoints can point to any member).`,
+		},
+		{
+			Name:  "user",
+			Usage: "manage users",
+			Subcommands: []*cli.Command{
+				{
+					Name:   "add",
+					Usage:  "add a new user",
+					Action: actUserAdd,
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:  "new-password",
+							Usage: "password of the new user (prompts on the terminal if not given)",
+						},
+						&cli.BoolFlag{
+							Name:  "no-password",
+							Usage: "add the user without password (can only authenticate via TLS common name)",
+						},
+					},
+					UsageText: app.Name + " user add [--new-password <pw>|--no-password] <name>",
+				},
+				{
+					Name:      "delete",
+					Usage:     "delete users",
+					Action:    actUserDelete,
+					UsageText: app.Name + " user delete name1 [name2...]",
+				},
+				{
+					Name:   "passwd",
+					Usage:  "change password of a user",
+					Action: actUserPasswd,
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:  "new-password",
+							Usage: "new password of the user (prompts on the terminal if not given)",
+						},
+					},
+					UsageText: app.Name + " user passwd [--new-password <pw>] <name>",
+				},
+				{
+					Name:      "list",
+					Usage:     "list users",
+					Action:    actUserList,
+					UsageText: app.Name + " user list",
+				},
+				{
+					Name:      "get",
+					Usage:     "show roles of users",
+					Action:    actUserGet,
+					UsageText: app.Name + " user get name1 [name2...]",
+				},
+				{
+					Name:      "grant",
+					Usage:     "grant roles to a user",
+					Action:    actUserGrant,
+					UsageText: app.Name + " user grant <name> role1 [role2...]",
+				},
+				{
+					Name:      "revoke",
+					Usage:     "revoke roles from a user",
+					Action:    actUserRevoke,
+					UsageText: app.Name + " user revoke <name> role1 [role2...]",
+				},
+			},
+			UsageText: app.Name + " user <add|delete|passwd|list|get|grant|revoke> [arguments...]",
+		},
+		{
+			Name:  "role",
+			Usage: "manage roles",
+			Subcommands: []*cli.Command{
+				{
+					Name:      "add",
+					Usage:     "add new roles",
+					Action:    actRoleAdd,
+					UsageText: app.Name + " role add role1 [role2...]",
+				},
+				{
+					Name:      "delete",
+					Usage:     "delete roles",
+					Action:    actRoleDelete,
+					UsageText: app.Name + " role delete role1 [role2...]",
+				},
+				{
+					Name:      "list",
+					Usage:     "list roles",
+					Action:    actRoleList,
+					UsageText: app.Name + " role list",
+				},
+				{
+					Name:      "get",
+					Usage:     "show permissions of roles",
+					Action:    actRoleGet,
+					UsageText: app.Name + " role get role1 [role2...]",
+				},
+				{
+					Name:   "grant",
+					Usage:  "grant a key permission to a role",
+					Action: actRoleGrant,
+					Flags: []cli.Flag{
+						&cli.BoolFlag{
+							Name:  "prefix",
+							Usage: "grant the permission to all keys starting with the key",
+						},
+					},
+					UsageText: app.Name + " role grant [--prefix] <role> <read|write|readwrite> <key>",
+				},
+				{
+					Name:   "revoke",
+					Usage:  "revoke a key permission from a role",
+					Action: actRoleRevoke,
+					Flags: []cli.Flag{
+						&cli.BoolFlag{
+							Name:  "prefix",
+							Usage: "revoke the permission granted with --prefix",
+						},
+					},
+					UsageText: app.Name + " role revoke [--prefix] <role> <key>",
+				},
+			},
+			UsageText: app.Name + " role <add|delete|list|get|grant|revoke> [arguments...]",
+		},
+		{
+			Name:  "auth",
+			Usage: "enable or disable authentication",
+			Subcommands: []*cli.Command{
+				{
+					Name:      "enable",
+					Usage:     "enable authentication (the root user must exist)",
+					Action:    actAuthEnable,
+					UsageText: app.Name + " auth enable",
+				},
+				{
+					Name:      "disable",
+					Usage:     "disable authentication",
+					Action:    actAuthDisable,
+					UsageText: app.Name + " auth disable",
+				},
+			},
+			UsageText: app.Name + " auth <enable|disable>",
+		},
+		{
+			Name:  "check",
+			Usage: "check consistency of the cluster",
+			Subcommands: []*cli.Command{
+				{
+					Name:   "hashkv",
+					Usage:  "compare KV hashes of the members at the same revision",
+					Action: actCheckHashKV,
+					Flags: []cli.Flag{
+						&cli.BoolFlag{
+							Name:  "cluster",
+							Usage: "check all the cluster members (instead of the --endpoints)",
+						},
+						&cli.Int64Flag{
+							Name:  "rev",
+							Usage: "revision to hash (0 is the current revision)",
+						},
+						&cli.StringFlag{
+							Name:  "output, o",
+							Value: "table",
+							Usage: "output format (table or json)",
+						},
+					},
+					UsageText: app.Name + " check hashkv [--cluster] [--rev <rev>] [-o table|json]",
+					Description: `Hashkv command hashes the keys of each member at the same revision, and reports the members
+   with diverging hashes (e.g. the corrupted database after the restore).
+   The exit code is non-zero if any of the hashes diverge.`,
+				},
+			},
+			UsageText: app.Name + " check <hashkv> [arguments...]",
 		},
 	}
-
-	if err := app.Run(os.Args); err != nil {
-		logrus.Error(err)
-	}
+	return app
 }
@@ -0,0 +1,896 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/embed"
+)
+
+var (
+	testEtcd     *embed.Etcd // the embedded etcd server shared by the tests
+	testOpt      = opt       // the defaults of the global options, restored before each command
+	testEndpoint string
+)
+
+// testExit is the panic value of logrus.Fatal (i.e. checkErr) while running the commands
+type testExit int
+
+// startTestEtcd starts the embedded single-member etcd server, with its data in the dir
+func startTestEtcd(dir string) (*embed.Etcd, error) {
+	cfg := embed.NewConfig()
+	cfg.Dir = dir
+	cfg.Logger, cfg.LogLevel = "zap", "error"
+	curl, _ := url.Parse("http://127.0.0.1:0")
+	purl, _ := url.Parse("http://127.0.0.1:0")
+	cfg.LCUrls, cfg.ACUrls = []url.URL{*curl}, []url.URL{*curl}
+	cfg.LPUrls, cfg.APUrls = []url.URL{*purl}, []url.URL{*purl}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+	e, err := embed.StartEtcd(cfg)
+	if err != nil {
+		return nil, err
+	}
+	<-e.Server.ReadyNotify()
+	return e, nil
+}
+
+// newTestEtcd starts another embedded etcd server for the test (e.g. the target of the copy, or with auth enabled)
+func newTestEtcd(t testing.TB) (*embed.Etcd, *clientv3.Client) {
+	t.Helper()
+	e, err := startTestEtcd(filepath.Join(t.TempDir(), "etcd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := clientv3.New(clientv3.Config{Endpoints: []string{e.Clients[0].Addr().String()}})
+	if err != nil {
+		e.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		e.Close()
+	})
+	return e, client
+}
+
+// enableTestAuth enables the authentication, with the root user and the reader user allowed to read the prefixes
+func enableTestAuth(t testing.TB, client *clientv3.Client, readerPrefixes ...string) {
+	t.Helper()
+	steps := []func() error{
+		func() error { _, err := client.UserAdd(ctx, "root", "rootpw"); return err },
+		func() error { _, err := client.UserGrantRole(ctx, "root", "root"); return err },
+		func() error { _, err := client.RoleAdd(ctx, "reader"); return err },
+		func() error { _, err := client.UserAdd(ctx, "reader", "readerpw"); return err },
+		func() error { _, err := client.UserGrantRole(ctx, "reader", "reader"); return err },
+	}
+	for _, p := range readerPrefixes {
+		p := p
+		steps = append(steps, func() error {
+			_, err := client.RoleGrantPermission(ctx, "reader", p, clientv3.GetPrefixRangeEnd(p), clientv3.PermissionType(clientv3.PermRead))
+			return err
+		})
+	}
+	steps = append(steps, func() error { _, err := client.AuthEnable(ctx); return err })
+	for _, fn := range steps {
+		if err := fn(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "etcdTool-test")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if testEtcd, err = startTestEtcd(dir); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.RemoveAll(dir)
+		os.Exit(1)
+	}
+	testEndpoint = testEtcd.Clients[0].Addr().String()
+	logrus.StandardLogger().ExitFunc = func(code int) { panic(testExit(code)) }
+
+	code := m.Run()
+	testEtcd.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// runCmd runs the app with given arguments against the test server, and returns the standard output
+//   - NOTE: the fatal errors (checkErr) are returned as errors, instead of exiting the test
+func runCmd(t testing.TB, app *cli.App, args ...string) (out string, err error) {
+	t.Helper()
+	f, err := ioutil.TempFile("", "etcdTool-stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout, app.Writer = f, f
+	defer func() {
+		os.Stdout = stdout
+		if r := recover(); r != nil {
+			code, ok := r.(testExit)
+			if !ok {
+				panic(r)
+			}
+			err = fmt.Errorf("exit status %d", code)
+		}
+		f.Close()
+		buf, rerr := ioutil.ReadFile(f.Name())
+		os.Remove(f.Name())
+		if rerr != nil {
+			t.Fatal(rerr)
+		}
+		out = string(buf)
+	}()
+
+	opt, errLog = testOpt, nil
+	resetArchiveKeys()
+	logrus.SetLevel(logrus.InfoLevel)
+	if !testing.Verbose() {
+		logrus.SetLevel(logrus.ErrorLevel)
+	}
+	return "", app.Run(append([]string{"etcdTool", "-e", testEndpoint}, args...))
+}
+
+// runApp runs the command with given arguments against the test server
+func runApp(t testing.TB, args ...string) (string, error) {
+	t.Helper()
+	return runCmd(t, newApp(), args...)
+}
+
+// mustRunApp runs the command, and fails the test if the command fails
+func mustRunApp(t testing.TB, args ...string) string {
+	t.Helper()
+	out, err := runApp(t, args...)
+	if err != nil {
+		t.Fatalf("Command %s failed: %v", strings.Join(args, " "), err)
+	}
+	return out
+}
+
+// testClient returns the client of the test server
+func testClient(t testing.TB) *clientv3.Client {
+	t.Helper()
+	client, err := clientv3.New(clientv3.Config{Endpoints: []string{testEndpoint}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// testPrefix returns the prefix of the test's keys (the keys of the previous runs are removed)
+func testPrefix(t testing.TB) string {
+	t.Helper()
+	prefix := "/" + t.Name() + "/"
+	if _, err := testClient(t).Delete(ctx, prefix, clientv3.WithPrefix()); err != nil {
+		t.Fatal(err)
+	}
+	return prefix
+}
+
+// putTestKeys puts the key-value pairs, and returns the revision of the last put
+func putTestKeys(t testing.TB, kvs ...string) int64 {
+	t.Helper()
+	var (
+		client = testClient(t)
+		rev    int64
+	)
+	for i := 0; i+1 < len(kvs); i += 2 {
+		res, err := client.Put(ctx, kvs[i], kvs[i+1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		rev = res.Header.Revision
+	}
+	return rev
+}
+
+// getTestKeys returns the key-values under the prefix
+func getTestKeys(t testing.TB, prefix string) map[string]string {
+	t.Helper()
+	res, err := testClient(t).Get(ctx, prefix, clientv3.WithPrefix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret := make(map[string]string)
+	for _, kv := range res.Kvs {
+		ret[string(kv.Key)] = string(kv.Value)
+	}
+	return ret
+}
+
+// sortedKeys returns the sorted keys of the map
+func sortedKeys(m map[string]string) []string {
+	ret := make([]string, 0, len(m))
+	for k := range m {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// readTestArchive returns the entries of the archive (except the manifest)
+func readTestArchive(t testing.TB, fname string) map[string]string {
+	t.Helper()
+	ret := make(map[string]string)
+	_, _, err := readArchive(fname, func(name string, data []byte) error {
+		if name != manifestName {
+			ret[name] = string(data)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Could not read %s: %v", fname, err)
+	}
+	return ret
+}
+
+func TestGetJSONPretty(t *testing.T) {
+	prefix := testPrefix(t)
+	putTestKeys(t,
+		prefix+"json", `{"a":[1,2],"b":"c"}`,
+		prefix+"text", "not {json}",
+		prefix+"b64", base64.StdEncoding.EncodeToString([]byte(`{"x":1}`)))
+
+	if out := mustRunApp(t, "get", "--json-pretty", prefix+"json"); out != "{\n  \"a\": [\n    1,\n    2\n  ],\n  \"b\": \"c\"\n}\n" {
+		t.Errorf("Unexpected pretty-printed value %q", out)
+	}
+	if out := mustRunApp(t, "get", "--json-pretty", "--indent", "4", prefix+"text"); out != "not {json}" {
+		t.Errorf("Expected the non-JSON value unchanged, got %q", out)
+	}
+	if out := mustRunApp(t, "get", "--json-pretty", "--indent", "1", "--d64", prefix+"b64"); out != "{\n \"x\": 1\n}\n" {
+		t.Errorf("Unexpected pretty-printed base64 value %q", out)
+	}
+	if _, err := runApp(t, "get", "--json-pretty", "--indent", "-1", prefix+"json"); err == nil {
+		t.Error("Expected the negative --indent to fail")
+	}
+}
+
+func TestRenamePrefix(t *testing.T) {
+	var (
+		prefix = testPrefix(t)
+		kvs    []string
+	)
+	for i := 0; i < 10; i++ {
+		kvs = append(kvs, fmt.Sprintf("%sold/k%d", prefix, i), fmt.Sprintf("v%d", i))
+	}
+	putTestKeys(t, kvs...)
+
+	out := mustRunApp(t, "rename-prefix", "--dry-run", prefix+"old/", prefix+"new/")
+	if n := strings.Count(out, " -> "); n != 10 {
+		t.Errorf("Expected 10 keys in the dry-run, got %d", n)
+	} else if got := getTestKeys(t, prefix+"new/"); len(got) != 0 {
+		t.Fatalf("Expected no keys renamed in the dry-run, got %v", got)
+	}
+
+	mustRunApp(t, "rename-prefix", "-f", prefix+"old/", prefix+"new/")
+	if got := getTestKeys(t, prefix+"old/"); len(got) != 0 {
+		t.Errorf("Expected the old prefix empty, got %v", got)
+	}
+	got := getTestKeys(t, prefix+"new/")
+	if len(got) != 10 {
+		t.Errorf("Expected 10 keys in the new prefix, got %v", got)
+	}
+	for i := 0; i < 10; i++ {
+		if v := got[fmt.Sprintf("%snew/k%d", prefix, i)]; v != fmt.Sprintf("v%d", i) {
+			t.Errorf("Unexpected value of k%d: %q", i, v)
+		}
+	}
+}
+
+// writeTestFiles creates the files (relative to the dir) with given contents
+func writeTestFiles(t testing.TB, dir string, files ...string) {
+	t.Helper()
+	for i := 0; i+1 < len(files); i += 2 {
+		fname := filepath.Join(dir, filepath.FromSlash(files[i]))
+		if err := os.MkdirAll(filepath.Dir(fname), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(fname, []byte(files[i+1]), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestUploadTrimExtension(t *testing.T) {
+	var (
+		prefix = testPrefix(t)
+		dir    = t.TempDir()
+	)
+	writeTestFiles(t, dir, "cfg/0first.txt", "first", "cfg/a.json", `{"a":1}`, "cfg/a.yaml", "a: 1", "cfg/b.json", "{}")
+
+	_, err := runApp(t, "upload", "-C", dir, "--prefix", prefix, "--trim-extension", ".json", "--trim-extension", ".yaml", "cfg")
+	if err == nil || !strings.Contains(err.Error(), "both map to key "+prefix+"cfg/a") {
+		t.Errorf("Expected the collision of a.json and a.yaml, got %v", err)
+	}
+	if got := getTestKeys(t, prefix); len(got) != 0 {
+		t.Errorf("Expected no keys uploaded after the collision, got %v", got)
+	}
+
+	mustRunApp(t, "upload", "-C", dir, "--prefix", prefix, "--trim-extension", ".json", "cfg")
+	got := getTestKeys(t, prefix)
+	if exp := []string{prefix + "cfg/0first.txt", prefix + "cfg/a", prefix + "cfg/a.yaml", prefix + "cfg/b"}; !reflect.DeepEqual(sortedKeys(got), exp) {
+		t.Errorf("Expected keys %v, got %v", exp, sortedKeys(got))
+	}
+}
+
+func TestPutValue(t *testing.T) {
+	prefix := testPrefix(t)
+	mustRunApp(t, "put", "--value", "hello world", prefix+"literal")
+	mustRunApp(t, "put", "--value", "", prefix+"empty")
+	mustRunApp(t, "put", "--e64", "--value", "bin", prefix+"b64")
+
+	got := getTestKeys(t, prefix)
+	if v, has := got[prefix+"empty"]; !has || v != "" {
+		t.Errorf("Expected the empty value, got %q (exists: %v)", v, has)
+	}
+	if got[prefix+"b64"] != base64.StdEncoding.EncodeToString([]byte("bin")) {
+		t.Errorf("Expected the base64-encoded value, got %q", got[prefix+"b64"])
+	}
+	if out := mustRunApp(t, "get", prefix+"literal"); out != "hello world" {
+		t.Errorf("Expected the literal value, got %q", out)
+	}
+	if _, err := runApp(t, "put", "--value", "v", "file", prefix+"literal"); err == nil {
+		t.Error("Expected --value with the file argument to fail")
+	}
+}
+
+func TestListModifiedSince(t *testing.T) {
+	prefix := testPrefix(t)
+	putTestKeys(t, prefix+"old1", "v", prefix+"old2", "v")
+	rev := putTestKeys(t, prefix+"new1", "v")
+	putTestKeys(t, prefix+"new2", "v", prefix+"old1", "updated")
+
+	out := mustRunApp(t, "list", "--modified-since", strconv.FormatInt(rev, 10), prefix)
+	if exp := prefix + "new1\n" + prefix + "new2\n" + prefix + "old1\n"; out != exp {
+		t.Errorf("Expected %q, got %q", exp, out)
+	}
+	if _, err := runApp(t, "list", "--modified-since", "2020-01-01T00:00:00Z", prefix); err == nil {
+		t.Error("Expected the timestamp to be rejected")
+	}
+}
+
+func TestFailFast(t *testing.T) {
+	e, client := newTestEtcd(t)
+	ep := e.Clients[0].Addr().String()
+	for _, k := range []string{"/a/1", "/b/1", "/c/1"} {
+		if _, err := client.Put(ctx, k, "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	enableTestAuth(t, client, "/a/", "/c/")
+
+	// the /b/ prefix cannot be read, so the list fails after /a/
+	out, err := runApp(t, "-e", ep, "--user", "reader:readerpw", "list", "/a/", "/b/", "/c/")
+	if err == nil || out != "/a/1\n" {
+		t.Errorf("Expected the list to abort after /a/, got %q (%v)", out, err)
+	}
+	out, err = runApp(t, "-e", ep, "--user", "reader:readerpw", "--fail-fast=false", "list", "/a/", "/b/", "/c/")
+	if err == nil || out != "/a/1\n/c/1\n" {
+		t.Errorf("Expected the list to continue with /c/ and fail, got %q (%v)", out, err)
+	}
+}
+
+func TestExpandEndpoints(t *testing.T) {
+	prefix := testPrefix(t)
+	putTestKeys(t, prefix+"k", "v")
+	host, port, err := net.SplitHostPort(testEndpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ETCDTOOL_TEST_HOST", host)
+
+	ep, err := expandEnv("http://${ETCDTOOL_TEST_HOST}:" + port)
+	if err != nil {
+		t.Fatal(err)
+	} else if cfg := newEtcdClientConfig(ep); !reflect.DeepEqual(cfg.Endpoints, []string{"http://" + testEndpoint}) {
+		t.Errorf("Expected the expanded endpoint in the client config, got %v", cfg.Endpoints)
+	}
+	if out := mustRunApp(t, "-e", "http://${ETCDTOOL_TEST_HOST}:"+port, "get", prefix+"k"); out != "v" {
+		t.Errorf("Expected the value read via the expanded endpoint, got %q", out)
+	}
+	if _, err := runApp(t, "-e", "http://${ETCDTOOL_TEST_UNSET}:"+port, "get", prefix+"k"); err == nil {
+		t.Error("Expected the unset variable to fail")
+	}
+}
+
+func TestRemoveOlderThan(t *testing.T) {
+	var (
+		prefix = testPrefix(t)
+		client = testClient(t)
+	)
+	putWithLease := func(key string) {
+		lease, err := client.Grant(ctx, 60)
+		if err != nil {
+			t.Fatal(err)
+		} else if _, err = client.Put(ctx, key, "v", clientv3.WithLease(lease.ID)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	putWithLease(prefix + "old")
+	time.Sleep(3 * time.Second)
+	putWithLease(prefix + "new")
+	putTestKeys(t, prefix+"nolease", "v")
+
+	if out := mustRunApp(t, "rm", "--dry-run", "--older-than", "2s", prefix); out != prefix+"old\n" {
+		t.Errorf("Expected only the old key in the dry-run, got %q", out)
+	}
+	mustRunApp(t, "rm", "-f", "--older-than", "2s", prefix)
+	if got := sortedKeys(getTestKeys(t, prefix)); !reflect.DeepEqual(got, []string{prefix + "new", prefix + "nolease"}) {
+		t.Errorf("Expected only the old key removed, got %v", got)
+	}
+}
+
+func TestPutPrev(t *testing.T) {
+	var (
+		prefix = testPrefix(t)
+		dir    = t.TempDir()
+		fname  = filepath.Join(dir, "prev.ndjson")
+	)
+	putTestKeys(t, prefix+"k", "old\x00binary")
+
+	mustRunApp(t, "put", "--prev-out", fname, "--value", "new", prefix+"k")
+	buf, err := ioutil.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rec kvRecord
+	if err = json.Unmarshal(buf, &rec); err != nil {
+		t.Fatal(err)
+	} else if rec.Key != prefix+"k" || string(rec.Value) != "old\x00binary" {
+		t.Errorf("Expected the previous value in %s, got %q", fname, buf)
+	}
+
+	// the put fails (and the value is kept), if the previous value cannot be recorded
+	if _, err = runApp(t, "put", "--prev-out", filepath.Join(dir, "missing", "prev.ndjson"), "--value", "newer", prefix+"k"); err == nil {
+		t.Error("Expected the put to fail")
+	} else if v := getTestKeys(t, prefix)[prefix+"k"]; v != "new" {
+		t.Errorf("Expected the value unchanged, got %q", v)
+	}
+}
+
+// deadlineKV records the deadline of the last Get
+type deadlineKV struct {
+	clientv3.KV
+	deadline    time.Time
+	hasDeadline bool
+}
+
+func (kv *deadlineKV) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	kv.deadline, kv.hasDeadline = ctx.Deadline()
+	return &clientv3.GetResponse{}, nil
+}
+
+func TestTimeouts(t *testing.T) {
+	prefix := testPrefix(t)
+	mustRunApp(t, "get", prefix+"none")
+	if opt.opTimeout != 0 {
+		t.Errorf("Expected no default --op-timeout, got %d", opt.opTimeout)
+	}
+	mustRunApp(t, "-T", "7", "get", prefix+"none")
+	if opt.opTimeout != 7 {
+		t.Errorf("Expected --op-timeout set by --timeout, got %d", opt.opTimeout)
+	}
+	mustRunApp(t, "-T", "7", "--op-timeout", "3", "--keepalive-time", "2", "get", prefix+"none")
+	if opt.opTimeout != 3 {
+		t.Errorf("Expected --op-timeout 3, got %d", opt.opTimeout)
+	}
+
+	cfg := newEtcdClientConfig(testEndpoint)
+	if cfg.DialTimeout != 7*time.Second || cfg.DialKeepAliveTime != 2*time.Second || cfg.DialKeepAliveTimeout != 6*time.Second {
+		t.Errorf("Unexpected timeouts %v/%v/%v", cfg.DialTimeout, cfg.DialKeepAliveTime, cfg.DialKeepAliveTimeout)
+	}
+
+	mock := &deadlineKV{}
+	if _, err := withOpTimeout(mock).Get(context.Background(), "k"); err != nil {
+		t.Fatal(err)
+	} else if d := time.Until(mock.deadline); !mock.hasDeadline || d <= 2*time.Second || d > 3*time.Second {
+		t.Errorf("Expected the 3s deadline, got %v (%v)", d, mock.hasDeadline)
+	}
+	opt.opTimeout = 0
+	if _, err := withOpTimeout(mock).Get(context.Background(), "k"); err != nil {
+		t.Fatal(err)
+	} else if mock.hasDeadline {
+		t.Errorf("Expected no deadline without --op-timeout")
+	}
+}
+
+func TestErrorsTo(t *testing.T) {
+	e, client := newTestEtcd(t)
+	ep := e.Clients[0].Addr().String()
+	for _, k := range []string{"/a/1", "/b/1", "/c/1"} {
+		if _, err := client.Put(ctx, k, "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	enableTestAuth(t, client, "/a/")
+
+	var logBuf bytes.Buffer
+	logrus.SetOutput(&logBuf)
+	defer logrus.SetOutput(os.Stderr)
+
+	fname := filepath.Join(t.TempDir(), "errors.json")
+	out, err := runApp(t, "-e", ep, "--user", "reader:readerpw", "--fail-fast=false", "--errors-to", fname, "--errors-format", "json",
+		"list", "/a/", "/b/", "/c/")
+	if err == nil || out != "/a/1\n" {
+		t.Errorf("Expected the list to fail after listing /a/, got %q (%v)", out, err)
+	}
+	if strings.Contains(logBuf.String(), "Failed to") {
+		t.Errorf("Expected no per-key errors in the log, got %q", logBuf.String())
+	}
+
+	buf, err := ioutil.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, l := range strings.Split(strings.TrimSpace(string(buf)), "\n") {
+		var rec errorRecord
+		if err := json.Unmarshal([]byte(l), &rec); err != nil {
+			t.Fatalf("Invalid errors record %q: %v", l, err)
+		} else if rec.Operation != "list" || rec.Message == "" {
+			t.Errorf("Unexpected errors record %q", l)
+		}
+		keys = append(keys, rec.Key)
+	}
+	if !reflect.DeepEqual(keys, []string{"/b/", "/c/"}) {
+		t.Errorf("Expected errors of /b/ and /c/, got %v", keys)
+	}
+
+	// without --errors-to, the errors are logged
+	logBuf.Reset()
+	runApp(t, "-e", ep, "--user", "reader:readerpw", "--fail-fast=false", "list", "/a/", "/b/", "/c/")
+	if !strings.Contains(logBuf.String(), "Failed to list /b/") {
+		t.Errorf("Expected the errors in the log, got %q", logBuf.String())
+	}
+}
+
+func TestMkdirs(t *testing.T) {
+	var (
+		prefix = testPrefix(t)
+		dir    = t.TempDir()
+	)
+	mustRunApp(t, "put", "--value", "v", prefix+"plain/a/b")
+	if got := getTestKeys(t, prefix); !reflect.DeepEqual(sortedKeys(got), []string{prefix + "plain/a/b"}) {
+		t.Errorf("Expected no directory keys without --mkdirs, got %v", sortedKeys(got))
+	}
+
+	mustRunApp(t, "put", "--mkdirs", "--value", "v", prefix+"tree/a/b")
+	writeTestFiles(t, dir, "up/x/y", "y")
+	mustRunApp(t, "upload", "--mkdirs", "-C", dir, "--prefix", prefix, "up")
+	got := getTestKeys(t, prefix)
+	exp := []string{prefix, prefix + "plain/a/b", prefix + "tree/", prefix + "tree/a/", prefix + "tree/a/b", prefix + "up/", prefix + "up/x/", prefix + "up/x/y"}
+	if !reflect.DeepEqual(sortedKeys(got), exp) {
+		t.Errorf("Expected keys %v, got %v", exp, sortedKeys(got))
+	}
+	if got[prefix+"tree/a/"] != "" {
+		t.Errorf("Expected the empty directory key, got %q", got[prefix+"tree/a/"])
+	}
+}
+
+func TestAllKeys(t *testing.T) {
+	var (
+		prefix = testPrefix(t)
+		other  = testPrefix(&namedTB{TB: t, name: t.Name() + "Other"})
+		dir    = t.TempDir()
+	)
+	putTestKeys(t, prefix+"k", "v", other+"k", "v")
+
+	for _, args := range [][]string{{"list"}, {"dump", "--format", "json"}, {"tar", "-f", filepath.Join(dir, "x.tar")}, {"zip", "-f", filepath.Join(dir, "x.zip")}} {
+		if _, err := runApp(t, args...); err == nil {
+			t.Errorf("Expected %s without the keys to fail", args[0])
+		}
+		if _, err := runApp(t, append(args, "--all", prefix)...); err == nil {
+			t.Errorf("Expected %s --all with the keys to fail", args[0])
+		}
+	}
+
+	out := mustRunApp(t, "list", "--all")
+	if !strings.Contains(out, prefix+"k\n") || !strings.Contains(out, other+"k\n") {
+		t.Errorf("Expected all keys listed, got %q", out)
+	}
+	fname := filepath.Join(dir, "all.tar")
+	mustRunApp(t, "dump", "--format", "tar", "-f", fname, "--all")
+	if got := readTestArchive(t, fname); got[prefix+"k"] != "v" || got[other+"k"] != "v" {
+		t.Errorf("Expected all keys dumped, got %d keys", len(got))
+	}
+}
+
+// namedTB overrides the name of the test (e.g. for the testPrefix of another prefix)
+type namedTB struct {
+	testing.TB
+	name string
+}
+
+func (t *namedTB) Name() string { return t.name }
+
+func TestListGroupByDepth(t *testing.T) {
+	prefix := testPrefix(t)
+	putTestKeys(t,
+		prefix+"a/1", "v", prefix+"a/2", "v", prefix+"a/x/3", "v",
+		prefix+"b/1", "v", prefix+"c", "v")
+
+	out := mustRunApp(t, "list", "-o", "csv", "--group-by-depth", "2", prefix)
+	exp := "group,keys\n" + prefix + "a/,3\n" + prefix + "b/,1\n" + prefix + "c,1\n"
+	if out != exp {
+		t.Errorf("Expected groups %q, got %q", exp, out)
+	}
+	out = mustRunApp(t, "list", "-o", "csv", "--group-by-depth", "3", prefix+"a/")
+	exp = "group,keys\n" + prefix + "a/1,1\n" + prefix + "a/2,1\n" + prefix + "a/x/,1\n"
+	if out != exp {
+		t.Errorf("Expected groups %q, got %q", exp, out)
+	}
+}
+
+func TestListTable(t *testing.T) {
+	var (
+		prefix = testPrefix(t)
+		kvs    []string
+	)
+	// more than a page of keys, so the table is flushed page by page
+	for i := 0; i < 1500; i++ {
+		kvs = append(kvs, fmt.Sprintf("%sk%04d", prefix, i), "v")
+	}
+	since := putTestKeys(t, kvs...)
+	putTestKeys(t, prefix+"k0001", "new", prefix+"k1400", "new")
+
+	for _, args := range [][]string{{"-o", "table"}, {"-l"}} {
+		lines := strings.Split(strings.TrimSpace(mustRunApp(t, append(append([]string{"list"}, args...), prefix)...)), "\n")
+		if len(lines) != 1501 || !strings.HasPrefix(lines[0], "KEY ") || !strings.HasPrefix(lines[1500], prefix+"k1499 ") {
+			t.Errorf("%v: expected the header and 1500 keys, got %d lines", args, len(lines))
+		}
+	}
+
+	out := mustRunApp(t, "list", "-l", "--modified-since", fmt.Sprint(since+1), prefix)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[1], prefix+"k0001 ") || !strings.HasPrefix(lines[2], prefix+"k1400 ") {
+		t.Errorf("Expected the header and the 2 modified keys, got %q", out)
+	}
+}
+
+func TestListCSV(t *testing.T) {
+	var (
+		prefix = testPrefix(t)
+		comma  = prefix + "a,b"
+		quote  = prefix + `say "hi"`
+	)
+	putTestKeys(t, comma, "x,y", quote, "line1\nline2")
+
+	out := mustRunApp(t, "list", "-o", "csv", prefix)
+	if !strings.Contains(out, `"`+comma+`",`) || !strings.Contains(out, `"`+prefix+`say ""hi""",`) ||
+		!strings.Contains(out, `,"x,y"`) || !strings.Contains(out, ",\"line1\nline2\"") {
+		t.Fatalf("Expected the quoted keys and values, got %q", out)
+	}
+	recs, err := csv.NewReader(strings.NewReader(out)).ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to parse the CSV output: %v", err)
+	} else if len(recs) != 3 || recs[0][0] != "key" {
+		t.Fatalf("Expected the header and 2 records, got %q", recs)
+	}
+	got := map[string]string{recs[1][0]: recs[1][5], recs[2][0]: recs[2][5]}
+	if exp := map[string]string{comma: "x,y", quote: "line1\nline2"}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("Expected records %q, got %q", exp, got)
+	}
+
+	out = mustRunApp(t, "list", "-o", "csv", "--csv-delimiter", "tab", "--csv-header=false", prefix)
+	r := csv.NewReader(strings.NewReader(out))
+	r.Comma = '\t'
+	if recs, err = r.ReadAll(); err != nil {
+		t.Fatalf("Failed to parse the TSV output: %v", err)
+	} else if len(recs) != 2 || len(recs[0]) != 6 {
+		t.Fatalf("Expected 2 records without the header, got %q", recs)
+	}
+	if recs[0][0] != comma || recs[0][5] != "x,y" || !strings.HasPrefix(out, prefix+"a,b\t") {
+		t.Errorf("Expected the unquoted comma with the TAB delimiter, got %q", out)
+	}
+}
+
+// countOnlyKV simulates the backends without the WithCountOnly support (returns zero counts, or fails)
+type countOnlyKV struct {
+	clientv3.KV
+	fail bool
+}
+
+func (kv *countOnlyKV) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	if clientv3.OpGet(key, opts...).IsCountOnly() {
+		if kv.fail {
+			return nil, fmt.Errorf("Count-only not supported")
+		}
+		return &clientv3.GetResponse{}, nil
+	}
+	return kv.KV.Get(ctx, key, opts...)
+}
+
+func TestCount(t *testing.T) {
+	prefix := testPrefix(t)
+	putTestKeys(t, prefix+"a/1", "v", prefix+"a/2", "v", prefix+"b/1", "v")
+
+	if out, exp := mustRunApp(t, "count", prefix), "KEYS  PREFIX\n3     "+prefix+"\n"; out != exp {
+		t.Errorf("Expected %q, got %q", exp, out)
+	}
+	out := mustRunApp(t, "count", "--depth", "1", prefix)
+	if exp := "KEYS  PREFIX\n2     " + prefix + "a/\n1     " + prefix + "b/\n3     " + prefix + "\n"; out != exp {
+		t.Errorf("Expected %q, got %q", exp, out)
+	}
+
+	lines := strings.Split(strings.TrimSpace(mustRunApp(t, "count", "--all")), "\n")
+	if fields := strings.Fields(lines[len(lines)-1]); len(lines) != 2 || len(fields) != 2 || fields[1] != "total" {
+		t.Errorf("Expected the total count of the keyspace, got %q", lines)
+	} else if n, _ := strconv.Atoi(fields[0]); n < 3 {
+		t.Errorf("Expected at least 3 keys in the keyspace, got %d", n)
+	}
+
+	// the whole keyspace must be requested explicitly
+	if _, err := runApp(t, "count"); err == nil {
+		t.Error("Expected the count without the prefixes to fail")
+	}
+	if _, err := runApp(t, "count", "--all", prefix); err == nil {
+		t.Error("Expected the count of --all with the prefixes to fail")
+	}
+}
+
+func TestCountKeysFallback(t *testing.T) {
+	var (
+		prefix = testPrefix(t)
+		client = testClient(t)
+		kvs    []string
+	)
+	for i := 0; i < 1500; i++ {
+		kvs = append(kvs, fmt.Sprintf("%sk%04d", prefix, i), "v")
+	}
+	putTestKeys(t, kvs...)
+
+	if cnt := countKeys(client, prefix); cnt != 1500 {
+		t.Errorf("Expected 1500 keys, got %d", cnt)
+	}
+	for _, fail := range []bool{false, true} {
+		client.KV = &countOnlyKV{KV: clientv3.NewKV(client), fail: fail}
+		if cnt := countKeys(client, prefix); cnt != 1500 {
+			t.Errorf("Expected 1500 keys counted by the fallback (fail=%v), got %d", fail, cnt)
+		}
+		if cnt := countKeys(client, prefix+"none/"); cnt != 0 {
+			t.Errorf("Expected no keys (fail=%v), got %d", fail, cnt)
+		}
+	}
+}
+
+func TestAutoEncode(t *testing.T) {
+	var (
+		prefix = testPrefix(t)
+		dir    = t.TempDir()
+		bin    = "\x00\xff\xfebinary"
+	)
+	writeTestFiles(t, dir, "files/text.txt", "plain text ✓", "files/bin.dat", bin)
+	mustRunApp(t, "upload", "--auto-encode", "-C", dir, "--prefix", prefix, "files")
+
+	got := getTestKeys(t, prefix)
+	exp := []string{prefix + "files/bin.dat", prefix + "files/bin.dat" + encodingKeySuffix, prefix + "files/text.txt"}
+	if !reflect.DeepEqual(sortedKeys(got), exp) {
+		t.Fatalf("Expected keys %v, got %v", exp, sortedKeys(got))
+	}
+	if got[prefix+"files/text.txt"] != "plain text ✓" {
+		t.Errorf("Expected the text stored as-is, got %q", got[prefix+"files/text.txt"])
+	}
+	if got[prefix+"files/bin.dat"] != base64.StdEncoding.EncodeToString([]byte(bin)) {
+		t.Errorf("Expected the binary stored b64 encoded, got %q", got[prefix+"files/bin.dat"])
+	}
+
+	if out := mustRunApp(t, "get", "--auto-decode", prefix+"files/bin.dat"); out != bin {
+		t.Errorf("Expected the auto-decoded binary value, got %q", out)
+	}
+	if out := mustRunApp(t, "get", "--auto-decode", prefix+"files/"); out != bin+"plain text ✓" {
+		t.Errorf("Expected the decoded values without the encoding keys, got %q", out)
+	}
+}
+
+func TestGetOnCompacted(t *testing.T) {
+	var (
+		prefix = testPrefix(t)
+		client = testClient(t)
+	)
+	rev := putTestKeys(t, prefix+"k", "old")
+	putTestKeys(t, prefix+"other", "unchanged")
+	last := putTestKeys(t, prefix+"k", "new")
+	if _, err := client.Compact(ctx, last, clientv3.WithCompactPhysical()); err != nil {
+		t.Fatal(err)
+	}
+
+	r, minRev := strconv.FormatInt(rev, 10), strconv.FormatInt(last, 10)
+	for _, args := range [][]string{{prefix + "k"}, {"-r", "--with-min-mod-rev", minRev, prefix}, {"--with-min-mod-rev", minRev, prefix}} {
+		if _, err := runApp(t, append([]string{"get", "--rev", r}, args...)...); err == nil {
+			t.Errorf("Expected get %v at the compacted revision to fail", args)
+		}
+		if out := mustRunApp(t, append([]string{"get", "--rev", r, "--on-compacted", "latest"}, args...)...); out != "new" {
+			t.Errorf("Expected get %v to fall back to the latest value, got %q", args, out)
+		}
+	}
+}
+
+func TestRemovePrintDeleted(t *testing.T) {
+	var (
+		prefix = testPrefix(t)
+		kvs    []string
+		exp    []string
+	)
+	// more than a page of keys, so the keys are deleted in several batches
+	for i := 0; i < 1200; i++ {
+		key := fmt.Sprintf("%sdir/k%04d", prefix, i)
+		kvs, exp = append(kvs, key, fmt.Sprint(i)), append(exp, key)
+	}
+	putTestKeys(t, kvs...)
+	putTestKeys(t, prefix+"keep", "v", prefix+"one", "1")
+
+	out := mustRunApp(t, "rm", "-f", "--print-deleted", prefix+"dir/")
+	if lines := strings.Split(strings.TrimSpace(out), "\n"); !reflect.DeepEqual(lines, exp) {
+		t.Errorf("Expected %d deleted keys listed, got %d", len(exp), len(lines))
+	}
+	if got := getTestKeys(t, prefix); !reflect.DeepEqual(sortedKeys(got), []string{prefix + "keep", prefix + "one"}) {
+		t.Errorf("Expected only keep and one left, got %v", sortedKeys(got))
+	}
+
+	var rec kvRecord
+	if err := json.Unmarshal([]byte(mustRunApp(t, "rm", "--json", prefix+"one")), &rec); err != nil {
+		t.Fatal(err)
+	} else if rec.Key != prefix+"one" || string(rec.Value) != "1" {
+		t.Errorf("Expected the JSON record of the deleted key, got %+v", rec)
+	}
+}
+
+func TestRemoveRequireExisting(t *testing.T) {
+	prefix := testPrefix(t)
+	putTestKeys(t, prefix+"present", "v")
+
+	mustRunApp(t, "rm", "--require-existing", prefix+"present")
+	if got := getTestKeys(t, prefix); len(got) != 0 {
+		t.Errorf("Expected the key to be deleted, got %v", got)
+	}
+	if _, err := runApp(t, "rm", "--require-existing", prefix+"present"); err == nil {
+		t.Error("Expected the removal of the absent key to fail with --require-existing")
+	}
+	// the removal stays idempotent by default
+	mustRunApp(t, "rm", prefix+"present")
+}
+
+func TestRevisionWindow(t *testing.T) {
+	prefix := testPrefix(t)
+	r1 := putTestKeys(t, prefix+"a", "1")
+	r2 := putTestKeys(t, prefix+"b", "2")
+	r3 := putTestKeys(t, prefix+"c", "3")
+	putTestKeys(t, prefix+"a", "11") // a is now modified after c, but created before b
+
+	min, max := strconv.FormatInt(r2, 10), strconv.FormatInt(r3, 10)
+	if out := mustRunApp(t, "list", "--with-min-mod-rev", min, "--with-max-mod-rev", max, prefix); out != prefix+"b\n"+prefix+"c\n" {
+		t.Errorf("Expected b and c in the mod-rev window, got %q", out)
+	}
+	if out := mustRunApp(t, "get", "-r", "--with-min-mod-rev", min, "--with-max-mod-rev", max, prefix); out != "23" {
+		t.Errorf("Expected values of b and c, got %q", out)
+	}
+	if out := mustRunApp(t, "list", "--with-max-create-rev", strconv.FormatInt(r1, 10), prefix); out != prefix+"a\n" {
+		t.Errorf("Expected only a in the create-rev window, got %q", out)
+	}
+	if out := mustRunApp(t, "list", "--with-min-create-rev", min, "--with-min-mod-rev", strconv.FormatInt(r3, 10), prefix); out != prefix+"c\n" {
+		t.Errorf("Expected only c in the combined window, got %q", out)
+	}
+}
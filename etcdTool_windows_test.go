@@ -0,0 +1,21 @@
+//go:build windows
+// +build windows
+
+package main
+
+import "testing"
+
+func TestLocalPathConversions(t *testing.T) {
+	if p := key2LocalPath("a/b/c"); p != `a\b\c` {
+		t.Errorf("Expected a\\b\\c, got %s", p)
+	}
+	if k := localPath2Key(`a\b\c`); k != "a/b/c" {
+		t.Errorf("Expected a/b/c, got %s", k)
+	}
+	if k := localPath2Key(`C:\backup\a\b`); k != "/backup/a/b" {
+		t.Errorf("Expected /backup/a/b, got %s", k)
+	}
+	if k := localPath2Key(key2LocalPath("/a/b/c")); k != "/a/b/c" {
+		t.Errorf("Expected /a/b/c after the round-trip, got %s", k)
+	}
+}
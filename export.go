@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/mvcc/mvccpb"
+)
+
+// partNameRegex matches the part index of the split files (e.g. export.001.ndjson)
+var partNameRegex = regexp.MustCompile(`^(.*)\.(\d+)(\.[^.]*)?$`)
+
+// nopWriteCloser is a writer that does not close the underlying writer (e.g. STDOUT)
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// splitWriter writes the records into a series of files, rolling over to the next file at size/count threshold
+//   - NOTE: the files are named <name>.001<.ext>, <name>.002<.ext>, etc.
+type splitWriter struct {
+	fname             string
+	maxSize, maxCount int64
+	part              int
+	size, count       int64
+	out               io.WriteCloser
+}
+
+func (sw *splitWriter) partName() string {
+	if sw.maxSize <= 0 && sw.maxCount <= 0 {
+		return sw.fname
+	}
+	ext := filepath.Ext(sw.fname)
+	return fmt.Sprintf("%s.%03d%s", strings.TrimSuffix(sw.fname, ext), sw.part, ext)
+}
+
+// writeRecord writes the record, rolling over to the next file if the record would not fit into the current one
+func (sw *splitWriter) writeRecord(rec []byte) error {
+	if sw.out != nil && sw.count > 0 &&
+		((sw.maxSize > 0 && sw.size+int64(len(rec)) > sw.maxSize) || (sw.maxCount > 0 && sw.count >= sw.maxCount)) {
+		if err := sw.Close(); err != nil {
+			return err
+		}
+	}
+	if sw.out == nil {
+		sw.part++
+		fname := sw.partName()
+		f, err := os.Create(fname)
+		if err != nil {
+			return err
+		}
+		logrus.Debugf("Writing %s...", fname)
+		sw.out, sw.size, sw.count = f, 0, 0
+	}
+	n, err := sw.out.Write(rec)
+	sw.size += int64(n)
+	sw.count++
+	return err
+}
+
+func (sw *splitWriter) Close() error {
+	if sw.out == nil {
+		return nil
+	}
+	err := sw.out.Close()
+	sw.out = nil
+	logrus.Infof("Done writing %s [%d keys]", sw.partName(), sw.count)
+	return err
+}
+
+func actExport(c *cli.Context) error {
+	args, err := keyArgs(c, "export")
+	if err != nil {
+		return err
+	}
+
+	var (
+		client   = getEtcdClient()
+		optFile  = c.String("f")
+		optSize  = c.Int64("split-by-size")
+		optCount = c.Int64("split-by-count")
+		optFmt   = c.String("format")
+		optMeta  = c.Bool("with-metadata")
+		sw       = &splitWriter{fname: optFile, maxSize: optSize, maxCount: optCount}
+		failed   = failedKeys{op: "export"}
+		limit    keyLimit
+		rev      int64 // all the prefixes are exported at the same revision
+	)
+
+	switch optFmt {
+	case "ndjson":
+		if optMeta {
+			return fmt.Errorf("The --with-metadata option requires --format yaml (the JSON records always include the metadata)")
+		}
+	case "yaml":
+		if optSize > 0 || optCount > 0 {
+			return fmt.Errorf("Cannot split the YAML document")
+		}
+	default:
+		return fmt.Errorf("Invalid format '%s' (expected ndjson or yaml)", optFmt)
+	}
+
+	filter, err := newKeyFilter(c)
+	if err != nil {
+		return err
+	}
+	if optFile == "" {
+		if optSize > 0 || optCount > 0 {
+			return fmt.Errorf("Must specify output file (-f file) when splitting the export")
+		}
+		sw.out, sw.fname = nopWriteCloser{os.Stdout}, "STDOUT"
+	}
+	defer sw.Close()
+
+	writeFn := func(res *clientv3.GetResponse) error {
+		// the excluded keys do not count toward the --max-keys
+		kvs := make([]*mvccpb.KeyValue, 0, len(res.Kvs))
+		for _, v := range res.Kvs {
+			if filter.match(v.Key) {
+				kvs = append(kvs, v)
+			} else {
+				logrus.Debugf("Skipping %s (excluded or not matching)", v.Key)
+			}
+		}
+		if err := limit.add(int64(len(kvs))); err != nil {
+			return err
+		}
+		for _, v := range kvs {
+			var (
+				rec []byte
+				err error
+			)
+			if optFmt == "yaml" {
+				rec, err = yamlEntry(v, optMeta)
+			} else if rec, err = json.Marshal(newKvRecord(v)); err == nil {
+				rec = append(rec, '\n')
+			}
+			if err != nil {
+				return err
+			}
+			if err = sw.writeRecord(rec); err != nil {
+				return err
+			}
+			logrus.Debugf("Add %s [%d]...", v.Key, len(v.Value))
+		}
+		return nil
+	}
+
+	for _, a := range args {
+		logrus.Debugf("Doing EXPORT(%s)...", a)
+		err := rangePagesAt(client, a, &rev, writeFn)
+		if limit.exceeded() {
+			return err
+		} else if err != nil && !opt.failFast {
+			failed.add(a, err)
+			continue
+		}
+		checkErr(err)
+	}
+
+	if err := sw.Close(); err != nil {
+		return err
+	}
+	return failed.result("")
+}
+
+// expandGlobs expands the file-name patterns (e.g. export.*.ndjson) into sorted file-names
+func expandGlobs(args []string) ([]string, error) {
+	var ret []string
+	for _, a := range args {
+		if a == "-" {
+			ret = append(ret, a)
+			continue
+		}
+		m, err := filepath.Glob(a)
+		if err != nil {
+			return nil, err
+		} else if len(m) <= 0 {
+			return nil, fmt.Errorf("No files found for '%s'", a)
+		}
+		sortParts(m)
+		ret = append(ret, m...)
+	}
+	return ret, nil
+}
+
+// sortParts sorts the file-names, ordering the parts of the split files by their numeric index
+//   - NOTE: the part indexes are zero-padded to 3 digits only, so e.g. export.1000.ndjson must sort after export.999.ndjson
+func sortParts(names []string) {
+	type partKey struct {
+		group string
+		index int64
+	}
+	keys := make(map[string]partKey, len(names))
+	for _, n := range names {
+		k := partKey{group: n, index: -1}
+		if m := partNameRegex.FindStringSubmatch(n); m != nil {
+			if idx, err := strconv.ParseInt(m[2], 10, 64); err == nil {
+				k = partKey{group: m[1] + "\x00" + m[3], index: idx}
+			}
+		}
+		keys[n] = k
+	}
+	sort.Slice(names, func(i, j int) bool {
+		ki, kj := keys[names[i]], keys[names[j]]
+		if ki.group != kj.group {
+			return ki.group < kj.group
+		} else if ki.index != kj.index {
+			return ki.index < kj.index
+		}
+		return names[i] < names[j]
+	})
+}
+
+// detectImportFormat peeks the first non-whitespace byte of the input -- `[` for JSON array, `{` for NDJSON, YAML otherwise
+func detectImportFormat(in *bufio.Reader) (string, error) {
+	for i := 1; ; i++ {
+		buf, err := in.Peek(i)
+		if err == io.EOF {
+			return "ndjson", nil
+		} else if err != nil {
+			return "", err
+		}
+		switch buf[i-1] {
+		case ' ', '\t', '\r', '\n':
+			continue
+		case '[':
+			return "json", nil
+		case '{':
+			return "ndjson", nil
+		}
+		return "yaml", nil
+	}
+}
+
+// importRecords reads the JSON records from the input, and passes them to the callback function
+//   - the format is either "ndjson" (one record per line), "json" (array of records), "yaml" (mapping of the keys), or "auto" (detected)
+func importRecords(in io.Reader, format string, fn func(rec *kvRecord) error) error {
+	in, err := decryptingReader(in)
+	if err != nil {
+		return err
+	}
+	br := bufio.NewReader(in)
+	if format == "auto" {
+		if format, err = detectImportFormat(br); err != nil {
+			return err
+		}
+		logrus.Debugf("Detected %s format", format)
+	}
+
+	if format == "yaml" {
+		return readYamlRecords(br, fn)
+	}
+
+	dec := json.NewDecoder(br)
+	if format == "json" {
+		if t, err := dec.Token(); err != nil {
+			return err
+		} else if t != json.Delim('[') {
+			return fmt.Errorf("Expected JSON array, got %v", t)
+		}
+		for dec.More() {
+			var rec kvRecord
+			if err := dec.Decode(&rec); err != nil {
+				return err
+			}
+			if err := fn(&rec); err != nil {
+				return err
+			}
+		}
+		_, err = dec.Token()
+		return err
+	} else if format != "ndjson" {
+		return fmt.Errorf("Invalid format '%s' (expected auto, json, ndjson or yaml)", format)
+	}
+
+	for {
+		var rec kvRecord
+		if err := dec.Decode(&rec); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := fn(&rec); err != nil {
+			return err
+		}
+	}
+}
+
+func actImport(c *cli.Context) error {
+	if c.NArg() <= 0 {
+		return fmt.Errorf("Must specify which files to import")
+	}
+
+	var (
+		client    = getEtcdClient()
+		optPrefix = c.String("prefix")
+		optFormat = c.String("format")
+		cnt       int
+		limit     keyLimit
+		putFn     = func(rec *kvRecord) error {
+			if err := limit.add(1); err != nil {
+				return err
+			}
+			kk := optPrefix + rec.Key
+			logrus.Debugf("Doing PUT(%s,XX)...", kk)
+			if _, err := client.Put(ctx, kk, string(rec.Value)); err != nil {
+				return err
+			}
+			logrus.Infof("Put %s [%d]...", kk, len(rec.Value))
+			cnt++
+			return nil
+		}
+	)
+
+	files, err := expandGlobs(c.Args().Slice())
+	if err != nil {
+		return err
+	}
+
+	for _, fname := range files {
+		in := io.ReadCloser(os.Stdin)
+		if fname != "-" {
+			if in, err = os.Open(fname); err != nil {
+				return err
+			}
+		}
+		logrus.Debugf("Reading %s...", fname)
+		err = importRecords(in, optFormat, putFn)
+		in.Close()
+		if err != nil {
+			return fmt.Errorf("Could not import %s: %v", fname, err)
+		}
+	}
+
+	logrus.Infof("Imported %d keys.", cnt)
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExportSplitRoundTrip(t *testing.T) {
+	var (
+		prefix = testPrefix(t)
+		dir    = t.TempDir()
+		kvs    []string
+	)
+	for i := 0; i < 5; i++ {
+		kvs = append(kvs, fmt.Sprintf("%ssrc/k%d", prefix, i), strings.Repeat("v", 100*i))
+	}
+	putTestKeys(t, kvs...)
+
+	mustRunApp(t, "export", "--split-by-count", "2", "-f", filepath.Join(dir, "export.ndjson"), prefix+"src/")
+	parts, err := filepath.Glob(filepath.Join(dir, "export.*.ndjson"))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(parts) != 3 {
+		t.Fatalf("Expected 3 parts, got %v", parts)
+	}
+
+	mustRunApp(t, "import", "--prefix", "/dst", filepath.Join(dir, "export.*.ndjson"))
+	src, dst := getTestKeys(t, prefix+"src/"), getTestKeys(t, "/dst"+prefix+"src/")
+	if len(dst) != 5 {
+		t.Errorf("Expected 5 imported keys, got %d", len(dst))
+	}
+	for k, v := range src {
+		if dst["/dst"+k] != v {
+			t.Errorf("Key %s was not imported", k)
+		}
+	}
+}
+
+func TestSortParts(t *testing.T) {
+	names := []string{"export.1000.ndjson", "export.ndjson", "export.002.ndjson", "export.999.ndjson", "export.001.ndjson", "other.010.ndjson"}
+	sortParts(names)
+	exp := []string{"export.001.ndjson", "export.002.ndjson", "export.999.ndjson", "export.1000.ndjson", "export.ndjson", "other.010.ndjson"}
+	if !reflect.DeepEqual(names, exp) {
+		t.Errorf("Expected %v, got %v", exp, names)
+	}
+}
+
+func TestImportDetectFormat(t *testing.T) {
+	var (
+		prefix = testPrefix(t)
+		dir    = t.TempDir()
+	)
+	putTestKeys(t, prefix+"src/a", "1", prefix+"src/b", "2")
+	for _, format := range []string{"json", "ndjson"} {
+		fname := filepath.Join(dir, "export."+format)
+		mustRunApp(t, "dump", "--format", format, "-f", fname, prefix+"src/")
+		// the file name does not tell the format
+		renamed := filepath.Join(dir, format+".data")
+		if err := os.Rename(fname, renamed); err != nil {
+			t.Fatal(err)
+		}
+		mustRunApp(t, "import", "--prefix", prefix+format, renamed)
+	}
+
+	fromJSON, fromNDJSON := getTestKeys(t, prefix+"json"), getTestKeys(t, prefix+"ndjson")
+	if len(fromJSON) != 2 || len(fromNDJSON) != 2 {
+		t.Fatalf("Expected 2 keys imported from both formats, got %v and %v", fromJSON, fromNDJSON)
+	}
+	for k, v := range fromJSON {
+		if fromNDJSON[prefix+"ndjson"+strings.TrimPrefix(k, prefix+"json")] != v {
+			t.Errorf("Key %s differs between the formats", k)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/urfave/cli"
+)
+
+// keyFilter selects the keys by the glob patterns and regular expressions (`--match` and `--regex` options), and
+// skips the keys matching the `--exclude` glob patterns
+//   - NOTE: the filter is applied on the client, after the keys were read (etcd can only select the key ranges)
+type keyFilter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+// globRegexp converts the glob pattern into the anchored regexp
+//   - NOTE: unlike the file-name globs, the `*` also matches the '/', so e.g. `*/config/*` matches `/apps/web/config/db`
+func globRegexp(glob string) (*regexp.Regexp, error) {
+	var buf strings.Builder
+	buf.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch ch := glob[i]; ch {
+		case '*':
+			buf.WriteString(".*")
+		case '?':
+			buf.WriteString(".")
+		case '[':
+			j := strings.IndexByte(glob[i+1:], ']')
+			if j < 0 {
+				return nil, fmt.Errorf("Invalid pattern '%s' (missing ])", glob)
+			}
+			class := glob[i+1 : i+1+j]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			buf.WriteString("[" + class + "]")
+			i += j + 1
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+			}
+			buf.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		default:
+			buf.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+	buf.WriteString("$")
+	re, err := regexp.Compile(buf.String())
+	if err != nil {
+		return nil, fmt.Errorf("Invalid pattern '%s': %v", glob, err)
+	}
+	return re, nil
+}
+
+// newKeyFilter parses the filter options, or returns nil if no filter was given
+func newKeyFilter(c *cli.Context) (*keyFilter, error) {
+	kf := &keyFilter{}
+	for _, g := range c.StringSlice("match") {
+		re, err := globRegexp(g)
+		if err != nil {
+			return nil, err
+		}
+		kf.include = append(kf.include, re)
+	}
+	for _, r := range c.StringSlice("regex") {
+		re, err := regexp.Compile(r)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid regular expression '%s': %v", r, err)
+		}
+		kf.include = append(kf.include, re)
+	}
+	for _, g := range c.StringSlice("exclude") {
+		re, err := globRegexp(g)
+		if err != nil {
+			return nil, err
+		}
+		kf.exclude = append(kf.exclude, re)
+	}
+	if len(kf.include) <= 0 && len(kf.exclude) <= 0 {
+		return nil, nil
+	}
+	return kf, nil
+}
+
+// match checks if the key is not excluded, and matches any of the patterns (or if there are no patterns)
+func (kf *keyFilter) match(key []byte) bool {
+	if kf == nil {
+		return true
+	}
+	for _, re := range kf.exclude {
+		if re.Match(key) {
+			return false
+		}
+	}
+	if len(kf.include) <= 0 {
+		return true
+	}
+	for _, re := range kf.include {
+		if re.Match(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// keyFilterFlags returns the flags of the keyFilter
+func keyFilterFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:  "match",
+			Usage: "only the keys matching given glob pattern (e.g. '*/config/*'; can be repeated)",
+		},
+		&cli.StringSliceFlag{
+			Name:  "regex",
+			Usage: "only the keys matching given regular expression (unanchored; can be repeated)",
+		},
+		&cli.StringSliceFlag{
+			Name:  "exclude",
+			Usage: "skip the keys matching given glob pattern (e.g. '*/events/*'; can be repeated)",
+		},
+	}
+}
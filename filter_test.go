@@ -0,0 +1,107 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGlobRegexp(t *testing.T) {
+	for _, tc := range []struct {
+		glob  string
+		match []string
+		skip  []string
+	}{
+		{"*/config/*", []string{"/apps/web/config/db", "/config/x"}, []string{"/apps/web/config", "/apps/configs/db"}},
+		{"/a/*", []string{"/a/b", "/a/b/c", "/a/"}, []string{"/a", "/b/a/c"}},
+		{"/k?", []string{"/k1", "/kx", "/k/"}, []string{"/k", "/k10"}},
+		{"/k[0-9]", []string{"/k0", "/k9"}, []string{"/kx", "/k10"}},
+		{"/k[!0-9]", []string{"/kx", "/k-"}, []string{"/k0", "/k"}},
+		{`/a\*b`, []string{"/a*b"}, []string{"/axb", "/a*bc"}},
+		{`/a\?`, []string{"/a?"}, []string{"/ab"}},
+		{`/a.b+(c)`, []string{"/a.b+(c)"}, []string{"/axb+(c)", "/a.bb(c)"}},
+		{`/trailing\`, []string{`/trailing\`}, []string{"/trailing"}},
+	} {
+		re, err := globRegexp(tc.glob)
+		if err != nil {
+			t.Errorf("%s: %v", tc.glob, err)
+			continue
+		}
+		for _, k := range tc.match {
+			if !re.MatchString(k) {
+				t.Errorf("Expected %s to match %s", tc.glob, k)
+			}
+		}
+		for _, k := range tc.skip {
+			if re.MatchString(k) {
+				t.Errorf("Expected %s not to match %s", tc.glob, k)
+			}
+		}
+	}
+
+	if _, err := globRegexp("/k[0-9"); err == nil {
+		t.Error("Expected the unterminated class to fail")
+	}
+}
+
+func TestKeyFilterMatch(t *testing.T) {
+	var (
+		kf   = &keyFilter{}
+		keys = []string{"/apps/web/config/db", "/apps/web/events/1", "/apps/db/config/port", "/other"}
+	)
+	for _, g := range []string{"/apps/web/*", "*/port"} {
+		re, err := globRegexp(g)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kf.include = append(kf.include, re)
+	}
+
+	var got []string
+	for _, k := range keys {
+		if kf.match([]byte(k)) {
+			got = append(got, k)
+		}
+	}
+	if exp := []string{"/apps/web/config/db", "/apps/web/events/1", "/apps/db/config/port"}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("Expected %q matched, got %q", exp, got)
+	}
+	if !(*keyFilter)(nil).match([]byte("/any")) {
+		t.Error("Expected the nil filter to match all the keys")
+	}
+}
+
+func TestKeyFilterExclude(t *testing.T) {
+	kf := &keyFilter{}
+	for _, p := range []struct {
+		glob    string
+		exclude bool
+	}{{"/apps/*", false}, {"*/events/*", true}, {"/apps/db/*", true}} {
+		re, err := globRegexp(p.glob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.exclude {
+			kf.exclude = append(kf.exclude, re)
+		} else {
+			kf.include = append(kf.include, re)
+		}
+	}
+
+	// the excluded keys are skipped even if matching the include patterns
+	for k, exp := range map[string]bool{
+		"/apps/web/config":   true,
+		"/apps/web/events/1": false,
+		"/apps/db/config":    false,
+		"/other":             false,
+	} {
+		if got := kf.match([]byte(k)); got != exp {
+			t.Errorf("Expected match of %s %v, got %v", k, exp, got)
+		}
+	}
+
+	// without the include patterns, all the keys but the excluded ones match
+	kf.include = nil
+	if !kf.match([]byte("/other")) || kf.match([]byte("/x/events/y")) {
+		t.Error("Expected only the excluded keys skipped")
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/oauth2/google"
+)
+
+// gcsAPI is the endpoint of the Google Cloud Storage JSON API
+const gcsAPI = "https://storage.googleapis.com"
+
+// gcsBackend stores the archives in the Google Cloud Storage buckets (gs://bucket/key)
+//   - NOTE: uses the JSON API directly, since the GCS client library requires newer gRPC than the etcd client
+type gcsBackend struct {
+	client *http.Client
+	api    string
+}
+
+// init creates the HTTP client, authenticated via the Application Default Credentials (GOOGLE_APPLICATION_CREDENTIALS,
+// `gcloud auth application-default login`, or the service account of the instance)
+//   - the STORAGE_EMULATOR_HOST selects the GCS emulator (without the authentication)
+func (b *gcsBackend) init() error {
+	if b.client != nil {
+		return nil
+	} else if host := os.Getenv("STORAGE_EMULATOR_HOST"); host != "" {
+		if !strings.Contains(host, "://") {
+			host = "http://" + host
+		}
+		b.client, b.api = http.DefaultClient, strings.TrimSuffix(host, "/")
+		return nil
+	}
+	cl, err := google.DefaultClient(ctx, "https://www.googleapis.com/auth/devstorage.read_write")
+	if err != nil {
+		return fmt.Errorf("Could not find the GCS credentials: %v", err)
+	}
+	b.client, b.api = cl, gcsAPI
+	return nil
+}
+
+// do sends the request, and checks the response status (the body of the failed responses is decoded into the error)
+func (b *gcsBackend) do(method, u string, hdr map[string]string, body io.Reader, status ...int) (*http.Response, error) {
+	if err := b.init(); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range hdr {
+		req.Header.Set(k, v)
+	}
+	res, err := b.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range status {
+		if res.StatusCode == s {
+			return res, nil
+		}
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound && method == http.MethodGet {
+		return nil, os.ErrNotExist
+	}
+	var e struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if data, _ := ioutil.ReadAll(res.Body); json.Unmarshal(data, &e) == nil && e.Error.Message != "" {
+		return nil, fmt.Errorf("%s (%s)", e.Error.Message, res.Status)
+	}
+	return nil, fmt.Errorf("%s %s failed: %s", method, u, res.Status)
+}
+
+// objectURL returns the URL of the object metadata
+func (b *gcsBackend) objectURL(bucket, key string) string {
+	return fmt.Sprintf("%s/storage/v1/b/%s/o/%s", b.api, url.PathEscape(bucket), url.PathEscape(key))
+}
+
+// upload uses the resumable upload (in chunks of remoteChunkSize)
+func (b *gcsBackend) upload(bucket, key string, in io.Reader) error {
+	if err := b.init(); err != nil {
+		return err
+	}
+	res, err := b.do(http.MethodPost, fmt.Sprintf("%s/upload/storage/v1/b/%s/o?uploadType=resumable&name=%s", b.api,
+		url.PathEscape(bucket), url.QueryEscape(key)), map[string]string{"Content-Type": "application/json"},
+		strings.NewReader("{}"), http.StatusOK)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	session := res.Header.Get("Location")
+
+	buf := make([]byte, remoteChunkSize)
+	for off := int64(0); ; {
+		n, err := io.ReadFull(in, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		// the short chunk is the last one, and completes the upload
+		rng, last := fmt.Sprintf("bytes %d-%d/*", off, off+int64(n)-1), err != nil
+		if last && n > 0 {
+			rng = fmt.Sprintf("bytes %d-%d/%d", off, off+int64(n)-1, off+int64(n))
+		} else if last {
+			rng = fmt.Sprintf("bytes */%d", off)
+		}
+		// NOTE: 308 confirms the chunk, while the upload is not completed yet
+		res, err = b.do(http.MethodPut, session, map[string]string{"Content-Range": rng}, bytes.NewReader(buf[:n]),
+			http.StatusOK, http.StatusCreated, http.StatusPermanentRedirect)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+		if last {
+			return nil
+		}
+		off += int64(n)
+	}
+}
+
+func (b *gcsBackend) size(bucket, key string) (int64, error) {
+	if err := b.init(); err != nil {
+		return 0, err
+	}
+	res, err := b.do(http.MethodGet, b.objectURL(bucket, key)+"?fields=size", nil, nil, http.StatusOK)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	var obj struct {
+		Size string `json:"size"`
+	}
+	if err = json.NewDecoder(res.Body).Decode(&obj); err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(obj.Size, 10, 64)
+}
+
+func (b *gcsBackend) readRange(bucket, key string, off, n int64) ([]byte, error) {
+	if err := b.init(); err != nil {
+		return nil, err
+	}
+	res, err := b.do(http.MethodGet, b.objectURL(bucket, key)+"?alt=media",
+		map[string]string{"Range": fmt.Sprintf("bytes=%d-%d", off, off+n-1)}, nil, http.StatusPartialContent, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	return ioutil.ReadAll(res.Body)
+}
+
+func (b *gcsBackend) remove(bucket, key string) error {
+	if err := b.init(); err != nil {
+		return err
+	}
+	res, err := b.do(http.MethodDelete, b.objectURL(bucket, key), nil, nil, http.StatusNoContent, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+func (b *gcsBackend) list(bucket, prefix string) ([]string, error) {
+	if err := b.init(); err != nil {
+		return nil, err
+	}
+	var (
+		keys  []string
+		token string
+	)
+	for {
+		res, err := b.do(http.MethodGet, fmt.Sprintf("%s/storage/v1/b/%s/o?prefix=%s&pageToken=%s", b.api,
+			url.PathEscape(bucket), url.QueryEscape(prefix), url.QueryEscape(token)), nil, nil, http.StatusOK)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Items []struct {
+				Name string `json:"name"`
+			} `json:"items"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		err = json.NewDecoder(res.Body).Decode(&page)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, it := range page.Items {
+			keys = append(keys, it.Name)
+		}
+		if token = page.NextPageToken; token == "" {
+			return keys, nil
+		}
+	}
+}
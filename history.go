@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/etcdserver/api/v3rpc/rpctypes"
+	"go.etcd.io/etcd/mvcc/mvccpb"
+)
+
+// keyHistory returns the versions of the key (newest first), walking back from given revision (0 for the latest)
+//   - the walk stops at the creation of the key (version 1), at the compacted revision, or after max versions
+func keyHistory(client *clientv3.Client, key string, rev int64, max int) ([]*mvccpb.KeyValue, error) {
+	var ret []*mvccpb.KeyValue
+	for max <= 0 || len(ret) < max {
+		var opts []clientv3.OpOption
+		if rev > 0 {
+			opts = append(opts, clientv3.WithRev(rev))
+		}
+		logrus.Debugf("Doing GET(%s,rev=%d)...", key, rev)
+		res, err := client.Get(ctx, key, opts...)
+		if err == rpctypes.ErrCompacted && len(ret) > 0 {
+			logrus.Infof("Older versions of %s were compacted (before revision %d)", key, rev+1)
+			break
+		} else if err != nil {
+			return nil, err
+		} else if len(res.Kvs) <= 0 {
+			break
+		}
+		kv := res.Kvs[0]
+		ret = append(ret, kv)
+		if kv.Version <= 1 {
+			break
+		}
+		rev = kv.ModRevision - 1
+	}
+	return ret, nil
+}
+
+// printVersion prints the version of the key, and its value (or the diff from the previous version)
+func printVersion(kv, prev *mvccpb.KeyValue, values, diff bool) {
+	fmt.Printf("%s [rev %d, version %d, %d bytes]\n", kv.Key, kv.ModRevision, kv.Version, len(kv.Value))
+	switch {
+	case diff && prev != nil && utf8.Valid(prev.Value) && utf8.Valid(kv.Value):
+		for _, l := range lineDiff(string(prev.Value), string(kv.Value)) {
+			fmt.Printf("  %s\n", l)
+		}
+	case diff || values:
+		printValue("  ", kv.Value)
+	}
+}
+
+func actHistory(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("Must specify the key")
+	}
+
+	var (
+		client    = getEtcdClient()
+		key       = c.Args().First()
+		optOutput = c.String("output")
+		optDiff   = c.Bool("diff")
+		rev       int64
+		err       error
+	)
+
+	if optOutput != "plain" && optOutput != "json" {
+		return fmt.Errorf("Invalid output format '%s' (expecting plain or json)", optOutput)
+	} else if optDiff && optOutput == "json" {
+		return fmt.Errorf("Options --diff and --output json are mutually exclusive")
+	}
+	if s := c.String("rev"); s != "" {
+		if rev, err = parseRevision(s); err != nil {
+			return err
+		}
+	}
+
+	kvs, err := keyHistory(client, key, rev, c.Int("max-versions"))
+	if err != nil {
+		return err
+	} else if len(kvs) <= 0 {
+		return fmt.Errorf("Key %s not found (use --rev for the keys deleted since)", key)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	for i, kv := range kvs {
+		if optOutput == "json" {
+			checkErr(enc.Encode(newKvRecord(kv)))
+			continue
+		}
+		var prev *mvccpb.KeyValue
+		if i+1 < len(kvs) {
+			prev = kvs[i+1]
+		}
+		printVersion(kv, prev, c.Bool("values"), optDiff)
+	}
+	logrus.Infof("Found %d versions of %s (created at revision %d).", len(kvs), key, kvs[0].CreateRevision)
+	return nil
+}
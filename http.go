@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// httpBackend stores the archives on the HTTP(S) servers (http://host/path, e.g. the artifact repositories), using
+// the PUT and GET requests
+type httpBackend struct {
+	scheme   string
+	client   *http.Client
+	user     string
+	password string
+	headers  http.Header
+}
+
+// init reads the credentials (`--remote-user` option), and the extra headers (`--http-header` option, e.g. the API
+// tokens)
+func (b *httpBackend) init() error {
+	if b.client != nil {
+		return nil
+	}
+	b.headers = make(http.Header)
+	for _, h := range opt.httpHeaders {
+		i := strings.IndexByte(h, ':')
+		if i <= 0 {
+			return fmt.Errorf("Invalid HTTP header '%s' (expecting 'Name: value')", h)
+		}
+		b.headers.Add(strings.TrimSpace(h[:i]), strings.TrimSpace(h[i+1:]))
+	}
+	if opt.remoteUser != "" {
+		var err error
+		if b.user, b.password, err = parseUser(opt.remoteUser, opt.remotePassword, "--remote-password"); err != nil {
+			return err
+		}
+	}
+	b.client = &http.Client{}
+	return nil
+}
+
+// do sends the request for the object (the 404 status of HEAD and GET returns os.ErrNotExist)
+func (b *httpBackend) do(method, host, path string, hdr map[string]string, body io.Reader) (*http.Response, error) {
+	if err := b.init(); err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(b.scheme + host + "/" + path)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range b.headers {
+		req.Header[k] = v
+	}
+	for k, v := range hdr {
+		req.Header.Set(k, v)
+	}
+	if b.user != "" {
+		req.SetBasicAuth(b.user, b.password)
+	}
+	res, err := b.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	} else if res.StatusCode < 300 {
+		return res, nil
+	}
+	res.Body.Close()
+	if res.StatusCode == http.StatusNotFound && method != http.MethodPut {
+		return nil, os.ErrNotExist
+	}
+	return nil, fmt.Errorf("%s %s failed: %s", method, u.Redacted(), res.Status)
+}
+
+// upload streams the archive as the body of the PUT request (chunked, since the size is not known upfront)
+func (b *httpBackend) upload(host, path string, in io.Reader) error {
+	res, err := b.do(http.MethodPut, host, path, map[string]string{"Content-Type": "application/octet-stream"},
+		ioutil.NopCloser(in))
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+func (b *httpBackend) size(host, path string) (int64, error) {
+	res, err := b.do(http.MethodHead, host, path, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	res.Body.Close()
+	if res.ContentLength < 0 {
+		return 0, fmt.Errorf("Server did not report the size of %s%s/%s", b.scheme, host, path)
+	}
+	return res.ContentLength, nil
+}
+
+// readRange uses the Range requests
+//   - NOTE: if the server ignores the Range header, the whole object is downloaded up to the range
+func (b *httpBackend) readRange(host, path string, off, n int64) ([]byte, error) {
+	res, err := b.do(http.MethodGet, host, path, map[string]string{"Range": fmt.Sprintf("bytes=%d-%d", off, off+n-1)}, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusPartialContent {
+		if _, err = io.CopyN(ioutil.Discard, res.Body, off); err != nil {
+			return nil, err
+		}
+	}
+	return ioutil.ReadAll(io.LimitReader(res.Body, n))
+}
+
+// list is not supported by the HTTP servers (so the split archives are not checked for the missing volumes)
+func (b *httpBackend) list(host, prefix string) ([]string, error) {
+	return nil, nil
+}
+
+func (b *httpBackend) remove(host, path string) error {
+	res, err := b.do(http.MethodDelete, host, path, nil, nil)
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const ignoreFileName = ".etcdignore"
+
+// ignoreRule is a single pattern of the .etcdignore file
+type ignoreRule struct {
+	re      *regexp.Regexp // matches the slash-separated path, relative to the directory of the .etcdignore file
+	negate  bool           // the `!pattern` re-includes the path
+	dirOnly bool           // the `pattern/` matches only the directories
+}
+
+// ignoreRules are the .etcdignore files found in the uploaded directories (gitignore syntax)
+//   - NOTE: same as with git, the files inside the ignored directories cannot be re-included
+type ignoreRules struct {
+	dirs map[string][]ignoreRule
+}
+
+// ignorePatternRegexp converts the gitignore pattern into the regexp
+//   - the patterns with '/' (other than the trailing one) are relative to the directory of the .etcdignore file,
+//     the others match at any level
+func ignorePatternRegexp(pat string) (*regexp.Regexp, error) {
+	var buf strings.Builder
+	if strings.Contains(pat, "/") {
+		buf.WriteString("^")
+		pat = strings.TrimPrefix(pat, "/")
+	} else {
+		buf.WriteString("^(?:.*/)?")
+	}
+	for i := 0; i < len(pat); i++ {
+		switch ch := pat[i]; {
+		case strings.HasPrefix(pat[i:], "**/"):
+			buf.WriteString("(?:.*/)?")
+			i += 2
+		case pat[i:] == "**":
+			buf.WriteString(".*")
+			i++
+		case ch == '*':
+			buf.WriteString("[^/]*")
+		case ch == '?':
+			buf.WriteString("[^/]")
+		case ch == '[':
+			j := strings.IndexByte(pat[i+1:], ']')
+			if j < 0 {
+				return nil, fmt.Errorf("missing ]")
+			}
+			class := pat[i+1 : i+1+j]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			buf.WriteString("[" + class + "]")
+			i += j + 1
+		case ch == '\\' && i+1 < len(pat):
+			i++
+			buf.WriteString(regexp.QuoteMeta(pat[i : i+1]))
+		default:
+			buf.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+	buf.WriteString("$")
+	return regexp.Compile(buf.String())
+}
+
+// load reads the .etcdignore file of the directory (if any)
+func (ir *ignoreRules) load(dir string) error {
+	fname := filepath.Join(dir, ignoreFileName)
+	f, err := os.Open(fname)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var (
+		rules []ignoreRule
+		sc    = bufio.NewScanner(f)
+	)
+	for ln := 1; sc.Scan(); ln++ {
+		pat := strings.TrimRight(sc.Text(), " \t\r")
+		if pat == "" || strings.HasPrefix(pat, "#") {
+			continue
+		}
+		var r ignoreRule
+		if strings.HasPrefix(pat, "!") {
+			r.negate, pat = true, pat[1:]
+		} else if strings.HasPrefix(pat, `\!`) || strings.HasPrefix(pat, `\#`) {
+			pat = pat[1:]
+		}
+		if strings.HasSuffix(pat, "/") {
+			r.dirOnly, pat = true, strings.TrimRight(pat, "/")
+		}
+		if pat == "" {
+			continue
+		}
+		if r.re, err = ignorePatternRegexp(pat); err != nil {
+			return fmt.Errorf("Invalid pattern at line %d of %s: %v", ln, fname, err)
+		}
+		rules = append(rules, r)
+	}
+	if err = sc.Err(); err != nil {
+		return err
+	}
+	logrus.Debugf("Loaded %d patterns from %s", len(rules), fname)
+	if ir.dirs == nil {
+		ir.dirs = make(map[string][]ignoreRule)
+	}
+	ir.dirs[dir] = rules
+	return nil
+}
+
+// ignored checks the path against the .etcdignore files of its parent directories (up to the root directory)
+//   - the last matching pattern wins, and the deeper .etcdignore files take precedence
+func (ir *ignoreRules) ignored(root, path string, isDir bool) bool {
+	if len(ir.dirs) <= 0 {
+		return false
+	}
+	var parents []string
+	for d := filepath.Dir(path); ; d = filepath.Dir(d) {
+		parents = append(parents, d)
+		if d == root || d == filepath.Dir(d) {
+			break
+		}
+	}
+	ignored := false
+	for i := len(parents) - 1; i >= 0; i-- {
+		rules := ir.dirs[parents[i]]
+		if len(rules) <= 0 {
+			continue
+		}
+		rel, err := filepath.Rel(parents[i], path)
+		if err != nil {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		for _, r := range rules {
+			if (!r.dirOnly || isDir) && r.re.MatchString(rel) {
+				ignored = !r.negate
+			}
+		}
+	}
+	return ignored
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIgnorePatternRegexp(t *testing.T) {
+	for _, tc := range []struct {
+		pat   string
+		match []string
+		skip  []string
+	}{
+		{"*.tmp", []string{"a.tmp", "d/a.tmp", "d/e/.tmp"}, []string{"a.tmpx", "a/tmp", "a.tmp/x"}},
+		{"/build", []string{"build"}, []string{"src/build", "build/x"}},
+		{"docs/*.md", []string{"docs/a.md"}, []string{"x/docs/a.md", "docs/sub/a.md"}},
+		{"**/logs", []string{"logs", "a/logs", "a/b/logs"}, []string{"xlogs", "logs/a"}},
+		{"a/**/b", []string{"a/b", "a/x/b", "a/x/y/b"}, []string{"a/xb", "x/a/b"}},
+		{"cache/**", []string{"cache/x", "cache/x/y"}, []string{"cache", "x/cache/y"}},
+		{"k?", []string{"k1", "d/kx"}, []string{"k", "k10", "k/"}},
+		{"[!a]x", []string{"bx", "d/cx"}, []string{"ax", "x"}},
+		{"k[0-9]", []string{"k0"}, []string{"kx"}},
+		{`a\*`, []string{"a*"}, []string{"ab"}},
+		{"a.b", []string{"a.b"}, []string{"axb"}},
+	} {
+		re, err := ignorePatternRegexp(tc.pat)
+		if err != nil {
+			t.Errorf("%s: %v", tc.pat, err)
+			continue
+		}
+		for _, p := range tc.match {
+			if !re.MatchString(p) {
+				t.Errorf("Expected %s to match %s", tc.pat, p)
+			}
+		}
+		for _, p := range tc.skip {
+			if re.MatchString(p) {
+				t.Errorf("Expected %s not to match %s", tc.pat, p)
+			}
+		}
+	}
+
+	if _, err := ignorePatternRegexp("[a"); err == nil {
+		t.Error("Expected the unterminated class to fail")
+	}
+}
+
+func TestIgnoreRules(t *testing.T) {
+	var (
+		root = t.TempDir()
+		sub  = filepath.Join(root, "sub")
+		ir   ignoreRules
+	)
+	writeTestFiles(t, root,
+		ignoreFileName, "# comment\n\n*.tmp\n!keep.tmp\nbuild/\n\\#hash\n\\!bang\nsub/local.txt  \n",
+		"sub/"+ignoreFileName, "!x.tmp\nkeep.tmp\n*.txt\n")
+	for _, d := range []string{root, sub, filepath.Join(sub, "deep")} {
+		if err := ir.load(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{"a.tmp", false, true},
+		{"keep.tmp", false, false},
+		{"build", true, true},
+		{"build", false, false}, // the dir-only pattern
+		{"sub/build", true, true},
+		{"#hash", false, true},
+		{"!bang", false, true},
+		{"other.txt", false, false},
+		{"sub/y.tmp", false, true},
+		{"sub/x.tmp", false, false},   // re-included by the deeper .etcdignore
+		{"sub/keep.tmp", false, true}, // ignored by the deeper .etcdignore
+		{"sub/local.txt", false, true},
+		{"sub/deep/z.txt", false, true},
+		{"sub/deep/z.tmp", false, true},
+		{"sub/deep/x.tmp", false, false},
+	} {
+		path := filepath.Join(root, filepath.FromSlash(tc.path))
+		if got := ir.ignored(root, path, tc.isDir); got != tc.ignored {
+			t.Errorf("Expected %s (dir=%v) ignored=%v, got %v", tc.path, tc.isDir, tc.ignored, got)
+		}
+	}
+
+	// the .etcdignore files above the root directory are not applied
+	if ir.ignored(sub, filepath.Join(sub, "a.tmp"), false) {
+		t.Error("Expected the rules of the parent of the root directory not applied")
+	}
+	if (&ignoreRules{}).ignored(root, filepath.Join(root, "a.tmp"), false) {
+		t.Error("Expected nothing ignored without the .etcdignore files")
+	}
+}
+
+func TestIgnoreRulesInvalid(t *testing.T) {
+	var (
+		dir = t.TempDir()
+		ir  ignoreRules
+	)
+	writeTestFiles(t, dir, ignoreFileName, "*.tmp\nk[0-9\n")
+	if err := ir.load(dir); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Expected the invalid pattern at line 2 to fail, got %v", err)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// jqStep is a single step of the jq-style path (`.key`, `.[index]` or `.[]`)
+type jqStep struct {
+	key     string
+	index   int
+	isIndex bool
+	iterate bool
+}
+
+// jqPath is the sequence of steps (the empty path is the identity `.`)
+type jqPath []jqStep
+
+// jqQuery is the subset of the jq language, selecting the fields of the JSON values (`get --jq` option)
+//   - the paths (e.g. `.spec.replicas`, `.items[0].name`, `.["a-b"]` or `.items[].name`), separated by `,` for multiple
+//     results, and combined into the pipelines via `|` (e.g. `.items[] | .name, .id`)
+//   - NOTE: the functions, operators and slices of jq are not supported
+type jqQuery struct {
+	expr   string
+	stages [][]jqPath
+}
+
+// parseJqQuery parses the query expression
+func parseJqQuery(expr string) (*jqQuery, error) {
+	var (
+		q   = &jqQuery{expr: expr}
+		pos int
+	)
+	fail := func(msg string) (*jqQuery, error) {
+		return nil, fmt.Errorf("Invalid query '%s' at position %d: %s", expr, pos+1, msg)
+	}
+	skipSpace := func() {
+		for pos < len(expr) && (expr[pos] == ' ' || expr[pos] == '\t' || expr[pos] == '\n') {
+			pos++
+		}
+	}
+	readString := func() (string, error) {
+		end := pos + 1
+		for ; end < len(expr) && expr[end] != '"'; end++ {
+			if expr[end] == '\\' {
+				end++
+			}
+		}
+		if end >= len(expr) {
+			return "", fmt.Errorf("unterminated string")
+		}
+		s, err := strconv.Unquote(expr[pos : end+1])
+		pos = end + 1
+		return s, err
+	}
+
+	stage := []jqPath{}
+	for {
+		skipSpace()
+		if pos >= len(expr) || expr[pos] != '.' {
+			return fail("expected '.'")
+		}
+		var path jqPath
+		for pos < len(expr) {
+			var st jqStep
+			switch {
+			case expr[pos] == '.' && pos+1 < len(expr) && isJqIdentChar(expr[pos+1]):
+				end := pos + 1
+				for end < len(expr) && isJqIdentChar(expr[end]) {
+					end++
+				}
+				st.key, pos = expr[pos+1:end], end
+			case expr[pos] == '.' && pos+1 < len(expr) && expr[pos+1] == '"':
+				pos++
+				s, err := readString()
+				if err != nil {
+					return fail(err.Error())
+				}
+				st.key = s
+			case expr[pos] == '[' || strings.HasPrefix(expr[pos:], ".["):
+				pos += strings.IndexByte(expr[pos:], '[') + 1
+				skipSpace()
+				switch {
+				case pos < len(expr) && expr[pos] == ']':
+					st.iterate = true
+				case pos < len(expr) && expr[pos] == '"':
+					s, err := readString()
+					if err != nil {
+						return fail(err.Error())
+					}
+					st.key = s
+				default:
+					end := pos
+					for end < len(expr) && (expr[end] == '-' || (expr[end] >= '0' && expr[end] <= '9')) {
+						end++
+					}
+					n, err := strconv.Atoi(expr[pos:end])
+					if err != nil {
+						return fail("expected index, string or ']'")
+					}
+					st.index, st.isIndex, pos = n, true, end
+				}
+				skipSpace()
+				if pos >= len(expr) || expr[pos] != ']' {
+					return fail("expected ']'")
+				}
+				pos++
+			case expr[pos] == '.' && len(path) <= 0:
+				// the identity
+				pos++
+				continue
+			default:
+				goto done
+			}
+			path = append(path, st)
+		}
+	done:
+		stage = append(stage, path)
+		skipSpace()
+		if pos >= len(expr) {
+			q.stages = append(q.stages, stage)
+			return q, nil
+		}
+		switch expr[pos] {
+		case ',':
+		case '|':
+			q.stages, stage = append(q.stages, stage), []jqPath{}
+		default:
+			return fail(fmt.Sprintf("unexpected '%c'", expr[pos]))
+		}
+		pos++
+	}
+}
+
+func isJqIdentChar(ch byte) bool {
+	return ch == '_' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || (ch >= '0' && ch <= '9')
+}
+
+// eval applies the path on the value
+func (p jqPath) eval(v interface{}) ([]interface{}, error) {
+	cur := []interface{}{v}
+	for _, st := range p {
+		var next []interface{}
+		for _, c := range cur {
+			switch c := c.(type) {
+			case nil:
+				if st.iterate {
+					return nil, fmt.Errorf("Cannot iterate over null")
+				}
+				next = append(next, nil)
+			case map[string]interface{}:
+				if st.isIndex {
+					return nil, fmt.Errorf("Cannot index object with number")
+				} else if st.iterate {
+					// NOTE: the object keys are iterated in sorted order (the original order is not kept)
+					keys := make([]string, 0, len(c))
+					for k := range c {
+						keys = append(keys, k)
+					}
+					sort.Strings(keys)
+					for _, k := range keys {
+						next = append(next, c[k])
+					}
+					continue
+				}
+				next = append(next, c[st.key])
+			case []interface{}:
+				if st.iterate {
+					next = append(next, c...)
+					continue
+				} else if !st.isIndex {
+					return nil, fmt.Errorf("Cannot index array with \"%s\"", st.key)
+				}
+				i := st.index
+				if i < 0 {
+					i += len(c)
+				}
+				if i < 0 || i >= len(c) {
+					next = append(next, nil)
+				} else {
+					next = append(next, c[i])
+				}
+			default:
+				return nil, fmt.Errorf("Cannot index %s", jqTypeName(c))
+			}
+		}
+		cur = next
+	}
+	return cur, nil
+}
+
+func jqTypeName(v interface{}) string {
+	switch v.(type) {
+	case string:
+		return "string"
+	case json.Number:
+		return "number"
+	case bool:
+		return "boolean"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// eval parses the JSON value, and returns the results of the query
+func (q *jqQuery) eval(data []byte) ([]interface{}, error) {
+	var doc interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("not a JSON value: %v", err)
+	}
+	vals := []interface{}{doc}
+	for _, stage := range q.stages {
+		var next []interface{}
+		for _, v := range vals {
+			for _, p := range stage {
+				res, err := p.eval(v)
+				if err != nil {
+					return nil, err
+				}
+				next = append(next, res...)
+			}
+		}
+		vals = next
+	}
+	return vals, nil
+}
+
+// format formats the result as JSON (or the strings without quotes, if raw)
+func (q *jqQuery) format(v interface{}, raw bool) ([]byte, error) {
+	if s, ok := v.(string); ok && raw {
+		return []byte(s), nil
+	}
+	return json.Marshal(v)
+}
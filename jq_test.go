@@ -0,0 +1,106 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestJqQuery(t *testing.T) {
+	const doc = `{"a": {"b": 1, "c": "two"}, "a-b": true, "items": [{"name": "x", "id": 1}, {"name": "y", "id": 2}],
+		"list": [10, 20, 30], "empty": null}`
+	for _, tc := range []struct {
+		query string
+		exp   string // the results (one per line, the strings raw)
+	}{
+		{".", `{"a":{"b":1,"c":"two"},"a-b":true,"empty":null,"items":[{"id":1,"name":"x"},{"id":2,"name":"y"}],"list":[10,20,30]}`},
+		{".a.b", "1"},
+		{".a.c", "two"},
+		{".a.missing", "null"},
+		{".empty.x", "null"},
+		{`.["a-b"]`, "true"},
+		{`.a["c"]`, "two"},
+		{`."a-b"`, "true"},
+		{".items[].name", "x\ny"},
+		{".items[1].id", "2"},
+		{".list[-1]", "30"},
+		{".list[ -3 ]", "10"},
+		{".list[5]", "null"},
+		{".list[-4]", "null"},
+		{".a[]", "1\ntwo"},
+		{".a | .b, .c", "1\ntwo"},
+		{".items[] | .name, .id", "x\n1\ny\n2"},
+		{".items | .[0] | .name", "x"},
+		{".list[0], .a.b", "10\n1"},
+	} {
+		q, err := parseJqQuery(tc.query)
+		if err != nil {
+			t.Errorf("%s: %v", tc.query, err)
+			continue
+		}
+		res, err := q.eval([]byte(doc))
+		if err != nil {
+			t.Errorf("%s: %v", tc.query, err)
+			continue
+		}
+		var got []string
+		for _, r := range res {
+			out, err := q.format(r, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, string(out))
+		}
+		if strings.Join(got, "\n") != tc.exp {
+			t.Errorf("%s: expected %q, got %q", tc.query, tc.exp, got)
+		}
+	}
+}
+
+func TestJqQueryErrors(t *testing.T) {
+	for _, tc := range []struct {
+		query string
+		err   string
+	}{
+		{"", "at position 1: expected '.'"},
+		{"a", "at position 1: expected '.'"},
+		{".a]", "at position 3: unexpected ']'"},
+		{".a[", "at position 4: expected index, string or ']'"},
+		{".a[x]", "at position 4: expected index, string or ']'"},
+		{".[1", "at position 4: expected ']'"},
+		{`.["x`, "at position 3: unterminated string"},
+		{`."x`, "at position 2: unterminated string"},
+		{".a |", "at position 5: expected '.'"},
+		{".a, b", "at position 5: expected '.'"},
+	} {
+		if _, err := parseJqQuery(tc.query); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%q: expected error %q, got %v", tc.query, tc.err, err)
+		}
+	}
+
+	for _, tc := range []struct {
+		query, doc, err string
+	}{
+		{".a[0]", `{"a": {}}`, "Cannot index object with number"},
+		{".a.b", `{"a": [1]}`, `Cannot index array with "b"`},
+		{".a.b", `{"a": "s"}`, "Cannot index string"},
+		{".a[]", `{}`, "Cannot iterate over null"},
+		{".a", `not json`, "not a JSON value"},
+	} {
+		q, err := parseJqQuery(tc.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = q.eval([]byte(tc.doc)); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s on %s: expected error %q, got %v", tc.query, tc.doc, tc.err, err)
+		}
+	}
+
+	// the strings are quoted unless raw
+	q, err := parseJqQuery(".a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, err := q.format("s", false); err != nil || string(out) != `"s"` {
+		t.Errorf("Expected the quoted string, got %s (%v)", out, err)
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"go.etcd.io/etcd/clientv3"
+)
+
+// leaseInfo is the state of the lease (`lease ttl` command)
+type leaseInfo struct {
+	ID         string   `json:"id"`
+	TTL        int64    `json:"ttl"`
+	GrantedTTL int64    `json:"granted_ttl"`
+	Keys       []string `json:"keys,omitempty"`
+}
+
+// parseLeaseID parses the lease ID (hexadecimal, as printed by the `lease` commands and by etcdctl)
+func parseLeaseID(s string) (clientv3.LeaseID, error) {
+	id, err := strconv.ParseInt(s, 16, 64)
+	if err != nil || id == 0 {
+		return clientv3.NoLease, fmt.Errorf("Invalid lease ID '%s' (expecting a hexadecimal number)", s)
+	}
+	return clientv3.LeaseID(id), nil
+}
+
+// grantLease creates a new lease with given TTL (in seconds)
+func grantLease(client *clientv3.Client, ttl int64) (clientv3.LeaseID, error) {
+	logrus.Debugf("Doing GRANT(ttl=%d)...", ttl)
+	res, err := client.Grant(ctx, ttl)
+	if err != nil {
+		return clientv3.NoLease, err
+	}
+	logrus.Debugf("Granted lease %x [ttl=%ds]", res.ID, res.TTL)
+	return res.ID, nil
+}
+
+// leaseOption returns the lease for the `--ttl` (grants a new lease) or the `--lease` (existing lease) options
+//   - returns NoLease if neither option is given
+func leaseOption(c *cli.Context, client *clientv3.Client) (clientv3.LeaseID, error) {
+	optTTL, optLease := c.Int64("ttl"), c.String("lease")
+	if optTTL > 0 && optLease != "" {
+		return clientv3.NoLease, fmt.Errorf("Options --ttl and --lease are mutually exclusive")
+	} else if optTTL > 0 {
+		return grantLease(client, optTTL)
+	} else if optLease != "" {
+		return parseLeaseID(optLease)
+	}
+	return clientv3.NoLease, nil
+}
+
+// keepLeaseAlive renews the lease until the process is interrupted (SIGINT/SIGTERM)
+//   - NOTE: the lease is not revoked on exit, so the attached keys expire after the lease's TTL
+func keepLeaseAlive(client *clientv3.Client, id clientv3.LeaseID) error {
+	kctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ch, err := client.KeepAlive(kctx, id)
+	if err != nil {
+		return err
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	logrus.Infof("Keeping lease %x alive (interrupt to stop)...", id)
+	for {
+		select {
+		case ka, ok := <-ch:
+			if !ok {
+				return fmt.Errorf("Could not keep lease %x alive (lease expired or connection lost)", id)
+			}
+			logrus.Debugf("Renewed lease %x [ttl=%ds]", ka.ID, ka.TTL)
+		case s := <-sigs:
+			logrus.Infof("Got %s, leaving lease %x to expire", s, id)
+			return nil
+		}
+	}
+}
+
+func actLeaseGrant(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("Must specify the TTL of the lease (in seconds)")
+	}
+	ttl, err := strconv.ParseInt(c.Args().First(), 10, 64)
+	if err != nil || ttl <= 0 {
+		return fmt.Errorf("Invalid TTL '%s' (expecting a positive number of seconds)", c.Args().First())
+	}
+
+	client := getEtcdClient()
+	id, err := grantLease(client, ttl)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("Granted lease %x [ttl=%ds]", id, ttl)
+	fmt.Printf("%x\n", id)
+	return nil
+}
+
+func actLeaseRevoke(c *cli.Context) error {
+	if c.NArg() <= 0 {
+		return fmt.Errorf("Must specify which leases to revoke")
+	}
+
+	var (
+		client = getEtcdClient()
+		failed = failedKeys{op: "revoke"}
+	)
+
+	for _, a := range c.Args().Slice() {
+		id, err := parseLeaseID(a)
+		if err == nil {
+			logrus.Debugf("Doing REVOKE(%x)...", id)
+			_, err = client.Revoke(ctx, id)
+		}
+		if err != nil && !opt.failFast {
+			failed.add(a, err)
+			continue
+		}
+		checkErr(err)
+		logrus.Infof("Revoked lease %x (the attached keys were deleted)", id)
+	}
+	return failed.result("")
+}
+
+func actLeaseTTL(c *cli.Context) error {
+	if c.NArg() <= 0 {
+		return fmt.Errorf("Must specify which leases to show")
+	}
+
+	var (
+		client  = getEtcdClient()
+		optKeys = c.Bool("keys")
+		optJSON = c.Bool("json")
+		enc     = json.NewEncoder(os.Stdout)
+		failed  = failedKeys{op: "ttl"}
+		opts    []clientv3.LeaseOption
+	)
+
+	if optKeys {
+		opts = append(opts, clientv3.WithAttachedKeys())
+	}
+
+	for _, a := range c.Args().Slice() {
+		var res *clientv3.LeaseTimeToLiveResponse
+		id, err := parseLeaseID(a)
+		if err == nil {
+			logrus.Debugf("Doing TTL(%x,%v)...", id, optKeys)
+			res, err = client.TimeToLive(ctx, id, opts...)
+		}
+		if err == nil && res.TTL < 0 {
+			err = fmt.Errorf("Lease not found (expired or revoked)")
+		}
+		if err != nil && !opt.failFast {
+			failed.add(a, err)
+			continue
+		}
+		checkErr(err)
+		li := leaseInfo{
+			ID:         fmt.Sprintf("%x", res.ID),
+			TTL:        res.TTL,
+			GrantedTTL: res.GrantedTTL,
+		}
+		for _, k := range res.Keys {
+			li.Keys = append(li.Keys, string(k))
+		}
+		if optJSON {
+			checkErr(enc.Encode(li))
+			continue
+		}
+		fmt.Printf("%s\n", li.ID)
+		fmt.Printf("   ttl:         %d\n", li.TTL)
+		fmt.Printf("   granted_ttl: %d\n", li.GrantedTTL)
+		if optKeys {
+			fmt.Printf("   keys:        %d\n", len(li.Keys))
+			for _, k := range li.Keys {
+				fmt.Printf("      %s\n", k)
+			}
+		}
+	}
+	return failed.result("")
+}
+
+func actLeaseList(c *cli.Context) error {
+	client := getEtcdClient()
+
+	logrus.Debugf("Doing LEASES()...")
+	res, err := client.Leases(ctx)
+	if err != nil {
+		return err
+	}
+	for _, l := range res.Leases {
+		fmt.Printf("%x\n", l.ID)
+	}
+	logrus.Infof("Found %d leases.", len(res.Leases))
+	return nil
+}
+
+func actLeaseKeepAlive(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("Must specify which lease to keep alive")
+	}
+	id, err := parseLeaseID(c.Args().First())
+	if err != nil {
+		return err
+	}
+
+	client := getEtcdClient()
+	if !c.Bool("once") {
+		return keepLeaseAlive(client, id)
+	}
+	logrus.Debugf("Doing KEEPALIVE(%x,once)...", id)
+	res, err := client.KeepAliveOnce(ctx, id)
+	if err != nil {
+		return fmt.Errorf("Could not renew lease %x: %v", id, err)
+	}
+	logrus.Infof("Renewed lease %x [ttl=%ds]", res.ID, res.TTL)
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestPutLeaseKeepalive(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Interrupting the process is not supported on Windows")
+	}
+	var (
+		prefix = testPrefix(t)
+		key    = prefix + "presence"
+		done   = make(chan error, 1)
+	)
+	go func() {
+		_, err := runApp(t, "put", "--ttl", "2", "--lease-keepalive", "--value", "here", key)
+		done <- err
+	}()
+
+	exists := func() bool { _, has := getTestKeys(t, prefix)[key]; return has }
+	deadline := time.Now().Add(5 * time.Second)
+	for !exists() {
+		if time.Now().After(deadline) {
+			t.Fatal("Key was not put")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	// the key outlives its TTL while the lease is kept alive
+	time.Sleep(3 * time.Second)
+	if !exists() {
+		t.Fatal("Expected the key to persist while the command runs")
+	}
+
+	p, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	} else if err = p.Signal(os.Interrupt); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Expected the interrupted command to succeed, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Command was not interrupted")
+	}
+
+	deadline = time.Now().Add(5 * time.Second)
+	for exists() {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the key to expire after the command stopped")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
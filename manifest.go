@@ -0,0 +1,189 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/mvcc/mvccpb"
+)
+
+// manifestName is the name of the manifest file, written into the dumped directories and archives
+const manifestName = ".etcdTool-manifest.json"
+
+// manifestKey is the metadata of the dumped key
+type manifestKey struct {
+	Key            string `json:"key"`
+	CreateRevision int64  `json:"create_revision"`
+	ModRevision    int64  `json:"mod_revision"`
+	Version        int64  `json:"version"`
+	Lease          int64  `json:"lease,omitempty"`
+	SHA256         string `json:"sha256,omitempty"` // checksum of the dumped content (hex)
+}
+
+// manifestLease is the TTL of the lease attached to the dumped keys
+type manifestLease struct {
+	TTL        int64 `json:"ttl"`         // remaining TTL (at the time of the dump)
+	GrantedTTL int64 `json:"granted_ttl"` // TTL the lease was granted with
+}
+
+// manifest records the metadata of the dumped keys (which the files do not keep), and the cluster info
+type manifest struct {
+	Tool      string                   `json:"tool"`
+	Created   time.Time                `json:"created"`
+	ClusterID uint64                   `json:"cluster_id"`
+	MemberID  uint64                   `json:"member_id"`
+	Revision  int64                    `json:"revision"`
+	Since     int64                    `json:"since_revision,omitempty"` // only the keys modified after the revision were dumped
+	Keys      map[string]*manifestKey  `json:"keys"`                     // indexed by the file-names
+	Leases    map[int64]*manifestLease `json:"leases,omitempty"`
+}
+
+func newManifest() *manifest {
+	return &manifest{
+		Tool:    "etcdTool " + version,
+		Created: time.Now().UTC(),
+		Keys:    make(map[string]*manifestKey),
+		Leases:  make(map[int64]*manifestLease),
+	}
+}
+
+// incremental returns the description of the incremental dump (or "", if all the keys were dumped)
+func (m *manifest) incremental() string {
+	if m.Since <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(", incremental since revision %d", m.Since)
+}
+
+// setHeader records the cluster info from the response header
+func (m *manifest) setHeader(res *clientv3.GetResponse) {
+	if m.ClusterID == 0 {
+		m.ClusterID, m.MemberID = res.Header.ClusterId, res.Header.MemberId
+	}
+}
+
+// add records the metadata of the key, and the checksum of its dumped content (e.g. decoded via `--d64`)
+func (m *manifest) add(name string, kv *mvccpb.KeyValue, data []byte) {
+	sum := sha256.Sum256(data)
+	m.Keys[name] = &manifestKey{
+		Key:            string(kv.Key),
+		CreateRevision: kv.CreateRevision,
+		ModRevision:    kv.ModRevision,
+		Version:        kv.Version,
+		Lease:          kv.Lease,
+		SHA256:         hex.EncodeToString(sum[:]),
+	}
+}
+
+// addLease records the TTL of the lease (once per lease)
+func (m *manifest) addLease(client *clientv3.Client, id int64) error {
+	if _, has := m.Leases[id]; has {
+		return nil
+	}
+	logrus.Debugf("Doing TTL(%x)...", id)
+	res, err := client.TimeToLive(ctx, clientv3.LeaseID(id))
+	if err != nil {
+		return err
+	}
+	m.Leases[id] = &manifestLease{TTL: res.TTL, GrantedTTL: res.GrantedTTL}
+	return nil
+}
+
+// key returns the original key of the file-name (converts the file-name if not in the manifest)
+//   - NOTE: this restores the original keys also for the `--strip` and `--strip-level` dumps
+func (m *manifest) key(name string) string {
+	if mk := m.entry(name); mk != nil {
+		return mk.Key
+	}
+	return fileName2KvKey(name)
+}
+
+// entry returns the metadata of the file-name (or nil if not in the manifest)
+//   - NOTE: the paths relative to the dumped directory lose the leading '/' of the file-names
+func (m *manifest) entry(name string) *manifestKey {
+	if m == nil {
+		return nil
+	} else if mk, has := m.Keys[name]; has {
+		return mk
+	}
+	return m.Keys["/"+name]
+}
+
+func parseManifest(data []byte) (*manifest, error) {
+	m := new(manifest)
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// readDirManifest reads the manifest of the dumped directory (returns nil if the directory has no manifest)
+func readDirManifest(dir string) (*manifest, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, manifestName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return parseManifest(data)
+}
+
+// readArchiveManifest reads the manifest of the TAR or ZIP archive (returns nil if the archive has no manifest)
+//   - NOTE: the manifest is the last entry of the TAR archive, so the whole TAR archive is read
+func readArchiveManifest(fname string) (*manifest, error) {
+	if isZip, err := isZipFile(fname); err != nil {
+		return nil, err
+	} else if isZip {
+		return readZipManifest(fname)
+	}
+	var m *manifest
+	_, _, err := readArchive(fname, func(name string, data []byte) (err error) {
+		if name == manifestName {
+			m, err = parseManifest(data)
+		}
+		return err
+	})
+	return m, err
+}
+
+// readZipManifest reads only the manifest entry of the ZIP archive (via the central directory)
+func readZipManifest(fname string) (*manifest, error) {
+	af, err := openArchive(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer af.Close()
+	ra, size, err := af.readerAt()
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zip.NewReader(ra, size)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range zr.File {
+		if f.Name != manifestName {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		return parseManifest(data)
+	}
+	return nil, nil
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"go.etcd.io/etcd/clientv3"
+	pb "go.etcd.io/etcd/etcdserver/etcdserverpb"
+)
+
+// memberRecord is the cluster member (`member list --output json` option)
+type memberRecord struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Started    bool     `json:"started"`
+	IsLearner  bool     `json:"is_learner,omitempty"`
+	PeerURLs   []string `json:"peer_urls"`
+	ClientURLs []string `json:"client_urls"`
+}
+
+func newMemberRecord(m *pb.Member) *memberRecord {
+	return &memberRecord{
+		ID:         fmt.Sprintf("%x", m.ID),
+		Name:       m.Name,
+		Started:    len(m.ClientURLs) > 0,
+		IsLearner:  m.IsLearner,
+		PeerURLs:   m.PeerURLs,
+		ClientURLs: m.ClientURLs,
+	}
+}
+
+// parseMemberID parses the member ID (hexadecimal, same as printed by the `member list` and `status` commands)
+func parseMemberID(s string) (uint64, error) {
+	id, err := strconv.ParseUint(s, 16, 64)
+	if err != nil || id == 0 {
+		return 0, fmt.Errorf("Invalid member ID '%s' (expecting a hexadecimal number)", s)
+	}
+	return id, nil
+}
+
+// peerURLs returns the `--peer-urls` option (comma-separated URLs)
+func peerURLs(c *cli.Context) ([]string, error) {
+	s := c.String("peer-urls")
+	if s == "" {
+		return nil, fmt.Errorf("Must specify --peer-urls")
+	}
+	return strings.Split(s, ","), nil
+}
+
+func actMemberList(c *cli.Context) error {
+	var (
+		client    = getEtcdClient()
+		optOutput = c.String("output")
+	)
+
+	if optOutput != "table" && optOutput != "json" {
+		return fmt.Errorf("Invalid output format '%s' (expecting table or json)", optOutput)
+	}
+	logrus.Debugf("Doing MEMBERLIST()...")
+	res, err := client.MemberList(ctx)
+	if err != nil {
+		return err
+	}
+
+	if optOutput == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		for _, m := range res.Members {
+			checkErr(enc.Encode(newMemberRecord(m)))
+		}
+		return nil
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "ID\tSTATUS\tNAME\tPEER URLS\tCLIENT URLS\tLEARNER\n")
+	for _, m := range res.Members {
+		mr := newMemberRecord(m)
+		status := "started"
+		if !mr.Started {
+			status = "unstarted"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%v\n", mr.ID, status, mr.Name, strings.Join(mr.PeerURLs, ","),
+			strings.Join(mr.ClientURLs, ","), mr.IsLearner)
+	}
+	return tw.Flush()
+}
+
+func actMemberAdd(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("Must specify the member name")
+	}
+
+	var (
+		client = getEtcdClient()
+		name   = c.Args().First()
+		res    *clientv3.MemberAddResponse
+	)
+
+	urls, err := peerURLs(c)
+	if err != nil {
+		return err
+	}
+	if c.Bool("learner") {
+		logrus.Debugf("Doing MEMBERADD(%s,learner)...", urls)
+		res, err = client.MemberAddAsLearner(ctx, urls)
+	} else {
+		logrus.Debugf("Doing MEMBERADD(%s)...", urls)
+		res, err = client.MemberAdd(ctx, urls)
+	}
+	if err != nil {
+		return err
+	}
+	logrus.Infof("Added member %s [%x] to cluster %x", name, res.Member.ID, res.Header.ClusterId)
+
+	// print the configuration of the new member, same as the etcdctl
+	var initial []string
+	for _, m := range res.Members {
+		mName := m.Name
+		if m.ID == res.Member.ID {
+			mName = name
+		} else if mName == "" {
+			continue
+		}
+		for _, u := range m.PeerURLs {
+			initial = append(initial, mName+"="+u)
+		}
+	}
+	fmt.Printf("ETCD_NAME=%q\n", name)
+	fmt.Printf("ETCD_INITIAL_CLUSTER=%q\n", strings.Join(initial, ","))
+	fmt.Printf("ETCD_INITIAL_ADVERTISE_PEER_URLS=%q\n", strings.Join(urls, ","))
+	fmt.Printf("ETCD_INITIAL_CLUSTER_STATE=\"existing\"\n")
+	return nil
+}
+
+func actMemberRemove(c *cli.Context) error {
+	if c.NArg() <= 0 {
+		return fmt.Errorf("Must specify which members to remove")
+	}
+
+	var (
+		client = getEtcdClient()
+		failed = failedKeys{op: "remove member"}
+	)
+
+	for _, a := range c.Args().Slice() {
+		id, err := parseMemberID(a)
+		if err == nil {
+			logrus.Debugf("Doing MEMBERREMOVE(%x)...", id)
+			_, err = client.MemberRemove(ctx, id)
+		}
+		if err != nil && !opt.failFast {
+			failed.add(a, err)
+			continue
+		}
+		checkErr(err)
+		logrus.Infof("Removed member %x", id)
+	}
+	return failed.result("")
+}
+
+func actMemberUpdate(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("Must specify which member to update")
+	}
+	id, err := parseMemberID(c.Args().First())
+	if err != nil {
+		return err
+	}
+	urls, err := peerURLs(c)
+	if err != nil {
+		return err
+	}
+
+	client := getEtcdClient()
+	logrus.Debugf("Doing MEMBERUPDATE(%x,%s)...", id, urls)
+	if _, err = client.MemberUpdate(ctx, id, urls); err != nil {
+		return err
+	}
+	logrus.Infof("Updated peer URLs of member %x to %s", id, strings.Join(urls, ","))
+	return nil
+}
+
+func actMemberPromote(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("Must specify which learner to promote")
+	}
+	id, err := parseMemberID(c.Args().First())
+	if err != nil {
+		return err
+	}
+
+	client := getEtcdClient()
+	logrus.Debugf("Doing MEMBERPROMOTE(%x)...", id)
+	if _, err = client.MemberPromote(ctx, id); err != nil {
+		return err
+	}
+	logrus.Infof("Promoted member %x to voting member", id)
+	return nil
+}
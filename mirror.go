@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/etcdserver/api/v3rpc/rpctypes"
+	"go.etcd.io/etcd/mvcc/mvccpb"
+)
+
+// mirrorRetryDelay is the delay before resuming the failed watch (`mirror` command)
+const mirrorRetryDelay = 2 * time.Second
+
+// mirror replicates the keys under the src prefix into the dst prefix of the target cluster (`mirror` command)
+type mirror struct {
+	client   *clientv3.Client
+	target   *clientv3.Client
+	src      string
+	dst      string
+	del      bool
+	dryRun   bool // only print the plan of the initial sync
+	revFile  string
+	rev      int64         // the last revision applied to the destination
+	progress *progressFile // records the mirrored keys (`--progress-file` option)
+}
+
+// commit applies the operations on the destination (in batches of transactions)
+func (m *mirror) commit(ops []clientv3.Op) error {
+	for i := 0; i < len(ops); i += maxTxnOps {
+		end := i + maxTxnOps
+		if end > len(ops) {
+			end = len(ops)
+		}
+		logrus.Debugf("Doing TXN(%d ops)...", end-i)
+		if _, err := m.target.Txn(ctx).Then(ops[i:end]...).Commit(); err != nil {
+			return err
+		}
+		for _, op := range ops[i:end] {
+			m.progress.add(len(op.ValueBytes()))
+		}
+	}
+	return nil
+}
+
+// setRev records the last applied revision (also into the `--rev-file`)
+func (m *mirror) setRev(rev int64) error {
+	m.rev = rev
+	if m.revFile == "" {
+		return nil
+	}
+	return writeRevisionFile(m.revFile, rev)
+}
+
+// sync does the initial sync of the destination, at the latest revision of the source (or prints its plan, if dryRun)
+func (m *mirror) sync() error {
+	var (
+		limit keyLimit
+		st    syncStats
+		rev   int64
+	)
+	src, err := loadPrefixAt(m.client, m.src, &rev, &limit)
+	if err != nil {
+		return fmt.Errorf("Could not read %s: %v", m.src, err)
+	}
+	dst, err := loadPrefix(m.target, m.dst, &limit)
+	if err != nil {
+		return fmt.Errorf("Could not read %s: %v", m.dst, err)
+	}
+	ops, names := syncOps(src, dst, m.dst, m.del, &st)
+	if m.dryRun {
+		for _, n := range names {
+			fmt.Printf("%s\n", n)
+		}
+		logrus.Infof("Would create %d, update %d and delete %d keys.", st.created, st.updated, st.deleted)
+		return nil
+	}
+	m.progress.setTotal(int64(len(ops)))
+	if err = m.commit(ops); err != nil {
+		return err
+	}
+	logrus.Infof("Synced %s at revision %d: %d created, %d updated, %d deleted.", m.dst, rev, st.created, st.updated, st.deleted)
+	return m.setRev(rev)
+}
+
+// watch replicates the changes after the last applied revision, until the watch fails (or the context is canceled)
+func (m *mirror) watch(wctx context.Context) error {
+	logrus.Debugf("Doing WATCH(%s,prefix,rev=%d)...", m.src, m.rev+1)
+	wch := m.client.Watch(clientv3.WithRequireLeader(wctx), m.src, clientv3.WithPrefix(), clientv3.WithRev(m.rev+1))
+	for wres := range wch {
+		if wres.CompactRevision != 0 {
+			return rpctypes.ErrCompacted
+		} else if err := wres.Err(); err != nil {
+			return err
+		} else if len(wres.Events) <= 0 {
+			continue
+		}
+		// NOTE: the events of each revision are committed separately (like the `replay` command), as the batched
+		// response can change the same key several times, which is not allowed within a single transaction
+		var cnt int
+		for i := 0; i < len(wres.Events); {
+			var (
+				rev = wres.Events[i].Kv.ModRevision
+				ops []clientv3.Op
+			)
+			for ; i < len(wres.Events) && wres.Events[i].Kv.ModRevision == rev; i++ {
+				ev := wres.Events[i]
+				key := m.dst + string(ev.Kv.Key[len(m.src):])
+				if ev.Type == mvccpb.DELETE {
+					ops = append(ops, clientv3.OpDelete(key))
+				} else {
+					ops = append(ops, clientv3.OpPut(key, string(ev.Kv.Value)))
+				}
+				logrus.Debugf("Mirroring %s %s [rev %d]", ev.Type, key, rev)
+			}
+			if err := m.commit(ops); err != nil {
+				return err
+			}
+			if err := m.setRev(rev); err != nil {
+				return err
+			}
+			cnt += len(ops)
+		}
+		logrus.Infof("Mirrored %d changes (revision %d)", cnt, m.rev)
+	}
+	if err := wctx.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("Watch of %s closed", m.src)
+}
+
+func actMirror(c *cli.Context) error {
+	if c.NArg() < 1 || c.NArg() > 2 {
+		return fmt.Errorf("Must specify <src-prefix> [dst-prefix]")
+	}
+
+	var (
+		m = &mirror{
+			client:  getEtcdClient(),
+			src:     c.Args().Get(0),
+			dst:     c.Args().Get(1),
+			del:     c.Bool("delete"),
+			dryRun:  c.Bool("dry-run"),
+			revFile: c.String("rev-file"),
+		}
+		sigs        = make(chan os.Signal, 1)
+		wctx, abort = context.WithCancel(ctx)
+		err         error
+	)
+	defer abort()
+
+	m.target = m.client
+	if c.String("target-endpoints") != "" {
+		if m.target, err = getTargetClient(c); err != nil {
+			return err
+		}
+		defer m.target.Close()
+		if m.dst == "" {
+			m.dst = m.src
+		}
+	} else if m.dst == "" {
+		return fmt.Errorf("Must specify <dst-prefix> (or mirror into --target-endpoints)")
+	} else if m.src == "" || strings.HasPrefix(m.dst, m.src) || strings.HasPrefix(m.src, m.dst) {
+		return fmt.Errorf("Prefixes '%s' and '%s' must not overlap", m.src, m.dst)
+	}
+
+	if m.revFile != "" {
+		if m.rev, err = readRevisionFile(m.revFile); err != nil {
+			return err
+		} else if m.rev > 0 {
+			logrus.Infof("Resuming from revision %d (recorded in %s)", m.rev, m.revFile)
+		}
+	}
+
+	if m.dryRun {
+		if m.rev > 0 {
+			logrus.Infof("Would resume from revision %d (without the initial sync)", m.rev)
+			return nil
+		}
+		return m.sync()
+	}
+
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	go func() {
+		if s, ok := <-sigs; ok {
+			logrus.Infof("Got %s, stopping", s)
+			abort()
+		}
+	}()
+
+	m.progress = newProgressFile(c.String("progress-file"), "mirror")
+	logrus.Infof("Mirroring %s into %s (interrupt to stop)...", m.src, m.dst)
+	for wctx.Err() == nil {
+		if m.rev == 0 {
+			if err = m.sync(); err != nil {
+				return err
+			}
+		}
+		err = m.watch(wctx)
+		switch {
+		case wctx.Err() != nil:
+		case err == rpctypes.ErrCompacted:
+			logrus.Warnf("Revision %d was compacted, re-syncing %s", m.rev+1, m.dst)
+			m.rev = 0
+		default:
+			logrus.WithError(err).Warnf("Mirroring failed, resuming from revision %d", m.rev)
+			select {
+			case <-time.After(mirrorRetryDelay):
+			case <-wctx.Done():
+			}
+		}
+	}
+	m.progress.done()
+	logrus.Infof("Stopped at revision %d.", m.rev)
+	return nil
+}
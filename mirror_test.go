@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMirrorRepeatedKey(t *testing.T) {
+	var (
+		prefix      = testPrefix(t)
+		client      = testClient(t)
+		m           = &mirror{client: client, target: client, src: prefix + "src/", dst: prefix + "dst/"}
+		done        = make(chan error, 1)
+		wctx, abort = context.WithCancel(ctx)
+	)
+	defer abort()
+
+	// the changes are replayed from the past revision, so they are all delivered in a single watch response
+	m.rev = putTestKeys(t, prefix+"src/other", "x")
+	putTestKeys(t, prefix+"src/k", "1", prefix+"src/k", "2", prefix+"src/other", "y", prefix+"src/k", "3")
+	go func() { done <- m.watch(wctx) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got := getTestKeys(t, prefix+"dst/")
+		if got[prefix+"dst/k"] == "3" && got[prefix+"dst/other"] == "y" {
+			break
+		}
+		select {
+		case err := <-done:
+			t.Fatalf("Mirroring failed: %v", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the latest values mirrored, got %v", got)
+		}
+	}
+	abort()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Expected the canceled watch, got %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"unicode/utf8"
+)
+
+// parseDelimiter parses the `--csv-delimiter` option (a single character, or `\t`/`tab` for TAB)
+func parseDelimiter(in string) (rune, error) {
+	if in == `\t` || in == "tab" {
+		return '\t', nil
+	}
+	r, n := utf8.DecodeRuneInString(in)
+	if n != len(in) || r == utf8.RuneError || r == '"' || r == '\r' || r == '\n' {
+		return 0, fmt.Errorf("Invalid CSV delimiter '%s'", in)
+	}
+	return r, nil
+}
+
+// newCSVWriter creates the RFC 4180 CSV writer on STDOUT, and writes the header (unless disabled)
+func newCSVWriter(delimiter string, header bool, columns ...string) (*csv.Writer, error) {
+	comma, err := parseDelimiter(delimiter)
+	if err != nil {
+		return nil, err
+	}
+	cw := csv.NewWriter(os.Stdout)
+	cw.Comma = comma
+	if header {
+		if err = cw.Write(columns); err != nil {
+			return nil, err
+		}
+	}
+	return cw, nil
+}
+
+// jsonArrayWriter streams the records as a JSON array (one record per line), without collecting them in memory
+type jsonArrayWriter struct {
+	out io.Writer
+	n   int
+}
+
+func (w *jsonArrayWriter) write(rec interface{}) error {
+	buf, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	sep := ",\n"
+	if w.n == 0 {
+		sep = "[\n"
+	}
+	w.n++
+	_, err = w.out.Write(append([]byte(sep), buf...))
+	return err
+}
+
+// Close terminates the JSON array (does not close the underlying writer)
+func (w *jsonArrayWriter) Close() error {
+	end := "\n]\n"
+	if w.n == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(w.out, end)
+	return err
+}
+
+// humanSize formats the byte count using the binary units (e.g. 1.5K, 12M), same as `du -h`
+func humanSize(n int64) string {
+	const units = "KMGTPE"
+	if n < 1024 {
+		return strconv.FormatInt(n, 10)
+	}
+	f, i := float64(n)/1024, 0
+	for ; f >= 1024 && i < len(units)-1; i++ {
+		f /= 1024
+	}
+	if f < 10 {
+		return fmt.Sprintf("%.1f%c", f, units[i])
+	}
+	return fmt.Sprintf("%.0f%c", f, units[i])
+}
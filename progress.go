@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.etcd.io/etcd/clientv3"
+)
+
+// progressInterval is the minimal interval between the updates of the `--progress-file`
+const progressInterval = time.Second
+
+// progressRecord is the JSON content of the `--progress-file`
+type progressRecord struct {
+	Op        string    `json:"op"`
+	Processed int64     `json:"processed"`
+	Total     int64     `json:"total,omitempty"`
+	Bytes     int64     `json:"bytes"`
+	Done      bool      `json:"done"`
+	Updated   time.Time `json:"updated"`
+}
+
+// progressFile periodically writes the progress into a file, which can be polled by the controlling process
+//   - NOTE: all the methods are no-op on nil progressFile (i.e. when `--progress-file` was not given)
+type progressFile struct {
+	fname string
+	last  time.Time
+	rec   progressRecord
+}
+
+func newProgressFile(fname, op string) *progressFile {
+	if fname == "" {
+		return nil
+	}
+	return &progressFile{fname: fname, rec: progressRecord{Op: op}}
+}
+
+// setTotal sets the expected number of keys/files (0 if unknown)
+func (p *progressFile) setTotal(total int64) {
+	if p == nil {
+		return
+	}
+	p.rec.Total = total
+	p.write()
+}
+
+// add records the processed key/file, and updates the file if the progressInterval elapsed
+func (p *progressFile) add(bytes int) {
+	if p == nil {
+		return
+	}
+	p.rec.Processed++
+	p.rec.Bytes += int64(bytes)
+	if time.Since(p.last) >= progressInterval {
+		p.write()
+	}
+}
+
+// done writes the final progress
+func (p *progressFile) done() {
+	if p == nil {
+		return
+	}
+	p.rec.Done = true
+	p.write()
+}
+
+// write replaces the progress file atomically (via temporary file and rename), so the readers never see partial content
+func (p *progressFile) write() {
+	p.last = time.Now()
+	p.rec.Updated = p.last.UTC()
+	buf, err := json.Marshal(&p.rec)
+	if err == nil {
+		var f *os.File
+		if f, err = ioutil.TempFile(filepath.Dir(p.fname), filepath.Base(p.fname)+".*"); err == nil {
+			_, err = f.Write(append(buf, '\n'))
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err == nil {
+				err = os.Rename(f.Name(), p.fname)
+			}
+			if err != nil {
+				os.Remove(f.Name())
+			}
+		}
+	}
+	if err != nil {
+		logrus.WithError(err).Warnf("Could not write progress into %s", p.fname)
+	}
+}
+
+// countPrefixes counts the keys under the prefixes (count-only requests; returns 0 if the count fails)
+func countPrefixes(client *clientv3.Client, prefixes []string) int64 {
+	var total int64
+	for _, a := range prefixes {
+		logrus.Debugf("Doing GET(%s,count)...", a)
+		res, err := client.Get(ctx, a, clientv3.WithPrefix(), clientv3.WithCountOnly())
+		if err != nil {
+			logrus.WithError(err).Warnf("Could not count keys in %s", a)
+			return 0
+		}
+		total += res.Count
+	}
+	return total
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// backupFile is the backup found at the destination (see listBackups)
+type backupFile struct {
+	name   string
+	time   time.Time
+	files  []string // the archive, or the volumes of the split archive, and the <file>.errors
+	failed bool     // the backup has the <file>.errors (some keys failed, or the archive did not verify)
+}
+
+// targetRegexp converts the target with the %Y, %m, %d, %H, %M and %S fields (see expandTarget) into the regexp
+// matching the backups, and their volumes and .errors files
+func targetRegexp(target string) (*regexp.Regexp, []byte, error) {
+	var (
+		buf    strings.Builder
+		fields []byte
+	)
+	buf.WriteString("^(")
+	for i := 0; i < len(target); i++ {
+		if target[i] != '%' || i+1 >= len(target) {
+			buf.WriteString(regexp.QuoteMeta(target[i : i+1]))
+			continue
+		}
+		i++
+		switch f := target[i]; f {
+		case 'Y':
+			buf.WriteString(`(\d{4})`)
+			fields = append(fields, f)
+		case 'm', 'd', 'H', 'M', 'S':
+			buf.WriteString(`(\d{2})`)
+			fields = append(fields, f)
+		case '%':
+			buf.WriteString("%")
+		default:
+			return nil, nil, fmt.Errorf("Unsupported field %%%c in %s", f, target)
+		}
+	}
+	if len(fields) <= 0 {
+		return nil, nil, fmt.Errorf("Target %s has no time fields (e.g. backup-%%Y%%m%%d.tar.gz)", target)
+	}
+	buf.WriteString(`)(\.\d{3}|\.errors)?$`)
+	re, err := regexp.Compile(buf.String())
+	return re, fields, err
+}
+
+// listBackups returns the backups matching the target, the newest first (the time of the backup is parsed from the
+// name, so the backups stored remotely do not need the timestamps)
+//   - NOTE: the sftp:// backups are listed only in the directory of the first time field
+func listBackups(target string) ([]*backupFile, error) {
+	if !isRemoteURL(target) {
+		// the globbed file-names are cleaned (e.g. ./backup-%Y.tar matches backup-2020.tar)
+		target = filepath.Clean(target)
+	}
+	re, fields, err := targetRegexp(target)
+	if err != nil {
+		return nil, err
+	}
+	prefix := target[:strings.IndexByte(target, '%')]
+
+	var names []string
+	if isRemoteURL(target) {
+		be, bucket, key, err := parseRemoteURL(target)
+		if err != nil {
+			return nil, err
+		} else if _, ok := be.(*httpBackend); ok {
+			return nil, fmt.Errorf("Cannot list the backups at %s (the HTTP servers do not list the files)", target)
+		}
+		keyPrefix := key[:strings.IndexByte(key, '%')]
+		logrus.Debugf("Doing LIST(%s*)...", prefix)
+		keys, err := be.list(bucket, keyPrefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range keys {
+			names = append(names, strings.TrimSuffix(prefix, keyPrefix)+k)
+		}
+	} else {
+		glob := strings.NewReplacer("%Y", "*", "%m", "*", "%d", "*", "%H", "*", "%M", "*", "%S", "*",
+			"%%", "%").Replace(target)
+		if names, err = filepath.Glob(glob + "*"); err != nil {
+			return nil, err
+		}
+	}
+
+	byName := make(map[string]*backupFile)
+	var backups []*backupFile
+	for _, n := range names {
+		m := re.FindStringSubmatch(n)
+		if m == nil {
+			continue
+		}
+		b := byName[m[1]]
+		if b == nil {
+			// the missing fields default to the start of the period
+			tf := map[byte]int{'Y': 1970, 'm': 1, 'd': 1}
+			for i, f := range fields {
+				tf[f], _ = strconv.Atoi(m[i+2])
+			}
+			b = &backupFile{name: m[1], time: time.Date(tf['Y'], time.Month(tf['m']), tf['d'], tf['H'], tf['M'], tf['S'], 0,
+				time.UTC)}
+			byName[m[1]] = b
+			backups = append(backups, b)
+		}
+		b.files = append(b.files, n)
+		b.failed = b.failed || m[len(m)-1] == ".errors"
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		if backups[i].time.Equal(backups[j].time) {
+			return backups[i].name > backups[j].name
+		}
+		return backups[i].time.After(backups[j].time)
+	})
+	return backups, nil
+}
+
+// removeBackupFile deletes the file, or the remote object (see isRemoteURL)
+func removeBackupFile(fname string) error {
+	if !isRemoteURL(fname) {
+		return os.Remove(fname)
+	}
+	be, bucket, key, err := parseRemoteURL(fname)
+	if err != nil {
+		return err
+	}
+	logrus.Debugf("Doing DELETE(%s)...", fname)
+	return be.remove(bucket, key)
+}
+
+// pruneBackups deletes the backups older than keepDays, and beyond the keepCount newest ones
+//   - NOTE: the newest complete backup is never deleted
+//   - NOTE: the failed backups are not counted, and they are deleted once there is a newer complete backup
+func pruneBackups(target string, keepDays, keepCount int, dryRun bool) error {
+	if keepDays <= 0 && keepCount <= 0 {
+		return fmt.Errorf("Must specify the --keep-days or --keep-count")
+	}
+	backups, err := listBackups(target)
+	if err != nil {
+		return err
+	}
+	var (
+		cutoff  = time.Now().AddDate(0, 0, -keepDays)
+		kept    int // the complete backups kept so far
+		deleted int
+	)
+	for _, b := range backups {
+		if b.failed && kept == 0 {
+			logrus.Debugf("Keeping %s (failed, no newer complete backup)", b.name)
+			continue
+		} else if !b.failed && (kept == 0 || ((keepCount <= 0 || kept < keepCount) && (keepDays <= 0 || !b.time.Before(cutoff)))) {
+			logrus.Debugf("Keeping %s", b.name)
+			kept++
+			continue
+		}
+		deleted++
+		if dryRun {
+			fmt.Printf("%s\n", b.name)
+			continue
+		}
+		for _, f := range b.files {
+			if err = removeBackupFile(f); err != nil {
+				return fmt.Errorf("Deleted %d backups, then failed: %v", deleted-1, err)
+			}
+		}
+		logrus.Infof("Deleted %s", b.name)
+	}
+	if dryRun {
+		logrus.Infof("Would delete %d of %d backups.", deleted, len(backups))
+	} else {
+		logrus.Infof("Deleted %d of %d backups.", deleted, len(backups))
+	}
+	return nil
+}
+
+func actPrune(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("Must specify the backup target (e.g. s3://bucket/backup-%%Y%%m%%d.tar.gz)")
+	}
+	return pruneBackups(c.Args().First(), c.Int("keep-days"), c.Int("keep-count"), c.Bool("dry-run"))
+}
+
+// pruneFlags are the flags shared by the prune and backupd commands
+func pruneFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.IntFlag{
+			Name:  "keep-days",
+			Usage: "delete the backups older than given number of days",
+		},
+		&cli.IntFlag{
+			Name:  "keep-count",
+			Usage: "keep only given number of the newest backups",
+		},
+	}
+}
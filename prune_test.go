@@ -0,0 +1,36 @@
+package main
+
+import (
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestPruneBackups(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir,
+		"backup-20200105.tar", "", "backup-20200105.tar.errors", "", // failed, but no newer complete backup
+		"backup-20200104.tar", "",
+		"backup-20200103.tar", "",
+		"backup-20200102.tar.000", "", "backup-20200102.tar.001", "",
+		"backup-20200101.tar", "", "backup-20200101.tar.errors", "",
+		"other.tar", "")
+
+	// the failed backups are not counted, and the target is matched also when not clean
+	mustRunApp(t, "prune", "--keep-count", "2", dir+"/./backup-%Y%m%d.tar")
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range files {
+		got = append(got, f.Name())
+	}
+	sort.Strings(got)
+	exp := []string{"backup-20200103.tar", "backup-20200104.tar", "backup-20200105.tar", "backup-20200105.tar.errors", "other.tar"}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("Expected %v left, got %v", exp, got)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+// writeGPGKeys generates the GPG key, and writes the armored public key and the binary secret key into the files
+func writeGPGKeys(t *testing.T, pubFile, secFile string) {
+	t.Helper()
+	e, err := openpgp.NewEntity("Test", "", "test@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pub, sec bytes.Buffer
+	w, err := armor.Encode(&pub, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if err = e.Serialize(w); err != nil {
+		t.Fatal(err)
+	} else if err = w.Close(); err != nil {
+		t.Fatal(err)
+	} else if err = e.SerializePrivate(&sec, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(pubFile, pub.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	} else if err = ioutil.WriteFile(secFile, sec.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecipientRoundTrip(t *testing.T) {
+	var (
+		prefix = testPrefix(t)
+		dir    = t.TempDir()
+	)
+	defer func() { opt = testOpt }()
+	exp := putRandomKeys(t, prefix, 10, 20<<10)
+
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFiles(t, dir,
+		"age.key", "# test identity\n"+id.String()+"\n",
+		"age.pub", id.Recipient().String()+"\n",
+		"other.key", other.String()+"\n")
+	writeGPGKeys(t, filepath.Join(dir, "gpg.asc"), filepath.Join(dir, "gpg.sec"))
+	writeGPGKeys(t, filepath.Join(dir, "other.asc"), filepath.Join(dir, "other.sec"))
+
+	for _, tc := range []struct {
+		name      string
+		recipient string
+		identity  string
+		wrong     string // the identity of the other recipient
+	}{
+		{"age", id.Recipient().String(), "age.key", "other.key"},
+		{"age file", filepath.Join(dir, "age.pub"), "age.key", "other.key"},
+		{"gpg", filepath.Join(dir, "gpg.asc"), "gpg.sec", "other.sec"},
+	} {
+		for _, format := range []string{"tar.gz", "zip"} {
+			fname := filepath.Join(dir, strings.Replace(tc.name, " ", "-", -1)+"."+format)
+			mustRunApp(t, "dump", "--format", format, "--recipient", tc.recipient, "-f", fname, prefix)
+			if buf, err := ioutil.ReadFile(fname); err != nil {
+				t.Fatal(err)
+			} else if recipientFormat(buf[:len(ageMagic)]) != strings.Fields(tc.name)[0] {
+				t.Fatalf("%s: expected the %s archive encrypted to the recipient", tc.name, format)
+			}
+
+			opt = testOpt
+			resetArchiveKeys()
+			if _, err := readDecrypted(fname, format); err == nil || !strings.Contains(err.Error(), "--identity-file") {
+				t.Errorf("%s: expected the %s archive to require the identity, got %v", tc.name, format, err)
+			}
+
+			resetArchiveKeys()
+			opt.identityFile = filepath.Join(dir, tc.identity)
+			if got, err := readDecrypted(fname, format); err != nil {
+				t.Errorf("%s: could not read the %s archive: %v", tc.name, format, err)
+			} else if !sameEntries(got, exp) {
+				t.Errorf("%s: expected %d entries of the %s archive, got %d", tc.name, len(exp), format, len(got))
+			}
+
+			resetArchiveKeys()
+			opt.identityFile = filepath.Join(dir, tc.wrong)
+			if _, err := readDecrypted(fname, format); err == nil {
+				t.Errorf("%s: expected the other identity to fail the %s archive", tc.name, format)
+			}
+		}
+	}
+
+	// the identity of the other kind is rejected
+	resetArchiveKeys()
+	opt.identityFile = filepath.Join(dir, "gpg.sec")
+	if _, err := readDecrypted(filepath.Join(dir, "age.tar.gz"), "tar.gz"); err == nil || !strings.Contains(err.Error(), "has no age identities") {
+		t.Errorf("Expected the GPG identity to fail the age archive, got %v", err)
+	}
+	if _, err := runApp(t, "dump", "--recipient", id.Recipient().String(), "--recipient", filepath.Join(dir, "gpg.asc"),
+		"-f", filepath.Join(dir, "mixed.tar"), prefix); err == nil {
+		t.Error("Expected the mixed age and GPG recipients to fail")
+	}
+}
+
+func TestRecipientTampered(t *testing.T) {
+	var (
+		prefix = testPrefix(t)
+		dir    = t.TempDir()
+	)
+	defer func() { opt = testOpt }()
+	putRandomKeys(t, prefix, 10, 20<<10)
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFiles(t, dir, "age.key", id.String()+"\n")
+	writeGPGKeys(t, filepath.Join(dir, "gpg.asc"), filepath.Join(dir, "gpg.sec"))
+
+	for _, tc := range []struct {
+		recipient, identity string
+	}{
+		{id.Recipient().String(), "age.key"},
+		{filepath.Join(dir, "gpg.asc"), "gpg.sec"},
+	} {
+		fname := filepath.Join(dir, "backup.tar")
+		mustRunApp(t, "dump", "--format", "tar", "--recipient", tc.recipient, "-f", fname, prefix)
+		orig, err := ioutil.ReadFile(fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for name, data := range map[string][]byte{
+			"modified":  append(append(append([]byte{}, orig[:len(orig)/2]...), orig[len(orig)/2]^0x01), orig[len(orig)/2+1:]...),
+			"truncated": orig[:len(orig)-100],
+		} {
+			if err = ioutil.WriteFile(fname, data, 0666); err != nil {
+				t.Fatal(err)
+			}
+			resetArchiveKeys()
+			opt.identityFile = filepath.Join(dir, tc.identity)
+			if _, err = readDecrypted(fname, "tar"); err == nil {
+				t.Errorf("Expected the %s archive encrypted to %s to fail", name, tc.identity)
+			}
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// remoteChunkSize is the size of the ranged reads of the remote objects, and the part size of the uploads
+const remoteChunkSize = 8 << 20
+
+// remoteBackend stores the archives in the object storage (e.g. `-f s3://bucket/path/backup.tar.gz`)
+type remoteBackend interface {
+	// upload streams the content into the object (without staging it locally)
+	upload(bucket, key string, in io.Reader) error
+	// size returns the size of the object (or os.ErrNotExist, if the object does not exist)
+	size(bucket, key string) (int64, error)
+	// readRange reads n bytes of the object, starting at the offset
+	readRange(bucket, key string, off, n int64) ([]byte, error)
+	// list returns the keys of the objects, which start with the prefix (or nil, if the storage cannot list the objects)
+	list(bucket, prefix string) ([]string, error)
+	// remove deletes the object
+	remove(bucket, key string) error
+}
+
+// remoteBackends are indexed by the URL scheme (the backends are initialized on the first use)
+var remoteBackends = map[string]remoteBackend{
+	"s3://":     &s3Backend{},
+	"gs://":     &gcsBackend{},
+	"azblob://": &azblobBackend{},
+	"http://":   &httpBackend{scheme: "http://"},
+	"https://":  &httpBackend{scheme: "https://"},
+	"sftp://":   &sftpBackend{},
+}
+
+// remoteScheme returns the URL scheme of the remote archive, or "" for the local files
+func remoteScheme(fname string) string {
+	for scheme := range remoteBackends {
+		if strings.HasPrefix(fname, scheme) {
+			return scheme
+		}
+	}
+	return ""
+}
+
+func isRemoteURL(fname string) bool {
+	return remoteScheme(fname) != ""
+}
+
+// parseRemoteURL splits the scheme://bucket/key URL (the bucket is the container for the azblob://, and the host for
+// the http(s):// and sftp://)
+func parseRemoteURL(u string) (be remoteBackend, bucket, key string, err error) {
+	scheme := remoteScheme(u)
+	path := strings.TrimPrefix(u, scheme)
+	if i := strings.IndexByte(path, '/'); scheme != "" && i > 0 && i < len(path)-1 {
+		return remoteBackends[scheme], path[:i], path[i+1:], nil
+	}
+	expect := "bucket/key"
+	if _, ok := remoteBackends[scheme].(*httpBackend); ok || scheme == "sftp://" {
+		expect = "host/path"
+	}
+	return nil, "", "", fmt.Errorf("Invalid URL %s (expecting %s%s)", u, scheme, expect)
+}
+
+// remoteWriter streams the archive into the remote object
+type remoteWriter struct {
+	url  string
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func newRemoteWriter(u string) (*remoteWriter, error) {
+	be, bucket, key, err := parseRemoteURL(u)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		pr, pw = io.Pipe()
+		rw     = &remoteWriter{url: u, pw: pw, done: make(chan error, 1)}
+	)
+	logrus.Debugf("Doing UPLOAD(%s)...", u)
+	go func() {
+		err := be.upload(bucket, key, pr)
+		// unblocks the writes, if the upload failed
+		pr.CloseWithError(err)
+		rw.done <- err
+	}()
+	return rw, nil
+}
+
+func (rw *remoteWriter) Write(p []byte) (int, error) {
+	return rw.pw.Write(p)
+}
+
+// abort fails the upload, so the following Close does not complete the truncated object
+func (rw *remoteWriter) abort(err error) {
+	rw.pw.CloseWithError(err)
+}
+
+func (rw *remoteWriter) Close() error {
+	rw.pw.Close()
+	if err := <-rw.done; err != nil {
+		return fmt.Errorf("Could not upload %s: %v", rw.url, err)
+	}
+	logrus.Debugf("Uploaded %s", rw.url)
+	return nil
+}
+
+// remoteObject reads the remote object via the ranged reads of remoteChunkSize (the last chunk is cached, so both
+// the sequential reads of the TAR archives, and the random reads of the ZIP archives are served from the chunk)
+type remoteObject struct {
+	be          remoteBackend
+	url         string
+	bucket, key string
+	size        int64
+	buf         []byte
+	off         int64 // the offset of the cached chunk
+}
+
+// openRemoteObject opens the remote object (the missing objects return the os.IsNotExist error, same as the files)
+func openRemoteObject(u string) (*remoteObject, error) {
+	be, bucket, key, err := parseRemoteURL(u)
+	if err != nil {
+		return nil, err
+	}
+	logrus.Debugf("Doing STAT(%s)...", u)
+	size, err := be.size(bucket, key)
+	if err == os.ErrNotExist {
+		return nil, &os.PathError{Op: "open", Path: u, Err: err}
+	} else if err != nil {
+		return nil, err
+	}
+	return &remoteObject{be: be, url: u, bucket: bucket, key: key, size: size}, nil
+}
+
+func (o *remoteObject) ReadAt(p []byte, off int64) (int, error) {
+	total := 0
+	for len(p) > 0 {
+		if off >= o.size {
+			return total, io.EOF
+		} else if off < o.off || off >= o.off+int64(len(o.buf)) {
+			if err := o.fetch(off); err != nil {
+				return total, err
+			}
+		}
+		n := copy(p, o.buf[off-o.off:])
+		total += n
+		off += int64(n)
+		p = p[n:]
+	}
+	return total, nil
+}
+
+// fetch reads the chunk starting at the offset
+func (o *remoteObject) fetch(off int64) error {
+	n := int64(remoteChunkSize)
+	if off+n > o.size {
+		n = o.size - off
+	}
+	logrus.Debugf("Doing READ(%s,%d,%d)...", o.url, off, n)
+	buf, err := o.be.readRange(o.bucket, o.key, off, n)
+	if err != nil {
+		return err
+	} else if int64(len(buf)) != n {
+		return io.ErrUnexpectedEOF // the object was replaced while reading
+	}
+	o.buf, o.off = buf, off
+	return nil
+}
+
+func (o *remoteObject) Close() error {
+	o.buf = nil
+	return nil
+}
+
+// countRemoteVolumes returns the number of the volumes of the split archive stored remotely (see volumeName)
+func countRemoteVolumes(base string) (int, error) {
+	be, bucket, key, err := parseRemoteURL(base)
+	if err != nil {
+		return 0, err
+	}
+	logrus.Debugf("Doing LIST(%s.*)...", base)
+	keys, err := be.list(bucket, key+".")
+	cnt := 0
+	for _, k := range keys {
+		if ext := strings.TrimPrefix(k, key); len(ext) == len(firstVolumeExt) && strings.Trim(ext[1:], "0123456789") == "" {
+			cnt++
+		}
+	}
+	return cnt, err
+}
+
+// createOutput creates the output file, or the remote object (see isRemoteURL)
+func createOutput(fname string) (io.WriteCloser, error) {
+	if isRemoteURL(fname) {
+		rw, err := newRemoteWriter(fname)
+		if err != nil {
+			return nil, err
+		}
+		return rw, nil
+	}
+	f, err := os.Create(fname)
+	if err != nil {
+		return nil, err
+	}
+	return &localOutput{File: f}, nil
+}
+
+// outputAborter is the output, which can discard the partially written content (instead of completing it on Close)
+type outputAborter interface {
+	abort(err error)
+}
+
+// localOutput is the local output file, which is removed on Close if aborted
+type localOutput struct {
+	*os.File
+	aborted bool
+}
+
+func (f *localOutput) abort(err error) {
+	f.aborted = true
+}
+
+func (f *localOutput) Close() error {
+	err := f.File.Close()
+	if f.aborted {
+		logrus.Debugf("Removing incomplete %s", f.Name())
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// openInput opens the input file, or the remote object (see isRemoteURL), and returns its size
+func openInput(fname string) (volumeFile, int64, error) {
+	if isRemoteURL(fname) {
+		o, err := openRemoteObject(fname)
+		if err != nil {
+			return nil, 0, err
+		}
+		return o, o.size, nil
+	}
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, 0, err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, st.Size(), nil
+}
+
+// statInput checks the input file, or the remote object exists
+func statInput(fname string) error {
+	if isRemoteURL(fname) {
+		_, err := openRemoteObject(fname)
+		return err
+	}
+	_, err := os.Stat(fname)
+	return err
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"go.etcd.io/etcd/clientv3"
+)
+
+// replayer applies the changelog records (see changelog) on top of the restored base archive (`replay` command)
+//   - NOTE: the events of each revision are applied together (in a transaction, if not larger than maxTxnOps)
+type replayer struct {
+	client   *clientv3.Client
+	prefix   string
+	dryRun   bool
+	baseRev  int64 // the revision of the base archive (the older events are skipped)
+	untilRev int64 // the last revision to replay (or 0 to replay all)
+	rev      int64 // the revision of the pending ops
+	ops      []clientv3.Op
+	done     bool // the --until-rev was reached
+	events   int
+	skipped  int
+}
+
+// flush applies the pending ops of the current revision
+func (r *replayer) flush() error {
+	for i := 0; i < len(r.ops); i += maxTxnOps {
+		end := i + maxTxnOps
+		if end > len(r.ops) {
+			end = len(r.ops)
+		}
+		logrus.Debugf("Doing TXN(%d ops)...", end-i)
+		if _, err := r.client.Txn(ctx).Then(r.ops[i:end]...).Commit(); err != nil {
+			return fmt.Errorf("Could not replay revision %d: %v", r.rev, err)
+		}
+	}
+	r.ops = r.ops[:0]
+	return nil
+}
+
+// add queues the event of the record (flushing the events of the previous revision)
+func (r *replayer) add(rec *watchRecord) error {
+	rev := rec.Kv.ModRevision
+	if rev != r.rev {
+		if err := r.flush(); err != nil {
+			return err
+		}
+		r.rev = rev
+	}
+	kk := r.prefix + rec.Kv.Key
+	r.events++
+	if r.dryRun {
+		fmt.Printf("%s %s [rev %d]\n", rec.Type, kk, rev)
+		return nil
+	}
+	switch rec.Type {
+	case "PUT":
+		logrus.Debugf("Replaying PUT(%s,XX) of revision %d", kk, rev)
+		r.ops = append(r.ops, clientv3.OpPut(kk, string(rec.Kv.Value)))
+	case "DELETE":
+		logrus.Debugf("Replaying DELETE(%s) of revision %d", kk, rev)
+		r.ops = append(r.ops, clientv3.OpDelete(kk))
+	default:
+		return fmt.Errorf("Unsupported event %s of %s", rec.Type, rec.Kv.Key)
+	}
+	return nil
+}
+
+// replayFile applies the records of the (optionally compressed) changelog file
+func (r *replayer) replayFile(fname string) error {
+	f, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	in, _, err := decompressReader(f)
+	if err != nil {
+		return err
+	}
+
+	var (
+		br   = bufio.NewReader(in)
+		line int
+	)
+	for !r.done {
+		data, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			if len(bytes.TrimSpace(data)) > 0 {
+				logrus.Warnf("Ignoring incomplete record at the end of %s [%d bytes]", fname, len(data))
+			}
+			break
+		} else if err != nil {
+			return err
+		}
+		line++
+
+		var rec watchRecord
+		if err = json.Unmarshal(data, &rec); err != nil || rec.Kv == nil {
+			return fmt.Errorf("Invalid record at line %d of %s: %v", line, fname, err)
+		}
+		switch rev := rec.Kv.ModRevision; {
+		case rev <= r.baseRev:
+			// already contained in the base archive
+			r.skipped++
+		case r.untilRev > 0 && rev > r.untilRev:
+			r.done = true
+		case rev < r.rev:
+			return fmt.Errorf("Record at line %d of %s (revision %d) precedes revision %d (changelogs must be given in order)",
+				line, fname, rev, r.rev)
+		default:
+			if err = r.add(&rec); err != nil {
+				return err
+			}
+		}
+	}
+	return r.flush()
+}
+
+// actReplay restores the base archive, then replays the changelogs up to the `--until-rev` (point-in-time restore)
+//   - NOTE: the leases are not restored, and the keys not contained in the base archive are not deleted
+func actReplay(c *cli.Context) error {
+	args := c.Args().Slice()
+	if len(args) < 2 {
+		return fmt.Errorf("Must specify the base archive and the changelog files")
+	}
+
+	var (
+		base = args[0]
+		r    = &replayer{client: getEtcdClient(), prefix: c.String("prefix"), dryRun: c.Bool("dry-run")}
+		st   restoreStats
+		err  error
+	)
+	if s := c.String("until-rev"); s != "" {
+		if r.untilRev, err = parseRevision(s); err != nil {
+			return err
+		}
+	}
+
+	mf, err := readArchiveManifest(base)
+	if err != nil {
+		return fmt.Errorf("Could not read manifest of %s: %v", base, err)
+	} else if mf == nil || mf.Revision <= 0 {
+		return fmt.Errorf("Archive %s has no manifest (the revision of the base backup is unknown)", base)
+	} else if r.untilRev > 0 && r.untilRev < mf.Revision {
+		return fmt.Errorf("Archive %s was dumped at revision %d, after the --until-rev %d", base, mf.Revision, r.untilRev)
+	}
+	r.baseRev, r.rev = mf.Revision, mf.Revision
+	logManifest(mf, base)
+
+	if _, _, err = readArchive(base, restoreEntryFn(c, &st, mf)); err != nil {
+		return fmt.Errorf("Could not restore %s: %v", base, err)
+	}
+	logRestored(c, &st, base)
+
+	for _, fname := range args[1:] {
+		if r.done {
+			logrus.Debugf("Skipping %s (after revision %d)", fname, r.untilRev)
+			continue
+		}
+		logrus.Infof("Replaying %s...", fname)
+		if err = r.replayFile(fname); err != nil {
+			return fmt.Errorf("Replayed %d events up to revision %d, then failed: %v", r.events, r.rev, err)
+		}
+	}
+	if r.skipped > 0 {
+		logrus.Debugf("Skipped %d events up to revision %d (contained in %s)", r.skipped, r.baseRev, base)
+	}
+	rev := r.rev
+	if r.done {
+		rev = r.untilRev
+	} else if r.untilRev > 0 {
+		logrus.Warnf("Changelogs end at revision %d, before the --until-rev %d", r.rev, r.untilRev)
+	}
+	if r.dryRun {
+		logrus.Infof("Would replay %d events, up to revision %d.", r.events, rev)
+	} else {
+		logrus.Infof("Replayed %d events, the keys are restored at revision %d.", r.events, rev)
+	}
+	return nil
+}
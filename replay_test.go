@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeChangelog writes the changelog records into the file
+func writeChangelog(t *testing.T, fname string, recs ...watchRecord) {
+	t.Helper()
+	var buf strings.Builder
+	for i := range recs {
+		data, err := json.Marshal(&recs[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf.Write(append(data, '\n'))
+	}
+	if err := ioutil.WriteFile(fname, []byte(buf.String()), 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// putRecord returns the changelog record of the PUT event
+func putRecord(key, value string, rev int64) watchRecord {
+	return watchRecord{Type: "PUT", Kv: &kvRecord{Key: key, Value: []byte(value), ModRevision: rev}}
+}
+
+// deleteRecord returns the changelog record of the DELETE event
+func deleteRecord(key string, rev int64) watchRecord {
+	return watchRecord{Type: "DELETE", Kv: &kvRecord{Key: key, ModRevision: rev}}
+}
+
+func TestReplayer(t *testing.T) {
+	var (
+		prefix = testPrefix(t)
+		dir    = t.TempDir()
+		log1   = filepath.Join(dir, "changes.1.log")
+		log2   = filepath.Join(dir, "changes.2.log")
+	)
+	writeChangelog(t, log1,
+		putRecord("a", "base", 5),
+		putRecord("a", "1", 10), putRecord("b", "1", 10),
+		deleteRecord("b", 11), putRecord("c", "1", 11))
+	writeChangelog(t, log2,
+		putRecord("c", "2", 12),
+		putRecord("d", "1", 13))
+	// an incomplete last record is ignored
+	if err := ioutil.WriteFile(log2+".partial", []byte(`{"type":"PUT","kv":{"key":"e"`), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		untilRev int64
+		files    []string
+		exp      map[string]string
+		events   int
+	}{
+		{"all", 0, []string{log1, log2}, map[string]string{"a": "1", "c": "2", "d": "1"}, 6},
+		{"until revision", 12, []string{log1, log2}, map[string]string{"a": "1", "c": "2"}, 5},
+		{"until first file", 10, []string{log1, log2}, map[string]string{"a": "1", "b": "1"}, 2},
+	} {
+		var (
+			dst = prefix + strings.Replace(tc.name, " ", "-", -1) + "/"
+			r   = &replayer{client: testClient(t), prefix: dst, baseRev: 5, rev: 5, untilRev: tc.untilRev}
+		)
+		for _, fname := range tc.files {
+			if r.done {
+				break
+			}
+			if err := r.replayFile(fname); err != nil {
+				t.Fatalf("%s: %v", tc.name, err)
+			}
+		}
+		got := make(map[string]string)
+		for k, v := range getTestKeys(t, dst) {
+			got[strings.TrimPrefix(k, dst)] = v
+		}
+		if !reflect.DeepEqual(got, tc.exp) {
+			t.Errorf("%s: expected keys %v, got %v", tc.name, tc.exp, got)
+		}
+		if r.events != tc.events || r.skipped != 1 {
+			t.Errorf("%s: expected %d events replayed and 1 skipped, got %d and %d", tc.name, tc.events, r.events, r.skipped)
+		}
+		if done := tc.untilRev > 0; r.done != done {
+			t.Errorf("%s: expected done=%v, got %v", tc.name, done, r.done)
+		}
+	}
+
+	r := &replayer{client: testClient(t), prefix: prefix + "partial/", baseRev: 5, rev: 5}
+	if err := r.replayFile(log2 + ".partial"); err != nil || r.events != 0 {
+		t.Errorf("Expected the incomplete record ignored, got %d events (%v)", r.events, err)
+	}
+}
+
+func TestReplayerOutOfOrder(t *testing.T) {
+	var (
+		prefix = testPrefix(t)
+		dir    = t.TempDir()
+		log1   = filepath.Join(dir, "changes.1.log")
+		log2   = filepath.Join(dir, "changes.2.log")
+	)
+	writeChangelog(t, log1, putRecord("a", "1", 10), putRecord("a", "2", 8))
+	writeChangelog(t, log2, putRecord("b", "1", 12))
+
+	r := &replayer{client: testClient(t), prefix: prefix, baseRev: 5, rev: 5}
+	if err := r.replayFile(log1); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Expected the out-of-order record to fail, got %v", err)
+	}
+
+	// the changelogs given in the wrong order
+	r = &replayer{client: testClient(t), prefix: prefix, baseRev: 5, rev: 5}
+	if err := r.replayFile(log2); err != nil {
+		t.Fatal(err)
+	} else if err = r.replayFile(log1); err == nil {
+		t.Error("Expected the changelog preceding the replayed one to fail")
+	}
+	if got := getTestKeys(t, prefix); len(got) != 1 || got[prefix+"b"] != "1" {
+		t.Errorf("Expected only the first changelog replayed, got %v", got)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	var (
+		prefix = testPrefix(t)
+		dir    = t.TempDir()
+		base   = filepath.Join(dir, "full.tar")
+		log    = filepath.Join(dir, "changes.log")
+	)
+	putTestKeys(t, prefix+"src/a", "1", prefix+"src/b", "1")
+	mustRunApp(t, "dump", "--format", "tar", "-f", base, prefix+"src/")
+	mf, err := readArchiveManifest(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the events recorded before the dump are skipped
+	writeChangelog(t, log,
+		putRecord(prefix+"src/a", "old", mf.Revision),
+		putRecord(prefix+"src/a", "2", mf.Revision+1),
+		deleteRecord(prefix+"src/b", mf.Revision+2),
+		putRecord(prefix+"src/c", "3", mf.Revision+3))
+
+	dst := prefix + "dst"
+	mustRunApp(t, "replay", "--prefix", dst, "--until-rev", fmt.Sprint(mf.Revision+2), base, log)
+	if got := getTestKeys(t, dst); len(got) != 1 || got[dst+prefix+"src/a"] != "2" {
+		t.Errorf("Expected the keys at revision %d, got %v", mf.Revision+2, got)
+	}
+
+	// the base archive dumped after the --until-rev is rejected
+	if _, err = runApp(t, "replay", "--prefix", dst, "--until-rev", fmt.Sprint(mf.Revision-1), base, log); err == nil {
+		t.Error("Expected the replay before the base archive to fail")
+	}
+	if got := getTestKeys(t, dst); len(got) != 1 {
+		t.Errorf("Expected the keys unchanged by the failed replay, got %v", got)
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"go.etcd.io/etcd/clientv3"
+)
+
+// restoreStats counts the restored (and skipped) keys
+type restoreStats struct {
+	restored int
+	skipped  int
+}
+
+// leaseRestorer grants new leases in place of the leases recorded in the manifest (`--restore-leases` option)
+//   - the new leases are granted with the original TTLs, and are not kept alive
+type leaseRestorer struct {
+	client  *clientv3.Client
+	mf      *manifest
+	granted map[int64]clientv3.LeaseID
+}
+
+// newLeaseRestorer returns the leaseRestorer, or nil if the leases should not be restored
+func newLeaseRestorer(c *cli.Context, client *clientv3.Client, mf *manifest) *leaseRestorer {
+	if !c.Bool("restore-leases") || c.Bool("dry-run") {
+		return nil
+	} else if mf == nil || len(mf.Leases) <= 0 {
+		logrus.Warnf("No leases recorded in the manifest (nothing to restore)")
+		return nil
+	}
+	return &leaseRestorer{client: client, mf: mf, granted: make(map[int64]clientv3.LeaseID)}
+}
+
+// opts returns the put-options attaching the restored file-name to the new lease (grants the lease on first use)
+func (lr *leaseRestorer) opts(name string) ([]clientv3.OpOption, error) {
+	if lr == nil {
+		return nil, nil
+	}
+	mk := lr.mf.entry(name)
+	if mk == nil || mk.Lease == 0 {
+		return nil, nil
+	}
+	id, has := lr.granted[mk.Lease]
+	if !has {
+		ml := lr.mf.Leases[mk.Lease]
+		if ml == nil || ml.GrantedTTL <= 0 {
+			logrus.Warnf("Lease %x of %s expired before the dump (restoring without lease)", mk.Lease, mk.Key)
+			return nil, nil
+		}
+		var err error
+		if id, err = grantLease(lr.client, ml.GrantedTTL); err != nil {
+			return nil, fmt.Errorf("Could not grant lease for %s: %v", mk.Key, err)
+		}
+		logrus.Infof("Granted lease %x (TTL %ds) in place of lease %x", id, ml.GrantedTTL, mk.Lease)
+		lr.granted[mk.Lease] = id
+	}
+	return []clientv3.OpOption{clientv3.WithLease(id)}, nil
+}
+
+// restoreEntryFn returns the entryFunc, which puts the archived entries back as keys (`untar` and `unzip` commands)
+//   - the file-names are converted back into the keys via the manifest (or fileName2KvKey), and prefixed with the `--prefix`
+func restoreEntryFn(c *cli.Context, st *restoreStats, mf *manifest) entryFunc {
+	var (
+		client    = getEtcdClient()
+		optPrefix = c.String("prefix")
+		optDryRun = c.Bool("dry-run")
+		optSkip   = c.Bool("skip-existing")
+		limit     keyLimit
+	)
+	leases := newLeaseRestorer(c, client, mf)
+	return func(name string, data []byte) error {
+		if strings.HasSuffix(name, "/") {
+			// directory entries (keys ending with "/" are archived as `xxx⁄` files)
+			logrus.Debugf("Skipping directory %s", name)
+			return nil
+		} else if name == manifestName {
+			return nil
+		} else if err := limit.add(1); err != nil {
+			return err
+		}
+		kk := optPrefix + mf.key(name)
+		putOpts, err := leases.opts(name)
+		if err != nil {
+			return err
+		}
+		if optDryRun {
+			fmt.Printf("%s\n", kk)
+			st.restored++
+			return nil
+		} else if optSkip {
+			// put only if the key does not exist (atomically)
+			logrus.Debugf("Doing PUT(%s,XX,create-only)...", kk)
+			res, err := client.Txn(ctx).
+				If(clientv3.Compare(clientv3.CreateRevision(kk), "=", 0)).
+				Then(clientv3.OpPut(kk, string(data), putOpts...)).
+				Commit()
+			if err != nil {
+				return err
+			} else if !res.Succeeded {
+				logrus.Infof("Skipping %s (already exists)", kk)
+				st.skipped++
+				return nil
+			}
+		} else {
+			logrus.Debugf("Doing PUT(%s,XX)...", kk)
+			if _, err := client.Put(ctx, kk, string(data), putOpts...); err != nil {
+				return err
+			}
+		}
+		logrus.Infof("Put %s [%d]...", kk, len(data))
+		st.restored++
+		return nil
+	}
+}
+
+// logManifest logs the info from the manifest of the restored archive
+func logManifest(mf *manifest, fname string) {
+	if mf == nil {
+		logrus.Debugf("No manifest found in %s", fname)
+		return
+	}
+	logrus.Infof("Restoring %d keys dumped at revision %d of cluster %x (%s%s)", len(mf.Keys), mf.Revision, mf.ClusterID,
+		mf.Created.Format(time.RFC3339), mf.incremental())
+}
+
+// logRestored logs the final counts of the restore commands
+func logRestored(c *cli.Context, st *restoreStats, fname string) {
+	if c.Bool("dry-run") {
+		logrus.Infof("Would restore %d keys.", st.restored)
+	} else if st.skipped > 0 {
+		logrus.Infof("Restored %d keys from %s (%d existing keys skipped).", st.restored, fname, st.skipped)
+	} else {
+		logrus.Infof("Restored %d keys from %s.", st.restored, fname)
+	}
+}
+
+// entrySpool keeps the archived entries in a temporary file, until the manifest (the last entry) is read
+//   - NOTE: this way the archive is read only once (e.g. from the remote storage), and the entries are restored only
+//     after the whole archive was read successfully
+type entrySpool struct {
+	f       *os.File
+	entries []spoolEntry
+	off     int64
+}
+
+type spoolEntry struct {
+	name string
+	off  int64
+	size int
+}
+
+func newEntrySpool() (*entrySpool, error) {
+	f, err := ioutil.TempFile("", "etcdTool-spool")
+	if err != nil {
+		return nil, err
+	}
+	return &entrySpool{f: f}, nil
+}
+
+func (es *entrySpool) add(name string, data []byte) error {
+	if _, err := es.f.Write(data); err != nil {
+		return err
+	}
+	es.entries = append(es.entries, spoolEntry{name: name, off: es.off, size: len(data)})
+	es.off += int64(len(data))
+	return nil
+}
+
+// replay passes the spooled entries to fn (in the original order)
+func (es *entrySpool) replay(fn entryFunc) error {
+	for _, e := range es.entries {
+		data := make([]byte, e.size)
+		if _, err := es.f.ReadAt(data, e.off); err != nil {
+			return err
+		}
+		if err := fn(e.name, data); err != nil {
+			return fmt.Errorf("Entry %s: %v", e.name, err)
+		}
+	}
+	return nil
+}
+
+func (es *entrySpool) Close() error {
+	err := es.f.Close()
+	os.Remove(es.f.Name())
+	return err
+}
+
+func actUntar(c *cli.Context) error {
+	var (
+		optFile = c.String("f")
+		optComp = c.String("compression")
+		in      = io.ReadCloser(os.Stdin)
+		st      restoreStats
+		mf      *manifest
+		err     error
+	)
+
+	if c.Bool("z") {
+		if c.IsSet("compression") && optComp != "gzip" {
+			return fmt.Errorf("Options -z and --compression %s are mutually exclusive", optComp)
+		}
+		optComp = "gzip"
+	}
+	if optFile != "" && optFile != "-" {
+		if in, err = openArchive(optFile); err != nil {
+			return err
+		}
+	} else {
+		optFile = "STDIN"
+	}
+	defer in.Close()
+
+	spool, err := newEntrySpool()
+	if err != nil {
+		return err
+	}
+	defer spool.Close()
+	_, _, err = readTar(in, optComp, func(name string, data []byte) error {
+		if name == manifestName {
+			mf, err = parseManifest(data)
+			return err
+		}
+		return spool.add(name, data)
+	})
+	if err != nil {
+		return fmt.Errorf("Could not read %s: %v", optFile, err)
+	}
+
+	logManifest(mf, optFile)
+	if err = spool.replay(restoreEntryFn(c, &st, mf)); err != nil {
+		return fmt.Errorf("Could not restore %s: %v", optFile, err)
+	}
+	logRestored(c, &st, optFile)
+	return nil
+}
+
+func actUnzip(c *cli.Context) error {
+	var (
+		optFile = c.String("f")
+		st      restoreStats
+	)
+
+	if optFile == "" {
+		return fmt.Errorf("Must specify ZIP filename (-f file)")
+	}
+
+	mf, err := readArchiveManifest(optFile)
+	if err != nil {
+		return fmt.Errorf("Could not read manifest of %s: %v", optFile, err)
+	}
+	logManifest(mf, optFile)
+
+	if _, _, err := readZip(optFile, restoreEntryFn(c, &st, mf)); err != nil {
+		return fmt.Errorf("Could not restore %s: %v", optFile, err)
+	}
+	logRestored(c, &st, optFile)
+	return nil
+}
+
+// restoreFlags returns the flags common to the `untar` and `unzip` commands
+func restoreFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:  "prefix",
+			Usage: "prefix the restored keys",
+		},
+		&cli.BoolFlag{
+			Name:  "skip-existing",
+			Usage: "do not overwrite the existing keys",
+		},
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "only show which keys would be restored",
+		},
+		&cli.BoolFlag{
+			Name:  "restore-leases",
+			Usage: "attach the restored keys to new leases, granted with the TTLs recorded in the manifest",
+		},
+	}
+}
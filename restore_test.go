@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+func TestUntarCompression(t *testing.T) {
+	var (
+		prefix = testPrefix(t)
+		dir    = t.TempDir()
+		client = testClient(t)
+		exp    = map[string]string{prefix + "a": "1", prefix + "b/c": "2"}
+	)
+	putTestKeys(t, prefix+"a", "1", prefix+"b/c", "2")
+
+	for _, format := range []string{"tar", "tar.gz"} {
+		fname := filepath.Join(dir, "backup."+format)
+		// the stripped names are mapped back to the keys via the manifest
+		mustRunApp(t, "dump", "--format", format, "-f", fname, "--strip-level", "1", prefix)
+
+		for _, args := range [][]string{{"-f", fname}, {}} {
+			if _, err := client.Delete(ctx, prefix, clientv3.WithPrefix()); err != nil {
+				t.Fatal(err)
+			}
+			if len(args) == 0 {
+				// also the archive read from the STDIN is restored via its manifest
+				f, err := os.Open(fname)
+				if err != nil {
+					t.Fatal(err)
+				}
+				stdin := os.Stdin
+				os.Stdin = f
+				mustRunApp(t, "untar")
+				os.Stdin = stdin
+				f.Close()
+			} else {
+				mustRunApp(t, append([]string{"untar"}, args...)...)
+			}
+			if got := getTestKeys(t, prefix); !reflect.DeepEqual(got, exp) {
+				t.Errorf("%s %v: expected restored keys %v, got %v", format, args, exp, got)
+			}
+		}
+
+		// forcing the gzip decompression works only for the gzipped archive
+		if _, err := runApp(t, "untar", "-z", "-f", fname); (err == nil) != (format == "tar.gz") {
+			t.Errorf("%s: unexpected result of untar -z: %v", format, err)
+		}
+		if _, err := runApp(t, "untar", "--compression", "none", "-f", fname); (err == nil) != (format == "tar") {
+			t.Errorf("%s: unexpected result of untar --compression none: %v", format, err)
+		}
+	}
+}
+
+func TestUntarTruncated(t *testing.T) {
+	var (
+		prefix = testPrefix(t)
+		fname  = filepath.Join(t.TempDir(), "backup.tar")
+	)
+	putTestKeys(t, prefix+"a", "1", prefix+"b", "2")
+	mustRunApp(t, "dump", "--format", "tar", "-f", fname, prefix)
+	st, err := os.Stat(fname)
+	if err != nil {
+		t.Fatal(err)
+	} else if err = os.Truncate(fname, st.Size()-512); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := runApp(t, "untar", "-f", fname, "--prefix", "/untar-truncated"); err == nil {
+		t.Error("Expected the truncated archive to fail")
+	}
+	if got := getTestKeys(t, "/untar-truncated"); len(got) != 0 {
+		t.Errorf("Expected no keys restored from the truncated archive, got %v", got)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/sirupsen/logrus"
+)
+
+// s3Backend stores the archives in the S3 buckets (s3://bucket/key)
+type s3Backend struct {
+	sess    *session.Session
+	clients map[string]*s3.S3 // indexed by the bucket (the buckets may be in different regions)
+}
+
+// client returns the S3 client for the bucket
+//   - the credentials are configured same as for the aws(1) CLI (AWS_ACCESS_KEY_ID, AWS_PROFILE, ~/.aws/credentials,
+//     or the instance role)
+//   - NOTE: the region of the bucket is detected, unless configured (AWS_REGION), or using the `--s3-endpoint`
+func (b *s3Backend) client(bucket string) (*s3.S3, error) {
+	if cl, has := b.clients[bucket]; has {
+		return cl, nil
+	}
+	if b.sess == nil {
+		cfg := aws.Config{}
+		if opt.s3Endpoint != "" {
+			cfg.Endpoint, cfg.S3ForcePathStyle = aws.String(opt.s3Endpoint), aws.Bool(true)
+		}
+		sess, err := session.NewSessionWithOptions(session.Options{Config: cfg, SharedConfigState: session.SharedConfigEnable})
+		if err != nil {
+			return nil, err
+		}
+		b.sess, b.clients = sess, make(map[string]*s3.S3)
+	}
+	cfg := aws.NewConfig()
+	if aws.StringValue(b.sess.Config.Region) == "" {
+		region := "us-east-1"
+		if opt.s3Endpoint == "" {
+			logrus.Debugf("Doing S3 BUCKETREGION(%s)...", bucket)
+			r, err := s3manager.GetBucketRegion(ctx, b.sess, bucket, region)
+			if err != nil {
+				return nil, fmt.Errorf("Could not find region of S3 bucket %s: %v", bucket, err)
+			}
+			region = r
+		}
+		cfg.Region = aws.String(region)
+	}
+	b.clients[bucket] = s3.New(b.sess, cfg)
+	return b.clients[bucket], nil
+}
+
+// upload uses the multipart upload (in parts of remoteChunkSize)
+func (b *s3Backend) upload(bucket, key string, in io.Reader) error {
+	cl, err := b.client(bucket)
+	if err != nil {
+		return err
+	}
+	up := s3manager.NewUploaderWithClient(cl, func(up *s3manager.Uploader) { up.PartSize = remoteChunkSize })
+	_, err = up.UploadWithContext(ctx, &s3manager.UploadInput{Bucket: aws.String(bucket), Key: aws.String(key), Body: in})
+	return err
+}
+
+func (b *s3Backend) size(bucket, key string) (int64, error) {
+	cl, err := b.client(bucket)
+	if err != nil {
+		return 0, err
+	}
+	res, err := cl.HeadObjectWithContext(ctx, &s3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if aerr, ok := err.(awserr.RequestFailure); ok && aerr.StatusCode() == http.StatusNotFound {
+		return 0, os.ErrNotExist
+	} else if err != nil {
+		return 0, err
+	}
+	return aws.Int64Value(res.ContentLength), nil
+}
+
+func (b *s3Backend) readRange(bucket, key string, off, n int64) ([]byte, error) {
+	cl, err := b.client(bucket)
+	if err != nil {
+		return nil, err
+	}
+	res, err := cl.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key),
+		Range: aws.String(fmt.Sprintf("bytes=%d-%d", off, off+n-1))})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	return ioutil.ReadAll(res.Body)
+}
+
+func (b *s3Backend) remove(bucket, key string) error {
+	cl, err := b.client(bucket)
+	if err != nil {
+		return err
+	}
+	_, err = cl.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	return err
+}
+
+func (b *s3Backend) list(bucket, prefix string) ([]string, error) {
+	cl, err := b.client(bucket)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	err = cl.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{Bucket: aws.String(bucket), Prefix: aws.String(prefix)},
+		func(res *s3.ListObjectsV2Output, last bool) bool {
+			for _, obj := range res.Contents {
+				keys = append(keys, aws.StringValue(obj.Key))
+			}
+			return true
+		})
+	return keys, err
+}
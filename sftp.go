@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/sftp"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sftpBackend stores the archives on the SSH servers (sftp://[user@]host[:port]/path, or /~/path relative to the home
+// directory)
+type sftpBackend struct {
+	clients map[string]*sftp.Client // indexed by the [user@]host[:port]
+}
+
+// sftpAuth returns the SSH authentication methods -- the ssh-agent, the private keys (`--sftp-identity-file`, or the
+// default ~/.ssh/id_* keys), and the password (`--remote-user` and `--remote-password` options)
+//   - NOTE: the passphrase-protected keys are skipped (add them to the ssh-agent instead)
+func sftpAuth(login string) ([]ssh.AuthMethod, error) {
+	var auths []ssh.AuthMethod
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			auths = append(auths, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		} else {
+			logrus.WithError(err).Warnf("Could not connect to ssh-agent")
+		}
+	}
+	keyFiles := []string{opt.sftpIdentity}
+	if opt.sftpIdentity == "" {
+		home, _ := os.UserHomeDir()
+		keyFiles = []string{filepath.Join(home, ".ssh", "id_ed25519"), filepath.Join(home, ".ssh", "id_ecdsa"),
+			filepath.Join(home, ".ssh", "id_rsa")}
+	}
+	var signers []ssh.Signer
+	for _, fname := range keyFiles {
+		data, err := ioutil.ReadFile(fname)
+		if os.IsNotExist(err) && opt.sftpIdentity == "" {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKey(data)
+		if _, ok := err.(*ssh.PassphraseMissingError); ok {
+			logrus.Warnf("Skipping passphrase-protected key %s (use the ssh-agent)", fname)
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("Invalid private key %s: %v", fname, err)
+		}
+		signers = append(signers, signer)
+	}
+	if len(signers) > 0 {
+		auths = append(auths, ssh.PublicKeys(signers...))
+	}
+	if opt.remoteUser != "" && (opt.remotePassword != "" || strings.Contains(opt.remoteUser, ":") || len(auths) <= 0) {
+		_, pw, err := parseUser(opt.remoteUser, opt.remotePassword, "--remote-password")
+		if err != nil {
+			return nil, err
+		}
+		auths = append(auths, ssh.Password(pw))
+	}
+	if len(auths) <= 0 {
+		return nil, fmt.Errorf("No SSH credentials for %s (use the ssh-agent, --sftp-identity-file or --remote-user)", login)
+	}
+	return auths, nil
+}
+
+// sftpHostKeys verifies the host keys of the servers against the known_hosts (`--sftp-known-hosts` option)
+func sftpHostKeys() (ssh.HostKeyCallback, error) {
+	if opt.sftpInsecure {
+		logrus.Warnf("Skipping verification of the SFTP host keys")
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+	fname := opt.sftpKnownHosts
+	if fname == "" {
+		home, _ := os.UserHomeDir()
+		fname = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	cb, err := knownhosts.New(fname)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read known hosts: %v", err)
+	}
+	return cb, nil
+}
+
+// client connects to the server (the user defaults to the `--remote-user`, or the current user)
+func (b *sftpBackend) client(host string) (*sftp.Client, error) {
+	if cl, has := b.clients[host]; has {
+		return cl, nil
+	}
+	login, addr := "", host
+	if i := strings.LastIndexByte(host, '@'); i >= 0 {
+		login, addr = host[:i], host[i+1:]
+	} else if opt.remoteUser != "" {
+		login = strings.SplitN(opt.remoteUser, ":", 2)[0]
+	} else if u, err := user.Current(); err == nil {
+		login = u.Username
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "22")
+	}
+	auths, err := sftpAuth(login)
+	if err != nil {
+		return nil, err
+	}
+	hostKeys, err := sftpHostKeys()
+	if err != nil {
+		return nil, err
+	}
+	logrus.Debugf("Connecting to SFTP %s@%s...", login, addr)
+	conn, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{User: login, Auth: auths, HostKeyCallback: hostKeys,
+		Timeout: timeoutOr(opt.connectTimeout)})
+	if err != nil {
+		return nil, err
+	}
+	cl, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if b.clients == nil {
+		b.clients = make(map[string]*sftp.Client)
+	}
+	b.clients[host] = cl
+	return cl, nil
+}
+
+// sftpPath returns the path on the server (the ~/path is relative to the home directory)
+func sftpPath(key string) string {
+	if strings.HasPrefix(key, "~/") {
+		return key[2:]
+	}
+	return "/" + key
+}
+
+func (b *sftpBackend) upload(host, key string, in io.Reader) error {
+	cl, err := b.client(host)
+	if err != nil {
+		return err
+	}
+	p := sftpPath(key)
+	if err = cl.MkdirAll(path.Dir(p)); err != nil {
+		return err
+	}
+	f, err := cl.Create(p)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, in); err != nil {
+		// the failed (or aborted) upload does not leave the truncated file behind
+		f.Close()
+		cl.Remove(p)
+		return err
+	}
+	return f.Close()
+}
+
+func (b *sftpBackend) size(host, key string) (int64, error) {
+	cl, err := b.client(host)
+	if err != nil {
+		return 0, err
+	}
+	st, err := cl.Stat(sftpPath(key))
+	if os.IsNotExist(err) {
+		return 0, os.ErrNotExist
+	} else if err != nil {
+		return 0, err
+	}
+	return st.Size(), nil
+}
+
+func (b *sftpBackend) readRange(host, key string, off, n int64) ([]byte, error) {
+	cl, err := b.client(host)
+	if err != nil {
+		return nil, err
+	}
+	f, err := cl.Open(sftpPath(key))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	buf := make([]byte, n)
+	cnt, err := f.ReadAt(buf, off)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return buf[:cnt], nil
+}
+
+func (b *sftpBackend) list(host, prefix string) ([]string, error) {
+	cl, err := b.client(host)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		p    = sftpPath(prefix)
+		dir  = path.Dir(p)
+		keys []string
+	)
+	entries, err := cl.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if full := path.Join(dir, e.Name()); strings.HasPrefix(full, p) {
+			keys = append(keys, prefix+strings.TrimPrefix(full, p))
+		}
+	}
+	return keys, nil
+}
+
+func (b *sftpBackend) remove(host, key string) error {
+	cl, err := b.client(host)
+	if err != nil {
+		return err
+	}
+	return cl.Remove(sftpPath(key))
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/etcdserver/api/v3rpc/rpctypes"
+	pb "go.etcd.io/etcd/etcdserver/etcdserverpb"
+	"go.etcd.io/etcd/lease/leasepb"
+	"go.etcd.io/etcd/mvcc/mvccpb"
+)
+
+// boltMagic is the magic number of the bbolt database files (i.e. the etcd snapshots)
+const boltMagic = 0xED0CDAED
+
+// revBytesLen is the length of the revision keys in the snapshot ("key" bucket) -- main rev, '_', sub rev
+//   - NOTE: the tombstones (deleted keys) have an extra 't' suffix
+const revBytesLen = 8 + 1 + 8
+
+var (
+	snapshotKeyBucket   = []byte("key")
+	snapshotMetaBucket  = []byte("meta")
+	snapshotLeaseBucket = []byte("lease")
+	snapshotCompactKey  = []byte("finishedCompactRev")
+	errSnapshotReadOnly = errors.New("Cannot modify the snapshot (--db is read-only)")
+
+	// snapshotClient is the client of the `--db` snapshot (loaded on first use)
+	snapshotClient *clientv3.Client
+
+	// offlineCommands are the commands, which can read the keys from the `--db` snapshot
+	offlineCommands = map[string]bool{"help": true, "list": true, "stat": true, "get": true, "history": true,
+		"lease": true, "cp": true, "copy-key": true, "sync": true, "dump": true, "export": true, "tar": true,
+		"zip": true, "verify-archive": true, "diff": true}
+)
+
+// snapshotRecord is a revision of the key, as stored in the snapshot's "key" bucket
+type snapshotRecord struct {
+	rev       int64
+	tombstone bool
+	kv        *mvccpb.KeyValue
+}
+
+// snapshotKV serves the read-only KV operations from the etcd snapshot file (`--db` option)
+//   - NOTE: all the revisions kept in the snapshot are loaded into memory
+//   - NOTE: clientv3.Op does not expose the limit and sort options, so the gets return all the matching keys,
+//     sorted by the key (same as a single page of the paginated gets)
+type snapshotKV struct {
+	records    []snapshotRecord // sorted by the revision
+	rev        int64
+	compactRev int64
+	leases     map[int64]*leasepb.Lease
+	cachedRev  int64              // the revision of the cached state
+	cached     []*mvccpb.KeyValue // the live keys at the cachedRev (only the last state is cached)
+}
+
+// openSnapshot loads the keys and the leases of the etcd snapshot (e.g. saved by `etcdctl snapshot save`)
+func openSnapshot(fname string) (*snapshotKV, error) {
+	db, err := bolt.Open(fname, 0400, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	kv := &snapshotKV{
+		leases: make(map[int64]*leasepb.Lease),
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(snapshotKeyBucket)
+		if b == nil {
+			return fmt.Errorf("Not an etcd snapshot (no '%s' bucket)", snapshotKeyBucket)
+		}
+		err := b.ForEach(func(k, v []byte) error {
+			if len(k) < revBytesLen {
+				return fmt.Errorf("Invalid revision %x", k)
+			}
+			r := snapshotRecord{
+				rev:       int64(binary.BigEndian.Uint64(k)),
+				tombstone: len(k) > revBytesLen && k[revBytesLen] == 't',
+				kv:        new(mvccpb.KeyValue),
+			}
+			if err := r.kv.Unmarshal(v); err != nil {
+				return fmt.Errorf("Invalid key at revision %d: %v", r.rev, err)
+			}
+			kv.records, kv.rev = append(kv.records, r), r.rev
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if b = tx.Bucket(snapshotMetaBucket); b != nil {
+			if v := b.Get(snapshotCompactKey); len(v) >= 8 {
+				kv.compactRev = int64(binary.BigEndian.Uint64(v))
+			}
+		}
+		if b = tx.Bucket(snapshotLeaseBucket); b != nil {
+			return b.ForEach(func(_, v []byte) error {
+				le := new(leasepb.Lease)
+				if err := le.Unmarshal(v); err != nil {
+					return fmt.Errorf("Invalid lease: %v", err)
+				}
+				kv.leases[le.ID] = le
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return kv, nil
+}
+
+// isSnapshotFile checks if the file is the etcd snapshot (bbolt database)
+//   - NOTE: the magic number follows the 16-byte page header of the first meta page
+func isSnapshotFile(fname string) (bool, error) {
+	if isRemoteURL(fname) {
+		return false, nil // the snapshots are only read from the local files
+	}
+	f, err := os.Open(fname)
+	if os.IsNotExist(err) && volumeSetName(fname) != "" {
+		return false, nil // the split archive
+	} else if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	hdr := make([]byte, 20)
+	if _, err = io.ReadFull(f, hdr); err == io.ErrUnexpectedEOF || err == io.EOF {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return binary.LittleEndian.Uint32(hdr[16:]) == boltMagic, nil
+}
+
+// getSnapshotClient returns the client, which reads the keys from the `--db` snapshot (instead of the cluster)
+func getSnapshotClient() *clientv3.Client {
+	if snapshotClient == nil {
+		logrus.Debugf("Loading snapshot %s...", opt.db)
+		kv, err := openSnapshot(opt.db)
+		if err != nil {
+			logrus.WithError(err).Fatalf("Could not load snapshot %s", opt.db)
+		}
+		logrus.Debugf("Loaded %d revisions (revision %d, compacted at %d)", len(kv.records), kv.rev, kv.compactRev)
+		snapshotClient = kv.client()
+	}
+	return snapshotClient
+}
+
+// client returns the client, which serves the KV and lease operations from the snapshot
+func (kv *snapshotKV) client() *clientv3.Client {
+	return &clientv3.Client{KV: kv, Lease: &snapshotLease{kv: kv}}
+}
+
+func (kv *snapshotKV) header() *pb.ResponseHeader {
+	return &pb.ResponseHeader{Revision: kv.rev}
+}
+
+// state returns the live keys at the revision, sorted by the key
+//   - NOTE: only the last state is cached (the paged reads use the same revision), so the reads of many revisions do
+//     not keep a copy of the keyspace per revision
+func (kv *snapshotKV) state(rev int64) []*mvccpb.KeyValue {
+	if kv.cached != nil && kv.cachedRev == rev {
+		return kv.cached
+	}
+	m := make(map[string]*mvccpb.KeyValue)
+	for _, r := range kv.records {
+		if r.rev > rev {
+			break
+		} else if r.tombstone {
+			delete(m, string(r.kv.Key))
+		} else {
+			m[string(r.kv.Key)] = r.kv
+		}
+	}
+	s := make([]*mvccpb.KeyValue, 0, len(m))
+	for _, v := range m {
+		s = append(s, v)
+	}
+	sort.Slice(s, func(i, j int) bool { return bytes.Compare(s[i].Key, s[j].Key) < 0 })
+	kv.cachedRev, kv.cached = rev, s
+	return s
+}
+
+// inRange checks the revision against the min/max filter (0 for no limit)
+func inRange(rev, min, max int64) bool {
+	return (min <= 0 || rev >= min) && (max <= 0 || rev <= max)
+}
+
+func (kv *snapshotKV) get(op clientv3.Op) (*clientv3.GetResponse, error) {
+	rev := op.Rev()
+	switch {
+	case rev <= 0:
+		rev = kv.rev
+	case rev > kv.rev:
+		return nil, rpctypes.ErrFutureRev
+	case rev < kv.compactRev:
+		return nil, rpctypes.ErrCompacted
+	}
+
+	var (
+		res      = &clientv3.GetResponse{Header: kv.header()}
+		key, end = op.KeyBytes(), op.RangeBytes()
+		fromKey  = bytes.Equal(end, []byte{0})
+		s        = kv.state(rev)
+	)
+	for i := sort.Search(len(s), func(i int) bool { return bytes.Compare(s[i].Key, key) >= 0 }); i < len(s); i++ {
+		v := s[i]
+		if len(end) <= 0 && !bytes.Equal(v.Key, key) || len(end) > 0 && !fromKey && bytes.Compare(v.Key, end) >= 0 {
+			break
+		} else if !inRange(v.ModRevision, op.MinModRev(), op.MaxModRev()) ||
+			!inRange(v.CreateRevision, op.MinCreateRev(), op.MaxCreateRev()) {
+			continue
+		}
+		res.Count++
+		if op.IsCountOnly() {
+			continue
+		} else if op.IsKeysOnly() {
+			ko := *v
+			ko.Value = nil
+			v = &ko
+		}
+		res.Kvs = append(res.Kvs, v)
+	}
+	return res, nil
+}
+
+func (kv *snapshotKV) Put(ctx context.Context, key, val string, opts ...clientv3.OpOption) (*clientv3.PutResponse, error) {
+	return nil, errSnapshotReadOnly
+}
+
+func (kv *snapshotKV) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	return kv.get(clientv3.OpGet(key, opts...))
+}
+
+func (kv *snapshotKV) Delete(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.DeleteResponse, error) {
+	return nil, errSnapshotReadOnly
+}
+
+func (kv *snapshotKV) Compact(ctx context.Context, rev int64, opts ...clientv3.CompactOption) (*clientv3.CompactResponse, error) {
+	return nil, errSnapshotReadOnly
+}
+
+func (kv *snapshotKV) Do(ctx context.Context, op clientv3.Op) (clientv3.OpResponse, error) {
+	if !op.IsGet() {
+		return clientv3.OpResponse{}, errSnapshotReadOnly
+	}
+	res, err := kv.get(op)
+	if err != nil {
+		return clientv3.OpResponse{}, err
+	}
+	return res.OpResponse(), nil
+}
+
+func (kv *snapshotKV) Txn(ctx context.Context) clientv3.Txn {
+	return &snapshotTxn{kv: kv}
+}
+
+// snapshotTxn is a read-only transaction of the snapshot (the batched gets, without the comparisons)
+type snapshotTxn struct {
+	kv   *snapshotKV
+	cmps int
+	ops  []clientv3.Op
+}
+
+func (t *snapshotTxn) If(cs ...clientv3.Cmp) clientv3.Txn {
+	t.cmps += len(cs)
+	return t
+}
+
+func (t *snapshotTxn) Then(ops ...clientv3.Op) clientv3.Txn {
+	t.ops = append(t.ops, ops...)
+	return t
+}
+
+func (t *snapshotTxn) Else(ops ...clientv3.Op) clientv3.Txn {
+	return t
+}
+
+func (t *snapshotTxn) Commit() (*clientv3.TxnResponse, error) {
+	if t.cmps > 0 {
+		return nil, fmt.Errorf("Transaction comparisons are not supported with --db")
+	}
+	res := &clientv3.TxnResponse{Header: t.kv.header(), Succeeded: true}
+	for _, op := range t.ops {
+		if !op.IsGet() {
+			return nil, errSnapshotReadOnly
+		}
+		gr, err := t.kv.get(op)
+		if err != nil {
+			return nil, err
+		}
+		res.Responses = append(res.Responses, &pb.ResponseOp{
+			Response: &pb.ResponseOp_ResponseRange{ResponseRange: (*pb.RangeResponse)(gr)},
+		})
+	}
+	return res, nil
+}
+
+// snapshotLease serves the leases recorded in the snapshot
+//   - NOTE: the snapshot keeps the granted TTLs (the remaining TTLs only if the lease checkpoints are enabled)
+type snapshotLease struct {
+	kv *snapshotKV
+}
+
+func (l *snapshotLease) Grant(ctx context.Context, ttl int64) (*clientv3.LeaseGrantResponse, error) {
+	return nil, errSnapshotReadOnly
+}
+
+func (l *snapshotLease) Revoke(ctx context.Context, id clientv3.LeaseID) (*clientv3.LeaseRevokeResponse, error) {
+	return nil, errSnapshotReadOnly
+}
+
+func (l *snapshotLease) TimeToLive(ctx context.Context, id clientv3.LeaseID, opts ...clientv3.LeaseOption) (*clientv3.LeaseTimeToLiveResponse, error) {
+	res := &clientv3.LeaseTimeToLiveResponse{ResponseHeader: l.kv.header(), ID: id, TTL: -1}
+	le, has := l.kv.leases[int64(id)]
+	if !has {
+		return res, nil
+	}
+	res.TTL, res.GrantedTTL = le.RemainingTTL, le.TTL
+	if res.TTL <= 0 {
+		res.TTL = le.TTL
+	}
+	for _, v := range l.kv.state(l.kv.rev) {
+		if v.Lease == int64(id) {
+			res.Keys = append(res.Keys, v.Key)
+		}
+	}
+	return res, nil
+}
+
+func (l *snapshotLease) Leases(ctx context.Context) (*clientv3.LeaseLeasesResponse, error) {
+	res := &clientv3.LeaseLeasesResponse{ResponseHeader: l.kv.header()}
+	for id := range l.kv.leases {
+		res.Leases = append(res.Leases, clientv3.LeaseStatus{ID: clientv3.LeaseID(id)})
+	}
+	sort.Slice(res.Leases, func(i, j int) bool { return res.Leases[i].ID < res.Leases[j].ID })
+	return res, nil
+}
+
+func (l *snapshotLease) KeepAlive(ctx context.Context, id clientv3.LeaseID) (<-chan *clientv3.LeaseKeepAliveResponse, error) {
+	return nil, errSnapshotReadOnly
+}
+
+func (l *snapshotLease) KeepAliveOnce(ctx context.Context, id clientv3.LeaseID) (*clientv3.LeaseKeepAliveResponse, error) {
+	return nil, errSnapshotReadOnly
+}
+
+func (l *snapshotLease) Close() error {
+	return nil
+}
+
+func actSnapshotSave(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("Must specify the snapshot file")
+	}
+
+	var (
+		client = getEtcdClient()
+		fname  = c.Args().First()
+		part   = fname + ".part"
+	)
+
+	// same as the etcdctl, the snapshot is taken from a single member
+	if eps := client.Endpoints(); len(eps) != 1 {
+		return fmt.Errorf("Must specify a single endpoint (got %d)", len(eps))
+	}
+	st, err := endpointStatus(client, client.Endpoints()[0])
+	if err != nil {
+		return fmt.Errorf("Could not get status of %s: %v", client.Endpoints()[0], err)
+	}
+	logrus.Infof("Saving snapshot of member %x at revision %d [%d bytes]...", st.Header.MemberId, st.Header.Revision, st.DbSize)
+
+	// the snapshot is streamed into a temporary file, so an interrupted save does not leave a truncated snapshot
+	f, err := os.OpenFile(part, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	var n int64
+	logrus.Debugf("Doing SNAPSHOT()...")
+	rd, err := client.Snapshot(ctx)
+	if err == nil {
+		n, err = io.Copy(f, rd)
+		rd.Close()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(part, fname)
+	}
+	if err != nil {
+		os.Remove(part)
+		return fmt.Errorf("Could not save snapshot %s: %v", fname, err)
+	}
+	logrus.Infof("Saved snapshot %s [%d bytes]", fname, n)
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+func TestSnapshotRevisions(t *testing.T) {
+	var (
+		_, client = newTestEtcd(t)
+		fname     = filepath.Join(t.TempDir(), "snapshot.db")
+		revs      []int64
+	)
+	for _, v := range []string{"1", "2", "3"} {
+		res, err := client.Put(ctx, "/k", v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		revs = append(revs, res.Header.Revision)
+	}
+	if _, err := client.Delete(ctx, "/k"); err != nil {
+		t.Fatal(err)
+	}
+
+	rc, err := client.Snapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	f, err := os.Create(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = io.Copy(f, rc)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kv, err := openSnapshot(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the revisions are read out of order, so the cached state is replaced back and forth
+	for _, i := range []int{2, 0, 1, 0, 2} {
+		res, err := kv.Get(ctx, "/k", clientv3.WithRev(revs[i]))
+		if err != nil {
+			t.Fatal(err)
+		} else if len(res.Kvs) != 1 || string(res.Kvs[0].Value) != fmt.Sprint(i+1) {
+			t.Errorf("Expected value %d at revision %d, got %v", i+1, revs[i], res.Kvs)
+		}
+	}
+	if res, err := kv.Get(ctx, "/k"); err != nil {
+		t.Fatal(err)
+	} else if len(res.Kvs) != 0 {
+		t.Errorf("Expected the deleted key at the latest revision, got %v", res.Kvs)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/mvcc/mvccpb"
+)
+
+// keyInfo is the metadata of a single key
+type keyInfo struct {
+	Key            string `json:"key"`
+	Size           int    `json:"size"`
+	CreateRevision int64  `json:"create_revision"`
+	ModRevision    int64  `json:"mod_revision"`
+	Version        int64  `json:"version"`
+	Lease          int64  `json:"lease,omitempty"`
+}
+
+func newKeyInfo(kv *mvccpb.KeyValue) *keyInfo {
+	return &keyInfo{
+		Key:            string(kv.Key),
+		Size:           len(kv.Value),
+		CreateRevision: kv.CreateRevision,
+		ModRevision:    kv.ModRevision,
+		Version:        kv.Version,
+		Lease:          kv.Lease,
+	}
+}
+
+// subtreeStats are the aggregate statistics of the keys under a prefix (`stat --recursive` option)
+type subtreeStats struct {
+	Prefix            string `json:"prefix"`
+	Keys              int64  `json:"keys"`
+	TotalSize         int64  `json:"total_size"`
+	AvgSize           int64  `json:"avg_size"`
+	MinSize           int64  `json:"min_size"`
+	MaxSize           int64  `json:"max_size"`
+	OldestModRevision int64  `json:"oldest_mod_revision"`
+	NewestModRevision int64  `json:"newest_mod_revision"`
+	WithLease         int64  `json:"with_lease"`
+}
+
+func (st *subtreeStats) add(kv *mvccpb.KeyValue) {
+	sz := int64(len(kv.Value))
+	if st.Keys == 0 || sz < st.MinSize {
+		st.MinSize = sz
+	}
+	if sz > st.MaxSize {
+		st.MaxSize = sz
+	}
+	if st.Keys == 0 || kv.ModRevision < st.OldestModRevision {
+		st.OldestModRevision = kv.ModRevision
+	}
+	if kv.ModRevision > st.NewestModRevision {
+		st.NewestModRevision = kv.ModRevision
+	}
+	if kv.Lease != 0 {
+		st.WithLease++
+	}
+	st.Keys++
+	st.TotalSize += sz
+	st.AvgSize = st.TotalSize / st.Keys
+}
+
+func (st *subtreeStats) print() {
+	fmt.Printf("%s\n", st.Prefix)
+	fmt.Printf("   keys:                %d\n", st.Keys)
+	fmt.Printf("   total size:          %d\n", st.TotalSize)
+	fmt.Printf("   avg/min/max size:    %d / %d / %d\n", st.AvgSize, st.MinSize, st.MaxSize)
+	fmt.Printf("   oldest mod_revision: %d\n", st.OldestModRevision)
+	fmt.Printf("   newest mod_revision: %d\n", st.NewestModRevision)
+	fmt.Printf("   keys with leases:    %d\n", st.WithLease)
+}
+
+// statSubtree collects the statistics of the keys under the prefix, reading the keys in pages
+func statSubtree(client *clientv3.Client, prefix string) (*subtreeStats, error) {
+	st := &subtreeStats{Prefix: prefix}
+	err := rangePages(client, prefix, func(res *clientv3.GetResponse) error {
+		for _, v := range res.Kvs {
+			st.add(v)
+		}
+		return nil
+	})
+	return st, err
+}
+
+func actStat(c *cli.Context) error {
+	if c.NArg() <= 0 {
+		return fmt.Errorf("Must specify which keys to stat")
+	}
+
+	var (
+		client       = getEtcdClient()
+		optRecursive = c.Bool("recursive")
+		optJSON      = c.Bool("json")
+		enc          = json.NewEncoder(os.Stdout)
+		failed       = failedKeys{op: "stat"}
+	)
+
+	for _, a := range c.Args().Slice() {
+		if optRecursive {
+			logrus.Debugf("Doing STAT(%s,recursive)...", a)
+			st, err := statSubtree(client, a)
+			if err != nil && !opt.failFast {
+				failed.add(a, err)
+				continue
+			}
+			checkErr(err)
+			if optJSON {
+				checkErr(enc.Encode(st))
+			} else {
+				st.print()
+			}
+			continue
+		}
+
+		logrus.Debugf("Doing GET(%s)...", a)
+		res, err := client.Get(ctx, a)
+		if err != nil && !opt.failFast {
+			failed.add(a, err)
+			continue
+		}
+		checkErr(err)
+		if len(res.Kvs) <= 0 {
+			failed.add(a, fmt.Errorf("Key not found"))
+			continue
+		}
+		ki := newKeyInfo(res.Kvs[0])
+		if optJSON {
+			checkErr(enc.Encode(ki))
+			continue
+		}
+		fmt.Printf("%s\n", ki.Key)
+		fmt.Printf("   size:            %d\n", ki.Size)
+		fmt.Printf("   create_revision: %d\n", ki.CreateRevision)
+		fmt.Printf("   mod_revision:    %d\n", ki.ModRevision)
+		fmt.Printf("   version:         %d\n", ki.Version)
+		if ki.Lease != 0 {
+			fmt.Printf("   lease:           %x\n", ki.Lease)
+		}
+	}
+	return failed.result("")
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+func TestStatRecursive(t *testing.T) {
+	var (
+		prefix = testPrefix(t)
+		client = testClient(t)
+	)
+	first := putTestKeys(t, prefix+"a", "1", prefix+"b/c", "12345")
+	lres, err := client.Grant(ctx, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Revoke(ctx, lres.ID)
+	pres, err := client.Put(ctx, prefix+"b/d", "123", clientv3.WithLease(lres.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var st subtreeStats
+	if err = json.Unmarshal([]byte(mustRunApp(t, "stat", "--recursive", "--json", prefix)), &st); err != nil {
+		t.Fatal(err)
+	}
+	exp := subtreeStats{
+		Prefix:            prefix,
+		Keys:              3,
+		TotalSize:         9,
+		AvgSize:           3,
+		MinSize:           1,
+		MaxSize:           5,
+		OldestModRevision: first - 1,
+		NewestModRevision: pres.Header.Revision,
+		WithLease:         1,
+	}
+	if !reflect.DeepEqual(st, exp) {
+		t.Errorf("Expected %+v, got %+v", exp, st)
+	}
+}
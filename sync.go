@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"go.etcd.io/etcd/clientv3"
+)
+
+// syncStats counts the changes of the `sync` command
+type syncStats struct {
+	created int
+	updated int
+	deleted int
+}
+
+// syncOps returns the operations, which make the dst keys identical to the src keys (the keys relative to the prefix)
+func syncOps(src, dst keySet, dstPrefix string, del bool, st *syncStats) (ops []clientv3.Op, names []string) {
+	var keys []string
+	for k := range src {
+		keys = append(keys, k)
+	}
+	if del {
+		for k := range dst {
+			if _, has := src[k]; !has {
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		sv, inSrc := src[k]
+		dv, inDst := dst[k]
+		switch {
+		case !inSrc:
+			st.deleted++
+			ops = append(ops, clientv3.OpDelete(dstPrefix+k))
+			names = append(names, "delete: "+dstPrefix+k)
+		case !inDst:
+			st.created++
+			ops = append(ops, clientv3.OpPut(dstPrefix+k, string(sv)))
+			names = append(names, "create: "+dstPrefix+k)
+		case !bytes.Equal(sv, dv):
+			st.updated++
+			ops = append(ops, clientv3.OpPut(dstPrefix+k, string(sv)))
+			names = append(names, "update: "+dstPrefix+k)
+		}
+	}
+	return ops, names
+}
+
+func actSync(c *cli.Context) error {
+	if c.NArg() < 1 || c.NArg() > 2 {
+		return fmt.Errorf("Must specify <src-prefix> [dst-prefix]")
+	}
+
+	var (
+		client    = getEtcdClient()
+		target    = client
+		optSrc    = c.Args().Get(0)
+		optDst    = c.Args().Get(1)
+		optDryRun = c.Bool("dry-run")
+		limit     keyLimit
+		st        syncStats
+		applied   int
+		err       error
+	)
+
+	if c.String("target-endpoints") != "" {
+		if target, err = getTargetClient(c); err != nil {
+			return err
+		}
+		defer target.Close()
+		if optDst == "" {
+			optDst = optSrc
+		}
+	} else if optDst == "" {
+		return fmt.Errorf("Must specify <dst-prefix> (or sync into --target-endpoints)")
+	} else if optSrc == "" || strings.HasPrefix(optDst, optSrc) || strings.HasPrefix(optSrc, optDst) {
+		return fmt.Errorf("Prefixes '%s' and '%s' must not overlap", optSrc, optDst)
+	}
+
+	src, err := loadPrefix(client, optSrc, &limit)
+	if err != nil {
+		return fmt.Errorf("Could not read %s: %v", optSrc, err)
+	}
+	dst, err := loadPrefix(target, optDst, &limit)
+	if err != nil {
+		return fmt.Errorf("Could not read %s: %v", optDst, err)
+	}
+
+	ops, names := syncOps(src, dst, optDst, c.Bool("delete"), &st)
+	if optDryRun {
+		for _, n := range names {
+			fmt.Printf("%s\n", n)
+		}
+		logrus.Infof("Would create %d, update %d and delete %d keys.", st.created, st.updated, st.deleted)
+		return nil
+	}
+
+	for i := 0; i < len(ops); i += maxTxnOps {
+		end := i + maxTxnOps
+		if end > len(ops) {
+			end = len(ops)
+		}
+		logrus.Debugf("Doing TXN(%d ops)...", end-i)
+		if _, err = target.Txn(ctx).Then(ops[i:end]...).Commit(); err != nil {
+			return fmt.Errorf("Synced %d keys, then failed: %v", applied, err)
+		}
+		for _, n := range names[i:end] {
+			logrus.Debugf("Synced %s", n)
+		}
+		applied = end
+	}
+	logrus.Infof("Synced %s: %d created, %d updated, %d deleted (%d unchanged).", optDst, st.created, st.updated, st.deleted,
+		len(src)-st.created-st.updated)
+	return nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestSyncPlan(t *testing.T) {
+	prefix := testPrefix(t)
+	putTestKeys(t,
+		prefix+"src/new", "1", prefix+"src/changed", "2", prefix+"src/same", "3",
+		prefix+"dst/changed", "old", prefix+"dst/same", "3", prefix+"dst/extra", "4")
+	before := getTestKeys(t, prefix)
+
+	exp := []string{"create: " + prefix + "dst/new", "delete: " + prefix + "dst/extra", "update: " + prefix + "dst/changed"}
+	for _, cmd := range []string{"sync", "mirror"} {
+		out := mustRunApp(t, cmd, "--plan", "--delete", prefix+"src/", prefix+"dst/")
+		lines := strings.Split(strings.TrimSpace(out), "\n")
+		sort.Strings(lines)
+		if !reflect.DeepEqual(lines, exp) {
+			t.Errorf("%s: expected plan %v, got %v", cmd, exp, lines)
+		}
+		if got := getTestKeys(t, prefix); !reflect.DeepEqual(got, before) {
+			t.Errorf("%s: expected no changes with --plan, got %v", cmd, got)
+		}
+	}
+
+	mustRunApp(t, "sync", "--delete", prefix+"src/", prefix+"dst/")
+	if out := mustRunApp(t, "sync", "--plan", "--delete", prefix+"src/", prefix+"dst/"); out != "" {
+		t.Errorf("Expected an empty plan after the sync, got %q", out)
+	}
+}
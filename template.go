@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/sirupsen/logrus"
+	"go.etcd.io/etcd/mvcc/mvccpb"
+)
+
+var templateFuncs = template.FuncMap{
+	"base":       path.Base,
+	"dir":        path.Dir,
+	"trimPrefix": strings.TrimPrefix,
+	"trimSuffix": strings.TrimSuffix,
+	"replace":    strings.Replace,
+}
+
+// templateData is passed to the templates, when rendering the keys
+type templateData struct {
+	Key            string // full key (e.g. /config/apps/foo)
+	Name           string // last component of the key (e.g. foo)
+	Value          string
+	CreateRevision int64
+	ModRevision    int64
+	Version        int64
+}
+
+// keyTemplate renders each key into a file (`get/dump --output-template-file` option)
+type keyTemplate struct {
+	content *template.Template // renders the file content (or nil to write the value as-is)
+	fname   *template.Template // renders the file name
+}
+
+// newKeyTemplate parses the content template file (if specified) and the file-name template
+func newKeyTemplate(contentFile, fnameTmpl string) (*keyTemplate, error) {
+	var (
+		kt  = new(keyTemplate)
+		err error
+	)
+	if kt.fname, err = template.New("filename").Funcs(templateFuncs).Parse(fnameTmpl); err != nil {
+		return nil, err
+	}
+	if contentFile != "" {
+		if kt.content, err = template.New(filepath.Base(contentFile)).Funcs(templateFuncs).ParseFiles(contentFile); err != nil {
+			return nil, err
+		}
+	}
+	return kt, nil
+}
+
+// render renders the key (using the already decoded value) into the file, and returns the file name
+func (kt *keyTemplate) render(kv *mvccpb.KeyValue, value []byte) (string, error) {
+	var (
+		buf  bytes.Buffer
+		data = templateData{
+			Key:            string(kv.Key),
+			Name:           path.Base(string(kv.Key)),
+			Value:          string(value),
+			CreateRevision: kv.CreateRevision,
+			ModRevision:    kv.ModRevision,
+			Version:        kv.Version,
+		}
+	)
+
+	if err := kt.fname.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	fname := key2LocalPath(buf.String())
+
+	if kt.content != nil {
+		buf.Reset()
+		if err := kt.content.Execute(&buf, data); err != nil {
+			return "", err
+		}
+		value = buf.Bytes()
+	}
+
+	if err := os.MkdirAll(filepath.Dir(fname), 0777); err != nil {
+		return "", err
+	}
+	logrus.Debugf("Rendering %s into %s...", kv.Key, fname)
+	return fname, ioutil.WriteFile(fname, value, 0666)
+}
+
+// templateWriter renders the dumped keys into the files (`dump --output-template-file` option)
+type templateWriter struct {
+	tmpl *keyTemplate
+}
+
+func (tw *templateWriter) writeEntry(name string, kv *mvccpb.KeyValue, value []byte) error {
+	fname, err := tw.tmpl.render(kv, value)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("Wrote %s into %s...", kv.Key, fname)
+	return nil
+}
+
+// writeManifest is a no-op (the rendered files are not an archive)
+func (tw *templateWriter) writeManifest(m *manifest) error { return nil }
+
+func (tw *templateWriter) Close() error { return nil }
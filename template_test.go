@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestOutputTemplateFile(t *testing.T) {
+	var (
+		prefix = testPrefix(t)
+		dir    = t.TempDir()
+		tpl    = filepath.Join(dir, "app.conf.tmpl")
+	)
+	putTestKeys(t,
+		prefix+"apps/web", base64.StdEncoding.EncodeToString([]byte("port=80")),
+		prefix+"apps/db", base64.StdEncoding.EncodeToString([]byte("port=5432")))
+	if err := ioutil.WriteFile(tpl, []byte("# {{.Key}}\n{{.Value}}\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, cmd := range []string{"get", "dump"} {
+		out := filepath.Join(dir, cmd)
+		mustRunApp(t, cmd, "--d64", "--output-template-file", out+"/{{.Name}}.conf", "--template", tpl, prefix+"apps/")
+		for name, port := range map[string]string{"web": "80", "db": "5432"} {
+			buf, err := ioutil.ReadFile(filepath.Join(out, name+".conf"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if exp := "# " + prefix + "apps/" + name + "\nport=" + port + "\n"; string(buf) != exp {
+				t.Errorf("%s: expected %q, got %q", cmd, exp, buf)
+			}
+		}
+		if files, _ := filepath.Glob(filepath.Join(out, "*")); len(files) != 2 {
+			t.Errorf("%s: expected 2 files, got %v", cmd, files)
+		}
+	}
+
+	if _, err := runApp(t, "dump", "--format", "tar", "--output-template-file", dir+"/{{.Name}}", prefix); err == nil {
+		t.Errorf("Expected --output-template-file to fail with the tar format")
+	}
+}
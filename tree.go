@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"go.etcd.io/etcd/clientv3"
+)
+
+// treeNode is the key, or the subtree of the keys sharing the path (`tree` command)
+//   - NOTE: the subtree names keep the trailing '/', so the key `/a` and the subtree `/a/` are distinct nodes
+type treeNode struct {
+	children map[string]*treeNode
+	keys     int64 // the number of keys in the subtree (including the node itself)
+	size     int64 // the total size of the values in the subtree
+}
+
+// add adds the key (relative to the node) into the tree, up to maxDepth path components (or all, if 0)
+func (n *treeNode) add(rel string, size int64, maxDepth int) {
+	n.keys++
+	n.size += size
+	for depth := 1; rel != "" && (maxDepth <= 0 || depth <= maxDepth); depth++ {
+		seg := rel
+		if i := strings.IndexByte(rel, '/'); i >= 0 {
+			seg = rel[:i+1]
+		}
+		rel = rel[len(seg):]
+		child := n.children[seg]
+		if child == nil {
+			child = &treeNode{children: make(map[string]*treeNode)}
+			n.children[seg] = child
+		}
+		child.keys++
+		child.size += size
+		n = child
+	}
+}
+
+// isDir checks if the node is the subtree (also the empty one, if the name ends with '/')
+func (n *treeNode) isDir(name string) bool {
+	return strings.HasSuffix(name, "/") || len(n.children) > 0
+}
+
+// label returns the name of the node, with the key count and size of the subtree (or the size of the key)
+func (n *treeNode) label(name string, dir bool) string {
+	if !dir {
+		return fmt.Sprintf("%s [%d]", name, n.size)
+	}
+	return fmt.Sprintf("%s [%d keys, %d bytes]", name, n.keys, n.size)
+}
+
+// print prints the children of the node as the tree(1)-like branches, returning the number of the printed subtrees
+func (n *treeNode) print(indent string, dirsOnly bool) (dirs int) {
+	names := make([]string, 0, len(n.children))
+	for name, child := range n.children {
+		if dirsOnly && !child.isDir(name) {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		child := n.children[name]
+		branch, next := "├── ", "│   "
+		if i == len(names)-1 {
+			branch, next = "└── ", "    "
+		}
+		fmt.Printf("%s%s%s\n", indent, branch, child.label(name, child.isDir(name)))
+		if child.isDir(name) {
+			dirs++
+		}
+		dirs += child.print(indent+next, dirsOnly)
+	}
+	return dirs
+}
+
+func actTree(c *cli.Context) error {
+	var (
+		client   = getEtcdClient()
+		args     = c.Args().Slice()
+		optDepth = c.Int("level")
+		limit    keyLimit
+	)
+	if len(args) <= 0 {
+		// the whole keyspace
+		args = []string{""}
+	}
+
+	for _, a := range args {
+		root := &treeNode{children: make(map[string]*treeNode)}
+		err := rangePages(client, a, func(res *clientv3.GetResponse) error {
+			if err := limit.add(int64(len(res.Kvs))); err != nil {
+				return err
+			}
+			for _, v := range res.Kvs {
+				root.add(string(v.Key)[len(a):], int64(len(v.Value)), optDepth)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		name := a
+		if name == "" {
+			name = "."
+		}
+		fmt.Printf("%s\n", root.label(name, true))
+		dirs := root.print("", c.Bool("dirs-only"))
+		logrus.Infof("Found %d keys in %d subtrees of %s.", root.keys, dirs, name)
+	}
+	return nil
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"go.etcd.io/etcd/clientv3"
+)
+
+// txnCompare is a comparison of the transaction (e.g. `mod("/a") > "5"`)
+type txnCompare struct {
+	Key    string `json:"key"`
+	Target string `json:"target"` // value, version, create, mod or lease
+	Op     string `json:"op"`     // =, !=, < or >
+	Value  string `json:"value"`
+}
+
+// txnOp is an operation of the transaction (e.g. `put /a foo`)
+type txnOp struct {
+	Op     string `json:"op"` // put, del or get
+	Key    string `json:"key"`
+	Value  string `json:"value,omitempty"`
+	Prefix bool   `json:"prefix,omitempty"`
+}
+
+// txnRequest is the transaction read by the `txn` command
+type txnRequest struct {
+	Compare []txnCompare `json:"compare"`
+	Success []txnOp      `json:"success"`
+	Failure []txnOp      `json:"failure"`
+}
+
+// txnOpResult is the result of the transaction's operation (`txn --output json` option)
+type txnOpResult struct {
+	Op      string      `json:"op"`
+	Deleted int64       `json:"deleted,omitempty"`
+	Kvs     []*kvRecord `json:"kvs,omitempty"`
+}
+
+// txnResult is the result of the transaction (`txn --output json` option)
+type txnResult struct {
+	Succeeded  bool           `json:"succeeded"`
+	Revision   int64          `json:"revision"`
+	Responses  []*txnOpResult `json:"responses"`
+	operations []txnOp
+}
+
+var (
+	txnCompareRegex = regexp.MustCompile(`^(\w+)\(("(?:[^"\\]|\\.)*")\)\s*(=|!=|<|>)\s*("(?:[^"\\]|\\.)*")$`)
+	txnTokenRegex   = regexp.MustCompile(`"(?:[^"\\]|\\.)*"|\S+`)
+	txnTargets      = map[string]string{"value": "value", "version": "version", "ver": "version",
+		"create": "create", "c": "create", "mod": "mod", "m": "mod", "lease": "lease"}
+)
+
+// parseTxnCompare parses the comparison line (same syntax as the `etcdctl txn`, e.g. `value("/a") = "foo"`)
+func parseTxnCompare(line string) (txnCompare, error) {
+	m := txnCompareRegex.FindStringSubmatch(line)
+	if m == nil {
+		return txnCompare{}, fmt.Errorf("Invalid comparison '%s' (expecting e.g. mod(\"key\") > \"5\")", line)
+	}
+	k, _ := strconv.Unquote(m[2])
+	v, _ := strconv.Unquote(m[4])
+	return txnCompare{Key: k, Target: m[1], Op: m[3], Value: v}, nil
+}
+
+// parseTxnOp parses the operation line (`put <key> <value>`, `del [--prefix] <key>` or `get [--prefix] <key>`)
+//   - NOTE: the keys and the values can be double-quoted (Go syntax, e.g. "multi\nline")
+func parseTxnOp(line string) (txnOp, error) {
+	var (
+		op   txnOp
+		args []string
+	)
+	for _, t := range txnTokenRegex.FindAllString(line, -1) {
+		if t == "--prefix" {
+			op.Prefix = true
+			continue
+		} else if strings.HasPrefix(t, `"`) {
+			t, _ = strconv.Unquote(t)
+		}
+		args = append(args, t)
+	}
+	if len(args) > 0 {
+		op.Op = args[0]
+	}
+	switch {
+	case op.Op == "put" && len(args) == 3 && !op.Prefix:
+		op.Key, op.Value = args[1], args[2]
+	case (op.Op == "del" || op.Op == "get") && len(args) == 2:
+		op.Key = args[1]
+	default:
+		return op, fmt.Errorf("Invalid operation '%s' (expecting put <key> <value>, del [--prefix] <key> or get [--prefix] <key>)", line)
+	}
+	return op, nil
+}
+
+// parseTxnText parses the `etcdctl txn`-style input -- comparisons, success operations and failure operations,
+// separated by empty lines
+//   - NOTE: a run of empty lines is a single separator
+func parseTxnText(in io.Reader) (*txnRequest, error) {
+	var (
+		req     = new(txnRequest)
+		section int
+		blank   bool
+		sc      = bufio.NewScanner(in)
+	)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			if !blank {
+				section++
+			}
+			blank = true
+			continue
+		} else if strings.HasPrefix(line, "#") {
+			continue
+		}
+		blank = false
+		var err error
+		switch section {
+		case 0:
+			var tc txnCompare
+			if tc, err = parseTxnCompare(line); err == nil {
+				req.Compare = append(req.Compare, tc)
+			}
+		case 1, 2:
+			var op txnOp
+			if op, err = parseTxnOp(line); err == nil && section == 1 {
+				req.Success = append(req.Success, op)
+			} else if err == nil {
+				req.Failure = append(req.Failure, op)
+			}
+		default:
+			err = fmt.Errorf("Unexpected '%s' (only 3 sections expected)", line)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return req, sc.Err()
+}
+
+// readTxnRequest reads the transaction as JSON (if it starts with '{'), or as the `etcdctl txn`-style text
+func readTxnRequest(in io.Reader) (*txnRequest, error) {
+	buf, err := ioutil.ReadAll(in)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(buf), []byte("{")) {
+		req := new(txnRequest)
+		if err = json.Unmarshal(buf, req); err != nil {
+			return nil, err
+		}
+		return req, nil
+	}
+	return parseTxnText(bytes.NewReader(buf))
+}
+
+func (tc *txnCompare) cmp() (clientv3.Cmp, error) {
+	switch tc.Op {
+	case "=", "!=", "<", ">":
+	default:
+		return clientv3.Cmp{}, fmt.Errorf("Invalid comparison operator '%s' of %s", tc.Op, tc.Key)
+	}
+	target, has := txnTargets[tc.Target]
+	if !has {
+		return clientv3.Cmp{}, fmt.Errorf("Invalid comparison target '%s' of %s (expecting value, version, create, mod or lease)",
+			tc.Target, tc.Key)
+	} else if target == "value" {
+		return clientv3.Compare(clientv3.Value(tc.Key), tc.Op, tc.Value), nil
+	}
+
+	base := 10
+	if target == "lease" {
+		base = 16 // same as the lease IDs printed by the `lease` commands
+	}
+	n, err := strconv.ParseInt(tc.Value, base, 64)
+	if err != nil {
+		return clientv3.Cmp{}, fmt.Errorf("Invalid %s '%s' of %s", target, tc.Value, tc.Key)
+	}
+	switch target {
+	case "version":
+		return clientv3.Compare(clientv3.Version(tc.Key), tc.Op, n), nil
+	case "create":
+		return clientv3.Compare(clientv3.CreateRevision(tc.Key), tc.Op, n), nil
+	case "mod":
+		return clientv3.Compare(clientv3.ModRevision(tc.Key), tc.Op, n), nil
+	}
+	return clientv3.Compare(clientv3.LeaseValue(tc.Key), tc.Op, clientv3.LeaseID(n)), nil
+}
+
+func (op *txnOp) op() (clientv3.Op, error) {
+	var opts []clientv3.OpOption
+	if op.Prefix {
+		opts = append(opts, clientv3.WithPrefix())
+	}
+	switch op.Op {
+	case "put":
+		if op.Prefix {
+			return clientv3.Op{}, fmt.Errorf("Cannot put %s with --prefix", op.Key)
+		}
+		return clientv3.OpPut(op.Key, op.Value), nil
+	case "del":
+		return clientv3.OpDelete(op.Key, opts...), nil
+	case "get":
+		return clientv3.OpGet(op.Key, opts...), nil
+	}
+	return clientv3.Op{}, fmt.Errorf("Invalid operation '%s' of %s (expecting put, del or get)", op.Op, op.Key)
+}
+
+func txnOps(ops []txnOp) ([]clientv3.Op, error) {
+	ret := make([]clientv3.Op, 0, len(ops))
+	for _, o := range ops {
+		op, err := o.op()
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, op)
+	}
+	return ret, nil
+}
+
+func newTxnResult(res *clientv3.TxnResponse, ops []txnOp) *txnResult {
+	tr := &txnResult{Succeeded: res.Succeeded, Revision: res.Header.Revision, operations: ops}
+	for i, r := range res.Responses {
+		or := &txnOpResult{Op: ops[i].Op}
+		if rr := r.GetResponseDeleteRange(); rr != nil {
+			or.Deleted = rr.Deleted
+		} else if rr := r.GetResponseRange(); rr != nil {
+			for _, v := range rr.Kvs {
+				or.Kvs = append(or.Kvs, newKvRecord(v))
+			}
+		}
+		tr.Responses = append(tr.Responses, or)
+	}
+	return tr
+}
+
+func (tr *txnResult) print() {
+	if tr.Succeeded {
+		fmt.Printf("SUCCESS\n")
+	} else {
+		fmt.Printf("FAILURE\n")
+	}
+	for i, or := range tr.Responses {
+		switch or.Op {
+		case "put":
+			fmt.Printf("\nPUT %s\n", tr.operations[i].Key)
+		case "del":
+			fmt.Printf("\nDEL %s [%d deleted]\n", tr.operations[i].Key, or.Deleted)
+		case "get":
+			fmt.Printf("\nGET %s\n", tr.operations[i].Key)
+			for _, v := range or.Kvs {
+				fmt.Printf("%s\n%s\n", v.Key, v.Value)
+			}
+		}
+	}
+}
+
+func actTxn(c *cli.Context) error {
+	var (
+		client    = getEtcdClient()
+		optFile   = c.Args().First()
+		optOutput = c.String("output")
+		in        = io.ReadCloser(os.Stdin)
+		cmps      []clientv3.Cmp
+	)
+
+	if optOutput != "plain" && optOutput != "json" {
+		return fmt.Errorf("Invalid output format '%s' (expecting plain or json)", optOutput)
+	} else if c.NArg() > 1 {
+		return fmt.Errorf("Must specify a single <file|->")
+	} else if optFile != "" && optFile != "-" {
+		f, err := os.Open(optFile)
+		if err != nil {
+			return err
+		}
+		in = f
+	}
+	req, err := readTxnRequest(in)
+	in.Close()
+	if err != nil {
+		return fmt.Errorf("Could not read transaction: %v", err)
+	}
+
+	for _, tc := range req.Compare {
+		cmp, err := tc.cmp()
+		if err != nil {
+			return err
+		}
+		cmps = append(cmps, cmp)
+	}
+	thenOps, err := txnOps(req.Success)
+	if err != nil {
+		return err
+	}
+	elseOps, err := txnOps(req.Failure)
+	if err != nil {
+		return err
+	}
+
+	logrus.Debugf("Doing TXN(%#v)...", req)
+	res, err := client.Txn(ctx).If(cmps...).Then(thenOps...).Else(elseOps...).Commit()
+	if err != nil {
+		return err
+	}
+
+	ops := req.Success
+	if !res.Succeeded {
+		ops = req.Failure
+	}
+	tr := newTxnResult(res, ops)
+	if optOutput == "json" {
+		checkErr(json.NewEncoder(os.Stdout).Encode(tr))
+	} else {
+		tr.print()
+	}
+	if !res.Succeeded && c.Bool("require-success") {
+		return fmt.Errorf("Transaction comparisons failed")
+	}
+	return nil
+}
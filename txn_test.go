@@ -0,0 +1,35 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTxnText(t *testing.T) {
+	exp := &txnRequest{
+		Compare: []txnCompare{{Key: "/a", Target: "value", Op: "=", Value: "1"}},
+		Success: []txnOp{{Op: "put", Key: "/a", Value: "2"}},
+		Failure: []txnOp{{Op: "get", Key: "/a"}},
+	}
+	for _, in := range []string{
+		"value(\"/a\") = \"1\"\n\nput /a 2\n\nget /a\n",
+		// a run of empty lines (and comments) is a single separator
+		"value(\"/a\") = \"1\"\n\n\n\nput /a 2\n\n# failure\n  \n\nget /a\n\n\n",
+	} {
+		req, err := parseTxnText(strings.NewReader(in))
+		if err != nil {
+			t.Errorf("Failed to parse %q: %v", in, err)
+		} else if !reflect.DeepEqual(req, exp) {
+			t.Errorf("Expected %+v from %q, got %+v", exp, in, req)
+		}
+	}
+
+	// no comparisons
+	req, err := parseTxnText(strings.NewReader("\n\nput /a 2\n"))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(req.Compare) != 0 || len(req.Success) != 1 || len(req.Failure) != 0 {
+		t.Errorf("Expected only the success operation, got %+v", req)
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// firstVolumeExt is the extension of the first volume of the split archive
+const firstVolumeExt = ".000"
+
+// volumeName returns the file-name of the n-th volume of the split archive (e.g. backup.tar.000)
+func volumeName(fname string, n int) string {
+	return fmt.Sprintf("%s.%03d", fname, n)
+}
+
+// volumeWriter splits the archive into fixed-size volumes (`--split-size` option)
+//   - NOTE: the volumes are plain byte-ranges of the archive, so they can also be joined via `cat file.tar.* > file.tar`
+type volumeWriter struct {
+	fname   string
+	maxSize int64
+	vol     int
+	size    int64
+	out     io.WriteCloser
+	aborted bool
+}
+
+func (vw *volumeWriter) Write(p []byte) (int, error) {
+	if vw.aborted {
+		return 0, io.ErrClosedPipe
+	}
+	total := 0
+	for len(p) > 0 {
+		if vw.out == nil || vw.size >= vw.maxSize {
+			if err := vw.next(); err != nil {
+				return total, err
+			}
+		}
+		chunk := p
+		if rem := vw.maxSize - vw.size; int64(len(chunk)) > rem {
+			chunk = chunk[:rem]
+		}
+		n, err := vw.out.Write(chunk)
+		total += n
+		vw.size += int64(n)
+		if err != nil {
+			return total, err
+		}
+		p = p[n:]
+	}
+	return total, nil
+}
+
+// next closes the current volume, and creates the next one
+func (vw *volumeWriter) next() error {
+	if vw.out != nil {
+		if err := vw.out.Close(); err != nil {
+			return err
+		}
+		vw.vol++
+	}
+	fname := volumeName(vw.fname, vw.vol)
+	f, err := createOutput(fname)
+	if err != nil {
+		return err
+	}
+	logrus.Debugf("Writing %s...", fname)
+	vw.out, vw.size = f, 0
+	return nil
+}
+
+// abort discards the current volume, and the already written volumes are removed on Close
+func (vw *volumeWriter) abort(err error) {
+	vw.aborted = true
+	if a, ok := vw.out.(outputAborter); ok {
+		a.abort(err)
+	}
+}
+
+func (vw *volumeWriter) Close() error {
+	if vw.aborted {
+		if vw.out != nil {
+			vw.out.Close()
+			vw.out = nil
+		}
+		for i := 0; i < vw.vol; i++ {
+			logrus.Debugf("Removing incomplete %s", volumeName(vw.fname, i))
+			removeBackupFile(volumeName(vw.fname, i))
+		}
+		return nil
+	}
+	if vw.out == nil {
+		// empty archive still gets the first volume
+		if err := vw.next(); err != nil {
+			return err
+		}
+	}
+	err := vw.out.Close()
+	vw.out = nil
+	if err == nil {
+		logrus.Infof("Split %s into %d volumes", vw.fname, vw.vol+1)
+	}
+	return err
+}
+
+// volumeFile is the volume opened for reading (the file, or the remote object)
+type volumeFile interface {
+	io.ReaderAt
+	io.Closer
+}
+
+// volumeSet reads either the single file, or all the volumes of the split archive
+type volumeSet struct {
+	files []volumeFile
+	offs  []int64 // the starting offset of each file
+	size  int64
+}
+
+// archiveFile is the archive opened for reading (decrypted, if the archive was encrypted)
+type archiveFile struct {
+	vs     *volumeSet
+	ra     io.ReaderAt // nil until loaded, for the archives encrypted to the recipients (see readerAt)
+	size   int64
+	in     io.Reader
+	format string // the recipientFormat
+}
+
+// volumeSetName returns the base file-name of the split archive (given as file.tar.000, or as file.tar if only
+// the volumes exist), or "" if the file is not split
+func volumeSetName(fname string) string {
+	if strings.HasSuffix(fname, firstVolumeExt) {
+		return strings.TrimSuffix(fname, firstVolumeExt)
+	} else if err := statInput(fname); !os.IsNotExist(err) {
+		return ""
+	} else if err = statInput(volumeName(fname, 0)); err == nil {
+		return fname
+	}
+	return ""
+}
+
+// countVolumes returns the number of all the volumes of the split archive (also the ones after a missing volume)
+func countVolumes(base string) int {
+	if isRemoteURL(base) {
+		cnt, err := countRemoteVolumes(base)
+		if err != nil {
+			logrus.WithError(err).Warnf("Could not list volumes of %s", base)
+		}
+		return cnt
+	}
+	all, _ := filepath.Glob(base + ".[0-9][0-9][0-9]")
+	return len(all)
+}
+
+// openArchive opens the archive file, or all the volumes of the split archive (see volumeSetName)
+//   - the encrypted archives are decrypted (see the `--encrypt` option)
+func openArchive(fname string) (*archiveFile, error) {
+	vs := &volumeSet{}
+	names := []string{fname}
+	if base := volumeSetName(fname); base != "" {
+		names = nil
+		for n := 0; ; n++ {
+			vname := volumeName(base, n)
+			if err := statInput(vname); os.IsNotExist(err) {
+				break
+			}
+			names = append(names, vname)
+		}
+		if len(names) <= 0 {
+			return nil, &os.PathError{Op: "open", Path: fname, Err: os.ErrNotExist}
+		}
+		// the volumes must be contiguous (e.g. file.tar.001 missing, while file.tar.002 exists)
+		if all := countVolumes(base); all > len(names) {
+			return nil, fmt.Errorf("Missing volume %s of %s", volumeName(base, len(names)), base)
+		}
+		logrus.Debugf("Reading %d volumes of %s...", len(names), base)
+	}
+	for _, name := range names {
+		f, size, err := openInput(name)
+		if err != nil {
+			vs.Close()
+			return nil, err
+		}
+		vs.files = append(vs.files, f)
+		vs.offs = append(vs.offs, vs.size)
+		vs.size += size
+	}
+
+	af := &archiveFile{vs: vs, ra: vs, size: vs.size}
+	hdr := make([]byte, len(ageMagic))
+	n, _ := vs.ReadAt(hdr, 0)
+	if isEncrypted(vs) {
+		dr, err := newDecryptReaderAt(vs, vs.size)
+		if err != nil {
+			vs.Close()
+			return nil, err
+		}
+		af.ra, af.size = dr, dr.size
+	} else if af.format = recipientFormat(hdr[:n]); af.format != "" {
+		in, err := recipientDecryptReader(io.NewSectionReader(vs, 0, vs.size), af.format)
+		if err != nil {
+			vs.Close()
+			return nil, err
+		}
+		af.in, af.ra = in, nil
+		return af, nil
+	}
+	af.in = io.NewSectionReader(af.ra, 0, af.size)
+	return af, nil
+}
+
+func (af *archiveFile) Read(p []byte) (int, error) {
+	return af.in.Read(p)
+}
+
+// readerAt returns the random access to the archive (e.g. ZIP archives), and its size
+//   - NOTE: the archives encrypted to the recipients can only be decrypted sequentially, so they are decrypted
+//     into memory (rather than into a temporary file)
+func (af *archiveFile) readerAt() (io.ReaderAt, int64, error) {
+	if af.ra == nil {
+		logrus.Debugf("Decrypting %s archive into memory...", af.format)
+		data, err := ioutil.ReadAll(af.in)
+		if err != nil {
+			return nil, 0, err
+		}
+		af.ra, af.size = bytes.NewReader(data), int64(len(data))
+	}
+	return af.ra, af.size, nil
+}
+
+func (af *archiveFile) Close() error {
+	return af.vs.Close()
+}
+
+// ReadAt reads across the volumes (the ZIP archives are read from the end)
+func (vs *volumeSet) ReadAt(p []byte, off int64) (int, error) {
+	total := 0
+	for len(p) > 0 {
+		if off >= vs.size {
+			return total, io.EOF
+		}
+		// find the file containing the offset
+		i := sort.Search(len(vs.offs), func(i int) bool { return vs.offs[i] > off }) - 1
+		n, err := vs.files[i].ReadAt(p, off-vs.offs[i])
+		total += n
+		off += int64(n)
+		p = p[n:]
+		if err != nil && err != io.EOF {
+			return total, err
+		} else if n == 0 {
+			return total, io.ErrUnexpectedEOF // the volume was truncated while reading
+		}
+	}
+	return total, nil
+}
+
+func (vs *volumeSet) Close() error {
+	var err error
+	for _, f := range vs.files {
+		if cerr := f.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
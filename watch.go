@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/mvcc/mvccpb"
+)
+
+// maxDiffCells caps the size of the LCS table of lineDiff (the changed lines of the larger texts are shown as removed/added)
+const maxDiffCells = 1 << 22
+
+// lineDiff returns a compact line-based diff of the two texts (lines prefixed with "-", "+" or " ")
+//   - NOTE: the common leading and trailing lines are skipped first, so the typical small edits of the large values are cheap
+func lineDiff(a, b string) []string {
+	var (
+		al  = strings.Split(strings.TrimSuffix(a, "\n"), "\n")
+		bl  = strings.Split(strings.TrimSuffix(b, "\n"), "\n")
+		ret []string
+	)
+	pre := 0
+	for pre < len(al) && pre < len(bl) && al[pre] == bl[pre] {
+		ret = append(ret, " "+al[pre])
+		pre++
+	}
+	suf := 0
+	for suf < len(al)-pre && suf < len(bl)-pre && al[len(al)-1-suf] == bl[len(bl)-1-suf] {
+		suf++
+	}
+	ret = append(ret, lcsDiff(al[pre:len(al)-suf], bl[pre:len(bl)-suf])...)
+	for _, l := range al[len(al)-suf:] {
+		ret = append(ret, " "+l)
+	}
+	return ret
+}
+
+// lcsDiff returns the diff of the lines via the longest common subsequence (or all the lines as removed/added, if
+// the texts are too large for the O(n*m) table)
+func lcsDiff(al, bl []string) []string {
+	var ret []string
+	if (len(al)+1)*(len(bl)+1) > maxDiffCells {
+		for _, l := range al {
+			ret = append(ret, "-"+l)
+		}
+		for _, l := range bl {
+			ret = append(ret, "+"+l)
+		}
+		return ret
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of al[i:] and bl[j:]
+	lcs := make([][]int, len(al)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(bl)+1)
+	}
+	for i := len(al) - 1; i >= 0; i-- {
+		for j := len(bl) - 1; j >= 0; j-- {
+			if al[i] == bl[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(al) && j < len(bl) {
+		if al[i] == bl[j] {
+			ret = append(ret, " "+al[i])
+			i++
+			j++
+		} else if lcs[i+1][j] >= lcs[i][j+1] {
+			ret = append(ret, "-"+al[i])
+			i++
+		} else {
+			ret = append(ret, "+"+bl[j])
+			j++
+		}
+	}
+	for ; i < len(al); i++ {
+		ret = append(ret, "-"+al[i])
+	}
+	for ; j < len(bl); j++ {
+		ret = append(ret, "+"+bl[j])
+	}
+	return ret
+}
+
+// printValue prints the (multi-line) value, aligning the continuation lines after the label
+func printValue(label string, val []byte) {
+	pad := "\n" + strings.Repeat(" ", len(label))
+	fmt.Printf("%s%s\n", label, strings.Replace(strings.TrimSuffix(string(val), "\n"), "\n", pad, -1))
+}
+
+// printEvent prints the watch event (with the previous value, if available)
+func printEvent(ev *clientv3.Event, diff bool) {
+	kv, prev := ev.Kv, ev.PrevKv
+	fmt.Printf("%s %s [rev %d]\n", ev.Type, kv.Key, kv.ModRevision)
+	switch {
+	case ev.Type == mvccpb.DELETE:
+		if prev != nil {
+			printValue("  old: ", prev.Value)
+		}
+	case diff && prev != nil && utf8.Valid(prev.Value) && utf8.Valid(kv.Value):
+		for _, l := range lineDiff(string(prev.Value), string(kv.Value)) {
+			fmt.Printf("  %s\n", l)
+		}
+	case prev != nil:
+		printValue("  old: ", prev.Value)
+		printValue("  new: ", kv.Value)
+	default:
+		printValue("  ", kv.Value)
+	}
+}
+
+// watchRecord is a JSON record of the watch event (`watch --output json` option)
+type watchRecord struct {
+	Type   string    `json:"type"`
+	Kv     *kvRecord `json:"kv"`
+	PrevKv *kvRecord `json:"prev_kv,omitempty"`
+}
+
+func newWatchRecord(ev *clientv3.Event) *watchRecord {
+	rec := &watchRecord{Type: ev.Type.String(), Kv: newKvRecord(ev.Kv)}
+	if ev.PrevKv != nil {
+		rec.PrevKv = newKvRecord(ev.PrevKv)
+	}
+	return rec
+}
+
+func actWatch(c *cli.Context) error {
+	if c.NArg() <= 0 {
+		return fmt.Errorf("Must specify which keys to watch")
+	}
+
+	var (
+		client      = getEtcdClient()
+		optDiff     = c.Bool("diff")
+		optPrev     = c.Bool("prev-value") || optDiff
+		optRev      int64
+		optPrefix   = c.Bool("prefix")
+		optOutput   = c.String("output")
+		enc         = json.NewEncoder(os.Stdout)
+		events      = make(chan *clientv3.Event)
+		errs        = make(chan error, c.NArg())
+		sigs        = make(chan os.Signal, 1)
+		wctx, abort = context.WithCancel(ctx)
+		err         error
+	)
+	defer abort()
+
+	switch optOutput {
+	case "plain":
+	case "json":
+		if optDiff {
+			return fmt.Errorf("Option --diff is not supported with --output json")
+		}
+	default:
+		return fmt.Errorf("Invalid output format '%s' (expected plain or json)", optOutput)
+	}
+	if s := c.String("rev"); s != "" {
+		if optRev, err = parseRevision(s); err != nil {
+			return err
+		}
+	}
+
+	for _, a := range c.Args().Slice() {
+		var opts []clientv3.OpOption
+		if optPrefix || strings.HasSuffix(a, "/") {
+			// watching subtree
+			opts = append(opts, clientv3.WithPrefix())
+		}
+		if optPrev {
+			opts = append(opts, clientv3.WithPrevKV())
+		}
+		if optRev > 0 {
+			opts = append(opts, clientv3.WithRev(optRev))
+		}
+		logrus.Debugf("Doing WATCH(%s,%#v)...", a, opts)
+		go func(a string, wch clientv3.WatchChan) {
+			for wres := range wch {
+				if err := wres.Err(); err != nil {
+					errs <- fmt.Errorf("Watch of %s failed: %v", a, err)
+					return
+				}
+				for _, ev := range wres.Events {
+					select {
+					case events <- ev:
+					case <-wctx.Done():
+						return
+					}
+				}
+			}
+		}(a, client.Watch(clientv3.WithRequireLeader(wctx), a, opts...))
+	}
+
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	logrus.Infof("Watching %d keys (interrupt to stop)...", c.NArg())
+	for {
+		select {
+		case ev := <-events:
+			if optOutput == "json" {
+				checkErr(enc.Encode(newWatchRecord(ev)))
+				continue
+			}
+			printEvent(ev, optDiff)
+		case err := <-errs:
+			return err
+		case s := <-sigs:
+			logrus.Infof("Got %s, stopping", s)
+			return nil
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLineDiff(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		a, b string
+		want []string
+	}{
+		{"identical", "a\nb\n", "a\nb\n", []string{" a", " b"}},
+		{"edit", "a\nb\nc\n", "a\nB\nc\n", []string{" a", "-b", "+B", " c"}},
+		{"insert", "a\nc", "a\nb\nc", []string{" a", "+b", " c"}},
+		{"remove", "a\nb\nc", "a\nc", []string{" a", "-b", " c"}},
+	} {
+		if got := lineDiff(tc.a, tc.b); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: expected %q, got %q", tc.name, tc.want, got)
+		}
+	}
+}
+
+func TestLineDiffLarge(t *testing.T) {
+	lines := func(n int, format string) string {
+		var sb strings.Builder
+		for i := 0; i < n; i++ {
+			fmt.Fprintf(&sb, format+"\n", i)
+		}
+		return sb.String()
+	}
+
+	// the texts with nothing in common exceed the LCS table, so all the lines are shown as removed/added
+	a, b := lines(3000, "a%d"), lines(3000, "b%d")
+	diff := lineDiff(a, b)
+	if len(diff) != 6000 || diff[0] != "-a0" || diff[2999] != "-a2999" || diff[3000] != "+b0" || diff[5999] != "+b2999" {
+		t.Fatalf("Expected 3000 removed then 3000 added lines, got %d lines", len(diff))
+	}
+
+	// a small change in the middle of the large text is still diffed exactly
+	b = strings.Replace(a, "a1500\n", "changed\n", 1)
+	start := time.Now()
+	diff = lineDiff(a, b)
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("Diff of the small change took %v", d)
+	}
+	var changed []string
+	for _, l := range diff {
+		if !strings.HasPrefix(l, " ") {
+			changed = append(changed, l)
+		}
+	}
+	if len(diff) != 3001 || !reflect.DeepEqual(changed, []string{"-a1500", "+changed"}) {
+		t.Fatalf("Expected a single changed line, got %d lines with changes %q", len(diff), changed)
+	}
+}
+
+func TestWatchPrevValue(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Interrupting the process is not supported on Windows")
+	}
+	var (
+		prefix = testPrefix(t)
+		key    = prefix + "conf"
+		client = testClient(t)
+		done   = make(chan error, 1)
+		out    string
+	)
+	res, err := client.Put(ctx, key, "old-value")
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err = client.Put(ctx, key, "new-value"); err != nil {
+		t.Fatal(err)
+	}
+
+	// watching from the past revision replays both events
+	go func() {
+		var err error
+		out, err = runApp(t, "watch", "--prev-value", "--rev", fmt.Sprint(res.Header.Revision), key)
+		done <- err
+	}()
+	time.Sleep(time.Second)
+
+	p, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	} else if err = p.Signal(os.Interrupt); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Expected the interrupted watch to succeed, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Watch was not interrupted")
+	}
+
+	update := fmt.Sprintf("PUT %s [rev %d]\n  old: old-value\n  new: new-value\n", key, res.Header.Revision+1)
+	if !strings.Contains(out, update) {
+		t.Fatalf("Expected the update event with the previous value, got:\n%s", out)
+	}
+}
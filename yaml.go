@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"sort"
+
+	"go.etcd.io/etcd/mvcc/mvccpb"
+	"gopkg.in/yaml.v2"
+)
+
+// yamlValue is the value of the key in the YAML document (`export --format yaml` option)
+//   - without the metadata, the value is written as a plain YAML string (binary values are tagged as !!binary)
+type yamlValue struct {
+	Value          string `yaml:"value"`
+	CreateRevision int64  `yaml:"create_revision,omitempty"`
+	ModRevision    int64  `yaml:"mod_revision,omitempty"`
+	Version        int64  `yaml:"version,omitempty"`
+	Lease          int64  `yaml:"lease,omitempty"`
+}
+
+// UnmarshalYAML accepts both the plain values, and the values with the metadata
+func (yv *yamlValue) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&yv.Value); err == nil {
+		return nil
+	}
+	type plain yamlValue
+	return unmarshal((*plain)(yv))
+}
+
+// yamlEntry returns the YAML mapping entry of the key
+//   - NOTE: the entries of the keys can be concatenated into a single YAML document
+func yamlEntry(kv *mvccpb.KeyValue, metadata bool) ([]byte, error) {
+	var val interface{} = string(kv.Value)
+	if metadata {
+		val = &yamlValue{
+			Value:          string(kv.Value),
+			CreateRevision: kv.CreateRevision,
+			ModRevision:    kv.ModRevision,
+			Version:        kv.Version,
+			Lease:          kv.Lease,
+		}
+	}
+	return yaml.Marshal(yaml.MapSlice{{Key: string(kv.Key), Value: val}})
+}
+
+// readYamlRecords reads the YAML document, and passes the records to the callback function (sorted by the keys)
+func readYamlRecords(in io.Reader, fn func(rec *kvRecord) error) error {
+	buf, err := ioutil.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	var doc map[string]yamlValue
+	if err = yaml.Unmarshal(buf, &doc); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(doc))
+	for k := range doc {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		yv := doc[k]
+		rec := &kvRecord{
+			Key:            k,
+			Value:          []byte(yv.Value),
+			CreateRevision: yv.CreateRevision,
+			ModRevision:    yv.ModRevision,
+			Version:        yv.Version,
+			Lease:          yv.Lease,
+		}
+		if err = fn(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}